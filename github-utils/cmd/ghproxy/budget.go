@@ -0,0 +1,126 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// budget shares the rate limit of the token between the clients. A client
+// may use a share of the limit in each window of the rate limit, and no
+// request is sent once the remaining points reach the reserve. The requests
+// are admitted until the limit is known from the first response.
+//
+// A request is counted as one point, the minimal cost of a GraphQL query,
+// when it is admitted. Its client is then charged the rest of its cost, the
+// increase of the points used in the window reported by its response. The
+// cost of concurrent requests may be charged to each other's clients, but the
+// points of the window are all charged.
+type budget struct {
+	lock sync.Mutex
+	// share is the fraction of the limit a client may use, 0 for no limit.
+	share   float64
+	reserve int
+	// limit, remaining and reset describe the current window, as last
+	// reported by GitHub. The limit is 0 until the first response.
+	limit     int
+	remaining int
+	reset     time.Time
+	// windowUsed is the highest number of points used in the window
+	// reported by the responses.
+	windowUsed int
+	// used is the number of points charged to each client in the window.
+	used map[string]int
+}
+
+func newBudget(share float64, reserve int) *budget {
+	return &budget{share: share, reserve: reserve, used: map[string]int{}}
+}
+
+// admit counts a request of the client if the budget allows it. Otherwise it
+// returns the wait until the next window and the exhausted budget, reserve or
+// client-share.
+func (b *budget) admit(client string, now time.Time) (time.Duration, string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.limit == 0 {
+		b.used[client]++
+		return 0, ""
+	}
+	if !now.Before(b.reset) {
+		// The window reset since the last response.
+		b.remaining, b.windowUsed = b.limit, 0
+		b.used = map[string]int{}
+	}
+	wait := b.reset.Sub(now)
+	if b.remaining <= b.reserve {
+		return wait, "reserve"
+	}
+	if b.share > 0 && float64(b.used[client]) >= b.share*float64(b.limit) {
+		return wait, "client-share"
+	}
+	b.used[client]++
+	b.remaining--
+	return 0, ""
+}
+
+// update updates the window with the rate limit headers of a response to the
+// client, and charges the client the cost of the request beyond the point
+// counted by admit.
+func (b *budget) update(client string, header http.Header, now time.Time) {
+	limit, err := strconv.Atoi(header.Get("X-RateLimit-Limit"))
+	if err != nil {
+		return
+	}
+	remaining, err := strconv.Atoi(header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+	seconds, err := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return
+	}
+	reset := time.Unix(seconds, 0)
+	used, err := strconv.Atoi(header.Get("X-RateLimit-Used"))
+	if err != nil {
+		used = limit - remaining
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	switch {
+	case b.reset.IsZero():
+		// The points used before the first response are not known to
+		// be the client's.
+		b.windowUsed = used
+	case reset.After(b.reset):
+		// The request is the first one of a new window, charged all
+		// the points used in the window so far.
+		b.used = map[string]int{client: used}
+		b.windowUsed = used
+	case used > b.windowUsed:
+		if cost := used - b.windowUsed; cost > 1 {
+			b.used[client] += cost - 1
+		}
+		b.windowUsed = used
+	}
+	b.limit, b.remaining, b.reset = limit, remaining, reset
+	rateLimitRemaining.Set(float64(remaining))
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// cachedResponse is a response of GitHub.
+type cachedResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// cacheable returns whether the response can be served again: successful and
+// without GraphQL errors, which may be transient.
+func (r *cachedResponse) cacheable() bool {
+	if r.status != http.StatusOK {
+		return false
+	}
+	var response struct {
+		Errors []json.RawMessage `json:"errors"`
+	}
+	return json.Unmarshal(r.body, &response) == nil && len(response.Errors) == 0
+}
+
+// responseCache is a cache of the responses bounded in entries, evicting the
+// least recently used ones.
+type responseCache struct {
+	lock    sync.Mutex
+	entries int
+	// order holds the *cacheEntry from the most to the least recently used.
+	order *list.List
+	keys  map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key      string
+	response *cachedResponse
+	expires  time.Time
+}
+
+func newResponseCache(entries int) *responseCache {
+	return &responseCache{entries: entries, order: list.New(), keys: map[string]*list.Element{}}
+}
+
+// get returns the response cached for the key if it has not expired.
+func (c *responseCache) get(key string, now time.Time) (*cachedResponse, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	element, ok := c.keys[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if !now.Before(entry.expires) {
+		c.order.Remove(element)
+		delete(c.keys, key)
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return entry.response, true
+}
+
+// add caches the response for the key until it expires.
+func (c *responseCache) add(key string, response *cachedResponse, expires time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if element, ok := c.keys[key]; ok {
+		c.order.Remove(element)
+	}
+	c.keys[key] = c.order.PushFront(&cacheEntry{key: key, response: response, expires: expires})
+	for c.order.Len() > c.entries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.keys, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+func (c *responseCache) len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.order.Len()
+}
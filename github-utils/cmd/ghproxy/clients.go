@@ -0,0 +1,110 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// clients authenticates the clients of the proxy by their tokens.
+type clients struct {
+	// names is a map from the token of each client to its name.
+	names map[string]string
+	// mutations are the names of the clients allowed to send mutations.
+	mutations map[string]bool
+}
+
+// parseClients parses the content of --clients-file: one "<token> <client>"
+// line per client. Empty lines and lines starting with "#" are ignored.
+func parseClients(content string) (map[string]string, error) {
+	names := map[string]string{}
+	for i, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected \"<token> <client>\"", i+1)
+		}
+		if _, ok := names[fields[0]]; ok {
+			return nil, fmt.Errorf("line %d: duplicate token", i+1)
+		}
+		names[fields[0]] = fields[1]
+	}
+	return names, nil
+}
+
+// loadClients reads the clients from the file, and allows the named ones,
+// comma separated, to send mutations.
+func loadClients(path, mutationClients string) (*clients, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the clients: %v", err)
+	}
+	names, err := parseClients(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the clients in %q: %v", path, err)
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no clients in %q", path)
+	}
+	return newClients(names, mutationClients)
+}
+
+// newClients returns the clients of the names by token, the ones named in
+// mutationClients, comma separated, being allowed to send mutations.
+func newClients(names map[string]string, mutationClients string) (*clients, error) {
+	c := &clients{names: names, mutations: map[string]bool{}}
+	known := map[string]bool{}
+	for _, name := range names {
+		known[name] = true
+	}
+	for _, name := range strings.Split(mutationClients, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if !known[name] {
+			return nil, fmt.Errorf("unknown client %q allowed to send mutations", name)
+		}
+		c.mutations[name] = true
+	}
+	return c, nil
+}
+
+// authenticate returns the name of the client authenticated by the token in
+// the Authorization header of req, "bearer <token>" or "token <token>" like
+// the GitHub API, or "" if there is no valid token.
+func (c *clients) authenticate(req *http.Request) string {
+	fields := strings.Fields(req.Header.Get("Authorization"))
+	if len(fields) != 2 || (!strings.EqualFold(fields[0], "bearer") && !strings.EqualFold(fields[0], "token")) {
+		return ""
+	}
+	// Compare with every token in constant time, so that the response time
+	// does not reveal how much of a token is valid.
+	name := ""
+	for token, candidate := range c.names {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(fields[1])) == 1 {
+			name = candidate
+		}
+	}
+	return name
+}
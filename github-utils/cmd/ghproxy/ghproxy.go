@@ -0,0 +1,275 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// ghproxy fronts the GitHub GraphQL API for the tools of this repository. It
+// authenticates the tools with their own tokens and their requests to GitHub
+// with a shared token, caches the responses of the queries, shares the rate
+// limit of the token between the tools and exports metrics.
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"k8s.io/contrib/github-utils/github"
+)
+
+var (
+	address         = flag.String("address", "127.0.0.1:8080", "The address to serve on")
+	tokenFile       = flag.String("token-file", "", "The path to the token authenticating the requests to GitHub, shared by the clients")
+	clientsFile     = flag.String("clients-file", "", "The path of a file listing the tokens of the clients allowed to use the proxy, one \"<token> <client>\" per line")
+	mutationClients = flag.String("mutation-clients", "", "The comma separated clients of --clients-file allowed to send mutations. Mutations are rejected for the others")
+	upstream        = flag.String("upstream", github.APIURL+"/graphql", "The URL of the GraphQL API")
+	cacheTTL        = flag.Duration("cache-ttl", 5*time.Minute, "How long the responses of the queries are cached, 0 to disable the cache")
+	cacheEntries    = flag.Int("cache-entries", 10000, "The maximum number of cached responses")
+	clientShare     = flag.Float64("client-share", 0.25, "The fraction of the rate limit of the token a single client may use in a rate limit window, 0 for no limit")
+	reserve         = flag.Int("reserve", 500, "The points of the rate limit kept in reserve: the requests are rejected once fewer remain")
+)
+
+const (
+	// cacheHeader tells whether a response was cached.
+	cacheHeader = "X-Ghproxy-Cache"
+	// maxRequestSize bounds the size of the requests.
+	maxRequestSize = 1 << 20
+)
+
+const metricsNamespace = "ghproxy"
+
+var (
+	requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "requests_total",
+		Help:      "Number of requests, by client, cache result (hit, miss or bypass) and status code.",
+	}, []string{"client", "cache", "code"})
+	upstreamDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "upstream_duration_seconds",
+		Help:      "Duration of the requests forwarded to GitHub, by status code.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 10),
+	}, []string{"code"})
+	throttled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "throttled_total",
+		Help:      "Number of requests rejected by the rate limit budget, by client and reason (client-share or reserve).",
+	}, []string{"client", "reason"})
+	rateLimitRemaining = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "rate_limit_remaining",
+		Help:      "Points of the rate limit of the token remaining in the current window, as last reported by GitHub.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(requests, upstreamDuration, throttled, rateLimitRemaining)
+}
+
+func main() {
+	flag.Parse()
+	token, err := github.ReadToken(*tokenFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if token == "" {
+		log.Fatal("--token-file is required")
+	}
+	if *clientsFile == "" {
+		log.Fatal("--clients-file is required")
+	}
+	c, err := loadClients(*clientsFile, *mutationClients)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *clientShare < 0 || *clientShare > 1 {
+		log.Fatalf("--client-share must be between 0 and 1, got %v", *clientShare)
+	}
+	p := newProxy(*upstream, token, c, *cacheTTL, *cacheEntries, *clientShare, *reserve)
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "cache_entries",
+		Help:      "Number of cached responses.",
+	}, func() float64 { return float64(p.cache.len()) }))
+
+	mux := http.NewServeMux()
+	mux.Handle("/graphql", p)
+	mux.Handle("/metrics", promhttp.Handler())
+	log.Printf("Proxying %s on %s", *upstream, *address)
+	log.Fatal(http.ListenAndServe(*address, mux))
+}
+
+// proxy is the handler of the GraphQL requests.
+type proxy struct {
+	upstream   string
+	token      string
+	clients    *clients
+	httpClient *http.Client
+	cache      *responseCache
+	ttl        time.Duration
+	budget     *budget
+	now        func() time.Time
+}
+
+func newProxy(upstream, token string, c *clients, ttl time.Duration, entries int, share float64, reserve int) *proxy {
+	return &proxy{
+		upstream:   upstream,
+		token:      token,
+		clients:    c,
+		httpClient: &http.Client{Timeout: time.Minute},
+		cache:      newResponseCache(entries),
+		ttl:        ttl,
+		budget:     newBudget(share, reserve),
+		now:        time.Now,
+	}
+}
+
+// graphQLRequest is the body of a GraphQL request.
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+	OperationName string                 `json:"operationName,omitempty"`
+}
+
+func (p *proxy) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	// The clients are named by --clients-file, which bounds the labels of
+	// the metrics and the budgets.
+	client := p.clients.authenticate(req)
+	if client == "" {
+		client = "unauthenticated"
+	}
+	cache, code := "bypass", http.StatusOK
+	defer func() {
+		requests.WithLabelValues(client, cache, strconv.Itoa(code)).Inc()
+	}()
+	fail := func(status int, format string, args ...interface{}) {
+		code = status
+		http.Error(res, fmt.Sprintf(format, args...), status)
+	}
+
+	if client == "unauthenticated" {
+		res.Header().Set("WWW-Authenticate", `Bearer realm="ghproxy"`)
+		fail(http.StatusUnauthorized, "a token of --clients-file is required")
+		return
+	}
+	if req.Method != "POST" {
+		fail(http.StatusMethodNotAllowed, "only POST is supported")
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, maxRequestSize+1))
+	if err != nil {
+		fail(http.StatusBadRequest, "failed to read the request: %v", err)
+		return
+	}
+	if len(body) > maxRequestSize {
+		fail(http.StatusRequestEntityTooLarge, "the request is larger than %d bytes", maxRequestSize)
+		return
+	}
+	var request graphQLRequest
+	if err := json.Unmarshal(body, &request); err != nil || request.Query == "" {
+		fail(http.StatusBadRequest, "expected a GraphQL request with a query")
+		return
+	}
+	// The requests are re-encoded so that the key ignores their formatting.
+	body, _ = json.Marshal(request)
+	sum := sha256.Sum256(body)
+	key := hex.EncodeToString(sum[:])
+	mutation := isMutation(request)
+	if mutation && !p.clients.mutations[client] {
+		fail(http.StatusForbidden, "client %s is not allowed to send mutations", client)
+		return
+	}
+	cacheable := p.ttl > 0 && !mutation
+
+	if cacheable {
+		cache = "miss"
+		if req.Header.Get("Cache-Control") != "no-cache" {
+			if response, ok := p.cache.get(key, p.now()); ok {
+				cache = "hit"
+				p.write(res, response, "HIT")
+				return
+			}
+		}
+	}
+
+	if wait, reason := p.budget.admit(client, p.now()); reason != "" {
+		throttled.WithLabelValues(client, reason).Inc()
+		res.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds()+0.5)))
+		fail(http.StatusTooManyRequests, "the %s budget of the GitHub rate limit is exhausted, retry in %v", reason, wait)
+		return
+	}
+	response, err := p.forward(client, body)
+	if err != nil {
+		fail(http.StatusBadGateway, "%v", err)
+		return
+	}
+	code = response.status
+	if cacheable && response.cacheable() {
+		p.cache.add(key, response, p.now().Add(p.ttl))
+	}
+	p.write(res, response, "MISS")
+}
+
+// forward sends the request of the client to GitHub with the shared token.
+func (p *proxy) forward(client string, body []byte) (*cachedResponse, error) {
+	req, err := http.NewRequest("POST", p.upstream, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "bearer "+p.token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ghproxy")
+	start := p.now()
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		upstreamDuration.WithLabelValues("error").Observe(p.now().Sub(start).Seconds())
+		return nil, fmt.Errorf("failed to send the request to GitHub: %v", err)
+	}
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(resp.Body)
+	upstreamDuration.WithLabelValues(strconv.Itoa(resp.StatusCode)).Observe(p.now().Sub(start).Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the response of GitHub: %v", err)
+	}
+	p.budget.update(client, resp.Header, p.now())
+
+	response := &cachedResponse{status: resp.StatusCode, body: content, header: http.Header{}}
+	for _, name := range []string{"Content-Type", "Retry-After", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "X-RateLimit-Used"} {
+		if value := resp.Header.Get(name); value != "" {
+			response.header.Set(name, value)
+		}
+	}
+	return response, nil
+}
+
+func (p *proxy) write(res http.ResponseWriter, response *cachedResponse, cache string) {
+	for name, values := range response.header {
+		res.Header()[name] = values
+	}
+	res.Header().Set(cacheHeader, cache)
+	res.WriteHeader(response.status)
+	res.Write(response.body)
+}
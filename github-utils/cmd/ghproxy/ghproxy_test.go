@@ -0,0 +1,218 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeGitHub is a GraphQL API with a rate limit of 10 points per hour. The
+// queries cost 1 point, but the expensive ones cost 3.
+type fakeGitHub struct {
+	requests   int
+	used       int
+	reset      time.Time
+	authorized []string
+}
+
+func (f *fakeGitHub) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	f.requests++
+	f.used++
+	if strings.Contains(string(body), "expensive") {
+		f.used += 2
+	}
+	f.authorized = append(f.authorized, req.Header.Get("Authorization"))
+	res.Header().Set("X-RateLimit-Limit", "10")
+	res.Header().Set("X-RateLimit-Remaining", fmt.Sprint(10-f.used))
+	res.Header().Set("X-RateLimit-Used", fmt.Sprint(f.used))
+	res.Header().Set("X-RateLimit-Reset", fmt.Sprint(f.reset.Unix()))
+	if strings.Contains(string(body), "broken") {
+		fmt.Fprint(res, `{"data": null, "errors": [{"message": "Something went wrong"}]}`)
+		return
+	}
+	fmt.Fprintf(res, `{"data": {"request": %d}}`, f.requests)
+}
+
+// send sends the request authenticated by the token of the client, "<client>-token".
+func send(t *testing.T, p *proxy, client, body string) (int, string, string) {
+	req := httptest.NewRequest("POST", "/graphql", strings.NewReader(body))
+	if client != "" {
+		req.Header.Set("Authorization", "bearer "+client+"-token")
+	}
+	res := httptest.NewRecorder()
+	p.ServeHTTP(res, req)
+	return res.Code, res.Header().Get(cacheHeader), res.Body.String()
+}
+
+func TestProxy(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	fake := &fakeGitHub{reset: now.Add(time.Hour)}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	c, err := newClients(map[string]string{"mungegithub-token": "mungegithub", "node-perf-dash-token": "node-perf-dash"}, "mungegithub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := newProxy(server.URL, "shared-token", c, time.Minute, 10, 0.5, 3)
+	p.now = func() time.Time { return now }
+
+	table := []struct {
+		name   string
+		client string
+		body   string
+		code   int
+		cache  string
+		data   string
+	}{
+		{name: "miss", client: "mungegithub", body: `{"query": "{ viewer { login } }"}`, code: 200, cache: "MISS", data: `"request": 1`},
+		// The formatting of the request does not matter.
+		{name: "hit", client: "node-perf-dash", body: `{ "query" : "{ viewer { login } }" }`, code: 200, cache: "HIT", data: `"request": 1`},
+		// The expensive query is charged its 3 points.
+		{name: "other variables", client: "mungegithub", body: `{"query": "query($n: Int) { expensive }", "variables": {"n": 1}}`, code: 200, cache: "MISS", data: `"request": 2`},
+		{name: "mutation", client: "mungegithub", body: `{"query": "# star\nmutation { addStar(input: {}) { clientMutationId } }"}`, code: 200, cache: "MISS", data: `"request": 3`},
+		// mungegithub used its 5 points, half of the limit.
+		{name: "client share", client: "mungegithub", body: `{"query": "# star\nmutation { addStar(input: {}) { clientMutationId } }"}`, code: 429, data: "client-share"},
+		{name: "mutation not allowed", client: "node-perf-dash", body: `{"query": "mutation { addStar(input: {}) { clientMutationId } }"}`, code: 403, data: "not allowed to send mutations"},
+		// The mutations are found after a string holding a "#" and after
+		// a comma.
+		{name: "mutation after a string", client: "node-perf-dash", body: `{"query": "query{a(b:\"#\")} mutation{c}"}`, code: 403, data: "not allowed to send mutations"},
+		{name: "mutation after a comma", client: "node-perf-dash", body: `{"query": "query a{x},mutation b{c}"}`, code: 403, data: "not allowed to send mutations"},
+		{name: "unauthenticated", body: `{"query": "{ viewer { login } }"}`, code: 401},
+		{name: "unknown token", client: "unknown", body: `{"query": "{ viewer { login } }"}`, code: 401},
+		{name: "errors", client: "node-perf-dash", body: `{"query": "{ broken }"}`, code: 200, cache: "MISS", data: "Something went wrong"},
+		{name: "other client", client: "node-perf-dash", body: `{"query": "{ a }"}`, code: 200, cache: "MISS", data: `"request": 5`},
+		{name: "cached for the throttled client", client: "mungegithub", body: `{"query": "{ a }"}`, code: 200, cache: "HIT", data: `"request": 5`},
+		// 3 points remain.
+		{name: "reserve", client: "node-perf-dash", body: `{"query": "{ c }"}`, code: 429, data: "reserve"},
+		{name: "invalid", client: "node-perf-dash", body: `{"variables": {}}`, code: 400},
+	}
+	for _, tt := range table {
+		code, cache, body := send(t, p, tt.client, tt.body)
+		if code != tt.code || (tt.cache != "" && cache != tt.cache) || !strings.Contains(body, tt.data) {
+			t.Errorf("%s: expected %d %q with %q but got %d %q with %q", tt.name, tt.code, tt.cache, tt.data, code, cache, body)
+		}
+	}
+	for _, authorization := range fake.authorized {
+		if authorization != "bearer shared-token" {
+			t.Errorf("expected the shared token to be sent but got %q", authorization)
+		}
+	}
+
+	// The budgets are renewed with the rate limit.
+	now = now.Add(time.Hour)
+	fake.used, fake.reset = 0, now.Add(time.Hour)
+	if code, _, body := send(t, p, "mungegithub", `{"query": "{ d }"}`); code != 200 {
+		t.Errorf("expected the client to be admitted in the new window but got %d %q", code, body)
+	}
+	// The cached responses expire.
+	if _, cache, _ := send(t, p, "node-perf-dash", `{"query": "{ viewer { login } }"}`); cache != "MISS" {
+		t.Errorf("expected the cached response to expire but got %q", cache)
+	}
+}
+
+func TestIsMutation(t *testing.T) {
+	table := []struct {
+		name      string
+		query     string
+		operation string
+		expect    bool
+	}{
+		{name: "shorthand", query: "{ viewer { login } }"},
+		{name: "mutation", query: "mutation { addStar(input: {}) { clientMutationId } }", expect: true},
+		{name: "commented out", query: "# mutation { a }\n{ b }"},
+		{name: "in a string", query: `{ search(query: "mutation { a }") { count } }`},
+		{name: "in a block string", query: `{ search(query: """mutation { a } \""" #""") { count } }`},
+		{name: "field named mutation", query: "{ mutation { id } }"},
+		{name: "after a string holding a comment", query: `query{a(b:"#")} mutation{c}`, expect: true},
+		{name: "after a comma", query: "query a{x},mutation b{c}", expect: true},
+		{name: "named query", query: "query a{x} mutation b{c}", operation: "a"},
+		{name: "named mutation", query: "query a{x} mutation b{c}", operation: "b", expect: true},
+		{name: "unknown operation", query: "query a{x}", operation: "b", expect: true},
+		{name: "variables and fragments", query: "query a($n: Int = 1) @cached { ...f } fragment f on Query { x }", operation: "a"},
+	}
+	for _, tt := range table {
+		if got := isMutation(graphQLRequest{Query: tt.query, OperationName: tt.operation}); got != tt.expect {
+			t.Errorf("%s: expected %v but got %v", tt.name, tt.expect, got)
+		}
+	}
+}
+
+func TestClients(t *testing.T) {
+	table := []struct {
+		name      string
+		content   string
+		mutations string
+		// expect is the client authenticated by "bearer a", empty if
+		// the clients are invalid.
+		expect string
+	}{
+		{name: "valid", content: "# tools\na mungegithub\n\nb node-perf-dash\n", mutations: "mungegithub", expect: "mungegithub"},
+		{name: "missing client", content: "a\n"},
+		{name: "duplicate token", content: "a mungegithub\na node-perf-dash\n"},
+		{name: "unknown mutation client", content: "a mungegithub\n", mutations: "submit-queue"},
+	}
+	for _, tt := range table {
+		names, err := parseClients(tt.content)
+		var c *clients
+		if err == nil {
+			c, err = newClients(names, tt.mutations)
+		}
+		if (err == nil) != (tt.expect != "") {
+			t.Errorf("%s: expected valid clients %v but got %v", tt.name, tt.expect != "", err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		for authorization, expect := range map[string]string{"bearer a": tt.expect, "token b": "node-perf-dash", "bearer c": "", "a": "", "basic a": ""} {
+			req := httptest.NewRequest("POST", "/graphql", nil)
+			req.Header.Set("Authorization", authorization)
+			if got := c.authenticate(req); got != expect {
+				t.Errorf("%s: expected %q to authenticate %q but got %q", tt.name, authorization, expect, got)
+			}
+		}
+		if !c.mutations["mungegithub"] || c.mutations["node-perf-dash"] {
+			t.Errorf("%s: expected only mungegithub to send mutations but got %v", tt.name, c.mutations)
+		}
+	}
+}
+
+func TestResponseCache(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	cache := newResponseCache(2)
+	for _, key := range []string{"a", "b"} {
+		cache.add(key, &cachedResponse{body: []byte(key)}, now.Add(time.Minute))
+	}
+	// a is used, b is the least recently used and evicted by c.
+	cache.get("a", now)
+	cache.add("c", &cachedResponse{body: []byte("c")}, now.Add(time.Minute))
+	for key, expected := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, ok := cache.get(key, now); ok != expected {
+			t.Errorf("%s: expected cached %v but got %v", key, expected, ok)
+		}
+	}
+	if _, ok := cache.get("a", now.Add(time.Minute)); ok || cache.len() != 1 {
+		t.Errorf("expected a to expire but got %v with %d entries", ok, cache.len())
+	}
+}
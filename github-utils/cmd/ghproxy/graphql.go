@@ -0,0 +1,150 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import "strings"
+
+// operation is an operation defined by a GraphQL document.
+type operation struct {
+	// kind is "query", "mutation" or "subscription".
+	kind string
+	// name is empty for the anonymous operations.
+	name string
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isNameChar(c byte) bool {
+	return isNameStart(c) || (c >= '0' && c <= '9')
+}
+
+// parseOperations returns the operations defined by the GraphQL document. The
+// document is tokenized but not validated: the strings, block strings and
+// comments are skipped and the commas are whitespace, like in the GraphQL
+// specification, so that only the keywords of the definitions are found.
+func parseOperations(document string) []operation {
+	var operations []operation
+	// braces and parens are the nesting depths. A definition starts at the
+	// top level, and ends when its selection set is closed.
+	braces, parens := 0, 0
+	inDefinition, afterKeyword := false, false
+	for i := 0; i < len(document); {
+		c := document[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			i++
+		case c == '#':
+			for i < len(document) && document[i] != '\n' && document[i] != '\r' {
+				i++
+			}
+		case strings.HasPrefix(document[i:], `"""`):
+			i += 3
+			for i < len(document) && !strings.HasPrefix(document[i:], `"""`) {
+				if strings.HasPrefix(document[i:], `\"""`) {
+					i += 4
+				} else {
+					i++
+				}
+			}
+			i += 3
+			afterKeyword = false
+		case c == '"':
+			i++
+			for i < len(document) && document[i] != '"' && document[i] != '\n' {
+				if document[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			i++
+			afterKeyword = false
+		case isNameStart(c):
+			j := i
+			for j < len(document) && isNameChar(document[j]) {
+				j++
+			}
+			name := document[i:j]
+			i = j
+			if braces > 0 || parens > 0 {
+				continue
+			}
+			if !inDefinition {
+				inDefinition = true
+				if name == "query" || name == "mutation" || name == "subscription" {
+					operations = append(operations, operation{kind: name})
+					afterKeyword = true
+				}
+				continue
+			}
+			if afterKeyword {
+				operations[len(operations)-1].name = name
+			}
+			afterKeyword = false
+		default:
+			switch c {
+			case '{':
+				if braces == 0 && parens == 0 && !inDefinition {
+					// The query shorthand.
+					operations = append(operations, operation{kind: "query"})
+					inDefinition = true
+				}
+				braces++
+			case '}':
+				if braces > 0 {
+					braces--
+				}
+				if braces == 0 && parens == 0 {
+					inDefinition = false
+				}
+			case '(':
+				parens++
+			case ')':
+				if parens > 0 {
+					parens--
+				}
+			}
+			i++
+			afterKeyword = false
+		}
+	}
+	return operations
+}
+
+// isMutation returns whether the request executes a mutation, which is only
+// allowed for --mutation-clients and never cached: the operation named
+// operationName, or any operation of the document if there is no operation
+// name. A request naming an operation the document does not define is
+// treated as a mutation, so that ambiguous requests fail closed.
+func isMutation(request graphQLRequest) bool {
+	operations := parseOperations(request.Query)
+	if request.OperationName != "" {
+		for _, o := range operations {
+			if o.name == request.OperationName {
+				return o.kind == "mutation"
+			}
+		}
+		return true
+	}
+	for _, o := range operations {
+		if o.kind == "mutation" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,479 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// ghql runs ad-hoc queries and mutations of the GitHub GraphQL API with the
+// client of this repository, from the command line or interactively.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"k8s.io/contrib/github-utils/github"
+)
+
+// maxPages bounds the pages fetched with --paginate.
+const maxPages = 100
+
+var (
+	endpoint  = flag.String("endpoint", github.APIURL+"/graphql", "The URL of the GraphQL API")
+	tokenFile = flag.String("token-file", "", "If non-empty, the path to a token authenticating the requests, required by the GitHub API")
+	query     = flag.String("query", "", "The query to run, if empty the queries are read from --file or interactively from the standard input")
+	file      = flag.String("file", "", "If non-empty, the path to a file holding the query to run")
+	paginate  = flag.Bool("paginate", false, "If true, follow the pageInfo of the first connection of the response, passing its endCursor as $cursor or $after")
+	output    = flag.String("output", "json", "The output format, json or table")
+	verbose   = flag.Bool("verbose", false, "If true, print the requests sent to the API on the standard error")
+	variables = variableFlags{}
+)
+
+func init() {
+	flag.Var(variables, "var", "A variable of the queries as name=value, repeatable. The variables declared by a query but not set are prompted for")
+}
+
+// variableFlags are the values of the variables set on the command line.
+type variableFlags map[string]string
+
+func (v variableFlags) String() string {
+	var pairs []string
+	for name, value := range v {
+		pairs = append(pairs, name+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (v variableFlags) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("expected name=value but got %q", value)
+	}
+	v[strings.TrimPrefix(parts[0], "$")] = parts[1]
+	return nil
+}
+
+func main() {
+	flag.Parse()
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "ghql: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	if *output != "json" && *output != "table" {
+		return fmt.Errorf("--output must be json or table, got %q", *output)
+	}
+	client, err := github.NewClientFromTokenFile(*tokenFile)
+	if err != nil {
+		return err
+	}
+	client.GraphQLURL = *endpoint
+	input := bufio.NewReader(os.Stdin)
+	r := &runner{
+		client:    client,
+		variables: variables,
+		input:     input,
+		prompts:   os.Stderr,
+		out:       os.Stdout,
+		table:     *output == "table",
+		paginate:  *paginate,
+	}
+	if *verbose {
+		r.requests = os.Stderr
+	}
+	switch {
+	case *query != "":
+		return r.run(*query)
+	case *file != "":
+		content, err := ioutil.ReadFile(*file)
+		if err != nil {
+			return fmt.Errorf("failed to read the query: %v", err)
+		}
+		return r.run(string(content))
+	}
+	return r.repl()
+}
+
+// runner runs the queries.
+type runner struct {
+	client *github.Client
+	// variables are the values of the variables set on the command line.
+	variables map[string]string
+	// input is read for the queries of the REPL and the prompted variables,
+	// prompts is where the prompts are written.
+	input   *bufio.Reader
+	prompts io.Writer
+	out     io.Writer
+	// requests is where the requests are written if non-nil.
+	requests io.Writer
+	table    bool
+	paginate bool
+}
+
+// repl reads the queries from the input and runs them until the end of the
+// input or "\q". A query is complete once its braces are balanced.
+func (r *runner) repl() error {
+	var lines []string
+	for {
+		if len(lines) == 0 {
+			fmt.Fprint(r.prompts, "ghql> ")
+		} else {
+			fmt.Fprint(r.prompts, "  ... ")
+		}
+		line, err := r.input.ReadString('\n')
+		if err != nil && line == "" {
+			fmt.Fprintln(r.prompts)
+			return nil
+		}
+		if len(lines) == 0 && strings.TrimSpace(line) == "" {
+			continue
+		}
+		if len(lines) == 0 && strings.TrimSpace(line) == `\q` {
+			return nil
+		}
+		lines = append(lines, line)
+		text := strings.Join(lines, "")
+		if !complete(text) {
+			continue
+		}
+		lines = nil
+		if err := r.run(text); err != nil {
+			fmt.Fprintf(r.prompts, "error: %v\n", err)
+		}
+	}
+}
+
+// complete returns whether the text is a complete operation: its braces,
+// outside of the strings and the comments, are balanced.
+func complete(text string) bool {
+	depth, opened := 0, false
+	inString, inComment := false, false
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case inComment:
+			inComment = c != '\n'
+		case inString:
+			if c == '\\' {
+				i++
+			} else if c == '"' {
+				inString = false
+			}
+		case c == '#':
+			inComment = true
+		case c == '"':
+			inString = true
+		case c == '{':
+			depth++
+			opened = true
+		case c == '}':
+			depth--
+		}
+	}
+	return opened && depth <= 0
+}
+
+// variableDefinition is a variable declared by an operation.
+type variableDefinition struct {
+	name, typ string
+}
+
+var variablePattern = regexp.MustCompile(`\$(\w+)\s*:\s*([\w\[\]!]+)`)
+
+// variableDefinitions returns the variables declared in the header of the
+// operation, before its selection set.
+func variableDefinitions(query string) []variableDefinition {
+	header := query
+	if i := strings.Index(query, "{"); i >= 0 {
+		header = query[:i]
+	}
+	var definitions []variableDefinition
+	for _, match := range variablePattern.FindAllStringSubmatch(header, -1) {
+		definitions = append(definitions, variableDefinition{name: match[1], typ: match[2]})
+	}
+	return definitions
+}
+
+// parseValue parses the text of a variable of the type: the numbers and the
+// booleans of the scalar types, JSON for the lists and the input objects, and
+// as-is for the other types. An empty text of a nullable type is null.
+func parseValue(typ, text string) (interface{}, error) {
+	if text == "" && !strings.HasSuffix(typ, "!") {
+		return nil, nil
+	}
+	switch base := strings.TrimSuffix(typ, "!"); {
+	case base == "Int":
+		return strconv.ParseInt(text, 10, 64)
+	case base == "Float":
+		return strconv.ParseFloat(text, 64)
+	case base == "Boolean":
+		return strconv.ParseBool(text)
+	case strings.HasPrefix(base, "[") || strings.HasSuffix(base, "Input"):
+		var value interface{}
+		if err := json.Unmarshal([]byte(text), &value); err != nil {
+			return nil, fmt.Errorf("expected JSON for type %s: %v", typ, err)
+		}
+		return value, nil
+	}
+	return text, nil
+}
+
+// resolveVariables returns the values of the variables of the query, set on
+// the command line or prompted for.
+func (r *runner) resolveVariables(query string) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	for _, definition := range variableDefinitions(query) {
+		text, ok := r.variables[definition.name]
+		if !ok {
+			// The cursor of the pagination starts empty.
+			if r.paginate && isCursor(definition.name) {
+				continue
+			}
+			fmt.Fprintf(r.prompts, "$%s (%s): ", definition.name, definition.typ)
+			line, err := r.input.ReadString('\n')
+			if err != nil && line == "" {
+				return nil, fmt.Errorf("no value for $%s", definition.name)
+			}
+			text = strings.TrimRight(line, "\r\n")
+		}
+		value, err := parseValue(definition.typ, text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for $%s: %v", definition.name, err)
+		}
+		values[definition.name] = value
+	}
+	return values, nil
+}
+
+func isCursor(name string) bool {
+	return name == "cursor" || name == "after"
+}
+
+// run runs the query, following the pagination if enabled, and prints its
+// result.
+func (r *runner) run(query string) error {
+	values, err := r.resolveVariables(query)
+	if err != nil {
+		return err
+	}
+	cursor := ""
+	if r.paginate {
+		for _, definition := range variableDefinitions(query) {
+			if isCursor(definition.name) {
+				cursor = definition.name
+			}
+		}
+		if cursor == "" {
+			return fmt.Errorf("--paginate requires the query to declare $cursor or $after")
+		}
+	}
+
+	data, err := r.query(query, values)
+	if err != nil {
+		return err
+	}
+	if cursor != "" {
+		connection := findConnection(data)
+		if connection == nil {
+			return fmt.Errorf("--paginate requires a connection with a pageInfo in the response")
+		}
+		for pages := 1; ; pages++ {
+			pageInfo, _ := connection["pageInfo"].(map[string]interface{})
+			next, _ := pageInfo["endCursor"].(string)
+			if more, _ := pageInfo["hasNextPage"].(bool); !more || next == "" {
+				break
+			}
+			if pages == maxPages {
+				return fmt.Errorf("more than %d pages of results", maxPages)
+			}
+			values[cursor] = next
+			page, err := r.query(query, values)
+			if err != nil {
+				return err
+			}
+			nextConnection := findConnection(page)
+			if nextConnection == nil {
+				return fmt.Errorf("no connection in page %d", pages+1)
+			}
+			// The items of the pages are merged into the first one.
+			for _, key := range []string{"nodes", "edges"} {
+				if items, ok := nextConnection[key].([]interface{}); ok {
+					existing, _ := connection[key].([]interface{})
+					connection[key] = append(existing, items...)
+				}
+			}
+			connection["pageInfo"] = nextConnection["pageInfo"]
+		}
+	}
+
+	if r.table {
+		return printTable(r.out, data)
+	}
+	content, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode the result: %v", err)
+	}
+	_, err = fmt.Fprintf(r.out, "%s\n", content)
+	return err
+}
+
+// query sends the query and returns the data of the response, keeping the
+// numbers as they were received.
+func (r *runner) query(query string, values map[string]interface{}) (interface{}, error) {
+	if r.requests != nil {
+		content, _ := json.MarshalIndent(map[string]interface{}{"query": query, "variables": values}, "", "  ")
+		fmt.Fprintf(r.requests, "POST %s\n%s\n", r.client.GraphQLURL, content)
+	}
+	var raw json.RawMessage
+	if err := r.client.Query(query, values, &raw); err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var data interface{}
+	if err := decoder.Decode(&data); err != nil {
+		return nil, fmt.Errorf("failed to decode the data: %v", err)
+	}
+	return data, nil
+}
+
+// findConnection returns the first object with a pageInfo in the data, depth
+// first in the order of the keys.
+func findConnection(data interface{}) map[string]interface{} {
+	switch v := data.(type) {
+	case map[string]interface{}:
+		if _, ok := v["pageInfo"]; ok {
+			return v
+		}
+		for _, key := range sortedKeys(v) {
+			if connection := findConnection(v[key]); connection != nil {
+				return connection
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if connection := findConnection(item); connection != nil {
+				return connection
+			}
+		}
+	}
+	return nil
+}
+
+// findRows returns the first list of objects in the data, depth first in the
+// order of the keys. The nodes of the edges are returned for the edges.
+func findRows(data interface{}) []interface{} {
+	switch v := data.(type) {
+	case map[string]interface{}:
+		for _, key := range sortedKeys(v) {
+			if rows := findRows(v[key]); rows != nil {
+				return rows
+			}
+		}
+	case []interface{}:
+		if len(v) == 0 {
+			return nil
+		}
+		if _, ok := v[0].(map[string]interface{}); !ok {
+			return nil
+		}
+		var rows []interface{}
+		for _, item := range v {
+			if edge, ok := item.(map[string]interface{}); ok && edge["node"] != nil {
+				item = edge["node"]
+			}
+			rows = append(rows, item)
+		}
+		return rows
+	}
+	return nil
+}
+
+// flatten flattens the fields of an object into the cells of a row, the
+// nested fields with dotted names and the lists as JSON.
+func flatten(prefix string, v interface{}, row map[string]string) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			name := key
+			if prefix != "" {
+				name = prefix + "." + key
+			}
+			flatten(name, value, row)
+		}
+	case []interface{}:
+		content, _ := json.Marshal(v)
+		row[prefix] = string(content)
+	case nil:
+		row[prefix] = ""
+	default:
+		row[prefix] = fmt.Sprint(v)
+	}
+}
+
+// printTable prints the first list of objects of the data as a table, with a
+// column per field, or the data flattened as a single row if it has none.
+func printTable(out io.Writer, data interface{}) error {
+	items := findRows(data)
+	if items == nil {
+		items = []interface{}{data}
+	}
+	var rows []map[string]string
+	columns := map[string]bool{}
+	for _, item := range items {
+		row := map[string]string{}
+		flatten("", item, row)
+		for column := range row {
+			columns[column] = true
+		}
+		rows = append(rows, row)
+	}
+	names := []string{}
+	for column := range columns {
+		names = append(names, column)
+	}
+	sort.Strings(names)
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, strings.ToUpper(strings.Join(names, "\t")))
+	for _, row := range rows {
+		var cells []string
+		for _, name := range names {
+			cells = append(cells, row[name])
+		}
+		fmt.Fprintln(w, strings.Join(cells, "\t"))
+	}
+	return w.Flush()
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
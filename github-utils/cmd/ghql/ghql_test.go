@@ -0,0 +1,186 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/contrib/github-utils/github"
+)
+
+func TestComplete(t *testing.T) {
+	table := map[string]bool{
+		"query {":                           false,
+		"query { viewer { login } }":        true,
+		"{ viewer {\n login\n":              false,
+		`{ search(query: "a { b") { a } }`:  true,
+		"{ a # }\n":                         false,
+		"query($n: Int!)":                   false,
+		"mutation { addStar(input: {}) { a": false,
+	}
+	for text, expected := range table {
+		if got := complete(text); got != expected {
+			t.Errorf("%q: expected %v but got %v", text, expected, got)
+		}
+	}
+}
+
+func TestParseVariables(t *testing.T) {
+	query := "query($owner: String!, $first: Int = 10, $states: [PullRequestState!], $cursor: String) { repository(owner: $owner) { id } }"
+	expected := []variableDefinition{{"owner", "String!"}, {"first", "Int"}, {"states", "[PullRequestState!]"}, {"cursor", "String"}}
+	if got := variableDefinitions(query); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected the variables %v but got %v", expected, got)
+	}
+
+	values := []struct {
+		typ, text string
+		value     interface{}
+		err       bool
+	}{
+		{typ: "Int!", text: "42", value: int64(42)},
+		{typ: "Int!", text: "many", err: true},
+		{typ: "Boolean", text: "true", value: true},
+		{typ: "String", text: "", value: nil},
+		{typ: "String!", text: "", value: ""},
+		{typ: "[PullRequestState!]", text: `["OPEN"]`, value: []interface{}{"OPEN"}},
+		{typ: "AddStarInput!", text: `{"starrableId": "R_1"}`, value: map[string]interface{}{"starrableId": "R_1"}},
+		{typ: "URI", text: "https://github.com", value: "https://github.com"},
+	}
+	for _, tt := range values {
+		value, err := parseValue(tt.typ, tt.text)
+		if (err != nil) != tt.err || (!tt.err && !reflect.DeepEqual(value, tt.value)) {
+			t.Errorf("%s %q: expected %#v (error %v) but got %#v (%v)", tt.typ, tt.text, tt.value, tt.err, value, err)
+		}
+	}
+}
+
+// issuesServer serves the issues of a repository two per page.
+func issuesServer(t *testing.T, requests *[]map[string]interface{}) *httptest.Server {
+	issues := []string{"first", "second", "third"}
+	return httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		var request struct {
+			Variables map[string]interface{} `json:"variables"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+			t.Errorf("failed to decode the request: %v", err)
+		}
+		*requests = append(*requests, request.Variables)
+		start := 0
+		if cursor, ok := request.Variables["cursor"].(string); ok {
+			fmt.Sscan(cursor, &start)
+		}
+		var nodes []string
+		for i := start; i < start+2 && i < len(issues); i++ {
+			nodes = append(nodes, fmt.Sprintf(`{"number": %d, "title": %q, "author": {"login": "user%d"}}`, 12345678901234567+i, issues[i], i))
+		}
+		fmt.Fprintf(res, `{"data": {"repository": {"issues": {"nodes": [%s], "pageInfo": {"hasNextPage": %v, "endCursor": "%d"}}}}}`,
+			strings.Join(nodes, ","), start+2 < len(issues), start+2)
+	}))
+}
+
+const issuesQuery = `query($owner: String!, $cursor: String) {
+  repository(owner: $owner, name: "contrib") {
+    issues(first: 2, after: $cursor) { nodes { number title author { login } } pageInfo { hasNextPage endCursor } }
+  }
+}`
+
+func TestRun(t *testing.T) {
+	var requests []map[string]interface{}
+	server := issuesServer(t, &requests)
+	defer server.Close()
+	client := github.NewClient("")
+	client.BaseURL, client.Retries = server.URL, 0
+
+	var out, prompts bytes.Buffer
+	r := &runner{
+		client:    client,
+		variables: map[string]string{},
+		// The owner is prompted for.
+		input:    bufio.NewReader(strings.NewReader("kubernetes\n")),
+		prompts:  &prompts,
+		out:      &out,
+		paginate: true,
+		table:    true,
+	}
+	if err := r.run(issuesQuery); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(prompts.String(), "$owner (String!): ") {
+		t.Errorf("expected a prompt for $owner but got %q", prompts.String())
+	}
+	if len(requests) != 2 || requests[0]["owner"] != "kubernetes" || requests[1]["cursor"] != "2" {
+		t.Errorf("expected 2 pages of kubernetes but got the variables %v", requests)
+	}
+	expected := `AUTHOR.LOGIN  NUMBER             TITLE
+user0         12345678901234567  first
+user1         12345678901234568  second
+user2         12345678901234569  third
+`
+	if out.String() != expected {
+		t.Errorf("expected the table\n%s\nbut got\n%s", expected, out.String())
+	}
+
+	// Without a cursor, the pages cannot be followed.
+	if err := r.run(`query { viewer { login } }`); err == nil || !strings.Contains(err.Error(), "$cursor") {
+		t.Errorf("expected an error about the cursor but got %v", err)
+	}
+}
+
+func TestREPL(t *testing.T) {
+	var requests []map[string]interface{}
+	server := issuesServer(t, &requests)
+	defer server.Close()
+	client := github.NewClient("")
+	client.BaseURL, client.Retries = server.URL, 0
+
+	var out, prompts bytes.Buffer
+	r := &runner{
+		client:    client,
+		variables: map[string]string{"owner": "kubernetes", "cursor": ""},
+		input:     bufio.NewReader(strings.NewReader("\n" + issuesQuery + "\n" + `query { viewer }` + "\n\\q\n" + issuesQuery)),
+		prompts:   &prompts,
+		out:       &out,
+	}
+	if err := r.repl(); err != nil {
+		t.Fatal(err)
+	}
+	// The queries after \q are not run.
+	if len(requests) != 2 {
+		t.Errorf("expected 2 queries but got %v", requests)
+	}
+	var result struct {
+		Repository struct {
+			Issues struct {
+				Nodes []struct {
+					Number json.Number `json:"number"`
+				} `json:"nodes"`
+			} `json:"issues"`
+		} `json:"repository"`
+	}
+	decoder := json.NewDecoder(&out)
+	if err := decoder.Decode(&result); err != nil || len(result.Repository.Issues.Nodes) != 2 || result.Repository.Issues.Nodes[0].Number != "12345678901234567" {
+		t.Errorf("expected the first page with the exact numbers but got %+v (%v)", result, err)
+	}
+}
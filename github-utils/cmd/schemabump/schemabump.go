@@ -0,0 +1,243 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// schemabump fetches the live schema of a GraphQL API and, if it differs from
+// the snapshot committed in a repository, commits the new snapshot to a
+// branch and opens a pull request with the GitHub GraphQL API.
+package main
+
+import (
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/contrib/github-utils/github"
+	"k8s.io/contrib/github-utils/schema"
+)
+
+var (
+	endpoint     = flag.String("endpoint", github.APIURL+"/graphql", "The URL of the GraphQL API whose schema is snapshotted")
+	tokenFile    = flag.String("token-file", "", "The path to a token allowed to push branches and open pull requests in the repository")
+	repo         = flag.String("repo", "", "The repository of the snapshot, as owner/name")
+	base         = flag.String("base", "master", "The branch the snapshot is committed on and the pull request is opened against")
+	dir          = flag.String("dir", "schema", "The directory of the snapshot in the repository")
+	branchPrefix = flag.String("branch-prefix", "schema-bump", "The prefix of the branch of the pull request, followed by the hash of the new schema")
+	dryRun       = flag.Bool("dry-run", false, "If true, report whether the schema changed without creating the branch, the commit and the pull request")
+)
+
+// bumper updates the snapshot of a schema in a repository.
+type bumper struct {
+	// schemaClient fetches the schema, client pushes to the repository.
+	schemaClient *github.Client
+	client       *github.Client
+	endpoint     string
+	owner, name  string
+	base, dir    string
+	branchPrefix string
+	dryRun       bool
+	now          func() time.Time
+}
+
+// repositoryState is the state of the repository relevant to a bump.
+type repositoryState struct {
+	Repository *struct {
+		ID   string `json:"id"`
+		Base *struct {
+			Target struct {
+				OID string `json:"oid"`
+			} `json:"target"`
+		} `json:"base"`
+		Head *struct {
+			Name string `json:"name"`
+		} `json:"head"`
+		SDL *struct {
+			Text string `json:"text"`
+		} `json:"sdl"`
+		PullRequests struct {
+			Nodes []struct {
+				URL string `json:"url"`
+			} `json:"nodes"`
+		} `json:"pullRequests"`
+	} `json:"repository"`
+}
+
+const stateQuery = `query($owner: String!, $name: String!, $base: String!, $head: String!, $headName: String!, $sdl: String!) {
+  repository(owner: $owner, name: $name) {
+    id
+    base: ref(qualifiedName: $base) { target { oid } }
+    head: ref(qualifiedName: $head) { name }
+    sdl: object(expression: $sdl) { ... on Blob { text } }
+    pullRequests(headRefName: $headName, states: OPEN, first: 1) { nodes { url } }
+  }
+}`
+
+const createRefMutation = `mutation($input: CreateRefInput!) {
+  createRef(input: $input) { ref { name } }
+}`
+
+const createCommitMutation = `mutation($input: CreateCommitOnBranchInput!) {
+  createCommitOnBranch(input: $input) { commit { oid } }
+}`
+
+const createPullRequestMutation = `mutation($input: CreatePullRequestInput!) {
+  createPullRequest(input: $input) { pullRequest { url } }
+}`
+
+func main() {
+	flag.Parse()
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "schemabump: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	parts := strings.Split(*repo, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("--repo must be owner/name, got %q", *repo)
+	}
+	client, err := github.NewClientFromTokenFile(*tokenFile)
+	if err != nil {
+		return err
+	}
+	if client.Token == "" {
+		return fmt.Errorf("--token-file is required")
+	}
+	schemaClient := github.NewClient(client.Token)
+	schemaClient.GraphQLURL = *endpoint
+	b := &bumper{
+		schemaClient: schemaClient,
+		client:       client,
+		endpoint:     *endpoint,
+		owner:        parts[0],
+		name:         parts[1],
+		base:         *base,
+		dir:          *dir,
+		branchPrefix: *branchPrefix,
+		dryRun:       *dryRun,
+		now:          time.Now,
+	}
+	message, err := b.bump()
+	if err != nil {
+		return err
+	}
+	fmt.Println(message)
+	return nil
+}
+
+// bump fetches the schema and opens a pull request updating the snapshot if
+// its SDL changed. It returns what it did.
+func (b *bumper) bump() (string, error) {
+	introspection, err := schema.Fetch(b.schemaClient)
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", b.endpoint, err)
+	}
+	files, err := schema.Render(b.endpoint, introspection, b.now())
+	if err != nil {
+		return "", err
+	}
+	sdl := string(files[schema.SDLFile])
+	branch := b.branchPrefix + "-" + schema.Hash([]byte(sdl))[:12]
+
+	var state repositoryState
+	if err := b.client.Query(stateQuery, map[string]interface{}{
+		"owner":    b.owner,
+		"name":     b.name,
+		"base":     "refs/heads/" + b.base,
+		"head":     "refs/heads/" + branch,
+		"headName": branch,
+		"sdl":      b.base + ":" + path.Join(b.dir, schema.SDLFile),
+	}, &state); err != nil {
+		return "", fmt.Errorf("failed to get the state of %s/%s: %v", b.owner, b.name, err)
+	}
+	repository := state.Repository
+	if repository == nil {
+		return "", fmt.Errorf("repository %s/%s not found", b.owner, b.name)
+	}
+	if repository.Base == nil {
+		return "", fmt.Errorf("branch %s not found in %s/%s", b.base, b.owner, b.name)
+	}
+	if repository.SDL != nil && repository.SDL.Text == sdl {
+		return "the schema is unchanged", nil
+	}
+	if len(repository.PullRequests.Nodes) > 0 {
+		return "the schema change is already proposed in " + repository.PullRequests.Nodes[0].URL, nil
+	}
+	if repository.Head != nil {
+		return "", fmt.Errorf("branch %s already exists without a pull request, delete it to retry", branch)
+	}
+	if b.dryRun {
+		return fmt.Sprintf("the schema changed, would propose it on branch %s", branch), nil
+	}
+
+	oid := repository.Base.Target.OID
+	if err := b.client.Query(createRefMutation, map[string]interface{}{"input": map[string]interface{}{
+		"repositoryId": repository.ID,
+		"name":         "refs/heads/" + branch,
+		"oid":          oid,
+	}}, &struct{}{}); err != nil {
+		return "", fmt.Errorf("failed to create branch %s: %v", branch, err)
+	}
+
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	additions := []map[string]interface{}{}
+	for _, name := range names {
+		additions = append(additions, map[string]interface{}{
+			"path":     path.Join(b.dir, name),
+			"contents": base64.StdEncoding.EncodeToString(files[name]),
+		})
+	}
+	headline := "Update the GraphQL schema snapshot"
+	body := fmt.Sprintf("Fetched from %s, SDL SHA-256 %s.", b.endpoint, schema.Hash([]byte(sdl)))
+	if err := b.client.Query(createCommitMutation, map[string]interface{}{"input": map[string]interface{}{
+		"branch": map[string]interface{}{
+			"repositoryNameWithOwner": b.owner + "/" + b.name,
+			"branchName":              branch,
+		},
+		"message":         map[string]interface{}{"headline": headline, "body": body},
+		"expectedHeadOid": oid,
+		"fileChanges":     map[string]interface{}{"additions": additions},
+	}}, &struct{}{}); err != nil {
+		return "", fmt.Errorf("failed to commit the snapshot to branch %s: %v", branch, err)
+	}
+
+	var created struct {
+		CreatePullRequest struct {
+			PullRequest struct {
+				URL string `json:"url"`
+			} `json:"pullRequest"`
+		} `json:"createPullRequest"`
+	}
+	if err := b.client.Query(createPullRequestMutation, map[string]interface{}{"input": map[string]interface{}{
+		"repositoryId": repository.ID,
+		"baseRefName":  b.base,
+		"headRefName":  branch,
+		"title":        headline,
+		"body":         body + "\n\nThis pull request was created by schemabump.",
+	}}, &created); err != nil {
+		return "", fmt.Errorf("failed to open the pull request of branch %s: %v", branch, err)
+	}
+	return "the schema change is proposed in " + created.CreatePullRequest.PullRequest.URL, nil
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/contrib/github-utils/github"
+	"k8s.io/contrib/github-utils/schema"
+)
+
+const testIntrospection = `{"__schema": {
+  "queryType": {"name": "Query"},
+  "directives": [],
+  "types": [
+    {"kind": "OBJECT", "name": "Query", "fields": [
+      {"name": "viewer", "args": [], "type": {"kind": "SCALAR", "name": "String"}}
+    ]}
+  ]
+}}`
+
+// fakeGitHub is a GraphQL API serving the schema and the state of a
+// repository, and recording the mutations.
+type fakeGitHub struct {
+	committedSDL string
+	pullRequest  string
+	headExists   bool
+	mutations    []string
+	additions    map[string]string
+}
+
+func (f *fakeGitHub) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	var request struct {
+		Query     string                 `json:"query"`
+		Variables map[string]interface{} `json:"variables"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	data := "{}"
+	switch {
+	case strings.Contains(request.Query, "__schema"):
+		data = testIntrospection
+	case strings.HasPrefix(request.Query, "query("):
+		state := map[string]interface{}{"id": "R_1", "base": map[string]interface{}{"target": map[string]string{"oid": "abc123"}}}
+		if f.committedSDL != "" {
+			state["sdl"] = map[string]string{"text": f.committedSDL}
+		}
+		if f.headExists {
+			state["head"] = map[string]string{"name": request.Variables["headName"].(string)}
+		}
+		nodes := []map[string]string{}
+		if f.pullRequest != "" {
+			nodes = append(nodes, map[string]string{"url": f.pullRequest})
+		}
+		state["pullRequests"] = map[string]interface{}{"nodes": nodes}
+		content, _ := json.Marshal(map[string]interface{}{"repository": state})
+		data = string(content)
+	default:
+		name := strings.Fields(strings.SplitN(request.Query, "{", 3)[1])[0]
+		name = strings.SplitN(name, "(", 2)[0]
+		f.mutations = append(f.mutations, name)
+		input := request.Variables["input"].(map[string]interface{})
+		if name == "createCommitOnBranch" {
+			if input["expectedHeadOid"] != "abc123" {
+				http.Error(res, "unexpected head", http.StatusBadRequest)
+				return
+			}
+			f.additions = map[string]string{}
+			for _, addition := range input["fileChanges"].(map[string]interface{})["additions"].([]interface{}) {
+				addition := addition.(map[string]interface{})
+				content, _ := base64.StdEncoding.DecodeString(addition["contents"].(string))
+				f.additions[addition["path"].(string)] = string(content)
+			}
+		}
+		if name == "createPullRequest" {
+			data = `{"createPullRequest": {"pullRequest": {"url": "https://github.com/kubernetes/contrib/pull/1"}}}`
+		}
+	}
+	res.Write([]byte(`{"data": ` + data + `}`))
+}
+
+func TestBump(t *testing.T) {
+	var introspection schema.Introspection
+	if err := json.Unmarshal([]byte(testIntrospection), &introspection); err != nil {
+		t.Fatal(err)
+	}
+	sdl := introspection.Schema.SDL()
+
+	table := []struct {
+		name      string
+		fake      fakeGitHub
+		dryRun    bool
+		message   string
+		err       string
+		mutations []string
+	}{
+		{name: "unchanged", fake: fakeGitHub{committedSDL: sdl}, message: "unchanged"},
+		{name: "changed", fake: fakeGitHub{committedSDL: "type Query {}"}, message: "proposed in https://github.com/kubernetes/contrib/pull/1", mutations: []string{"createRef", "createCommitOnBranch", "createPullRequest"}},
+		{name: "first snapshot", message: "proposed in", mutations: []string{"createRef", "createCommitOnBranch", "createPullRequest"}},
+		{name: "dry run", dryRun: true, message: "would propose it on branch schema-bump-"},
+		{name: "already proposed", fake: fakeGitHub{pullRequest: "https://github.com/kubernetes/contrib/pull/2"}, message: "already proposed in https://github.com/kubernetes/contrib/pull/2"},
+		{name: "stale branch", fake: fakeGitHub{headExists: true}, err: "already exists"},
+	}
+	for _, tt := range table {
+		server := httptest.NewServer(&tt.fake)
+		client := github.NewClient("token")
+		client.BaseURL, client.Retries = server.URL, 0
+		b := &bumper{
+			schemaClient: client,
+			client:       client,
+			endpoint:     server.URL + "/graphql",
+			owner:        "kubernetes",
+			name:         "contrib",
+			base:         "master",
+			dir:          "github-utils/schema",
+			branchPrefix: "schema-bump",
+			dryRun:       tt.dryRun,
+			now:          func() time.Time { return time.Date(2017, 6, 5, 0, 0, 0, 0, time.UTC) },
+		}
+		message, err := b.bump()
+		server.Close()
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: expected an error containing %q but got %v", tt.name, tt.err, err)
+			}
+			continue
+		}
+		if err != nil || !strings.Contains(message, tt.message) {
+			t.Errorf("%s: expected a message containing %q but got %q (%v)", tt.name, tt.message, message, err)
+		}
+		if strings.Join(tt.fake.mutations, " ") != strings.Join(tt.mutations, " ") {
+			t.Errorf("%s: expected the mutations %v but got %v", tt.name, tt.mutations, tt.fake.mutations)
+		}
+		if len(tt.mutations) > 0 {
+			if tt.fake.additions["github-utils/schema/schema.graphql"] != sdl || len(tt.fake.additions) != 3 {
+				t.Errorf("%s: expected the 3 files of the snapshot to be committed but got %v", tt.name, tt.fake.additions)
+			}
+		}
+	}
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// schemafetch fetches the schema of a GraphQL API by introspection and
+// writes it to a directory as introspection JSON and as SDL, together with
+// the metadata of the snapshot.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"k8s.io/contrib/github-utils/github"
+	"k8s.io/contrib/github-utils/schema"
+)
+
+var (
+	endpoint  = flag.String("endpoint", github.APIURL+"/graphql", "The URL of the GraphQL API")
+	tokenFile = flag.String("token-file", "", "If non-empty, the path to a token authenticating the requests, required by the GitHub API")
+	outputDir = flag.String("output-dir", ".", "The directory the schema is written to")
+)
+
+func main() {
+	flag.Parse()
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "schemafetch: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	client, err := github.NewClientFromTokenFile(*tokenFile)
+	if err != nil {
+		return err
+	}
+	client.GraphQLURL = *endpoint
+	introspection, err := schema.Fetch(client)
+	if err != nil {
+		return fmt.Errorf("%s: %v", *endpoint, err)
+	}
+	files, err := schema.Render(*endpoint, introspection, time.Now())
+	if err != nil {
+		return err
+	}
+	return schema.Write(*outputDir, files)
+}
@@ -0,0 +1,346 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package github is a client for the GitHub REST and GraphQL APIs shared by
+// the tools of this repository.
+package github
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// APIURL is the base URL of the GitHub API.
+	APIURL = "https://api.github.com"
+
+	defaultRetries          = 3
+	defaultRetryWait        = time.Second
+	defaultMaxRateLimitWait = 5 * time.Minute
+	// maxPages bounds the pages followed by List, in case of a pagination
+	// loop.
+	maxPages = 1000
+)
+
+// nextLink matches the URL of the next page in the Link header of a
+// response.
+var nextLink = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// Client is a client for the GitHub API. It throttles the requests, waits for
+// the rate limit to reset when it is exhausted and retries the transient
+// failures. It must be created with NewClient, and is safe for concurrent use.
+type Client struct {
+	// BaseURL is the base URL of the API, APIURL by default.
+	BaseURL string
+	// GraphQLURL is the URL of the GraphQL API, BaseURL followed by
+	// "/graphql" if empty.
+	GraphQLURL string
+	// Token authenticates the requests if non-empty.
+	Token string
+	// UserAgent identifies the tool sending the requests if non-empty, e.g.
+	// to ghproxy.
+	UserAgent  string
+	HTTPClient *http.Client
+	// Retries is the number of times a request is retried after a network
+	// error, a server error or a secondary rate limit.
+	Retries int
+	// RetryWait is the wait before the first retry, doubled at each retry
+	// unless the response has a Retry-After header.
+	RetryWait time.Duration
+	// MinInterval is the minimum time between the start of two requests.
+	MinInterval time.Duration
+	// MaxRateLimitWait is the longest a request waits for the rate limit to
+	// reset, it fails instead if the reset is later.
+	MaxRateLimitWait time.Duration
+
+	lock sync.Mutex
+	// next is the earliest time the next request can be sent.
+	next  time.Time
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+// NewClient returns a client of the GitHub API authenticated with the token,
+// or unauthenticated if it is empty.
+func NewClient(token string) *Client {
+	return &Client{
+		BaseURL:          APIURL,
+		Token:            token,
+		HTTPClient:       &http.Client{Timeout: 30 * time.Second},
+		Retries:          defaultRetries,
+		RetryWait:        defaultRetryWait,
+		MaxRateLimitWait: defaultMaxRateLimitWait,
+		now:              time.Now,
+		sleep:            time.Sleep,
+	}
+}
+
+// NewClientFromTokenFile returns a client authenticated with the token read
+// from the file, or unauthenticated if the path is empty.
+func NewClientFromTokenFile(path string) (*Client, error) {
+	token, err := ReadToken(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(token), nil
+}
+
+// ReadToken reads a GitHub token from the file, ignoring the surrounding
+// whitespace. It returns an empty token if the path is empty.
+func ReadToken(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	token, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the GitHub token: %v", err)
+	}
+	return strings.TrimSpace(string(token)), nil
+}
+
+// APIError is returned for the responses of the API with an unexpected
+// status code.
+type APIError struct {
+	URL        string
+	StatusCode int
+	// Message is the message of the error returned by the API, if any.
+	Message string
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("got status code %d from the GitHub API for %s", e.StatusCode, e.URL)
+	}
+	return fmt.Sprintf("got status code %d from the GitHub API for %s: %s", e.StatusCode, e.URL, e.Message)
+}
+
+// Get gets the path, e.g. "/repos/kubernetes/kubernetes", and decodes the
+// JSON response into v.
+func (c *Client) Get(path string, v interface{}) error {
+	body, _, err := c.do("GET", path, nil)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("failed to decode the response of the GitHub API for %s: %v", path, err)
+	}
+	return nil
+}
+
+// List gets all the pages of a list endpoint, following the next links of
+// the responses, and calls page with the body of each of them in order. It
+// stops at the first error returned by page.
+func (c *Client) List(path string, page func(body []byte) error) error {
+	for pages := 0; path != ""; pages++ {
+		if pages == maxPages {
+			return fmt.Errorf("more than %d pages listed from the GitHub API", maxPages)
+		}
+		body, header, err := c.do("GET", path, nil)
+		if err != nil {
+			return err
+		}
+		if err := page(body); err != nil {
+			return err
+		}
+		path = ""
+		if match := nextLink.FindStringSubmatch(header.Get("Link")); match != nil {
+			path = match[1]
+		}
+	}
+	return nil
+}
+
+// Query runs a query of the GraphQL API with the variables and decodes the
+// data of the response into v. The errors of the response are returned as an
+// error.
+func (c *Client) Query(query string, variables map[string]interface{}, v interface{}) error {
+	url := c.GraphQLURL
+	if url == "" {
+		url = "/graphql"
+	}
+	body, _, err := c.do("POST", url, map[string]interface{}{"query": query, "variables": variables})
+	if err != nil {
+		return err
+	}
+	var response struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return fmt.Errorf("failed to decode the response of the GitHub GraphQL API: %v", err)
+	}
+	if len(response.Errors) > 0 {
+		messages := []string{}
+		for _, e := range response.Errors {
+			messages = append(messages, e.Message)
+		}
+		return fmt.Errorf("the GitHub GraphQL API returned errors: %s", strings.Join(messages, "; "))
+	}
+	if err := json.Unmarshal(response.Data, v); err != nil {
+		return fmt.Errorf("failed to decode the data of the GitHub GraphQL API: %v", err)
+	}
+	return nil
+}
+
+// do sends the request, retrying it after the transient failures, and
+// returns the body and the header of the successful response.
+func (c *Client) do(method, path string, payload interface{}) ([]byte, http.Header, error) {
+	url := path
+	if !strings.HasPrefix(path, "https://") && !strings.HasPrefix(path, "http://") {
+		url = strings.TrimSuffix(c.BaseURL, "/") + path
+	}
+	var content []byte
+	if payload != nil {
+		var err error
+		if content, err = json.Marshal(payload); err != nil {
+			return nil, nil, fmt.Errorf("failed to encode the request to the GitHub API: %v", err)
+		}
+	}
+
+	wait := c.RetryWait
+	for attempt := 0; ; attempt++ {
+		if err := c.throttle(); err != nil {
+			return nil, nil, err
+		}
+		var reader io.Reader
+		if content != nil {
+			reader = bytes.NewReader(content)
+		}
+		body, header, retryAfter, err := c.send(method, url, reader)
+		if err == nil {
+			return body, header, nil
+		}
+		if retryAfter < 0 || attempt >= c.Retries {
+			return nil, nil, err
+		}
+		if retryAfter == 0 {
+			retryAfter = wait
+			wait *= 2
+		}
+		c.sleep(retryAfter)
+	}
+}
+
+// send sends a single request. On failure it returns whether the request can
+// be retried: a negative retryAfter if it can not, otherwise the wait
+// requested by the API or 0 for the default backoff.
+func (c *Client) send(method, url string, body io.Reader) ([]byte, http.Header, time.Duration, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, nil, -1, err
+	}
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "token "+c.Token)
+	}
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+	response, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	defer response.Body.Close()
+	content, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("failed to read the response of the GitHub API for %s: %v", url, err)
+	}
+	exhausted := c.updateRateLimit(response.Header)
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return content, response.Header, 0, nil
+	}
+
+	apiErr := &APIError{URL: url, StatusCode: response.StatusCode}
+	var message struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(content, &message) == nil {
+		apiErr.Message = message.Message
+	}
+	retryAfter := time.Duration(-1)
+	if seconds, err := strconv.Atoi(response.Header.Get("Retry-After")); err == nil {
+		retryAfter = time.Duration(seconds) * time.Second
+	}
+	switch {
+	case response.StatusCode >= 500:
+		if retryAfter < 0 {
+			retryAfter = 0
+		}
+	case response.StatusCode == http.StatusForbidden || response.StatusCode == http.StatusTooManyRequests:
+		// The primary rate limit is waited for by throttle, the
+		// secondary rate limits have a Retry-After header.
+		if exhausted {
+			retryAfter = 0
+		}
+	default:
+		retryAfter = -1
+	}
+	return nil, nil, retryAfter, apiErr
+}
+
+// updateRateLimit delays the next request until the reset of the rate limit
+// if it is exhausted, and returns whether it is.
+func (c *Client) updateRateLimit(header http.Header) bool {
+	if header.Get("X-RateLimit-Remaining") != "0" {
+		return false
+	}
+	reset, err := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return false
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if at := time.Unix(reset, 0); at.After(c.next) {
+		c.next = at
+	}
+	return true
+}
+
+// throttle waits until the next request can be sent, and reserves the slot
+// of the request after it.
+func (c *Client) throttle() error {
+	c.lock.Lock()
+	now := c.now()
+	start := c.next
+	if start.Before(now) {
+		start = now
+	}
+	wait := start.Sub(now)
+	if wait > c.MaxRateLimitWait {
+		c.lock.Unlock()
+		return fmt.Errorf("the GitHub API rate limit is exhausted until %v", start)
+	}
+	c.next = start.Add(c.MinInterval)
+	c.lock.Unlock()
+	if wait > 0 {
+		c.sleep(wait)
+	}
+	return nil
+}
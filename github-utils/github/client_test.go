@@ -0,0 +1,201 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package github
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testClient returns a client of the server which records its sleeps instead
+// of sleeping.
+func testClient(server *httptest.Server, slept *[]time.Duration) *Client {
+	client := NewClient("secret")
+	client.BaseURL = server.URL
+	client.sleep = func(d time.Duration) { *slept = append(*slept, d) }
+	return client
+}
+
+func TestList(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if auth := req.Header.Get("Authorization"); auth != "token secret" {
+			t.Errorf("expected the token to be sent but got %q", auth)
+		}
+		page, _ := strconv.Atoi(req.URL.Query().Get("page"))
+		if page < 2 {
+			res.Header().Set("Link", fmt.Sprintf(`<%s/items?page=%d>; rel="next", <%s/items?page=2>; rel="last"`, server.URL, page+1, server.URL))
+		}
+		fmt.Fprintf(res, "[%d]", page)
+	}))
+	defer server.Close()
+
+	var slept []time.Duration
+	var items []int
+	err := testClient(server, &slept).List("/items", func(body []byte) error {
+		var page []int
+		if err := json.Unmarshal(body, &page); err != nil {
+			return err
+		}
+		items = append(items, page...)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expect := []int{0, 1, 2}; !reflect.DeepEqual(items, expect) {
+		t.Errorf("expected the items %v but got %v", expect, items)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		statuses []int
+		header   http.Header
+		err      bool
+		slept    []time.Duration
+	}{
+		{name: "server errors", statuses: []int{500, 502, 200}, slept: []time.Duration{time.Second, 2 * time.Second}},
+		{name: "too many server errors", statuses: []int{500, 500, 500, 500}, err: true, slept: []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}},
+		{name: "secondary rate limit", statuses: []int{403, 200}, header: http.Header{"Retry-After": {"60"}}, slept: []time.Duration{time.Minute}},
+		{name: "forbidden", statuses: []int{403}, err: true},
+		{name: "not found", statuses: []int{404}, err: true},
+	} {
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			status := tt.statuses[requests]
+			requests++
+			if status != http.StatusOK {
+				for key, values := range tt.header {
+					res.Header()[key] = values
+				}
+				res.WriteHeader(status)
+				fmt.Fprint(res, `{"message": "failed"}`)
+				return
+			}
+			fmt.Fprint(res, `{"name": "kubernetes"}`)
+		}))
+
+		var slept []time.Duration
+		var repo struct {
+			Name string `json:"name"`
+		}
+		err := testClient(server, &slept).Get("/repos/kubernetes/kubernetes", &repo)
+		server.Close()
+		if (err != nil) != tt.err {
+			t.Errorf("%s: expected error %v but got %v", tt.name, tt.err, err)
+		} else if !tt.err && repo.Name != "kubernetes" {
+			t.Errorf("%s: expected the repository to be decoded but got %+v", tt.name, repo)
+		}
+		if requests != len(tt.statuses) {
+			t.Errorf("%s: expected %d requests but got %d", tt.name, len(tt.statuses), requests)
+		}
+		if !reflect.DeepEqual(slept, tt.slept) {
+			t.Errorf("%s: expected the waits %v but got %v", tt.name, tt.slept, slept)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	now := time.Unix(1000, 0)
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		requests++
+		res.Header().Set("X-RateLimit-Remaining", "0")
+		reset := 1000 + requests*60
+		if requests == 3 {
+			reset += 600
+		}
+		res.Header().Set("X-RateLimit-Reset", strconv.Itoa(reset))
+		if requests == 2 {
+			res.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(res, "{}")
+	}))
+	defer server.Close()
+
+	var slept []time.Duration
+	client := testClient(server, &slept)
+	client.now = func() time.Time { return now }
+	client.sleep = func(d time.Duration) {
+		slept = append(slept, d)
+		now = now.Add(d)
+	}
+	client.MaxRateLimitWait = 90 * time.Second
+	var v struct{}
+	// The first request exhausts the rate limit, so the second one waits
+	// for its reset, fails and is retried after the next reset.
+	for i := 0; i < 2; i++ {
+		if err := client.Get("/", &v); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if expect := []time.Duration{time.Minute, time.Second, time.Minute - time.Second}; !reflect.DeepEqual(slept, expect) {
+		t.Errorf("expected the waits %v but got %v", expect, slept)
+	}
+	// The last reset is too far away.
+	if err := client.Get("/", &v); err == nil {
+		t.Errorf("expected an error when the rate limit resets after the maximum wait")
+	}
+}
+
+func TestQuery(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		var request struct {
+			Query     string                 `json:"query"`
+			Variables map[string]interface{} `json:"variables"`
+		}
+		body, _ := ioutil.ReadAll(req.Body)
+		if err := json.Unmarshal(body, &request); err != nil || req.Method != "POST" || req.URL.Path != "/graphql" {
+			t.Errorf("unexpected request %s %s: %s", req.Method, req.URL, body)
+		}
+		if ua := req.Header.Get("User-Agent"); ua != "munger" {
+			t.Errorf("expected the user agent munger but got %q", ua)
+		}
+		if request.Variables["owner"] != "kubernetes" {
+			fmt.Fprint(res, `{"errors": [{"message": "not found"}]}`)
+			return
+		}
+		fmt.Fprint(res, `{"data": {"repository": {"stargazerCount": 5}}}`)
+	}))
+	defer server.Close()
+
+	var slept []time.Duration
+	client := testClient(server, &slept)
+	client.UserAgent = "munger"
+	var data struct {
+		Repository struct {
+			StargazerCount int `json:"stargazerCount"`
+		} `json:"repository"`
+	}
+	query := `query($owner: String!) { repository(owner: $owner, name: "kubernetes") { stargazerCount } }`
+	if err := client.Query(query, map[string]interface{}{"owner": "kubernetes"}, &data); err != nil || data.Repository.StargazerCount != 5 {
+		t.Errorf("expected the data to be decoded but got %+v and error %v", data, err)
+	}
+	if err := client.Query(query, map[string]interface{}{"owner": "other"}, &data); err == nil {
+		t.Errorf("expected the errors of the response to be returned")
+	}
+}
@@ -0,0 +1,15 @@
+module k8s.io/contrib/github-utils
+
+go 1.23
+
+require github.com/prometheus/client_golang v0.8.1-0.20170531130054-e7e903064f5e
+
+require (
+	github.com/beorn7/perks v0.0.0-20160229213445-3ac7bf7a47d1 // indirect
+	github.com/golang/protobuf v1.5.4 // indirect
+	github.com/matttproud/golang_protobuf_extensions v0.0.0-20150406173934-fc2b8d3a73c4 // indirect
+	github.com/prometheus/client_model v0.0.0-20150212101744-fa8ad6fec335 // indirect
+	github.com/prometheus/common v0.0.0-20170427095455-13ba4ddd0caa // indirect
+	github.com/prometheus/procfs v0.0.0-20170519190837-65c1f6f8f0fc // indirect
+	google.golang.org/protobuf v1.36.12 // indirect
+)
@@ -0,0 +1,366 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package schema fetches the schema of a GraphQL API by introspection and
+// renders it as SDL and as a snapshot of files.
+package schema
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// IntrospectionQuery is the standard introspection query of GraphQL.
+const IntrospectionQuery = `query IntrospectionQuery {
+  __schema {
+    queryType { name }
+    mutationType { name }
+    subscriptionType { name }
+    types { ...FullType }
+    directives {
+      name
+      description
+      locations
+      args { ...InputValue }
+    }
+  }
+}
+
+fragment FullType on __Type {
+  kind
+  name
+  description
+  fields(includeDeprecated: true) {
+    name
+    description
+    args { ...InputValue }
+    type { ...TypeRef }
+    isDeprecated
+    deprecationReason
+  }
+  inputFields { ...InputValue }
+  interfaces { ...TypeRef }
+  enumValues(includeDeprecated: true) {
+    name
+    description
+    isDeprecated
+    deprecationReason
+  }
+  possibleTypes { ...TypeRef }
+}
+
+fragment InputValue on __InputValue {
+  name
+  description
+  type { ...TypeRef }
+  defaultValue
+}
+
+fragment TypeRef on __Type {
+  kind
+  name
+  ofType {
+    kind
+    name
+    ofType {
+      kind
+      name
+      ofType {
+        kind
+        name
+        ofType {
+          kind
+          name
+          ofType {
+            kind
+            name
+            ofType {
+              kind
+              name
+            }
+          }
+        }
+      }
+    }
+  }
+}`
+
+// Introspection is the data of the response to the introspection query.
+type Introspection struct {
+	Schema Schema `json:"__schema"`
+}
+
+// Schema is the schema of a GraphQL API, as returned by introspection.
+type Schema struct {
+	QueryType        *TypeName   `json:"queryType"`
+	MutationType     *TypeName   `json:"mutationType"`
+	SubscriptionType *TypeName   `json:"subscriptionType"`
+	Types            []FullType  `json:"types"`
+	Directives       []Directive `json:"directives"`
+}
+
+// TypeName is a reference to a named type.
+type TypeName struct {
+	Name string `json:"name"`
+}
+
+// FullType is a type of the schema.
+type FullType struct {
+	Kind          string       `json:"kind"`
+	Name          string       `json:"name"`
+	Description   string       `json:"description"`
+	Fields        []Field      `json:"fields"`
+	InputFields   []InputValue `json:"inputFields"`
+	Interfaces    []TypeRef    `json:"interfaces"`
+	EnumValues    []EnumValue  `json:"enumValues"`
+	PossibleTypes []TypeRef    `json:"possibleTypes"`
+}
+
+// Field is a field of an object or an interface.
+type Field struct {
+	Name              string       `json:"name"`
+	Description       string       `json:"description"`
+	Args              []InputValue `json:"args"`
+	Type              TypeRef      `json:"type"`
+	IsDeprecated      bool         `json:"isDeprecated"`
+	DeprecationReason string       `json:"deprecationReason"`
+}
+
+// InputValue is an argument or a field of an input object.
+type InputValue struct {
+	Name         string  `json:"name"`
+	Description  string  `json:"description"`
+	Type         TypeRef `json:"type"`
+	DefaultValue *string `json:"defaultValue"`
+}
+
+// EnumValue is a value of an enum.
+type EnumValue struct {
+	Name              string `json:"name"`
+	Description       string `json:"description"`
+	IsDeprecated      bool   `json:"isDeprecated"`
+	DeprecationReason string `json:"deprecationReason"`
+}
+
+// Directive is a directive of the schema.
+type Directive struct {
+	Name        string       `json:"name"`
+	Description string       `json:"description"`
+	Locations   []string     `json:"locations"`
+	Args        []InputValue `json:"args"`
+}
+
+// TypeRef is the type of a field or an argument, possibly wrapped in lists
+// and non-null types.
+type TypeRef struct {
+	Kind   string   `json:"kind"`
+	Name   string   `json:"name"`
+	OfType *TypeRef `json:"ofType"`
+}
+
+func (t TypeRef) String() string {
+	switch {
+	case t.Kind == "NON_NULL" && t.OfType != nil:
+		return t.OfType.String() + "!"
+	case t.Kind == "LIST" && t.OfType != nil:
+		return "[" + t.OfType.String() + "]"
+	}
+	return t.Name
+}
+
+// builtinScalars are the scalars defined by the GraphQL specification, which
+// are not printed in the SDL.
+var builtinScalars = map[string]bool{"String": true, "Int": true, "Float": true, "Boolean": true, "ID": true}
+
+// builtinDirectives are the directives defined by the GraphQL specification.
+var builtinDirectives = map[string]bool{"skip": true, "include": true, "deprecated": true, "specifiedBy": true}
+
+// SDL prints the schema in the GraphQL schema definition language. The types
+// and directives are sorted by name, so that the SDL of the same schema is
+// always the same whatever the order of the introspection.
+func (s *Schema) SDL() string {
+	var blocks []string
+	if schema := s.schemaDefinition(); schema != "" {
+		blocks = append(blocks, schema)
+	}
+
+	directives := append([]Directive{}, s.Directives...)
+	sort.Slice(directives, func(i, j int) bool { return directives[i].Name < directives[j].Name })
+	for _, d := range directives {
+		if builtinDirectives[d.Name] {
+			continue
+		}
+		blocks = append(blocks, description(d.Description, "")+"directive @"+d.Name+arguments(d.Args, "")+" on "+strings.Join(d.Locations, " | "))
+	}
+
+	types := append([]FullType{}, s.Types...)
+	sort.Slice(types, func(i, j int) bool { return types[i].Name < types[j].Name })
+	for _, t := range types {
+		if strings.HasPrefix(t.Name, "__") || builtinScalars[t.Name] {
+			continue
+		}
+		blocks = append(blocks, description(t.Description, "")+typeDefinition(t))
+	}
+	return strings.Join(blocks, "\n\n") + "\n"
+}
+
+// schemaDefinition returns the schema definition, or an empty string if the
+// root types have their default names.
+func (s *Schema) schemaDefinition() string {
+	var roots []string
+	custom := false
+	for _, root := range []struct {
+		operation, defaultName string
+		t                      *TypeName
+	}{
+		{"query", "Query", s.QueryType},
+		{"mutation", "Mutation", s.MutationType},
+		{"subscription", "Subscription", s.SubscriptionType},
+	} {
+		if root.t == nil {
+			continue
+		}
+		if root.t.Name != root.defaultName {
+			custom = true
+		}
+		roots = append(roots, fmt.Sprintf("  %s: %s", root.operation, root.t.Name))
+	}
+	if !custom {
+		return ""
+	}
+	return "schema {\n" + strings.Join(roots, "\n") + "\n}"
+}
+
+func typeDefinition(t FullType) string {
+	switch t.Kind {
+	case "SCALAR":
+		return "scalar " + t.Name
+	case "OBJECT", "INTERFACE":
+		keyword := "type"
+		if t.Kind == "INTERFACE" {
+			keyword = "interface"
+		}
+		var lines []string
+		for _, f := range t.Fields {
+			lines = append(lines, description(f.Description, "  ")+"  "+f.Name+arguments(f.Args, "  ")+": "+f.Type.String()+deprecated(f.IsDeprecated, f.DeprecationReason))
+		}
+		return keyword + " " + t.Name + implements(t.Interfaces) + block(lines)
+	case "UNION":
+		var members []string
+		for _, p := range t.PossibleTypes {
+			members = append(members, p.String())
+		}
+		return "union " + t.Name + " = " + strings.Join(members, " | ")
+	case "ENUM":
+		var lines []string
+		for _, v := range t.EnumValues {
+			lines = append(lines, description(v.Description, "  ")+"  "+v.Name+deprecated(v.IsDeprecated, v.DeprecationReason))
+		}
+		return "enum " + t.Name + block(lines)
+	case "INPUT_OBJECT":
+		var lines []string
+		for _, f := range t.InputFields {
+			lines = append(lines, description(f.Description, "  ")+"  "+inputValue(f))
+		}
+		return "input " + t.Name + block(lines)
+	}
+	return fmt.Sprintf("# unknown kind %s of type %s", t.Kind, t.Name)
+}
+
+func implements(interfaces []TypeRef) string {
+	if len(interfaces) == 0 {
+		return ""
+	}
+	var names []string
+	for _, i := range interfaces {
+		names = append(names, i.String())
+	}
+	return " implements " + strings.Join(names, " & ")
+}
+
+func block(lines []string) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	return " {\n" + strings.Join(lines, "\n") + "\n}"
+}
+
+// arguments prints the arguments on one line, or one per line if any of them
+// has a description.
+func arguments(args []InputValue, indent string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	multiline := false
+	var printed []string
+	for _, a := range args {
+		if a.Description != "" {
+			multiline = true
+		}
+		printed = append(printed, inputValue(a))
+	}
+	if !multiline {
+		return "(" + strings.Join(printed, ", ") + ")"
+	}
+	var lines []string
+	for i, a := range args {
+		lines = append(lines, description(a.Description, indent+"  ")+indent+"  "+printed[i])
+	}
+	return "(\n" + strings.Join(lines, "\n") + "\n" + indent + ")"
+}
+
+func inputValue(v InputValue) string {
+	printed := v.Name + ": " + v.Type.String()
+	if v.DefaultValue != nil {
+		printed += " = " + *v.DefaultValue
+	}
+	return printed
+}
+
+func deprecated(isDeprecated bool, reason string) string {
+	if !isDeprecated {
+		return ""
+	}
+	if reason == "" || reason == "No longer supported" {
+		return " @deprecated"
+	}
+	return " @deprecated(reason: " + quote(reason) + ")"
+}
+
+// description prints the description as a block string followed by a new
+// line, or nothing if it is empty.
+func description(text, indent string) string {
+	if text == "" {
+		return ""
+	}
+	if !strings.Contains(text, "\n") && len(text) < 70 {
+		return indent + quote(text) + "\n"
+	}
+	escaped := strings.Replace(text, `"""`, `\"""`, -1)
+	lines := strings.Split(escaped, "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = indent + line
+		}
+	}
+	return indent + `"""` + "\n" + strings.Join(lines, "\n") + "\n" + indent + `"""` + "\n"
+}
+
+func quote(text string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return `"` + replacer.Replace(text) + `"`
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schema
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testIntrospection = `{"__schema": {
+  "queryType": {"name": "Query"},
+  "mutationType": null,
+  "subscriptionType": null,
+  "directives": [
+    {"name": "include", "locations": ["FIELD"], "args": []},
+    {"name": "preview", "description": "Requires a preview.", "locations": ["FIELD_DEFINITION", "OBJECT"], "args": [
+      {"name": "toggledBy", "type": {"kind": "NON_NULL", "ofType": {"kind": "SCALAR", "name": "String"}}}
+    ]}
+  ],
+  "types": [
+    {"kind": "OBJECT", "name": "Query", "fields": [
+      {"name": "repository", "description": "Lookup a repository.", "args": [
+        {"name": "owner", "type": {"kind": "NON_NULL", "ofType": {"kind": "SCALAR", "name": "String"}}},
+        {"name": "first", "type": {"kind": "SCALAR", "name": "Int"}, "defaultValue": "10"}
+      ], "type": {"kind": "OBJECT", "name": "Repository"}}
+    ], "interfaces": []},
+    {"kind": "SCALAR", "name": "String"},
+    {"kind": "SCALAR", "name": "DateTime", "description": "An ISO-8601 encoded UTC date string."},
+    {"kind": "OBJECT", "name": "__Type", "fields": []},
+    {"kind": "INTERFACE", "name": "Node", "fields": [
+      {"name": "id", "args": [], "type": {"kind": "NON_NULL", "ofType": {"kind": "SCALAR", "name": "ID"}}}
+    ]},
+    {"kind": "OBJECT", "name": "Repository", "interfaces": [{"kind": "INTERFACE", "name": "Node"}], "fields": [
+      {"name": "id", "args": [], "type": {"kind": "NON_NULL", "ofType": {"kind": "SCALAR", "name": "ID"}}},
+      {"name": "topics", "args": [], "type": {"kind": "LIST", "ofType": {"kind": "NON_NULL", "ofType": {"kind": "SCALAR", "name": "String"}}}},
+      {"name": "stars", "args": [], "type": {"kind": "SCALAR", "name": "Int"}, "isDeprecated": true, "deprecationReason": "Use \"stargazers\"."}
+    ]},
+    {"kind": "ENUM", "name": "State", "enumValues": [
+      {"name": "OPEN"},
+      {"name": "MERGED", "isDeprecated": true}
+    ]},
+    {"kind": "UNION", "name": "Item", "possibleTypes": [{"kind": "OBJECT", "name": "Repository"}, {"kind": "OBJECT", "name": "Query"}]},
+    {"kind": "INPUT_OBJECT", "name": "Filter", "inputFields": [
+      {"name": "state", "description": "The state of the\nitems.", "type": {"kind": "ENUM", "name": "State"}, "defaultValue": "OPEN"}
+    ]}
+  ]
+}}`
+
+const expectedSDL = `"Requires a preview."
+directive @preview(toggledBy: String!) on FIELD_DEFINITION | OBJECT
+
+"An ISO-8601 encoded UTC date string."
+scalar DateTime
+
+input Filter {
+  """
+  The state of the
+  items.
+  """
+  state: State = OPEN
+}
+
+union Item = Repository | Query
+
+interface Node {
+  id: ID!
+}
+
+type Query {
+  "Lookup a repository."
+  repository(owner: String!, first: Int = 10): Repository
+}
+
+type Repository implements Node {
+  id: ID!
+  topics: [String!]
+  stars: Int @deprecated(reason: "Use \"stargazers\".")
+}
+
+enum State {
+  OPEN
+  MERGED @deprecated
+}
+`
+
+func TestSDL(t *testing.T) {
+	var introspection Introspection
+	if err := json.Unmarshal([]byte(testIntrospection), &introspection); err != nil {
+		t.Fatalf("failed to decode the introspection: %v", err)
+	}
+	if sdl := introspection.Schema.SDL(); sdl != expectedSDL {
+		t.Errorf("expected the SDL\n%s\nbut got\n%s", expectedSDL, sdl)
+	}
+
+	introspection.Schema.QueryType.Name = "Root"
+	expected := "schema {\n  query: Root\n}"
+	if sdl := introspection.Schema.SDL(); sdl[:len(expected)] != expected {
+		t.Errorf("expected the SDL to start with the schema definition %q but got\n%s", expected, sdl)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	var introspection Introspection
+	if err := json.Unmarshal([]byte(testIntrospection), &introspection); err != nil {
+		t.Fatalf("failed to decode the introspection: %v", err)
+	}
+	now := time.Date(2017, 6, 5, 0, 0, 0, 0, time.UTC)
+	files, err := Render("https://example.com/graphql", &introspection, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	dir := filepath.Join(t.TempDir(), "snapshot")
+	if err := Write(dir, files); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var metadata Metadata
+	content, err := ioutil.ReadFile(filepath.Join(dir, MetadataFile))
+	if err != nil || json.Unmarshal(content, &metadata) != nil {
+		t.Fatalf("failed to read the metadata: %v: %s", err, content)
+	}
+	for name, sum := range map[string]string{IntrospectionFile: metadata.IntrospectionSHA256, SDLFile: metadata.SDLSHA256} {
+		content, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", name, err)
+		}
+		if Hash(content) != sum {
+			t.Errorf("expected the hash of %s to be %s but got %s", name, Hash(content), sum)
+		}
+	}
+	if metadata.Endpoint != "https://example.com/graphql" || !metadata.FetchedAt.Equal(now) {
+		t.Errorf("unexpected metadata %+v", metadata)
+	}
+}
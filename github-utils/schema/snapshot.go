@@ -0,0 +1,105 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schema
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"k8s.io/contrib/github-utils/github"
+)
+
+// The files of a snapshot.
+const (
+	IntrospectionFile = "schema.json"
+	SDLFile           = "schema.graphql"
+	MetadataFile      = "schema.meta.json"
+)
+
+// Metadata describes a snapshot of a schema, so that the changes of the
+// schema can be detected by comparing the hashes.
+type Metadata struct {
+	Endpoint  string    `json:"endpoint"`
+	FetchedAt time.Time `json:"fetchedAt"`
+	// IntrospectionSHA256 is the hash of the introspection JSON file.
+	IntrospectionSHA256 string `json:"introspectionSHA256"`
+	// SDLSHA256 is the hash of the SDL file. The SDL is sorted, so its hash
+	// only changes when the schema does.
+	SDLSHA256 string `json:"sdlSHA256"`
+}
+
+// Fetch runs the introspection query against the GraphQL API of the client.
+func Fetch(client *github.Client) (*Introspection, error) {
+	var introspection Introspection
+	if err := client.Query(IntrospectionQuery, nil, &introspection); err != nil {
+		return nil, fmt.Errorf("failed to fetch the schema: %v", err)
+	}
+	if len(introspection.Schema.Types) == 0 {
+		return nil, fmt.Errorf("the fetched schema has no types")
+	}
+	return &introspection, nil
+}
+
+// Render renders the snapshot of the introspection fetched from the endpoint,
+// keyed by file name.
+func Render(endpoint string, introspection *Introspection, now time.Time) (map[string][]byte, error) {
+	content, err := json.MarshalIndent(introspection, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode the introspection: %v", err)
+	}
+	content = append(content, '\n')
+	sdl := []byte(introspection.Schema.SDL())
+	metadata, err := json.MarshalIndent(Metadata{
+		Endpoint:            endpoint,
+		FetchedAt:           now.UTC(),
+		IntrospectionSHA256: Hash(content),
+		SDLSHA256:           Hash(sdl),
+	}, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode the metadata: %v", err)
+	}
+	return map[string][]byte{
+		IntrospectionFile: content,
+		SDLFile:           sdl,
+		MetadataFile:      append(metadata, '\n'),
+	}, nil
+}
+
+// Write writes the files of a snapshot to the directory.
+func Write(dir string, files map[string][]byte) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create the output directory: %v", err)
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Hash returns the hexadecimal SHA-256 of the content, as in the metadata.
+func Hash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
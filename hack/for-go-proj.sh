@@ -21,6 +21,7 @@ set -o nounset
 CONTRIB_ROOT="$(dirname ${BASH_SOURCE})/.."
 
 godep_projects=$(find "${CONTRIB_ROOT}" -wholename '*Godeps/Godeps.json')
+module_projects=$(find "${CONTRIB_ROOT}" -wholename '*vendor/modules.txt' -not -path '*/vendor/*/vendor/*')
 
 if [ $# -ne 1 ];then
   echo "missing subcommand: [build|install|test]"
@@ -50,3 +51,12 @@ for godep_file in ${godep_projects}; do
     godep go "${CMD}" ./...
   )
 done;
+
+for module_file in ${module_projects}; do
+  (
+    project="${module_file%vendor/modules.txt}"
+    echo "${CMD}ing ${project}"
+    cd "${project}"
+    go "${CMD}" -mod=vendor ./...
+  )
+done;
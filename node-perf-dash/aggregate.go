@@ -0,0 +1,183 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// aggregateFuncs are the functions aggregating the values of a metric over a
+// period, by name. The values are sorted in ascending order.
+var aggregateFuncs = map[string]func(sorted []float64) float64{
+	"mean": func(sorted []float64) float64 {
+		sum := 0.0
+		for _, value := range sorted {
+			sum += value
+		}
+		return sum / float64(len(sorted))
+	},
+	"min": func(sorted []float64) float64 { return sorted[0] },
+	"max": func(sorted []float64) float64 { return sorted[len(sorted)-1] },
+	"p50": func(sorted []float64) float64 { return percentile(sorted, 0.5) },
+	"p90": func(sorted []float64) float64 { return percentile(sorted, 0.9) },
+	"p99": func(sorted []float64) float64 { return percentile(sorted, 0.99) },
+}
+
+// aggregation is the downsampling of the series requested by the "aggregate"
+// and "fn" query parameters.
+type aggregation struct {
+	// Period is the rollup period the points are aggregated over, or empty
+	// if the series are not aggregated.
+	Period string
+	Fn     string
+}
+
+// parseAggregation parses the aggregation from the "aggregate" (daily or
+// weekly) and "fn" (mean by default) query parameters.
+func parseAggregation(req *http.Request) (aggregation, error) {
+	return parseAggregationQuery(req.URL.Query())
+}
+
+// parseAggregationQuery parses the aggregation from the parameters of a
+// query.
+func parseAggregationQuery(query url.Values) (aggregation, error) {
+	var a aggregation
+	switch aggregate := query.Get("aggregate"); aggregate {
+	case "":
+		if query.Get("fn") != "" {
+			return a, fmt.Errorf("fn requires aggregate")
+		}
+		return a, nil
+	case "daily":
+		a.Period = rollupDay
+	case "weekly":
+		a.Period = rollupWeek
+	default:
+		return a, fmt.Errorf("invalid aggregate %q, must be daily or weekly", aggregate)
+	}
+	a.Fn = query.Get("fn")
+	if a.Fn == "" {
+		a.Fn = "mean"
+	}
+	if _, ok := aggregateFuncs[a.Fn]; !ok {
+		return a, fmt.Errorf("invalid fn %q, must be one of mean, min, max, p50, p90 or p99", a.Fn)
+	}
+	return a, nil
+}
+
+// periodValues are the values of a metric in the builds of a period.
+type periodValues struct {
+	values []float64
+	// last is the last build of the period.
+	last int
+}
+
+func (p *periodValues) addBuild(build string) {
+	if n, err := strconv.Atoi(build); err == nil && n > p.last {
+		p.last = n
+	}
+}
+
+// aggregateSeries downsamples the points of the series to one point per period
+// of the aggregation. The builds dropped from the --builds window are included
+// from the rollups of the job, so that the series span the whole history of
+// the metrics: a series of the rollups matching the filter is added even if
+// none of its builds is left in the window. Each point has the timestamp of
+// the beginning of its period, the number of builds aggregated and the last
+// of them; the points without a timestamp are dropped. The SLO breaches are
+// evaluated on the aggregated points, and the annotations of the builds are
+// left out. It must not be called with dataLock held.
+func aggregateSeries(job string, series []*Series, filter seriesFilter, a aggregation) []*Series {
+	periods := map[string]map[int64]*periodValues{}
+	seriesByKey := map[string]*Series{}
+	valuesOf := func(s *Series, start int64) *periodValues {
+		key := s.Key()
+		if _, ok := periods[key]; !ok {
+			periods[key] = map[int64]*periodValues{}
+		}
+		values, ok := periods[key][start]
+		if !ok {
+			values = &periodValues{}
+			periods[key][start] = values
+		}
+		return values
+	}
+	for _, s := range series {
+		seriesByKey[s.Key()] = s
+		for _, point := range s.Points {
+			if point.Timestamp == 0 {
+				continue
+			}
+			values := valuesOf(s, periodStart(a.Period, time.Unix(point.Timestamp, 0)).Unix())
+			values.values = append(values.values, point.Value)
+			values.addBuild(point.Build)
+		}
+	}
+
+	dataLock.RLock()
+	for _, rollup := range allRollups[job] {
+		if rollup.Period != a.Period || !filter.matches(rollup.Test, rollup.Node, rollup.Labels, rollup.Bucket) {
+			continue
+		}
+		key := (&Series{Test: rollup.Test, Node: rollup.Node, Labels: rollup.Labels, Bucket: rollup.Bucket}).Key()
+		s, ok := seriesByKey[key]
+		if !ok {
+			s = &Series{Test: rollup.Test, Node: rollup.Node, Labels: rollup.Labels, Bucket: rollup.Bucket, Unit: rollup.Unit, Owner: lockedOwnerOf(rollup.Test, rollup.Labels)}
+			seriesByKey[key] = s
+		}
+		// A build is aggregated into the rollups once it is dropped
+		// from the --builds window, so it is never counted twice.
+		values := valuesOf(s, rollup.Start)
+		values.values = append(values.values, rollup.Values...)
+		for _, build := range rollup.Builds {
+			values.addBuild(build)
+		}
+	}
+	dataLock.RUnlock()
+
+	fn := aggregateFuncs[a.Fn]
+	result := []*Series{}
+	for key, s := range seriesByKey {
+		var starts []int64
+		for start := range periods[key] {
+			starts = append(starts, start)
+		}
+		sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+		aggregated := *s
+		aggregated.Points = []Point{}
+		aggregated.Annotations = nil
+		for _, start := range starts {
+			values := periods[key][start]
+			sort.Float64s(values.values)
+			aggregated.Points = append(aggregated.Points, Point{
+				Build:     strconv.Itoa(values.last),
+				Value:     fn(values.values),
+				Timestamp: start,
+				Count:     len(values.values),
+			})
+		}
+		aggregated.SLO = evaluateSLO(config.MatchSLO(s.Test, s.Labels, s.Bucket, s.Unit), aggregated.Points)
+		result = append(result, &aggregated)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Key() < result[j].Key() })
+	return result
+}
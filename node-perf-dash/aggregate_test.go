@@ -0,0 +1,134 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/kubernetes/test/e2e/perftype"
+)
+
+// perfData returns the data of a build with the given Perc99 value.
+func perfData(labels map[string]string, value float64, timestamp int64) *DataPerBuild {
+	return &DataPerBuild{
+		Perf:      []perftype.DataItem{{Data: map[string]float64{"Perc99": value}, Unit: "ms", Labels: labels}},
+		Timestamp: timestamp,
+	}
+}
+
+func TestAggregateSeries(t *testing.T) {
+	job := "aggregated"
+	labels := map[string]string{"datatype": "latency"}
+	// Monday 5 June 2017.
+	monday := time.Date(2017, 6, 5, 0, 0, 0, 0, time.UTC)
+	at := func(day, hour int) int64 {
+		return monday.AddDate(0, 0, day).Add(time.Duration(hour) * time.Hour).Unix()
+	}
+	defer func(c *Config) { config = c }(config)
+	max := 25.0
+	config = &Config{SLOs: []*SLOConfig{{Name: "slo", Metric: labels, Bucket: "Perc99", Max: &max}}}
+
+	// Builds 1 and 2 were dropped from the window into the rollups of
+	// Sunday.
+	dataLock.Lock()
+	rollupBuild(job, "test", "node", "1", perfData(labels, 10, at(-1, 1)))
+	rollupBuild(job, "test", "node", "2", perfData(labels, 30, at(-1, 2)))
+	// The rollups of another test do not match the filter.
+	rollupBuild(job, "other", "node", "2", perfData(labels, 30, at(-1, 2)))
+	dataLock.Unlock()
+	defer func() {
+		dataLock.Lock()
+		delete(allRollups, job)
+		delete(dirtyRollups, job)
+		dataLock.Unlock()
+	}()
+
+	series := []*Series{{Test: "test", Node: "node", Labels: labels, Bucket: "Perc99", Unit: "ms", Points: []Point{
+		{Build: "3", Value: 20, Timestamp: at(0, 1)},
+		{Build: "4", Value: 40, Timestamp: at(0, 2)},
+		{Build: "5", Value: 90, Timestamp: at(1, 1)},
+		{Build: "6", Value: 50},
+	}, SLO: &SLOStatus{Name: "slo", Max: &max, Breaches: []string{"4", "5", "6"}}, Annotations: []*Annotation{{Build: "6"}}}}
+	filter := seriesFilter{Test: "test"}
+
+	for _, tt := range []struct {
+		aggregation aggregation
+		expected    []Point
+		// breaches are the aggregated points breaching the SLO.
+		breaches []string
+	}{
+		{
+			aggregation: aggregation{Period: rollupDay, Fn: "mean"},
+			expected: []Point{
+				{Build: "2", Value: 20, Timestamp: at(-1, 0), Count: 2},
+				{Build: "4", Value: 30, Timestamp: at(0, 0), Count: 2},
+				{Build: "5", Value: 90, Timestamp: at(1, 0), Count: 1},
+			},
+			breaches: []string{"4", "5"},
+		},
+		{
+			aggregation: aggregation{Period: rollupWeek, Fn: "max"},
+			expected: []Point{
+				{Build: "2", Value: 30, Timestamp: monday.AddDate(0, 0, -7).Unix(), Count: 2},
+				{Build: "5", Value: 90, Timestamp: at(0, 0), Count: 3},
+			},
+			breaches: []string{"2", "5"},
+		},
+	} {
+		aggregated := aggregateSeries(job, series, filter, tt.aggregation)
+		if len(aggregated) != 1 {
+			t.Fatalf("%+v: expected 1 series but got %d", tt.aggregation, len(aggregated))
+		}
+		if !reflect.DeepEqual(aggregated[0].Points, tt.expected) {
+			t.Errorf("%+v: expected points %+v but got %+v", tt.aggregation, tt.expected, aggregated[0].Points)
+		}
+		if slo := aggregated[0].SLO; slo == nil || !reflect.DeepEqual(slo.Breaches, tt.breaches) {
+			t.Errorf("%+v: expected the breaches %v but got %+v", tt.aggregation, tt.breaches, slo)
+		}
+		if aggregated[0].Annotations != nil {
+			t.Errorf("%+v: expected the annotations of the builds to be left out but got %+v", tt.aggregation, aggregated[0].Annotations)
+		}
+	}
+	if len(series[0].Points) != 4 {
+		t.Errorf("expected the input series not to be modified but got %+v", series[0].Points)
+	}
+}
+
+func TestParseAggregation(t *testing.T) {
+	for _, tt := range []struct {
+		query    string
+		expected aggregation
+		err      bool
+	}{
+		{query: ""},
+		{query: "aggregate=daily", expected: aggregation{Period: rollupDay, Fn: "mean"}},
+		{query: "aggregate=weekly&fn=p99", expected: aggregation{Period: rollupWeek, Fn: "p99"}},
+		{query: "aggregate=monthly", err: true},
+		{query: "aggregate=daily&fn=sum", err: true},
+		{query: "fn=max", err: true},
+	} {
+		got, err := parseAggregation(httptest.NewRequest("GET", "/api/series?"+tt.query, nil))
+		if (err != nil) != tt.err {
+			t.Errorf("%q: expected error %v but got %v", tt.query, tt.err, err)
+		} else if !tt.err && got != tt.expected {
+			t.Errorf("%q: expected %+v but got %+v", tt.query, tt.expected, got)
+		}
+	}
+}
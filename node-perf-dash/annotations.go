@@ -0,0 +1,246 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxAnnotationLength is the maximum length of the text of an annotation.
+const maxAnnotationLength = 4096
+
+// allAnnotations is a map from job to its annotations, in the order they were
+// created. It is protected by dataLock.
+var allAnnotations = map[string][]*Annotation{}
+
+func annotationsKey(job string) string {
+	return "annotations/" + job
+}
+
+// Annotation is a free text note attached to a metric of a build, e.g. "spike
+// caused by infra outage", so that the spikes of the graphs carry the
+// knowledge of what caused them.
+type Annotation struct {
+	ID    string `json:"id"`
+	Build string `json:"build"`
+	// Test, Node, Labels and Bucket select the annotated metrics like the
+	// filters of the series API: an empty node or bucket matches all nodes
+	// or buckets, and the labels match the metrics which have them all.
+	Test   string            `json:"test"`
+	Node   string            `json:"node,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Bucket string            `json:"bucket,omitempty"`
+	Text   string            `json:"text"`
+	// Author is the user authenticated when creating the annotation.
+	Author    string    `json:"author"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// validate checks the annotation submitted through the API.
+func (a *Annotation) validate() error {
+	if n, err := strconv.Atoi(a.Build); err != nil || n <= 0 {
+		return fmt.Errorf("invalid build %q", a.Build)
+	}
+	if a.Test == "" {
+		return fmt.Errorf("test must not be empty")
+	}
+	if strings.TrimSpace(a.Text) == "" {
+		return fmt.Errorf("text must not be empty")
+	}
+	if len(a.Text) > maxAnnotationLength {
+		return fmt.Errorf("text must not exceed %d bytes", maxAnnotationLength)
+	}
+	return nil
+}
+
+// Matches returns whether the annotation applies to the series.
+func (a *Annotation) Matches(s *Series) bool {
+	if a.Test != s.Test || (a.Node != "" && a.Node != s.Node) || (a.Bucket != "" && a.Bucket != s.Bucket) {
+		return false
+	}
+	for key, value := range a.Labels {
+		if s.Labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// newAnnotationID returns a random identifier for a new annotation.
+func newAnnotationID() (string, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// annotateSeries attaches to each series the annotations of the job matching
+// it on one of its builds. It must not be called with dataLock held.
+func annotateSeries(job string, series []*Series) {
+	dataLock.RLock()
+	defer dataLock.RUnlock()
+	if len(allAnnotations[job]) == 0 {
+		return
+	}
+	for _, s := range series {
+		builds := map[string]bool{}
+		for _, point := range s.Points {
+			builds[point.Build] = true
+		}
+		for _, a := range allAnnotations[job] {
+			if builds[a.Build] && a.Matches(s) {
+				s.Annotations = append(s.Annotations, a)
+			}
+		}
+	}
+}
+
+// jobAnnotations returns the annotations of the job, of the build only if it
+// is non-empty.
+func jobAnnotations(job, build string) []*Annotation {
+	dataLock.RLock()
+	defer dataLock.RUnlock()
+	result := []*Annotation{}
+	for _, a := range allAnnotations[job] {
+		if build == "" || a.Build == build {
+			result = append(result, a)
+		}
+	}
+	return result
+}
+
+// saveAnnotations persists the annotations of the job. It must be called with
+// dataLock held.
+func saveAnnotations(job string) error {
+	if store == nil {
+		return nil
+	}
+	return store.Put(annotationsKey(job), allAnnotations[job])
+}
+
+// loadAnnotations loads the annotations of the job from the store. It must be
+// called with dataLock held.
+func loadAnnotations(job string) error {
+	var annotations []*Annotation
+	if err := store.Get(annotationsKey(job), &annotations); err != nil && err != errNotFound {
+		return err
+	}
+	if len(annotations) > 0 {
+		allAnnotations[job] = annotations
+	} else {
+		delete(allAnnotations, job)
+	}
+	return nil
+}
+
+// addAnnotation adds the annotation to the job and persists it.
+func addAnnotation(job string, a *Annotation) error {
+	dataLock.Lock()
+	defer dataLock.Unlock()
+	allAnnotations[job] = append(allAnnotations[job], a)
+	return saveAnnotations(job)
+}
+
+// removeAnnotation removes the annotation with the given ID from the job. It
+// returns false if the job has no such annotation.
+func removeAnnotation(job, id string) (bool, error) {
+	dataLock.Lock()
+	defer dataLock.Unlock()
+	var kept []*Annotation
+	for _, a := range allAnnotations[job] {
+		if a.ID != id {
+			kept = append(kept, a)
+		}
+	}
+	if len(kept) == len(allAnnotations[job]) {
+		return false, nil
+	}
+	allAnnotations[job] = kept
+	return true, saveAnnotations(job)
+}
+
+// serveAnnotations is the HTTP handler for the annotations of the job in the
+// "job" query parameter. GET lists them, of the build in the "build" parameter
+// if it is set. POST creates the annotation in the JSON body and DELETE
+// removes the annotation in the "id" parameter; both require a bearer token
+// from --api-tokens-file.
+func serveAnnotations(res http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	job := query.Get("job")
+	if _, ok := allTestData[job]; !ok {
+		writeError(res, http.StatusNotFound, fmt.Errorf("unknown job %q", job))
+		return
+	}
+	switch req.Method {
+	case http.MethodGet:
+		writeJSON(res, req, jobAnnotations(job, query.Get("build")))
+	case http.MethodPost:
+		user, ok := requireUser(res, req)
+		if !ok {
+			return
+		}
+		a := &Annotation{}
+		if err := json.NewDecoder(req.Body).Decode(a); err != nil {
+			writeError(res, http.StatusBadRequest, fmt.Errorf("failed to decode the annotation: %v", err))
+			return
+		}
+		if err := a.validate(); err != nil {
+			writeError(res, http.StatusBadRequest, err)
+			return
+		}
+		id, err := newAnnotationID()
+		if err != nil {
+			writeError(res, http.StatusInternalServerError, err)
+			return
+		}
+		a.ID, a.Author, a.CreatedAt = id, user, time.Now().UTC()
+		if err := addAnnotation(job, a); err != nil {
+			writeError(res, http.StatusInternalServerError, fmt.Errorf("failed to persist the annotation: %v", err))
+			return
+		}
+		serverLog.Info("Added an annotation", "job", job, "build", a.Build, "id", a.ID, "author", user)
+		writeJSON(res, req, a)
+	case http.MethodDelete:
+		user, ok := requireUser(res, req)
+		if !ok {
+			return
+		}
+		id := query.Get("id")
+		found, err := removeAnnotation(job, id)
+		if err != nil {
+			writeError(res, http.StatusInternalServerError, fmt.Errorf("failed to persist the annotations: %v", err))
+			return
+		}
+		if !found {
+			writeError(res, http.StatusNotFound, fmt.Errorf("job %q has no annotation %q", job, id))
+			return
+		}
+		serverLog.Info("Removed an annotation", "job", job, "id", id, "user", user)
+		writeJSON(res, req, jobAnnotations(job, ""))
+	default:
+		res.Header().Set("Allow", "GET, POST, DELETE")
+		writeError(res, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", req.Method))
+	}
+}
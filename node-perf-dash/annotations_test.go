@@ -0,0 +1,97 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseAPITokens(t *testing.T) {
+	tokens, err := parseAPITokens("# comment\nsecret alice\n\n  other bob  \n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 2 || tokens["secret"] != "alice" || tokens["other"] != "bob" {
+		t.Errorf("expected the tokens of alice and bob but got %v", tokens)
+	}
+	for _, content := range []string{"secret", "secret alice extra", "secret alice\nsecret bob"} {
+		if _, err := parseAPITokens(content); err == nil {
+			t.Errorf("expected an error parsing %q", content)
+		}
+	}
+}
+
+func TestServeAnnotations(t *testing.T) {
+	job := "annotated"
+	allTestData[job] = TestToBuildData{}
+	apiTokens = map[string]string{"secret": "alice"}
+	defer func() {
+		delete(allTestData, job)
+		delete(allAnnotations, job)
+		apiTokens = nil
+	}()
+
+	body := `{"build": "5", "test": "test", "labels": {"datatype": "latency"}, "text": "infra outage"}`
+	for _, tt := range []struct {
+		desc, token string
+		expected    int
+	}{
+		{desc: "no token", expected: http.StatusUnauthorized},
+		{desc: "invalid token", token: "wrong", expected: http.StatusUnauthorized},
+		{desc: "valid token", token: "secret", expected: http.StatusOK},
+	} {
+		req := httptest.NewRequest("POST", "/api/annotations?job="+job, strings.NewReader(body))
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		res := httptest.NewRecorder()
+		serveAnnotations(res, req)
+		if res.Code != tt.expected {
+			t.Errorf("%s: expected status %d but got %d: %s", tt.desc, tt.expected, res.Code, res.Body)
+		}
+	}
+
+	annotations := jobAnnotations(job, "")
+	if len(annotations) != 1 || annotations[0].Author != "alice" || annotations[0].ID == "" {
+		t.Fatalf("expected one annotation by alice but got %+v", annotations)
+	}
+
+	series := []*Series{
+		{Test: "test", Node: "node", Labels: map[string]string{"datatype": "latency"}, Bucket: "Perc99", Points: []Point{{Build: "4"}, {Build: "5"}}},
+		{Test: "test", Node: "node", Labels: map[string]string{"datatype": "throughput"}, Bucket: "Perc99", Points: []Point{{Build: "5"}}},
+		{Test: "test", Node: "node", Labels: map[string]string{"datatype": "latency"}, Bucket: "Perc99", Points: []Point{{Build: "6"}}},
+	}
+	annotateSeries(job, series)
+	for i, expected := range []int{1, 0, 0} {
+		if len(series[i].Annotations) != expected {
+			t.Errorf("expected %d annotations on series %d but got %+v", expected, i, series[i].Annotations)
+		}
+	}
+
+	req := httptest.NewRequest("DELETE", "/api/annotations?job="+job+"&id="+annotations[0].ID, nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	res := httptest.NewRecorder()
+	serveAnnotations(res, req)
+	var remaining []*Annotation
+	if err := json.Unmarshal(res.Body.Bytes(), &remaining); err != nil || res.Code != http.StatusOK || len(remaining) != 0 {
+		t.Errorf("expected the annotation to be removed but got status %d: %s", res.Code, res.Body)
+	}
+}
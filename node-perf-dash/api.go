@@ -0,0 +1,73 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// labelSelector selects the objects having all its labels.
+type labelSelector map[string]string
+
+// parseLabelSelector parses "key=value" requirements, e.g. the values of the
+// "label" query parameter.
+func parseLabelSelector(requirements []string) (labelSelector, error) {
+	selector := labelSelector{}
+	for _, requirement := range requirements {
+		parts := strings.SplitN(requirement, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid label requirement %q, expected <key>=<value>", requirement)
+		}
+		selector[parts[0]] = parts[1]
+	}
+	return selector, nil
+}
+
+// Matches returns true if labels satisfy all requirements of the selector.
+func (s labelSelector) Matches(labels map[string]string) bool {
+	for key, value := range s {
+		if labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// JobDetails describes a configured job.
+type JobDetails struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// serveJobs is the HTTP handler listing the configured jobs. The jobs can be
+// filtered by labels with "label=<key>=<value>" query parameters.
+func serveJobs(res http.ResponseWriter, req *http.Request) {
+	selector, err := parseLabelSelector(req.URL.Query()["label"])
+	if err != nil {
+		writeError(res, http.StatusBadRequest, err)
+		return
+	}
+	jobs := []JobDetails{}
+	for _, job := range config.Jobs {
+		if selector.Matches(job.Labels) {
+			jobs = append(jobs, JobDetails{Name: job.Name, Labels: job.Labels})
+		}
+	}
+	writeJSON(res, req, jobs)
+}
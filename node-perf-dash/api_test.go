@@ -0,0 +1,48 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+)
+
+func TestLabelSelector(t *testing.T) {
+	labels := map[string]string{"provider": "gce", "runtime": "containerd"}
+	table := []struct {
+		requirements []string
+		err          bool
+		expect       bool
+	}{
+		{requirements: nil, expect: true},
+		{requirements: []string{"provider=gce"}, expect: true},
+		{requirements: []string{"provider=gce", "runtime=containerd"}, expect: true},
+		{requirements: []string{"provider=gce", "runtime=docker"}, expect: false},
+		{requirements: []string{"arch=arm64"}, expect: false},
+		{requirements: []string{"provider"}, err: true},
+		{requirements: []string{"=gce"}, err: true},
+	}
+	for _, tt := range table {
+		selector, err := parseLabelSelector(tt.requirements)
+		if (err != nil) != tt.err {
+			t.Errorf("%v: expected error %v but got %v", tt.requirements, tt.err, err)
+			continue
+		}
+		if err == nil && selector.Matches(labels) != tt.expect {
+			t.Errorf("%v: expected %v but got %v", tt.requirements, tt.expect, !tt.expect)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+var (
+	maxArtifactBytes = flag.Int64("max-artifact-bytes", 64<<20, "The maximum size in bytes of an artifact served by /api/artifact. Zero means no limit")
+)
+
+// sniffLength is the number of bytes used to detect the content type of an
+// artifact, see http.DetectContentType.
+const sniffLength = 512
+
+// artifactProxy is the HTTP handler streaming the artifact in the "path"
+// query parameter of the build of the job in the "job" and "build" parameters
+// from the data source, so that the artifacts can be inspected without
+// credentials for the underlying bucket.
+type artifactProxy struct {
+	source Downloader
+}
+
+// cleanArtifactPath returns the cleaned path of an artifact relative to the
+// directory of its build, rejecting paths outside of it.
+func cleanArtifactPath(p string) (string, error) {
+	cleaned := path.Clean("/" + p)[1:]
+	if p == "" || cleaned == "" || strings.Contains(p, "..") || strings.HasPrefix(p, "/") {
+		return "", fmt.Errorf("invalid artifact path %q", p)
+	}
+	return cleaned, nil
+}
+
+// inlineArtifactTypes are the content types of the artifacts which are
+// displayed by the browser. The other artifacts are downloaded, since they are
+// not trusted and some types, e.g. SVG or XHTML, can run scripts.
+var inlineArtifactTypes = map[string]bool{
+	"application/json": true,
+	"text/plain":       true,
+	"image/png":        true,
+	"image/jpeg":       true,
+	"image/gif":        true,
+	"image/webp":       true,
+}
+
+// artifactContentType returns the content type of the artifact from its
+// extension, or else from its first bytes, and whether it is displayed by the
+// browser. Artifacts are never rendered as HTML: the HTML artifacts are
+// displayed as text, and the artifacts of the other types which are not
+// inlineArtifactTypes are served as application/octet-stream.
+func artifactContentType(name string, head []byte) (string, bool) {
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = http.DetectContentType(head)
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	switch {
+	case err != nil:
+		return "application/octet-stream", false
+	case mediaType == "text/html" || mediaType == "text/plain":
+		return "text/plain; charset=utf-8", true
+	case inlineArtifactTypes[mediaType]:
+		return mediaType, true
+	default:
+		return "application/octet-stream", false
+	}
+}
+
+// ServeHTTP is the HTTP handler for serving artifacts.
+func (p *artifactProxy) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	job := query.Get("job")
+	if _, ok := allTestData[job]; !ok {
+		writeError(res, http.StatusNotFound, fmt.Errorf("unknown job %q", job))
+		return
+	}
+	buildNumber, err := strconv.Atoi(query.Get("build"))
+	if err != nil {
+		writeError(res, http.StatusBadRequest, fmt.Errorf("invalid build %q", query.Get("build")))
+		return
+	}
+	artifact, err := cleanArtifactPath(query.Get("path"))
+	if err != nil {
+		writeError(res, http.StatusBadRequest, err)
+		return
+	}
+
+	body, err := p.source.GetFile(job, buildNumber, artifact)
+	if err != nil {
+		serverLog.Debug("Failed to get the artifact", "job", job, "build", buildNumber, "path", artifact, "err", err)
+		writeError(res, http.StatusNotFound, fmt.Errorf("artifact %q of build %d not found", artifact, buildNumber))
+		return
+	}
+	defer body.Close()
+
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(body, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		writeError(res, http.StatusBadGateway, fmt.Errorf("failed to read artifact %q: %v", artifact, err))
+		return
+	}
+	head = head[:n]
+
+	contentType, inline := artifactContentType(artifact, head)
+	res.Header().Set("Content-type", contentType)
+	res.Header().Set("X-Content-Type-Options", "nosniff")
+	// The artifacts are served on the origin of the API, so they must not
+	// run scripts even if the browser renders them.
+	res.Header().Set("Content-Security-Policy", "sandbox")
+	if !inline {
+		res.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(artifact)}))
+	}
+	res.WriteHeader(http.StatusOK)
+	res.Write(head)
+	var rest io.Reader = body
+	if *maxArtifactBytes > 0 {
+		// Read one more byte than allowed to detect oversized artifacts.
+		rest = io.LimitReader(body, *maxArtifactBytes-int64(n)+1)
+	}
+	written, err := io.Copy(res, rest)
+	if err != nil {
+		serverLog.Warn("Failed to stream the artifact", "job", job, "build", buildNumber, "path", artifact, "err", err)
+		panic(http.ErrAbortHandler)
+	}
+	if *maxArtifactBytes > 0 && int64(n)+written > *maxArtifactBytes {
+		// The headers are already sent, abort the response so that the
+		// client does not mistake the truncated artifact for the whole.
+		serverLog.Warn("Aborting an oversized artifact", "job", job, "build", buildNumber, "path", artifact, "max", *maxArtifactBytes)
+		panic(http.ErrAbortHandler)
+	}
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+)
+
+func TestCleanArtifactPath(t *testing.T) {
+	table := []struct {
+		path   string
+		expect string
+		err    bool
+	}{
+		{path: "artifacts/performance-density.json", expect: "artifacts/performance-density.json"},
+		{path: "./artifacts//kubelet.log", expect: "artifacts/kubelet.log"},
+		{path: "", err: true},
+		{path: "/etc/passwd", err: true},
+		{path: "../other-build/started.json", err: true},
+		{path: "artifacts/../../secret", err: true},
+	}
+	for _, tt := range table {
+		got, err := cleanArtifactPath(tt.path)
+		if (err != nil) != tt.err {
+			t.Errorf("%q: expected error %v but got %v", tt.path, tt.err, err)
+			continue
+		}
+		if got != tt.expect {
+			t.Errorf("%q: expected %q but got %q", tt.path, tt.expect, got)
+		}
+	}
+}
+
+func TestArtifactContentType(t *testing.T) {
+	table := []struct {
+		name   string
+		head   string
+		expect string
+		inline bool
+	}{
+		{name: "performance.json", head: "{}", expect: "application/json", inline: true},
+		{name: "artifacts/kubelet", head: "I0612 kubelet started", expect: "text/plain; charset=utf-8", inline: true},
+		{name: "report.html", head: "<html>", expect: "text/plain; charset=utf-8", inline: true},
+		{name: "junit", head: "<html><script>", expect: "text/plain; charset=utf-8", inline: true},
+		{name: "flamegraph.png", head: "\x89PNG", expect: "image/png", inline: true},
+		{name: "flamegraph.svg", head: "<svg><script>", expect: "application/octet-stream"},
+		{name: "report.xhtml", head: "<html><script>", expect: "application/octet-stream"},
+		{name: "junit.xml", head: "<?xml", expect: "application/octet-stream"},
+		{name: "kubelet.tar.gz", head: "\x1f\x8b", expect: "application/octet-stream"},
+	}
+	for _, tt := range table {
+		if got, inline := artifactContentType(tt.name, []byte(tt.head)); got != tt.expect || inline != tt.inline {
+			t.Errorf("%s: expected %q (inline %v) but got %q (inline %v)", tt.name, tt.expect, tt.inline, got, inline)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// auditPrefix is the directory of the audit log in the store, with an
+	// entry per key named after its time, so that the keys sort in
+	// chronological order.
+	auditPrefix    = "audit"
+	auditKeyFormat = "20060102T150405.000000000Z"
+	// maxAuditEntries bounds the entries kept in memory without a store.
+	maxAuditEntries = 10000
+	// maxAuditBodyBytes bounds the request bodies recorded in the entries.
+	maxAuditBodyBytes = 4096
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// AuditEntry is a request recorded in the audit log.
+type AuditEntry struct {
+	Time time.Time `json:"time"`
+	// User is the authenticated user, empty if the request was not
+	// authenticated.
+	User   string `json:"user,omitempty"`
+	Client string `json:"client"`
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	// Parameters are the query parameters of the request.
+	Parameters map[string][]string `json:"parameters,omitempty"`
+	// Body is the JSON body of the request, if it is small enough.
+	Body json.RawMessage `json:"body,omitempty"`
+	// Status is the status code of the response.
+	Status int `json:"status"`
+}
+
+var (
+	auditLock sync.Mutex
+	// auditLog is the audit log without a store, oldest entry first.
+	auditLog []*AuditEntry
+)
+
+// audited returns true if the authenticated requests of the operation are
+// recorded in the audit log.
+func (o *apiOperation) audited() bool {
+	return o.Auth || o.OptionalAuth
+}
+
+// statusRecorder records the status code of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// audited returns the handler of the endpoint recording the authenticated
+// requests of its audited operations in the audit log, once they are served.
+// The unauthenticated requests are not recorded, so that anyone can not fill
+// the log: they are either rejected or anonymous reads.
+func audited(endpoint apiEndpoint) http.Handler {
+	audit := false
+	for _, o := range endpoint.Operations {
+		audit = audit || o.audited()
+	}
+	if !audit {
+		return endpoint.Handler
+	}
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		o := endpoint.Operations[req.Method]
+		user, _ := authenticate(req)
+		if o == nil || !o.audited() || user == "" {
+			endpoint.Handler.ServeHTTP(res, req)
+			return
+		}
+		entry := &AuditEntry{
+			Time:       time.Now().UTC(),
+			User:       user,
+			Client:     clientID(req),
+			Method:     req.Method,
+			Path:       req.URL.Path,
+			Parameters: req.URL.Query(),
+		}
+		if req.Body != nil {
+			body, err := ioutil.ReadAll(http.MaxBytesReader(res, req.Body, *maxRequestBytes))
+			req.Body.Close()
+			if err != nil {
+				code := http.StatusBadRequest
+				if _, ok := err.(*http.MaxBytesError); ok {
+					code = http.StatusRequestEntityTooLarge
+				}
+				writeError(res, code, fmt.Errorf("failed to read the request: %v", err))
+				return
+			}
+			if len(body) <= maxAuditBodyBytes && json.Valid(body) {
+				entry.Body = body
+			}
+			req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+		recorder := &statusRecorder{ResponseWriter: res, status: http.StatusOK}
+		endpoint.Handler.ServeHTTP(recorder, req)
+		entry.Status = recorder.status
+		if err := recordAudit(entry); err != nil {
+			serverLog.Error("Failed to record the audit entry", "user", user, "method", req.Method, "path", req.URL.Path, "err", err)
+		}
+	})
+}
+
+// recordAudit appends the entry to the audit log: in the store if there is
+// one, where the entries are never overwritten, otherwise in memory.
+func recordAudit(entry *AuditEntry) error {
+	serverLog.Info("Audit", "user", entry.User, "client", entry.Client, "method", entry.Method, "path", entry.Path, "status", entry.Status)
+	if store == nil {
+		auditLock.Lock()
+		defer auditLock.Unlock()
+		auditLog = append(auditLog, entry)
+		if len(auditLog) > maxAuditEntries {
+			auditLog = auditLog[len(auditLog)-maxAuditEntries:]
+		}
+		return nil
+	}
+	// The entries recorded at the same time, possibly by other replicas,
+	// are told apart by a sequence number.
+	for n := 0; ; n++ {
+		err := store.Create(fmt.Sprintf("%s/%s-%04d", auditPrefix, entry.Time.Format(auditKeyFormat), n), entry)
+		if err != errExists {
+			return err
+		}
+	}
+}
+
+// auditFilter selects the entries of the audit log.
+type auditFilter struct {
+	user, path   string
+	since, until time.Time
+}
+
+func (f auditFilter) matches(entry *AuditEntry) bool {
+	return (f.user == "" || entry.User == f.user) &&
+		(f.path == "" || entry.Path == f.path) &&
+		(f.since.IsZero() || !entry.Time.Before(f.since)) &&
+		(f.until.IsZero() || entry.Time.Before(f.until))
+}
+
+// auditEntries returns the latest entries of the audit log matching the
+// filter, at most limit of them, newest first.
+func auditEntries(filter auditFilter, limit int) ([]*AuditEntry, error) {
+	entries := []*AuditEntry{}
+	if store == nil {
+		auditLock.Lock()
+		defer auditLock.Unlock()
+		for i := len(auditLog) - 1; i >= 0 && len(entries) < limit; i-- {
+			if filter.matches(auditLog[i]) {
+				entries = append(entries, auditLog[i])
+			}
+		}
+		return entries, nil
+	}
+	keys, err := store.List(auditPrefix)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(keys) - 1; i >= 0 && len(entries) < limit; i-- {
+		// The time in the key skips the entries out of the range
+		// without reading them.
+		at := strings.TrimPrefix(keys[i], auditPrefix+"/")
+		if !filter.until.IsZero() && at >= filter.until.UTC().Format(auditKeyFormat) {
+			continue
+		}
+		if !filter.since.IsZero() && at < filter.since.UTC().Format(auditKeyFormat) {
+			break
+		}
+		entry := &AuditEntry{}
+		if err := store.Get(keys[i], entry); err != nil {
+			return nil, err
+		}
+		if filter.matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// parseAuditTime parses the time in the query parameter, zero if it is not
+// set.
+func parseAuditTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, expected RFC 3339: %v", value, err)
+	}
+	return t, nil
+}
+
+// serveAudit is the HTTP handler listing the latest entries of the audit log,
+// newest first, to the users of --admin-users. The entries can be filtered by
+// "user", "path" and the "since" and "until" times, and are limited to
+// "limit".
+func serveAudit(res http.ResponseWriter, req *http.Request) {
+	if _, ok := requireAdmin(res, req); !ok {
+		return
+	}
+	query := req.URL.Query()
+	filter := auditFilter{user: query.Get("user"), path: query.Get("path")}
+	var err error
+	if filter.since, err = parseAuditTime(query.Get("since")); err != nil {
+		writeError(res, http.StatusBadRequest, err)
+		return
+	}
+	if filter.until, err = parseAuditTime(query.Get("until")); err != nil {
+		writeError(res, http.StatusBadRequest, err)
+		return
+	}
+	limit := defaultAuditLimit
+	if value := query.Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 || limit > maxAuditLimit {
+			writeError(res, http.StatusBadRequest, fmt.Errorf("invalid limit %q, expected 1 to %d", value, maxAuditLimit))
+			return
+		}
+	}
+	entries, err := auditEntries(filter, limit)
+	if err != nil {
+		writeError(res, http.StatusInternalServerError, fmt.Errorf("failed to read the audit log: %v", err))
+		return
+	}
+	writeJSON(res, req, entries)
+}
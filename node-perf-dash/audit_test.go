@@ -0,0 +1,144 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAudit(t *testing.T) {
+	apiTokens = map[string]string{"alice-token": "alice", "bob-token": "bob"}
+	*adminUsers = "carol, alice"
+	defer func() {
+		apiTokens = nil
+		*adminUsers = ""
+		auditLog = nil
+	}()
+
+	// The handler of the endpoint checks that the body is still read.
+	endpoint := apiEndpoint{
+		Path: "/api/test",
+		Handler: http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			body, _ := ioutil.ReadAll(req.Body)
+			if req.Method == http.MethodPost && string(body) != `{"text":"flaky"}` {
+				t.Errorf("expected the body to be passed to the handler but got %q", body)
+			}
+			if req.Method == http.MethodPost {
+				res.WriteHeader(http.StatusCreated)
+			}
+		}),
+		Operations: map[string]*apiOperation{
+			http.MethodGet:    {OptionalAuth: true},
+			http.MethodPost:   {Auth: true},
+			http.MethodDelete: {Auth: true},
+		},
+	}
+	handler := audited(endpoint)
+	request := func(h http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, req)
+		return res
+	}
+
+	for _, backend := range []string{"memory", "store"} {
+		auditLog = nil
+		if backend == "store" {
+			dir, err := ioutil.TempDir("", "node-perf-dash-audit")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			if store, err = NewFileStore(dir); err != nil {
+				t.Fatal(err)
+			}
+			defer func() { store = nil }()
+		}
+
+		request(handler, "POST", "/api/test?job=a", "alice-token", `{"text":"flaky"}`)
+		// The anonymous reads and the unauthenticated requests are
+		// not recorded.
+		request(handler, "GET", "/api/test", "", "")
+		request(handler, "GET", "/api/test?name=perf", "bob-token", "")
+		request(handler, "DELETE", "/api/test?build=3", "", "")
+		request(handler, "DELETE", "/api/test?build=3", "invalid-token", "")
+		request(handler, "DELETE", "/api/test?build=4", "bob-token", "")
+
+		for _, tt := range []struct {
+			name, query, token string
+			code               int
+			// expect are the method, user and status of the
+			// entries.
+			expect []string
+		}{
+			{name: "not an administrator", token: "bob-token", code: http.StatusForbidden},
+			{name: "unauthenticated", code: http.StatusUnauthorized},
+			{name: "all", token: "alice-token", code: http.StatusOK, expect: []string{"DELETE bob 200", "GET bob 200", "POST alice 201"}},
+			{name: "user", query: "user=alice", token: "alice-token", code: http.StatusOK, expect: []string{"POST alice 201"}},
+			{name: "limit", query: "limit=2", token: "alice-token", code: http.StatusOK, expect: []string{"DELETE bob 200", "GET bob 200"}},
+			{name: "future", query: "since=2100-01-01T00:00:00Z", token: "alice-token", code: http.StatusOK, expect: []string{}},
+			{name: "past", query: "until=2000-01-01T00:00:00Z", token: "alice-token", code: http.StatusOK, expect: []string{}},
+			{name: "invalid limit", query: "limit=0", token: "alice-token", code: http.StatusBadRequest},
+			{name: "invalid time", query: "since=yesterday", token: "alice-token", code: http.StatusBadRequest},
+		} {
+			res := request(http.HandlerFunc(serveAudit), "GET", "/api/audit?"+tt.query, tt.token, "")
+			if res.Code != tt.code {
+				t.Errorf("%s, %s: expected status %d but got %d: %s", backend, tt.name, tt.code, res.Code, res.Body)
+				continue
+			}
+			if tt.code != http.StatusOK {
+				continue
+			}
+			var entries []*AuditEntry
+			if err := json.Unmarshal(res.Body.Bytes(), &entries); err != nil {
+				t.Errorf("%s, %s: failed to decode the entries: %v", backend, tt.name, err)
+				continue
+			}
+			got := []string{}
+			for _, entry := range entries {
+				got = append(got, fmt.Sprintf("%s %s %d", entry.Method, entry.User, entry.Status))
+			}
+			if !reflect.DeepEqual(got, tt.expect) {
+				t.Errorf("%s, %s: expected the entries %v but got %v", backend, tt.name, tt.expect, got)
+			}
+			if tt.name == "all" {
+				post := entries[len(entries)-1]
+				if !reflect.DeepEqual(post.Parameters, map[string][]string{"job": {"a"}}) || string(post.Body) != `{"text":"flaky"}` || post.Path != "/api/test" {
+					t.Errorf("%s: expected the parameters and body of the POST to be recorded but got %+v", backend, post)
+				}
+			}
+		}
+	}
+
+	// The body is bounded by --max-request-bytes.
+	defer func(limit int64) { *maxRequestBytes = limit }(*maxRequestBytes)
+	*maxRequestBytes = 8
+	if res := request(handler, "POST", "/api/test", "alice-token", `{"text":"flaky"}`); res.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected a too large body to be rejected but got %d: %s", res.Code, res.Body)
+	}
+}
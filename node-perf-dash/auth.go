@@ -0,0 +1,131 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+var (
+	apiTokensFile = flag.String("api-tokens-file", "", "The path of a file listing the bearer tokens of the users allowed to modify the annotations and saved views, one \"<token> <user>\" per line. If empty, they are read-only")
+	adminUsers    = flag.String("admin-users", "", "The comma separated users of --api-tokens-file allowed to read the audit log")
+)
+
+// apiTokens is a map from bearer token to the user it authenticates. It is
+// loaded from --api-tokens-file on startup.
+var apiTokens map[string]string
+
+// errUnauthenticated is returned by authenticate if the request does not carry
+// a valid token.
+var errUnauthenticated = errors.New("a valid bearer token is required")
+
+// parseAPITokens parses the content of --api-tokens-file. Empty lines and
+// lines starting with "#" are ignored.
+func parseAPITokens(content string) (map[string]string, error) {
+	tokens := map[string]string{}
+	for i, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected \"<token> <user>\"", i+1)
+		}
+		if _, ok := tokens[fields[0]]; ok {
+			return nil, fmt.Errorf("line %d: duplicate token", i+1)
+		}
+		tokens[fields[0]] = fields[1]
+	}
+	return tokens, nil
+}
+
+// loadAPITokensFromFlags loads the tokens from --api-tokens-file, if it is
+// set.
+func loadAPITokensFromFlags() (map[string]string, error) {
+	if *apiTokensFile == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(*apiTokensFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the API tokens: %v", err)
+	}
+	tokens, err := parseAPITokens(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the API tokens in %q: %v", *apiTokensFile, err)
+	}
+	return tokens, nil
+}
+
+// authenticate returns the user authenticated by the bearer token in the
+// Authorization header of req, or errUnauthenticated.
+func authenticate(req *http.Request) (string, error) {
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if token == "" || token == req.Header.Get("Authorization") {
+		return "", errUnauthenticated
+	}
+	// Compare with every token in constant time, so that the response time
+	// does not reveal how much of a token is valid.
+	user := ""
+	for candidate, candidateUser := range apiTokens {
+		if subtle.ConstantTimeCompare([]byte(candidate), []byte(token)) == 1 {
+			user = candidateUser
+		}
+	}
+	if user == "" {
+		return "", errUnauthenticated
+	}
+	return user, nil
+}
+
+// requireUser authenticates req, writing an error response and returning false
+// if it is not authenticated.
+func requireUser(res http.ResponseWriter, req *http.Request) (string, bool) {
+	if apiTokens == nil {
+		writeError(res, http.StatusForbidden, fmt.Errorf("authentication is disabled: --api-tokens-file is not set"))
+		return "", false
+	}
+	user, err := authenticate(req)
+	if err != nil {
+		res.Header().Set("WWW-Authenticate", `Bearer realm="node-perf-dash"`)
+		writeError(res, http.StatusUnauthorized, err)
+		return "", false
+	}
+	return user, true
+}
+
+// requireAdmin authenticates req as one of --admin-users, writing an error
+// response and returning false if it is not.
+func requireAdmin(res http.ResponseWriter, req *http.Request) (string, bool) {
+	user, ok := requireUser(res, req)
+	if !ok {
+		return "", false
+	}
+	for _, admin := range strings.Split(*adminUsers, ",") {
+		if strings.TrimSpace(admin) == user {
+			return user, true
+		}
+	}
+	writeError(res, http.StatusForbidden, fmt.Errorf("user %q is not an administrator", user))
+	return "", false
+}
@@ -0,0 +1,417 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	bigqueryProject     = flag.String("bigquery-project", "", "The Google Cloud project running the queries of the 'bigquery' data source")
+	bigqueryTable       = flag.String("bigquery-table", "", "The table of the 'bigquery' data source, as project.dataset.table, e.g. my-project.perf.artifacts")
+	bigqueryTokenFile   = flag.String("bigquery-token-file", "", "If non-empty, the path to an OAuth2 access token for BigQuery, which is otherwise obtained from the GCE metadata server")
+	bigqueryBatchBuilds = flag.Int("bigquery-batch-builds", 20, "The number of builds of a job whose artifacts are read by each query of the 'bigquery' data source")
+)
+
+const (
+	// bigqueryAPI is the base URL of the BigQuery REST API.
+	bigqueryAPI = "https://bigquery.googleapis.com/bigquery/v2"
+	// metadataTokenURL returns the access token of the default service
+	// account on GCE and GKE.
+	metadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+	// bigqueryTimeout is how long a query waits for its results in a
+	// single request, before they are polled.
+	bigqueryTimeout = 30 * time.Second
+	// maxBigQueryBytes is the maximum size of a BigQuery response, which
+	// may contain all the artifacts of a build.
+	maxBigQueryBytes = 256 << 20
+	// bigqueryBatchTTL is how long the artifacts read by a query are
+	// reused, so that the builds ingested since are read again.
+	bigqueryBatchTTL = 5 * time.Minute
+)
+
+// BigQueryDownloader gets test data from a BigQuery table in which the
+// artifacts of the builds are ingested, one row per artifact, with the
+// columns:
+//
+//	job      STRING  the name of the job
+//	number   INT64   the build number
+//	artifact STRING  the path of the artifact in the build, e.g.
+//	                 "artifacts/performance-<host>.json"
+//	content  STRING  the content of the artifact
+//
+// The table is expected to be partitioned by integer range on number and
+// clustered by job, so that the queries of a range of builds of a job only
+// scan these builds.
+//
+// The artifacts of --bigquery-batch-builds builds, the listed build and the
+// ones before it, are read with a single query and kept for the next builds,
+// which the parser fetches in decreasing order. This is much faster and
+// cheaper than fetching them one by one from GCS when backfilling the
+// history of a job.
+type BigQueryDownloader struct {
+	client  *http.Client
+	apiURL  string
+	project string
+	table   string
+	// batchBuilds is the number of builds read per query.
+	batchBuilds int
+	// token returns the access token to authenticate with.
+	token func() (string, error)
+
+	// lock protects batches, the artifacts last read for each job.
+	lock    sync.Mutex
+	batches map[string]*bigqueryBatch
+}
+
+// bigqueryBatch is the artifacts of a range of builds of a job read by a
+// query.
+type bigqueryBatch struct {
+	first, last int
+	fetched     time.Time
+	// artifacts is a map from build number to the path of each artifact of
+	// the build to its content.
+	artifacts map[int]map[string]string
+}
+
+// NewBigQueryDownloader creates a new BigQueryDownloader querying the table
+// in the given project. The access token is read from tokenFile if it is
+// non-empty, or else from the GCE metadata server.
+func NewBigQueryDownloader(project, table, tokenFile string) *BigQueryDownloader {
+	d := &BigQueryDownloader{
+		client:      &http.Client{Timeout: httpTimeout},
+		apiURL:      bigqueryAPI,
+		project:     project,
+		table:       table,
+		batchBuilds: *bigqueryBatchBuilds,
+		batches:     map[string]*bigqueryBatch{},
+	}
+	if d.batchBuilds < 1 {
+		d.batchBuilds = 1
+	}
+	if tokenFile != "" {
+		d.token = func() (string, error) {
+			data, err := ioutil.ReadFile(tokenFile)
+			if err != nil {
+				return "", fmt.Errorf("failed to read the BigQuery token: %v", err)
+			}
+			return strings.TrimSpace(string(data)), nil
+		}
+	} else {
+		d.token = (&metadataToken{client: d.client}).get
+	}
+	return d
+}
+
+// metadataToken caches the access token of the GCE metadata server until it
+// expires.
+type metadataToken struct {
+	client  *http.Client
+	lock    sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func (m *metadataToken) get() (string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.token != "" && time.Now().Before(m.expires) {
+		return m.token, nil
+	}
+	req, err := http.NewRequest("GET", metadataTokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	response, err := m.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get a token from the metadata server: %v", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("got status code %d getting a token from the metadata server", response.StatusCode)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("failed to parse the token of the metadata server: %v", err)
+	}
+	m.token = token.AccessToken
+	// Refresh the token a minute before it expires.
+	m.expires = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+	return m.token, nil
+}
+
+// bigqueryParameter is a named parameter of a query.
+type bigqueryParameter struct {
+	Name          string `json:"name"`
+	ParameterType struct {
+		Type string `json:"type"`
+	} `json:"parameterType"`
+	ParameterValue struct {
+		Value string `json:"value"`
+	} `json:"parameterValue"`
+}
+
+func stringParameter(name, value string) bigqueryParameter {
+	p := bigqueryParameter{Name: name}
+	p.ParameterType.Type = "STRING"
+	p.ParameterValue.Value = value
+	return p
+}
+
+func intParameter(name string, value int) bigqueryParameter {
+	p := bigqueryParameter{Name: name}
+	p.ParameterType.Type = "INT64"
+	p.ParameterValue.Value = strconv.Itoa(value)
+	return p
+}
+
+// bigqueryResponse is the response of a query or of the request of its
+// results.
+type bigqueryResponse struct {
+	JobComplete  bool `json:"jobComplete"`
+	JobReference struct {
+		JobID    string `json:"jobId"`
+		Location string `json:"location"`
+	} `json:"jobReference"`
+	Rows []struct {
+		F []struct {
+			V *string `json:"v"`
+		} `json:"f"`
+	} `json:"rows"`
+	PageToken string `json:"pageToken"`
+}
+
+// do sends the request to the BigQuery API and decodes its response.
+func (d *BigQueryDownloader) do(method, rawurl string, body interface{}, response interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, rawurl, reader)
+	if err != nil {
+		return err
+	}
+	token, err := d.token()
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	res, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(res.Body, maxBigQueryBytes+1))
+	if err != nil {
+		return fmt.Errorf("failed to read the BigQuery response: %v", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("got status code %d from BigQuery: %s", res.StatusCode, data)
+	}
+	if len(data) > maxBigQueryBytes {
+		return fmt.Errorf("the BigQuery response exceeds %d bytes", maxBigQueryBytes)
+	}
+	return json.Unmarshal(data, response)
+}
+
+// query runs the standard SQL query with the named parameters and returns
+// its rows. The NULL values are returned as empty strings.
+func (d *BigQueryDownloader) query(sql string, parameters ...bigqueryParameter) ([][]string, error) {
+	request := map[string]interface{}{
+		"query":           sql,
+		"useLegacySql":    false,
+		"parameterMode":   "NAMED",
+		"queryParameters": parameters,
+		"timeoutMs":       int64(bigqueryTimeout / time.Millisecond),
+	}
+	var response bigqueryResponse
+	if err := d.do("POST", fmt.Sprintf("%s/projects/%s/queries", d.apiURL, url.PathEscape(d.project)), request, &response); err != nil {
+		return nil, err
+	}
+	var rows [][]string
+	for {
+		if response.JobComplete {
+			for _, row := range response.Rows {
+				var values []string
+				for _, field := range row.F {
+					value := ""
+					if field.V != nil {
+						value = *field.V
+					}
+					values = append(values, value)
+				}
+				rows = append(rows, values)
+			}
+			if response.PageToken == "" {
+				return rows, nil
+			}
+		}
+		// Poll the results until the query is complete, then get the
+		// next page.
+		query := url.Values{"timeoutMs": {strconv.FormatInt(int64(bigqueryTimeout/time.Millisecond), 10)}}
+		if response.JobReference.Location != "" {
+			query.Set("location", response.JobReference.Location)
+		}
+		if response.JobComplete {
+			query.Set("pageToken", response.PageToken)
+		}
+		resultsURL := fmt.Sprintf("%s/projects/%s/queries/%s?%s", d.apiURL, url.PathEscape(d.project), url.PathEscape(response.JobReference.JobID), query.Encode())
+		response = bigqueryResponse{}
+		if err := d.do("GET", resultsURL, nil, &response); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// GetLastestBuildNumber returns the latest build number in the table.
+func (d *BigQueryDownloader) GetLastestBuildNumber(job string) (int, error) {
+	rows, err := d.query(fmt.Sprintf("SELECT MAX(number) FROM `%s` WHERE job = @job", d.table), stringParameter("job", job))
+	if err != nil {
+		return -1, err
+	}
+	if len(rows) == 0 || len(rows[0]) == 0 || rows[0][0] == "" {
+		return -1, fmt.Errorf("no builds found for job %q in %s", job, d.table)
+	}
+	n, err := strconv.Atoi(rows[0][0])
+	if err != nil {
+		return -1, fmt.Errorf("failed to parse the latest build number %q: %v", rows[0][0], err)
+	}
+	downloaderLog.Debug("Read the latest build number", "job", job, "build", n)
+	return n, nil
+}
+
+// ListBuilds returns the builds of the job in the table.
+func (d *BigQueryDownloader) ListBuilds(job string) ([]int, error) {
+	rows, err := d.query(fmt.Sprintf("SELECT DISTINCT number FROM `%s` WHERE job = @job", d.table), stringParameter("job", job))
+	if err != nil {
+		return nil, err
+	}
+	var builds []int
+	for _, row := range rows {
+		if n, err := strconv.Atoi(row[0]); err == nil {
+			builds = append(builds, n)
+		}
+	}
+	return builds, nil
+}
+
+// cachedArtifacts returns the artifacts of the build from the last query of
+// the job, if it read the build recently enough.
+func (d *BigQueryDownloader) cachedArtifacts(job string, buildNumber int) (map[string]string, bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	batch := d.batches[job]
+	if batch == nil || buildNumber < batch.first || buildNumber > batch.last || time.Since(batch.fetched) > bigqueryBatchTTL {
+		return nil, false
+	}
+	return batch.artifacts[buildNumber], true
+}
+
+// readBatch reads the artifacts of the build and of the builds before it,
+// up to batchBuilds builds, and returns those of the build.
+func (d *BigQueryDownloader) readBatch(job string, buildNumber int) (map[string]string, error) {
+	batch := &bigqueryBatch{first: buildNumber - d.batchBuilds + 1, last: buildNumber, fetched: time.Now(), artifacts: map[int]map[string]string{}}
+	if batch.first < 0 {
+		batch.first = 0
+	}
+	rows, err := d.query(fmt.Sprintf("SELECT number, artifact, content FROM `%s` WHERE job = @job AND number BETWEEN @first AND @last", d.table),
+		stringParameter("job", job), intParameter("first", batch.first), intParameter("last", batch.last))
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		n, err := strconv.Atoi(row[0])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the build number %q: %v", row[0], err)
+		}
+		if batch.artifacts[n] == nil {
+			batch.artifacts[n] = map[string]string{}
+		}
+		batch.artifacts[n][row[1]] = row[2]
+	}
+	downloaderLog.Debug("Read the artifacts of builds", "job", job, "first", batch.first, "last", batch.last, "artifacts", len(rows))
+	d.lock.Lock()
+	d.batches[job] = batch
+	d.lock.Unlock()
+	return batch.artifacts[buildNumber], nil
+}
+
+// ListFilesInBuild returns the artifacts with the specified prefix for the
+// test job at the given buildNumber. The contents of all the artifacts of the
+// build, and of the batch of builds before it, are read at the same time, so
+// that getting them does not need more queries.
+func (d *BigQueryDownloader) ListFilesInBuild(job string, buildNumber int, prefix string) ([]string, error) {
+	downloaderLog.Debug("Listing files", "job", job, "build", buildNumber, "prefix", prefix)
+	artifacts, ok := d.cachedArtifacts(job, buildNumber)
+	if !ok {
+		var err error
+		if artifacts, err = d.readBatch(job, buildNumber); err != nil {
+			return nil, err
+		}
+	}
+	filesInBuild := []string{}
+	for artifact := range artifacts {
+		if strings.HasPrefix(artifact, prefix) {
+			filesInBuild = append(filesInBuild, artifact)
+		}
+	}
+	sort.Strings(filesInBuild)
+	return filesInBuild, nil
+}
+
+// GetFile returns readcloser of the desired file, from the artifacts last
+// read if they include this build.
+func (d *BigQueryDownloader) GetFile(job string, buildNumber int, filePath string) (io.ReadCloser, error) {
+	downloaderLog.Debug("Downloading file", "job", job, "build", buildNumber, "path", filePath)
+	artifacts, listed := d.cachedArtifacts(job, buildNumber)
+	content, ok := artifacts[filePath]
+	if !listed {
+		rows, err := d.query(fmt.Sprintf("SELECT content FROM `%s` WHERE job = @job AND number = @number AND artifact = @artifact LIMIT 1", d.table),
+			stringParameter("job", job), intParameter("number", buildNumber), stringParameter("artifact", filePath))
+		if err != nil {
+			return nil, err
+		}
+		if ok = len(rows) > 0; ok {
+			content = rows[0][0]
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("artifact %q of build %d of job %q not found in %s", filePath, buildNumber, job, d.table)
+	}
+	return ioutil.NopCloser(strings.NewReader(content)), nil
+}
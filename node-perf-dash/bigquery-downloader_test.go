@@ -0,0 +1,114 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBigQueryDownloader(t *testing.T) {
+	queries := 0
+	parameters := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer token" {
+			http.Error(res, "unauthenticated", http.StatusUnauthorized)
+			return
+		}
+		// The results of the queries are polled once before they
+		// complete, and returned in two pages.
+		if req.Method == "GET" {
+			if req.URL.Path != "/projects/project/queries/job1" {
+				http.NotFound(res, req)
+			} else if req.URL.Query().Get("pageToken") == "" {
+				res.Write([]byte(`{"jobComplete":true,"jobReference":{"jobId":"job1"},"rows":[{"f":[{"v":"12"},{"v":"artifacts/performance-node1.json"},{"v":"{\"version\":\"v2\"}"}]}],"pageToken":"2"}`))
+			} else {
+				res.Write([]byte(`{"jobComplete":true,"jobReference":{"jobId":"job1"},"rows":[{"f":[{"v":"12"},{"v":"started.json"},{"v":"{}"}]},{"f":[{"v":"11"},{"v":"started.json"},{"v":"{}"}]}]}`))
+			}
+			return
+		}
+		queries++
+		var request struct {
+			Query           string              `json:"query"`
+			QueryParameters []bigqueryParameter `json:"queryParameters"`
+		}
+		json.NewDecoder(req.Body).Decode(&request)
+		for _, p := range request.QueryParameters {
+			parameters[p.Name] = p.ParameterValue.Value
+		}
+		switch {
+		case strings.HasPrefix(request.Query, "SELECT MAX(number)"):
+			res.Write([]byte(`{"jobComplete":true,"rows":[{"f":[{"v":"12"}]}]}`))
+		case strings.HasPrefix(request.Query, "SELECT number, artifact, content"):
+			res.Write([]byte(`{"jobComplete":false,"jobReference":{"jobId":"job1"}}`))
+		default:
+			res.Write([]byte(`{"jobComplete":true}`))
+		}
+	}))
+	defer server.Close()
+	d := NewBigQueryDownloader("project", "dataset.table", "")
+	d.apiURL = server.URL
+	d.batchBuilds = 5
+	d.token = func() (string, error) { return "token", nil }
+
+	if build, err := d.GetLastestBuildNumber("job"); err != nil || build != 12 {
+		t.Errorf("Expected latest build 12 but got %d (%v)", build, err)
+	}
+	listed, err := d.ListFilesInBuild("job", 12, "artifacts/")
+	if expect := []string{"artifacts/performance-node1.json"}; err != nil || !reflect.DeepEqual(listed, expect) {
+		t.Errorf("Expected files %v but got %v (%v)", expect, listed, err)
+	}
+	if parameters["first"] != "8" || parameters["last"] != "12" {
+		t.Errorf("Expected the builds 8 to 12 to be read but got %v", parameters)
+	}
+	// The artifacts of the builds of the batch are listed and read
+	// without another query.
+	queries = 0
+	if listed, err := d.ListFilesInBuild("job", 11, ""); err != nil || !reflect.DeepEqual(listed, []string{"started.json"}) {
+		t.Errorf("Expected the files of build 11 to be listed from the batch but got %v (%v)", listed, err)
+	}
+	if listed, err := d.ListFilesInBuild("job", 9, ""); err != nil || len(listed) != 0 {
+		t.Errorf("Expected no files for build 9 but got %v (%v)", listed, err)
+	}
+	body, err := d.GetFile("job", 12, "artifacts/performance-node1.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadAll(body); string(data) != `{"version":"v2"}` {
+		t.Errorf("Unexpected content %q", data)
+	}
+	if _, err := d.GetFile("job", 12, "artifacts/missing.json"); err == nil {
+		t.Errorf("Expected an error getting a missing artifact")
+	}
+	if queries != 0 {
+		t.Errorf("Expected no queries getting the artifacts of the listed build but got %d", queries)
+	}
+	if _, err := d.GetFile("job", 11, "started.json"); err != nil {
+		t.Errorf("Expected the artifact of build 11 to be read from the batch but got %v", err)
+	}
+	if queries != 0 {
+		t.Errorf("Expected no queries getting the artifacts of the batch but got %d", queries)
+	}
+	if _, err := d.GetFile("job", 7, "started.json"); err == nil || queries != 1 {
+		t.Errorf("Expected a query failing to find the artifact of a build out of the batch but got %d queries (%v)", queries, err)
+	}
+}
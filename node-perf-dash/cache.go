@@ -0,0 +1,386 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+var (
+	storeDir = flag.String("store-dir", "", "If non-empty, persist the parsed data in this directory so that it survives restarts")
+)
+
+const testInfoKey = "testinfo"
+
+var (
+	// store keeps the persistent cache of the parsed data. It is nil if
+	// --store-dir is not set.
+	store Store
+
+	// dirtyBuilds is a map from job to the builds which have been updated
+	// since the last flush of the persistent cache. It is protected by
+	// dataLock.
+	dirtyBuilds = map[string]map[string]bool{}
+
+	// flushLock serializes the flushes of the persistent cache.
+	flushLock sync.Mutex
+)
+
+// buildSnapshot is the persisted data of a build. It is a map from test to
+// node to the data of the build.
+type buildSnapshot map[string]map[string]*DataPerBuild
+
+// jobState is the persisted data collection state of a job.
+type jobState struct {
+	// LastBuild is the last build grabbed for the job.
+	LastBuild int `json:"lastBuild"`
+	// Scan is the progress of the unfinished scan of the job, if any.
+	Scan *scanCheckpoint `json:"scan,omitempty"`
+}
+
+func buildsKey(job string) string {
+	return "builds/" + job
+}
+
+func buildKey(job, build string) string {
+	return buildsKey(job) + "/" + build
+}
+
+func jobStateKey(job string) string {
+	return "jobs/" + job
+}
+
+// markBuildDirty records that the data of the build has changed and needs to
+// be flushed. It must be called with dataLock held.
+func markBuildDirty(job, build string) {
+	if store == nil {
+		return
+	}
+	if _, ok := dirtyBuilds[job]; !ok {
+		dirtyBuilds[job] = map[string]bool{}
+	}
+	dirtyBuilds[job][build] = true
+}
+
+// snapshotBuild extracts the data of the build from testData. It must be
+// called with dataLock held.
+func snapshotBuild(testData TestToBuildData, build string) buildSnapshot {
+	snapshot := buildSnapshot{}
+	for test, dataPerTest := range testData {
+		for node, dataPerNode := range dataPerTest.Data {
+			if data, ok := dataPerNode[build]; ok {
+				if _, ok := snapshot[test]; !ok {
+					snapshot[test] = map[string]*DataPerBuild{}
+				}
+				snapshot[test][node] = data
+			}
+		}
+	}
+	return snapshot
+}
+
+// buildsInMemory returns the builds of testData which have data for at least
+// one test. It must be called with dataLock held.
+func buildsInMemory(testData TestToBuildData) map[string]bool {
+	result := map[string]bool{}
+	for _, dataPerTest := range testData {
+		for _, dataPerNode := range dataPerTest.Data {
+			for build := range dataPerNode {
+				result[build] = true
+			}
+		}
+	}
+	return result
+}
+
+// sortBuildKeys sorts the keys of builds by build number in ascending order.
+func sortBuildKeys(keys []string) {
+	sort.Slice(keys, func(i, j int) bool {
+		a, _ := strconv.Atoi(path.Base(keys[i]))
+		b, _ := strconv.Atoi(path.Base(keys[j]))
+		return a < b
+	})
+}
+
+// loadCache populates allTestData, allTestInfo and allGrabbedLastBuild for the
+// given jobs from the persistent cache.
+func loadCache(jobs []string) error {
+	if store == nil {
+		return nil
+	}
+	dataLock.Lock()
+	defer dataLock.Unlock()
+
+	info := TestInfo{Info: map[string]string{}}
+	if err := store.Get(testInfoKey, &info); err != nil && err != errNotFound {
+		return err
+	}
+	for test, desc := range info.Info {
+		allTestInfo.Info[test] = desc
+	}
+	if err := loadViews(); err != nil {
+		return err
+	}
+	if err := loadPullVerdicts(); err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		state := jobState{}
+		if err := store.Get(jobStateKey(job), &state); err != nil && err != errNotFound {
+			return err
+		}
+		allGrabbedLastBuild[job] = state.LastBuild
+		if state.Scan != nil {
+			allScans[job] = state.Scan
+		}
+
+		// Load the rollups first, so that the builds dropped from the
+		// --builds window while loading are not aggregated twice.
+		var rollups []*Rollup
+		if err := store.Get(rollupsKey(job), &rollups); err != nil && err != errNotFound {
+			return err
+		}
+		setRollups(job, rollups)
+
+		if err := loadGolden(job); err != nil {
+			return err
+		}
+		if err := loadAnnotations(job); err != nil {
+			return err
+		}
+		if err := loadUnparsed(job); err != nil {
+			return err
+		}
+
+		keys, err := store.List(buildsKey(job))
+		if err != nil {
+			return err
+		}
+		// Load the builds in order, so that only the newest --builds
+		// builds are kept.
+		sortBuildKeys(keys)
+		for _, key := range keys {
+			if err := loadBuild(job, key); err != nil {
+				return err
+			}
+		}
+		mainLog.Info("Loaded the persistent cache", "job", job, "builds", len(keys), "lastBuild", state.LastBuild)
+	}
+	return nil
+}
+
+// loadBuild merges the persisted build under key into the data of the job. It
+// must be called with dataLock held.
+func loadBuild(job, key string) error {
+	snapshot := buildSnapshot{}
+	if err := store.Get(key, &snapshot); err != nil {
+		return err
+	}
+	build := path.Base(key)
+	for test, dataPerNode := range snapshot {
+		for node, data := range dataPerNode {
+			// Apply the current unit configuration to the builds
+			// parsed before it changed.
+			for i := range data.Perf {
+				normalizeDataItem(&data.Perf[i])
+			}
+			allTestData[job].GetDataPerBuild(job, build, test, node)
+			allTestData[job][test].Data[node][build] = data
+			removeStaledBuilds(allTestData[job], job, test, node, build)
+		}
+	}
+	if len(snapshot) > 0 {
+		buildCache.touch(buildRef{Job: job, Build: build})
+		buildCache.evictExcess()
+	}
+	return nil
+}
+
+// syncCache loads into memory the data that another replica persisted since
+// the last load, for the given jobs. It is used by the standby replicas to
+// follow the leader, which is the only one fetching and persisting builds.
+// The data of a build never changes once persisted, so only the builds which
+// are not in memory yet are loaded.
+func syncCache(jobs []string) error {
+	if store == nil {
+		return nil
+	}
+	dataLock.Lock()
+	defer dataLock.Unlock()
+
+	info := TestInfo{Info: map[string]string{}}
+	if err := store.Get(testInfoKey, &info); err != nil && err != errNotFound {
+		return err
+	}
+	for test, desc := range info.Info {
+		allTestInfo.Info[test] = desc
+	}
+	if err := loadViews(); err != nil {
+		return err
+	}
+	if err := loadPullVerdicts(); err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		state := jobState{}
+		if err := store.Get(jobStateKey(job), &state); err != nil && err != errNotFound {
+			return err
+		}
+		allGrabbedLastBuild[job] = state.LastBuild
+		if state.Scan != nil {
+			allScans[job] = state.Scan
+		} else {
+			delete(allScans, job)
+		}
+
+		keys, err := store.List(buildsKey(job))
+		if err != nil {
+			return err
+		}
+		sortBuildKeys(keys)
+		retained := retainedBuilds(job)
+		loaded := 0
+		for _, key := range keys {
+			if retained[path.Base(key)] {
+				continue
+			}
+			if err := loadBuild(job, key); err != nil {
+				return err
+			}
+			loaded++
+		}
+
+		// Replace the rollups after loading the builds, so that the
+		// builds dropped from the --builds window while loading are
+		// not aggregated twice: the leader already aggregated them.
+		var rollups []*Rollup
+		if err := store.Get(rollupsKey(job), &rollups); err != nil && err != errNotFound {
+			return err
+		}
+		setRollups(job, rollups)
+
+		if err := loadGolden(job); err != nil {
+			return err
+		}
+		if err := loadAnnotations(job); err != nil {
+			return err
+		}
+		if err := loadUnparsed(job); err != nil {
+			return err
+		}
+		if loaded > 0 {
+			mainLog.Debug("Loaded the builds persisted by the leader", "job", job, "builds", loaded, "lastBuild", state.LastBuild)
+		}
+	}
+	return nil
+}
+
+// flushCache writes the builds and rollups updated since the last flush, the
+// test descriptions and the collection state of all jobs to the persistent
+// cache.
+// The builds which are no longer within the --builds window are removed from
+// the cache.
+func flushCache() error {
+	if store == nil {
+		return nil
+	}
+	flushLock.Lock()
+	defer flushLock.Unlock()
+
+	// Take a snapshot of the data to flush, then write it without holding
+	// dataLock. The data of a build is never modified once it is merged,
+	// so the snapshot can be encoded safely.
+	dataLock.Lock()
+	snapshots := map[string]buildSnapshot{}
+	for job, builds := range dirtyBuilds {
+		for build := range builds {
+			snapshots[buildKey(job, build)] = snapshotBuild(allTestData[job], build)
+		}
+	}
+	dirtyBuilds = map[string]map[string]bool{}
+	rollups := map[string][]Rollup{}
+	for job := range dirtyRollups {
+		rollups[job] = copyRollups(job)
+	}
+	dirtyRollups = map[string]bool{}
+	info := TestInfo{Info: map[string]string{}}
+	for test, desc := range allTestInfo.Info {
+		info.Info[test] = desc
+	}
+	states := map[string]jobState{}
+	for job := range allTestData {
+		states[job] = currentJobState(job)
+	}
+	dataLock.Unlock()
+
+	var errs []error
+	for key, snapshot := range snapshots {
+		if err := store.Put(key, snapshot); err != nil {
+			errs = append(errs, err)
+			// Retry in the next flush.
+			dataLock.Lock()
+			markBuildDirty(path.Base(path.Dir(key)), path.Base(key))
+			dataLock.Unlock()
+		}
+	}
+	for job, jobRollups := range rollups {
+		if err := store.Put(rollupsKey(job), jobRollups); err != nil {
+			errs = append(errs, err)
+			dataLock.Lock()
+			dirtyRollups[job] = true
+			dataLock.Unlock()
+		}
+	}
+	if err := store.Put(testInfoKey, info); err != nil {
+		errs = append(errs, err)
+	}
+	for job, state := range states {
+		if err := store.Put(jobStateKey(job), state); err != nil {
+			errs = append(errs, err)
+		}
+		keys, err := store.List(buildsKey(job))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		// The retained builds are only known under dataLock, which is
+		// held until the stale builds are deleted: a build merged since
+		// the snapshot may have been evicted to the store meanwhile,
+		// and must not be deleted.
+		dataLock.RLock()
+		kept := retainedBuilds(job)
+		for _, key := range keys {
+			if !kept[path.Base(key)] {
+				if err := store.Delete(key); err != nil {
+					errs = append(errs, err)
+				}
+			}
+		}
+		dataLock.RUnlock()
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to flush the persistent cache: %v", errs)
+	}
+	return nil
+}
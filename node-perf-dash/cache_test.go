@@ -0,0 +1,145 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestFlushLoadCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "node-perf-dash-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if store, err = NewFileStore(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { store = nil }()
+
+	job := "cache"
+	allTestData[job] = TestToBuildData{}
+	defer func() {
+		delete(allTestData, job)
+		delete(allGrabbedLastBuild, job)
+		delete(allRollups, job)
+	}()
+	for _, build := range []string{"1", "2"} {
+		buildData := TestToBuildData{}
+		buildData.GetDataPerBuild(job, build, "test", "node").Version = "v" + build
+		mergeBuildData(allTestData[job], &TestInfo{Info: map[string]string{}}, job, build, buildData, &TestInfo{})
+	}
+	allGrabbedLastBuild[job] = 2
+	if err := flushCache(); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := store.List(buildsKey(job))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sortBuildKeys(keys)
+	if expected := []string{"builds/cache/1", "builds/cache/2"}; !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Expected keys %v but got %v", expected, keys)
+	}
+
+	// A restart loads the flushed builds and collection state.
+	allTestData[job] = TestToBuildData{}
+	allGrabbedLastBuild[job] = 0
+	if err := loadCache([]string{job}); err != nil {
+		t.Fatal(err)
+	}
+	if allGrabbedLastBuild[job] != 2 {
+		t.Errorf("Expected the last build 2 but got %d", allGrabbedLastBuild[job])
+	}
+	for _, build := range []string{"1", "2"} {
+		if data := allTestData[job]["test"].Data["node"][build]; data == nil || data.Version != "v"+build {
+			t.Errorf("Expected version v%s for build %s but got %+v", build, build, data)
+		}
+	}
+
+	// The builds dropped from memory are removed by the next flush.
+	delete(allTestData[job]["test"].Data["node"], "1")
+	if err := flushCache(); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err = store.List(buildsKey(job)); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"builds/cache/2"}; !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Expected keys %v after the flush but got %v", expected, keys)
+	}
+}
+
+// listHookStore runs the hook before the first listing of the store.
+type listHookStore struct {
+	Store
+	hook func()
+}
+
+func (s *listHookStore) List(prefix string) ([]string, error) {
+	if hook := s.hook; hook != nil {
+		s.hook = nil
+		hook()
+	}
+	return s.Store.List(prefix)
+}
+
+func TestFlushCacheKeepsEvictedBuilds(t *testing.T) {
+	dir, err := ioutil.TempDir("", "node-perf-dash-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileStore, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buildCache = newBuildLRU()
+	buildCache.capacity = 1
+	defer func() {
+		store = nil
+		buildCache = newBuildLRU()
+	}()
+
+	job := "evicted"
+	allTestData[job] = TestToBuildData{}
+	defer func() {
+		delete(allTestData, job)
+		delete(allRollups, job)
+	}()
+	merge := func(build string) {
+		buildData := TestToBuildData{}
+		buildData.GetDataPerBuild(job, build, "test", "node").Version = "v" + build
+		mergeBuildData(allTestData[job], &TestInfo{Info: map[string]string{}}, job, build, buildData, &TestInfo{})
+	}
+	// Builds 2 and 3 are merged while the cache is flushed, and build 2 is
+	// evicted to the store.
+	store = &listHookStore{Store: fileStore, hook: func() {
+		merge("2")
+		merge("3")
+	}}
+	merge("1")
+	if err := flushCache(); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Get(buildKey(job, "2"), &buildSnapshot{}); err != nil {
+		t.Errorf("expected the evicted build 2 to be kept in the store but got %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Exit codes of the "check" subcommand.
+const (
+	checkPassed    = 0
+	checkRegressed = 1
+	checkFailed    = 2
+)
+
+// checkCommand implements the "check" subcommand, meant to gate CI: it fetches
+// the latest builds of the configured jobs, prints the regressions of their
+// latest build according to the SLOs and --thresholds and returns the exit
+// code of the command, non-zero if there are regressions.
+func checkCommand(args []string) int {
+	flag.CommandLine.Parse(args)
+	if err := initLogging(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to initialize logging: %v\n", err)
+		return checkFailed
+	}
+	err := check(context.Background(), os.Stdout)
+	if err == errRegressed {
+		return checkRegressed
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		return checkFailed
+	}
+	return checkPassed
+}
+
+// errRegressed is returned by check if there are regressions.
+var errRegressed = fmt.Errorf("regressions were found")
+
+// check writes the regressions of the latest builds of the configured jobs to
+// out. It returns errRegressed if there are any.
+func check(ctx context.Context, out io.Writer) error {
+	var err error
+	if config, err = loadConfigFromFlags(); err != nil {
+		return err
+	}
+	if errs := config.Validate(); len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %v", errs)
+	}
+	registerPlugins(config.Plugins)
+	if thresholds, err = loadThresholdsFromFlags(); err != nil {
+		return err
+	}
+	source, err := newDownloader(*datasource)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "%s\n", buildInfo())
+	regressed := 0
+	for _, job := range config.JobNames() {
+		allTestData[job] = TestToBuildData{}
+		if err := Parse(ctx, allTestData, &allTestInfo, job, source); err != nil {
+			return fmt.Errorf("failed to fetch the builds of job %q: %v", job, err)
+		}
+		testData, err := jobData(job)
+		if err != nil {
+			return err
+		}
+		for _, r := range detectRegressions(job, extractSeries(job, testData, seriesFilter{})) {
+			regressed++
+			fmt.Fprintf(out, "REGRESSION: job %q build %s: %s on %s, %s %s: %g %s", r.Job, r.Build, r.Test, r.Node, formatLabels(r.Labels), r.Bucket, r.Value, r.Unit)
+			if r.Kind == regressionSLO {
+				fmt.Fprintf(out, " breaches SLO %s\n", r.SLO)
+			} else {
+				fmt.Fprintf(out, " is %+.1f%% compared to %g %s\n", r.Change*100, r.Baseline, r.Unit)
+			}
+		}
+	}
+	if regressed > 0 {
+		fmt.Fprintf(out, "FAIL: %d regressions\n", regressed)
+		return errRegressed
+	}
+	fmt.Fprintf(out, "PASS: no regressions\n")
+	return nil
+}
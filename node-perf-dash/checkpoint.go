@@ -0,0 +1,152 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strconv"
+)
+
+// allScans stores the progress of the unfinished scan of each job. It is
+// protected by dataLock.
+var allScans = map[string]*scanCheckpoint{}
+
+// scanCheckpoint is the progress of a scan over the builds of a job. It is
+// persisted after every build, so that a scan interrupted by a crash resumes
+// where it left off.
+type scanCheckpoint struct {
+	// LastListedBuild is the latest build found when the scan started.
+	LastListedBuild int `json:"lastListedBuild"`
+	// Done lists the builds which have been parsed completely.
+	Done []int `json:"done,omitempty"`
+	// InProgress is the build being parsed, or 0 if there is none. Its
+	// data is only merged once it is parsed completely, so it is parsed
+	// again when the scan resumes.
+	InProgress int `json:"inProgress,omitempty"`
+}
+
+// beginScan starts a scan of the job up to lastBuild, resuming the unfinished
+// scan if there is one. It returns the builds which do not need to be fetched
+// again, because they were finished by the interrupted scan or are already
+// present in memory or in the store.
+func beginScan(job string, lastBuild int) map[int]bool {
+	dataLock.Lock()
+	defer dataLock.Unlock()
+
+	skip := map[int]bool{}
+	for build := range retainedBuilds(job) {
+		if n, err := strconv.Atoi(build); err == nil {
+			skip[n] = true
+		}
+	}
+	scan := &scanCheckpoint{LastListedBuild: lastBuild}
+	if previous := allScans[job]; previous != nil {
+		parserLog.Info("Resuming the interrupted scan", "job", job, "lastListedBuild", previous.LastListedBuild, "done", len(previous.Done), "interruptedBuild", previous.InProgress)
+		scan.Done = previous.Done
+		for _, n := range previous.Done {
+			skip[n] = true
+		}
+	}
+	allScans[job] = scan
+	return skip
+}
+
+// setScanInProgress records that the build of the job is being parsed.
+func setScanInProgress(job string, build int) error {
+	dataLock.Lock()
+	if scan := allScans[job]; scan != nil {
+		scan.InProgress = build
+	}
+	dataLock.Unlock()
+	return checkpointJob(job)
+}
+
+// finishScanBuild records that the build of the job has been parsed and
+// merged, and persists it together with the scan progress.
+func finishScanBuild(job string, build int) error {
+	dataLock.Lock()
+	if scan := allScans[job]; scan != nil {
+		scan.Done = append(scan.Done, build)
+		scan.InProgress = 0
+	}
+	dataLock.Unlock()
+	return checkpointBuild(job, strconv.Itoa(build))
+}
+
+// finishScan records that the scan of the job has completed up to lastBuild.
+func finishScan(job string, lastBuild int) error {
+	dataLock.Lock()
+	allGrabbedLastBuild[job] = lastBuild
+	delete(allScans, job)
+	dataLock.Unlock()
+	return checkpointJob(job)
+}
+
+// currentJobState returns the collection state of the job to persist. It must
+// be called with dataLock held.
+func currentJobState(job string) jobState {
+	state := jobState{LastBuild: allGrabbedLastBuild[job]}
+	if scan := allScans[job]; scan != nil {
+		copied := *scan
+		copied.Done = append([]int(nil), scan.Done...)
+		state.Scan = &copied
+	}
+	return state
+}
+
+// checkpointJob persists the collection state of the job.
+func checkpointJob(job string) error {
+	if store == nil {
+		return nil
+	}
+	flushLock.Lock()
+	defer flushLock.Unlock()
+
+	dataLock.RLock()
+	state := currentJobState(job)
+	dataLock.RUnlock()
+	return store.Put(jobStateKey(job), state)
+}
+
+// checkpointBuild persists the data of the build and the collection state of
+// the job immediately rather than at the next flush.
+func checkpointBuild(job, build string) error {
+	if store == nil {
+		return nil
+	}
+	flushLock.Lock()
+	defer flushLock.Unlock()
+
+	dataLock.Lock()
+	snapshot := snapshotBuild(allTestData[job], build)
+	delete(dirtyBuilds[job], build)
+	state := currentJobState(job)
+	dataLock.Unlock()
+
+	if len(snapshot) == 0 {
+		// The build has no data to display, only its progress needs to
+		// be recorded.
+		return store.Put(jobStateKey(job), state)
+	}
+	if err := store.Put(buildKey(job, build), snapshot); err != nil {
+		// Retry in the next flush.
+		dataLock.Lock()
+		markBuildDirty(job, build)
+		dataLock.Unlock()
+		return err
+	}
+	return store.Put(jobStateKey(job), state)
+}
@@ -0,0 +1,372 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package client is a client for the HTTP API of node-perf-dash, for the
+// tools and bots consuming the dashboard programmatically.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRetries   = 3
+	defaultRetryWait = time.Second
+)
+
+// htmlTag matches the tags of the HTML error pages of node-perf-dash.
+var htmlTag = regexp.MustCompile(`<[^>]*>`)
+
+// Client is a client for the API of a node-perf-dash instance. It retries the
+// network errors, the server errors and the rate limited requests. It must be
+// created with NewClient, and is safe for concurrent use.
+type Client struct {
+	// BaseURL is the URL node-perf-dash is served at, e.g.
+	// "http://node-perf-dash.example.com".
+	BaseURL string
+	// Token is the bearer token authenticating the requests if non-empty.
+	Token      string
+	HTTPClient *http.Client
+	// Retries is the number of times a request is retried.
+	Retries int
+	// RetryWait is the wait before the first retry, doubled at each retry
+	// unless the response has a Retry-After header.
+	RetryWait time.Duration
+}
+
+// NewClient returns a client of the node-perf-dash served at baseURL.
+func NewClient(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: time.Minute},
+		Retries:    defaultRetries,
+		RetryWait:  defaultRetryWait,
+	}
+}
+
+// APIError is returned for the responses of the API with an unexpected
+// status code.
+type APIError struct {
+	URL        string
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("got status code %d for %s: %s", e.StatusCode, e.URL, e.Message)
+}
+
+// Job is a job configured in node-perf-dash.
+type Job struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// Filter selects the series of a job. The empty fields match all the series.
+type Filter struct {
+	Test   string
+	Node   string
+	Bucket string
+	Owner  string
+	// Metrics selects the series having all these labels, e.g.
+	// {"datatype": "latency"}.
+	Metrics map[string]string
+}
+
+// SeriesOptions are the options of GetSeries.
+type SeriesOptions struct {
+	Filter
+	// Aggregate downsamples the series to one point per period, "daily"
+	// or "weekly", if non-empty.
+	Aggregate string
+	// Fn is the function aggregating the values of a period, e.g. "mean"
+	// or "p99".
+	Fn string
+}
+
+// SeriesResponse is the response of GetSeries.
+type SeriesResponse struct {
+	Job    string    `json:"job"`
+	Series []*Series `json:"series"`
+}
+
+// Series is the evolution of a metric over the builds of a job.
+type Series struct {
+	Test        string            `json:"test"`
+	Node        string            `json:"node"`
+	Labels      map[string]string `json:"labels"`
+	Bucket      string            `json:"bucket"`
+	Unit        string            `json:"unit"`
+	Owner       string            `json:"owner,omitempty"`
+	Points      []Point           `json:"points"`
+	SLO         *SLOStatus        `json:"slo,omitempty"`
+	Annotations []*Annotation     `json:"annotations,omitempty"`
+}
+
+// Point is the value of a metric in a build.
+type Point struct {
+	Build     string  `json:"build"`
+	Value     float64 `json:"value"`
+	Timestamp int64   `json:"timestamp,omitempty"`
+	Count     int     `json:"count,omitempty"`
+}
+
+// SLOStatus is the status of a series against its SLO.
+type SLOStatus struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Max         *float64 `json:"max,omitempty"`
+	Min         *float64 `json:"min,omitempty"`
+	Breaches    []string `json:"breaches,omitempty"`
+}
+
+// Annotation is a note attached to the metrics of a build.
+type Annotation struct {
+	ID        string            `json:"id"`
+	Build     string            `json:"build"`
+	Test      string            `json:"test"`
+	Node      string            `json:"node,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Bucket    string            `json:"bucket,omitempty"`
+	Text      string            `json:"text"`
+	Author    string            `json:"author"`
+	CreatedAt time.Time         `json:"createdAt"`
+}
+
+// Regression is a regression of a metric detected in a build.
+type Regression struct {
+	Job      string            `json:"job"`
+	Test     string            `json:"test"`
+	Node     string            `json:"node"`
+	Labels   map[string]string `json:"labels"`
+	Bucket   string            `json:"bucket"`
+	Unit     string            `json:"unit"`
+	Owner    string            `json:"owner,omitempty"`
+	Build    string            `json:"build"`
+	Value    float64           `json:"value"`
+	Baseline float64           `json:"baseline"`
+	Change   float64           `json:"change"`
+	// Kind is "relative" for a change exceeding the threshold of the metric,
+	// or "slo" for a breach of its SLO.
+	Kind string `json:"kind"`
+	SLO  string `json:"slo,omitempty"`
+}
+
+// Comparison is the comparison of a build with the golden baseline of its
+// job.
+type Comparison struct {
+	Job     string             `json:"job"`
+	Build   string             `json:"build"`
+	Golden  GoldenBaseline     `json:"golden"`
+	Metrics []MetricComparison `json:"metrics"`
+}
+
+// GoldenBaseline is the build the other builds of a job are compared with.
+type GoldenBaseline struct {
+	Build      string    `json:"build"`
+	Note       string    `json:"note,omitempty"`
+	MarkedAt   time.Time `json:"markedAt,omitempty"`
+	Configured bool      `json:"configured,omitempty"`
+}
+
+// MetricComparison is the comparison of a metric with its golden value.
+type MetricComparison struct {
+	Test      string            `json:"test"`
+	Node      string            `json:"node"`
+	Labels    map[string]string `json:"labels"`
+	Bucket    string            `json:"bucket"`
+	Unit      string            `json:"unit"`
+	Owner     string            `json:"owner,omitempty"`
+	Golden    float64           `json:"golden"`
+	Value     float64           `json:"value"`
+	Change    float64           `json:"change"`
+	Regressed bool              `json:"regressed"`
+}
+
+// ListJobs returns the configured jobs having all the labels.
+func (c *Client) ListJobs(ctx context.Context, labels map[string]string) ([]Job, error) {
+	query := url.Values{}
+	addLabels(query, "label", labels)
+	var jobs []Job
+	if err := c.get(ctx, "/api/jobs", query, &jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// GetSeries returns the series of the job selected by the options, which may
+// be nil.
+func (c *Client) GetSeries(ctx context.Context, job string, options *SeriesOptions) (*SeriesResponse, error) {
+	if options == nil {
+		options = &SeriesOptions{}
+	}
+	query := options.Filter.values()
+	query.Set("job", job)
+	setNonEmpty(query, "aggregate", options.Aggregate)
+	setNonEmpty(query, "fn", options.Fn)
+	series := &SeriesResponse{}
+	if err := c.get(ctx, "/api/series", query, series); err != nil {
+		return nil, err
+	}
+	return series, nil
+}
+
+// GetRegressions returns the regressions of the series selected by the
+// filter, of the job or of all the jobs if it is empty.
+func (c *Client) GetRegressions(ctx context.Context, job string, filter Filter) ([]Regression, error) {
+	query := filter.values()
+	setNonEmpty(query, "job", job)
+	var regressions []Regression
+	if err := c.get(ctx, "/api/regressions", query, &regressions); err != nil {
+		return nil, err
+	}
+	return regressions, nil
+}
+
+// Compare compares the metrics selected by the filter of the build, or of the
+// latest build if it is empty, with the golden baseline of the job.
+func (c *Client) Compare(ctx context.Context, job, build string, filter Filter) (*Comparison, error) {
+	query := filter.values()
+	query.Set("job", job)
+	setNonEmpty(query, "build", build)
+	comparison := &Comparison{}
+	if err := c.get(ctx, "/api/compare", query, comparison); err != nil {
+		return nil, err
+	}
+	return comparison, nil
+}
+
+// values returns the query parameters of the filter, as parsed by the series
+// API.
+func (f Filter) values() url.Values {
+	query := url.Values{}
+	setNonEmpty(query, "test", f.Test)
+	setNonEmpty(query, "node", f.Node)
+	setNonEmpty(query, "bucket", f.Bucket)
+	setNonEmpty(query, "owner", f.Owner)
+	addLabels(query, "metric", f.Metrics)
+	return query
+}
+
+func setNonEmpty(query url.Values, key, value string) {
+	if value != "" {
+		query.Set(key, value)
+	}
+}
+
+// addLabels adds the labels as sorted "<key>=<value>" parameters.
+func addLabels(query url.Values, key string, labels map[string]string) {
+	var names []string
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		query.Add(key, name+"="+labels[name])
+	}
+}
+
+// get gets the path with the query and decodes the JSON response into v.
+func (c *Client) get(ctx context.Context, path string, query url.Values, v interface{}) error {
+	u := c.BaseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	body, err := c.do(ctx, u)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("failed to decode the response of %s: %v", u, err)
+	}
+	return nil
+}
+
+// do gets the URL, retrying the transient failures, and returns the body of
+// the response.
+func (c *Client) do(ctx context.Context, u string) ([]byte, error) {
+	wait := c.RetryWait
+	for attempt := 0; ; attempt++ {
+		body, retryAfter, err := c.send(ctx, u)
+		if err == nil {
+			return body, nil
+		}
+		if retryAfter < 0 || attempt >= c.Retries {
+			return nil, err
+		}
+		if retryAfter == 0 {
+			retryAfter = wait
+			wait *= 2
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(retryAfter):
+		}
+	}
+}
+
+// send sends a single request. On failure, it returns the wait before the
+// request can be retried, 0 for the default backoff or a negative one if it
+// must not be retried.
+func (c *Client) send(ctx context.Context, u string) ([]byte, time.Duration, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, -1, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	response, err := c.HTTPClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, -1, ctx.Err()
+		}
+		return nil, 0, fmt.Errorf("failed to get %s: %v", u, err)
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read the response of %s: %v", u, err)
+	}
+	if response.StatusCode == http.StatusOK {
+		return body, 0, nil
+	}
+
+	apiErr := &APIError{URL: u, StatusCode: response.StatusCode, Message: strings.Join(strings.Fields(htmlTag.ReplaceAllString(string(body), " ")), " ")}
+	switch {
+	case response.StatusCode == http.StatusTooManyRequests:
+		var seconds int
+		if _, err := fmt.Sscanf(response.Header.Get("Retry-After"), "%d", &seconds); err == nil && seconds > 0 {
+			return nil, time.Duration(seconds) * time.Second, apiErr
+		}
+		return nil, 0, apiErr
+	case response.StatusCode >= 500:
+		return nil, 0, apiErr
+	}
+	return nil, -1, apiErr
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestQueries(t *testing.T) {
+	var got []string
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		got = append(got, req.URL.String())
+		switch req.URL.Path {
+		case "/api/jobs", "/api/regressions":
+			fmt.Fprint(res, "[]")
+		default:
+			fmt.Fprint(res, "{}")
+		}
+	}))
+	defer server.Close()
+	c := NewClient(server.URL + "/")
+	ctx := context.Background()
+	filter := Filter{Test: "density", Bucket: "Perc99", Metrics: map[string]string{"latencytype": "create-pod", "datatype": "latency"}}
+
+	if _, err := c.ListJobs(ctx, map[string]string{"sig": "node"}); err != nil {
+		t.Error(err)
+	}
+	if _, err := c.GetSeries(ctx, "job", nil); err != nil {
+		t.Error(err)
+	}
+	if _, err := c.GetSeries(ctx, "job", &SeriesOptions{Filter: filter, Aggregate: "daily", Fn: "p99"}); err != nil {
+		t.Error(err)
+	}
+	if _, err := c.GetRegressions(ctx, "", Filter{Owner: "sig-node"}); err != nil {
+		t.Error(err)
+	}
+	if _, err := c.Compare(ctx, "job", "42", filter); err != nil {
+		t.Error(err)
+	}
+	expected := []string{
+		"/api/jobs?label=sig%3Dnode",
+		"/api/series?job=job",
+		"/api/series?aggregate=daily&bucket=Perc99&fn=p99&job=job&metric=datatype%3Dlatency&metric=latencytype%3Dcreate-pod&test=density",
+		"/api/regressions?owner=sig-node",
+		"/api/compare?bucket=Perc99&build=42&job=job&metric=datatype%3Dlatency&metric=latencytype%3Dcreate-pod&test=density",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected the requests %v but got %v", expected, got)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	tests := []struct {
+		desc     string
+		statuses []int
+		requests int
+		status   int
+	}{
+		{desc: "success", statuses: []int{200}, requests: 1},
+		{desc: "server errors are retried", statuses: []int{503, 500, 200}, requests: 3},
+		{desc: "rate limits are retried", statuses: []int{429, 200}, requests: 2},
+		{desc: "retries are bounded", statuses: []int{503, 503, 503, 503, 503}, requests: 3, status: 503},
+		{desc: "client errors are not retried", statuses: []int{404, 200}, requests: 1, status: 404},
+	}
+	for _, test := range tests {
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			status := test.statuses[requests]
+			requests++
+			if req.Header.Get("Authorization") != "Bearer token" {
+				status = http.StatusUnauthorized
+			}
+			if status == http.StatusTooManyRequests {
+				res.Header().Set("Retry-After", "0")
+			}
+			res.WriteHeader(status)
+			if status == http.StatusOK {
+				fmt.Fprint(res, `[{"name": "job"}]`)
+			} else {
+				fmt.Fprintf(res, "<h3>%s</h3><p>unknown job", http.StatusText(status))
+			}
+		}))
+		c := NewClient(server.URL)
+		c.Token = "token"
+		c.Retries = 2
+		c.RetryWait = time.Millisecond
+		jobs, err := c.ListJobs(context.Background(), nil)
+		server.Close()
+
+		if requests != test.requests {
+			t.Errorf("%s: expected %d requests but got %d", test.desc, test.requests, requests)
+		}
+		if test.status == 0 {
+			if err != nil || len(jobs) != 1 || jobs[0].Name != "job" {
+				t.Errorf("%s: expected the job but got %v (%v)", test.desc, jobs, err)
+			}
+			continue
+		}
+		apiErr, ok := err.(*APIError)
+		if !ok || apiErr.StatusCode != test.status {
+			t.Errorf("%s: expected an error with status %d but got %v", test.desc, test.status, err)
+			continue
+		}
+		if expected := http.StatusText(test.status) + " unknown job"; apiErr.Message != expected {
+			t.Errorf("%s: expected the message %q but got %q", test.desc, expected, apiErr.Message)
+		}
+	}
+}
+
+func TestCancel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	c := NewClient(server.URL)
+	c.RetryWait = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.ListJobs(ctx, nil); err != context.DeadlineExceeded {
+		t.Errorf("expected the retries to stop with the context but got %v", err)
+	}
+}
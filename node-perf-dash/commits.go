@@ -0,0 +1,230 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"k8s.io/contrib/github-utils/github"
+)
+
+var (
+	githubRepo      = flag.String("github-repo", "kubernetes/kubernetes", "The GitHub repository, as <owner>/<name>, the tested versions are built from")
+	githubTokenFile = flag.String("github-token-file", "", "If non-empty, the path to a GitHub token used when resolving commit titles")
+)
+
+const startedFile = "started.json"
+
+// versionCommitRegexp extracts the commit from a version built from the
+// source, e.g. "v1.8.0-alpha.0.690+3cb7796762047e".
+var versionCommitRegexp = regexp.MustCompile(`\+([0-9a-f]{7,40})$`)
+
+// getBuildVersion returns the version of the sources tested by the build, as
+// recorded in its started.json. It returns an empty string if it is unknown.
+func getBuildVersion(job string, buildNumber int, source Downloader) string {
+	body, err := source.GetFile(job, buildNumber, startedFile)
+	if err != nil {
+		parserLog.Debug("No started.json for the build", "job", job, "build", buildNumber, "err", err)
+		return ""
+	}
+	defer body.Close()
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		parserLog.Debug("Failed to read started.json", "job", job, "build", buildNumber, "err", err)
+		return ""
+	}
+	var started struct {
+		Version    string `json:"version"`
+		JobVersion string `json:"job-version"`
+	}
+	if err := json.Unmarshal(data, &started); err != nil {
+		parserLog.Debug("Failed to parse started.json", "job", job, "build", buildNumber, "err", err)
+		return ""
+	}
+	if started.JobVersion != "" {
+		return started.JobVersion
+	}
+	return started.Version
+}
+
+// versionCommit returns the commit a version is built from, or an empty
+// string if the version does not identify a commit.
+func versionCommit(version string) string {
+	if match := versionCommitRegexp.FindStringSubmatch(version); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+// Commit is a commit in a commit range.
+type Commit struct {
+	SHA   string `json:"sha"`
+	Title string `json:"title"`
+}
+
+// CommitRange is the range of commits between the versions tested by two
+// builds of a job.
+type CommitRange struct {
+	Job          string   `json:"job"`
+	Build        string   `json:"build"`
+	BaseBuild    string   `json:"baseBuild"`
+	Version      string   `json:"version"`
+	BaseVersion  string   `json:"baseVersion"`
+	Commit       string   `json:"commit"`
+	BaseCommit   string   `json:"baseCommit"`
+	CompareURL   string   `json:"compareURL"`
+	Commits      []Commit `json:"commits,omitempty"`
+	CommitsError string   `json:"commitsError,omitempty"`
+}
+
+// buildVersions returns a map from build to the version it tested.
+func buildVersions(testData TestToBuildData) map[string]string {
+	versions := map[string]string{}
+	for _, dataPerTest := range testData {
+		for _, dataPerNode := range dataPerTest.Data {
+			for build, data := range dataPerNode {
+				if data.Version != "" {
+					versions[build] = data.Version
+				}
+			}
+		}
+	}
+	return versions
+}
+
+// serveCommits is the HTTP handler returning the commit range between the
+// versions tested by the build in the "build" query parameter and the one in
+// "base", which defaults to the previous build with a known version. With
+// "titles=true", the commits of the range are resolved using the GitHub API.
+func serveCommits(res http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	job, build, base := query.Get("job"), query.Get("build"), query.Get("base")
+	if _, ok := allTestData[job]; !ok {
+		writeError(res, http.StatusNotFound, fmt.Errorf("unknown job %q", job))
+		return
+	}
+	buildNumber, err := strconv.Atoi(build)
+	if err != nil {
+		writeError(res, http.StatusBadRequest, fmt.Errorf("invalid build %q", build))
+		return
+	}
+
+	testData, err := jobData(job)
+	if err != nil {
+		writeError(res, http.StatusInternalServerError, err)
+		return
+	}
+	versions := buildVersions(testData)
+
+	if base == "" {
+		// Find the previous build with a known version.
+		var previous []int
+		for b := range versions {
+			if n, err := strconv.Atoi(b); err == nil && n < buildNumber {
+				previous = append(previous, n)
+			}
+		}
+		if len(previous) == 0 {
+			writeError(res, http.StatusNotFound, fmt.Errorf("no build before %d with a known version", buildNumber))
+			return
+		}
+		sort.Ints(previous)
+		base = strconv.Itoa(previous[len(previous)-1])
+	}
+
+	result := CommitRange{
+		Job:         job,
+		Build:       build,
+		BaseBuild:   base,
+		Version:     versions[build],
+		BaseVersion: versions[base],
+		Commit:      versionCommit(versions[build]),
+		BaseCommit:  versionCommit(versions[base]),
+	}
+	if result.Commit == "" || result.BaseCommit == "" {
+		writeError(res, http.StatusNotFound, fmt.Errorf("the commits tested by builds %s (%q) and %s (%q) are unknown", build, result.Version, base, result.BaseVersion))
+		return
+	}
+	result.CompareURL = fmt.Sprintf("https://github.com/%s/compare/%s...%s", *githubRepo, result.BaseCommit, result.Commit)
+	if query.Get("titles") == "true" {
+		commits, err := commitResolver.Compare(result.BaseCommit, result.Commit)
+		if err != nil {
+			serverLog.Warn("Failed to resolve the commit range", "base", result.BaseCommit, "head", result.Commit, "err", err)
+			result.CommitsError = err.Error()
+		}
+		result.Commits = commits
+	}
+	writeJSON(res, req, result)
+}
+
+// commitResolver resolves the commits in a range using the GitHub API. Its
+// client is created from the flags by main.
+var commitResolver = &githubCompare{cache: map[string][]Commit{}}
+
+// githubCompare resolves commit ranges using the compare API of GitHub. The
+// ranges are immutable, so the results are cached.
+type githubCompare struct {
+	client *github.Client
+	lock   sync.Mutex
+	cache  map[string][]Commit
+}
+
+// Compare returns the commits after base up to head.
+func (g *githubCompare) Compare(base, head string) ([]Commit, error) {
+	key := base + "..." + head
+	g.lock.Lock()
+	commits, ok := g.cache[key]
+	g.lock.Unlock()
+	if ok {
+		return commits, nil
+	}
+
+	err := g.client.List(fmt.Sprintf("/repos/%s/compare/%s?per_page=100", *githubRepo, key), func(body []byte) error {
+		var comparison struct {
+			Commits []struct {
+				SHA    string `json:"sha"`
+				Commit struct {
+					Message string `json:"message"`
+				} `json:"commit"`
+			} `json:"commits"`
+		}
+		if err := json.Unmarshal(body, &comparison); err != nil {
+			return fmt.Errorf("failed to decode the response of the GitHub API: %v", err)
+		}
+		for _, c := range comparison.Commits {
+			commits = append(commits, Commit{SHA: c.SHA, Title: strings.SplitN(c.Commit.Message, "\n", 2)[0]})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	g.lock.Lock()
+	g.cache[key] = commits
+	g.lock.Unlock()
+	return commits, nil
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"k8s.io/contrib/github-utils/github"
+)
+
+func TestServeCommits(t *testing.T) {
+	// The compare API returns the range of builds 1 and 3 in two pages,
+	// and fails for the range of builds 1 and 4.
+	requests := 0
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		requests++
+		switch req.URL.Path + "?" + req.URL.RawQuery {
+		case "/repos/kubernetes/kubernetes/compare/aaaaaaa...ccccccc?per_page=100":
+			res.Header().Set("Link", fmt.Sprintf(`<%s/repos/kubernetes/kubernetes/compare/aaaaaaa...ccccccc?per_page=100&page=2>; rel="next"`, server.URL))
+			fmt.Fprint(res, `{"commits": [{"sha": "bbbbbbb", "commit": {"message": "Fix the kubelet\n\nDetails."}}]}`)
+		case "/repos/kubernetes/kubernetes/compare/aaaaaaa...ccccccc?per_page=100&page=2":
+			fmt.Fprint(res, `{"commits": [{"sha": "ccccccc", "commit": {"message": "Speed up the pod creation"}}]}`)
+		default:
+			http.Error(res, `{"message": "Not Found"}`, http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	client := github.NewClient("")
+	client.BaseURL = server.URL
+	client.Retries = 0
+	defer func(resolver *githubCompare) { commitResolver = resolver }(commitResolver)
+	commitResolver = &githubCompare{client: client, cache: map[string][]Commit{}}
+
+	// The version of build 2 is unknown.
+	job := "commits"
+	allTestData[job] = TestToBuildData{}
+	defer delete(allTestData, job)
+	for build, version := range map[string]string{"1": "v1.8.0-alpha.0.1+aaaaaaa", "2": "", "3": "v1.8.0-alpha.0.3+ccccccc", "4": "v1.8.0-alpha.0.4+ddddddd"} {
+		allTestData[job].GetDataPerBuild(job, build, "test", "node").Version = version
+	}
+
+	table := []struct {
+		name     string
+		query    string
+		code     int
+		requests int
+		expect   CommitRange
+	}{
+		{name: "unknown job", query: "job=unknown&build=3", code: http.StatusNotFound},
+		{name: "invalid build", query: "job=commits&build=latest", code: http.StatusBadRequest},
+		{name: "no previous version", query: "job=commits&build=1", code: http.StatusNotFound},
+		{name: "unknown base commit", query: "job=commits&build=3&base=2", code: http.StatusNotFound},
+		{
+			name:  "previous build with a version",
+			query: "job=commits&build=3",
+			code:  http.StatusOK,
+			expect: CommitRange{Job: job, Build: "3", BaseBuild: "1", Version: "v1.8.0-alpha.0.3+ccccccc", BaseVersion: "v1.8.0-alpha.0.1+aaaaaaa", Commit: "ccccccc", BaseCommit: "aaaaaaa",
+				CompareURL: "https://github.com/kubernetes/kubernetes/compare/aaaaaaa...ccccccc"},
+		},
+		{
+			name:     "titles of all the pages",
+			query:    "job=commits&build=3&titles=true",
+			code:     http.StatusOK,
+			requests: 2,
+			expect: CommitRange{Job: job, Build: "3", BaseBuild: "1", Version: "v1.8.0-alpha.0.3+ccccccc", BaseVersion: "v1.8.0-alpha.0.1+aaaaaaa", Commit: "ccccccc", BaseCommit: "aaaaaaa",
+				CompareURL: "https://github.com/kubernetes/kubernetes/compare/aaaaaaa...ccccccc",
+				Commits:    []Commit{{SHA: "bbbbbbb", Title: "Fix the kubelet"}, {SHA: "ccccccc", Title: "Speed up the pod creation"}}},
+		},
+		{
+			name:  "cached titles",
+			query: "job=commits&build=3&base=1&titles=true",
+			code:  http.StatusOK,
+			expect: CommitRange{Job: job, Build: "3", BaseBuild: "1", Version: "v1.8.0-alpha.0.3+ccccccc", BaseVersion: "v1.8.0-alpha.0.1+aaaaaaa", Commit: "ccccccc", BaseCommit: "aaaaaaa",
+				CompareURL: "https://github.com/kubernetes/kubernetes/compare/aaaaaaa...ccccccc",
+				Commits:    []Commit{{SHA: "bbbbbbb", Title: "Fix the kubelet"}, {SHA: "ccccccc", Title: "Speed up the pod creation"}}},
+		},
+		{
+			// The range is returned without the titles GitHub
+			// failed to resolve.
+			name:     "GitHub error",
+			query:    "job=commits&build=4&base=1&titles=true",
+			code:     http.StatusOK,
+			requests: 1,
+			expect: CommitRange{Job: job, Build: "4", BaseBuild: "1", Version: "v1.8.0-alpha.0.4+ddddddd", BaseVersion: "v1.8.0-alpha.0.1+aaaaaaa", Commit: "ddddddd", BaseCommit: "aaaaaaa",
+				CompareURL: "https://github.com/kubernetes/kubernetes/compare/aaaaaaa...ddddddd"},
+		},
+	}
+	for _, tt := range table {
+		requests = 0
+		res := httptest.NewRecorder()
+		serveCommits(res, httptest.NewRequest("GET", "/api/commits?"+tt.query, nil))
+		if res.Code != tt.code {
+			t.Errorf("%s: expected status %d but got %d: %s", tt.name, tt.code, res.Code, res.Body)
+			continue
+		}
+		if requests != tt.requests {
+			t.Errorf("%s: expected %d requests to GitHub but got %d", tt.name, tt.requests, requests)
+		}
+		if tt.code != http.StatusOK {
+			continue
+		}
+		var got CommitRange
+		if err := json.Unmarshal(res.Body.Bytes(), &got); err != nil {
+			t.Errorf("%s: failed to decode the response: %v", tt.name, err)
+			continue
+		}
+		if tt.name == "GitHub error" {
+			if got.CommitsError == "" {
+				t.Errorf("%s: expected the error of GitHub to be returned", tt.name)
+			}
+			got.CommitsError = ""
+		}
+		if !reflect.DeepEqual(got, tt.expect) {
+			t.Errorf("%s: expected %+v but got %+v", tt.name, tt.expect, got)
+		}
+	}
+}
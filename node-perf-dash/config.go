@@ -0,0 +1,254 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+)
+
+// Config is the configuration of node-perf-dash. It is loaded from the file
+// specified by --config. If no file is specified, it is built from
+// --jenkins-job instead.
+type Config struct {
+	// Jobs is the list of jobs to display.
+	Jobs []*JobConfig `json:"jobs"`
+	// SLOs are the service level objectives of the metrics. They are
+	// displayed with the series they apply to and breaching them is
+	// reported as a regression.
+	SLOs []*SLOConfig `json:"slos,omitempty"`
+	// Units describes the units of the metrics, overriding the canonical
+	// unit of their dimension and the unit of the metrics reported without
+	// one.
+	Units []*UnitConfig `json:"units,omitempty"`
+	// Owners assigns the metrics to the SIGs or teams owning them. The
+	// first matching entry applies.
+	Owners []*OwnerConfig `json:"owners,omitempty"`
+	// Variants declare pairs of jobs compared as variant and control.
+	Variants []*VariantConfig `json:"variants,omitempty"`
+	// Digest configures the email digests of the jobs with recipients.
+	Digest *DigestConfig `json:"digest,omitempty"`
+	// Plugins are the out-of-tree parsers, run as external commands on
+	// the artifacts they match. The built-in parsers take precedence.
+	Plugins []*PluginConfig `json:"plugins,omitempty"`
+	// Presubmits declare the presubmit jobs whose runs on the pull
+	// requests are compared with the master builds of a job.
+	Presubmits []*PresubmitConfig `json:"presubmits,omitempty"`
+	// Quotas limit the requests of all the jobs to the data sources, so
+	// that large configurations do not exceed their rate limits.
+	Quotas []*QuotaConfig `json:"quotas,omitempty"`
+}
+
+// JobConfig is the configuration of a single job.
+type JobConfig struct {
+	// Name is the name of the job, e.g. "ci-kubernetes-node-kubelet-benchmark".
+	Name string `json:"name"`
+	// Tests is a regular expression matching the names of the tests to
+	// display (e.g. "density_.*"). All tests are displayed if it is empty.
+	Tests string `json:"tests,omitempty"`
+	// RefreshInterval is how often new builds of the job are fetched, e.g.
+	// "1h". It defaults to --refresh-interval.
+	RefreshInterval Duration `json:"refreshInterval,omitempty"`
+	// Labels are arbitrary key/value pairs describing the job, e.g.
+	// "provider: gce" or "runtime: containerd". They are returned with the
+	// data of the job and can be used to filter jobs in the API.
+	Labels map[string]string `json:"labels,omitempty"`
+	// DigestTo lists the email addresses the digests of the job are sent
+	// to.
+	DigestTo []string `json:"digestTo,omitempty"`
+	// GoldenBuild is the build the other builds of the job are compared
+	// with, unless another build is marked as golden through the API.
+	GoldenBuild int `json:"goldenBuild,omitempty"`
+
+	// testsRegexp is the compiled form of Tests.
+	testsRegexp *regexp.Regexp
+}
+
+// IncludesTest returns true if the given test should be displayed for the job.
+func (j *JobConfig) IncludesTest(test string) bool {
+	return j.testsRegexp == nil || j.testsRegexp.MatchString(test)
+}
+
+// Interval returns how often new builds of the job should be fetched.
+func (j *JobConfig) Interval() time.Duration {
+	if j.RefreshInterval.Duration > 0 {
+		return j.RefreshInterval.Duration
+	}
+	return *refreshInterval
+}
+
+// Duration is a time.Duration which is configured as a string such as "10m".
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalJSON parses the duration from a JSON string.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"10m\": %v", err)
+	}
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = duration
+	return nil
+}
+
+// MarshalJSON formats the duration as a JSON string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// loadConfig reads the configuration from the YAML (or JSON) file at path.
+// The returned configuration is not validated.
+func loadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file %q: %v", path, err)
+	}
+	config := &Config{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %q: %v", path, err)
+	}
+	return config, nil
+}
+
+// configFromJobList builds a configuration displaying all tests of the given
+// jobs.
+func configFromJobList(jobs []string) *Config {
+	config := &Config{}
+	for _, job := range jobs {
+		config.Jobs = append(config.Jobs, &JobConfig{Name: job})
+	}
+	return config
+}
+
+// Validate checks the configuration and prepares it for use. It returns all
+// problems found rather than only the first one, so that they can be fixed in
+// one go.
+func (c *Config) Validate() []error {
+	var errs []error
+	if len(c.Jobs) == 0 {
+		errs = append(errs, fmt.Errorf("no jobs are configured"))
+	}
+	seen := map[string]bool{}
+	for i, job := range c.Jobs {
+		if strings.TrimSpace(job.Name) == "" {
+			errs = append(errs, fmt.Errorf("jobs[%d]: name must not be empty", i))
+			continue
+		}
+		if seen[job.Name] {
+			errs = append(errs, fmt.Errorf("job %q: configured more than once", job.Name))
+		}
+		seen[job.Name] = true
+		for key := range job.Labels {
+			if key == "" || strings.ContainsAny(key, "=,") {
+				errs = append(errs, fmt.Errorf("job %q: invalid label key %q", job.Name, key))
+			}
+		}
+		if job.GoldenBuild < 0 {
+			errs = append(errs, fmt.Errorf("job %q: golden build must not be negative", job.Name))
+		}
+		if job.RefreshInterval.Duration < 0 {
+			errs = append(errs, fmt.Errorf("job %q: refresh interval must not be negative", job.Name))
+		}
+		if job.Tests != "" {
+			re, err := regexp.Compile(job.Tests)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("job %q: invalid tests regular expression %q: %v", job.Name, job.Tests, err))
+			}
+			job.testsRegexp = re
+		}
+	}
+	for _, slo := range c.SLOs {
+		errs = append(errs, slo.validate()...)
+	}
+	for _, unit := range c.Units {
+		errs = append(errs, unit.validate()...)
+	}
+	for _, owner := range c.Owners {
+		errs = append(errs, owner.validate()...)
+	}
+	for _, variant := range c.Variants {
+		errs = append(errs, variant.validate(c)...)
+	}
+	errs = append(errs, c.Digest.validate(c.Jobs)...)
+	plugins := map[string]bool{}
+	for _, plugin := range c.Plugins {
+		if plugins[plugin.Name] {
+			errs = append(errs, fmt.Errorf("plugin %q: configured more than once", plugin.Name))
+		}
+		plugins[plugin.Name] = true
+		errs = append(errs, plugin.validate()...)
+	}
+	presubmits := map[string]bool{}
+	for _, presubmit := range c.Presubmits {
+		if presubmits[presubmit.Job] {
+			errs = append(errs, fmt.Errorf("presubmit %q: configured more than once", presubmit.Job))
+		}
+		presubmits[presubmit.Job] = true
+		errs = append(errs, presubmit.validate(c)...)
+	}
+	quotas := map[string]bool{}
+	for _, quota := range c.Quotas {
+		if quotas[quota.Datasource] {
+			errs = append(errs, fmt.Errorf("quota %q: configured more than once", quota.Datasource))
+		}
+		quotas[quota.Datasource] = true
+		errs = append(errs, quota.validate()...)
+	}
+	return errs
+}
+
+// JobNames returns the names of all configured jobs.
+func (c *Config) JobNames() JobList {
+	jobs := JobList{}
+	for _, job := range c.Jobs {
+		jobs = append(jobs, job.Name)
+	}
+	return jobs
+}
+
+// jobLabels returns the labels configured for the named job.
+func jobLabels(job string) map[string]string {
+	if jobConfig := config.Job(job); jobConfig != nil {
+		return jobConfig.Labels
+	}
+	return nil
+}
+
+// Job returns the configuration of the named job, or nil if no such job is
+// configured.
+func (c *Config) Job(name string) *JobConfig {
+	if c == nil {
+		return nil
+	}
+	for _, job := range c.Jobs {
+		if job.Name == name {
+			return job
+		}
+	}
+	return nil
+}
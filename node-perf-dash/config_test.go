@@ -0,0 +1,116 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+)
+
+func TestConfigValidate(t *testing.T) {
+	table := []struct {
+		name   string
+		config Config
+		errs   int
+	}{
+		{
+			name:   "no jobs",
+			config: Config{},
+			errs:   1,
+		},
+		{
+			name: "valid",
+			config: Config{Jobs: []*JobConfig{
+				{Name: "ci-kubernetes-node-kubelet-benchmark", Tests: "density_.*"},
+				{Name: "ci-kubernetes-node-kubelet-density"},
+			}},
+			errs: 0,
+		},
+		{
+			name: "empty name and invalid regexp",
+			config: Config{Jobs: []*JobConfig{
+				{Name: ""},
+				{Name: "ci-kubernetes-node-kubelet-benchmark", Tests: "density_(["},
+			}},
+			errs: 2,
+		},
+		{
+			name: "duplicated job",
+			config: Config{Jobs: []*JobConfig{
+				{Name: "ci-kubernetes-node-kubelet-benchmark"},
+				{Name: "ci-kubernetes-node-kubelet-benchmark"},
+			}},
+			errs: 1,
+		},
+		{
+			name: "quotas",
+			config: Config{
+				Jobs: []*JobConfig{{Name: "ci-kubernetes-node-kubelet-benchmark"}},
+				Quotas: []*QuotaConfig{
+					{Datasource: "google-gcs", RequestsPerSecond: 10, BytesPerDay: 1 << 30},
+					{Datasource: "http", BytesPerDay: 1 << 30},
+				},
+			},
+			errs: 0,
+		},
+		{
+			name: "invalid quotas",
+			config: Config{
+				Jobs: []*JobConfig{{Name: "ci-kubernetes-node-kubelet-benchmark"}},
+				Quotas: []*QuotaConfig{
+					{Datasource: "s3", RequestsPerSecond: 10},
+					{Datasource: "google-gcs"},
+					{Datasource: "google-gcs", RequestsPerSecond: -1, Burst: 5},
+					{Datasource: "http", Burst: 5, BytesPerDay: 1},
+				},
+			},
+			// Unknown data source, no limit, configured more than
+			// once, negative rate, burst without a rate.
+			errs: 5,
+		},
+	}
+
+	for _, tt := range table {
+		errs := tt.config.Validate()
+		if len(errs) != tt.errs {
+			t.Errorf("%s: expected %d errors but got %v", tt.name, tt.errs, errs)
+		}
+	}
+}
+
+func TestJobConfigIncludesTest(t *testing.T) {
+	config := Config{Jobs: []*JobConfig{
+		{Name: "all"},
+		{Name: "density", Tests: "^density_"},
+	}}
+	if errs := config.Validate(); len(errs) != 0 {
+		t.Fatalf("Unexpected errors: %v", errs)
+	}
+	table := []struct {
+		job    string
+		test   string
+		expect bool
+	}{
+		{"all", "resource_0", true},
+		{"density", "density_create_batch_35_0_0", true},
+		{"density", "resource_0", false},
+	}
+	for _, tt := range table {
+		if out := config.Job(tt.job).IncludesTest(tt.test); out != tt.expect {
+			t.Errorf("Job %q, test %q: expected %v but got %v", tt.job, tt.test, tt.expect, out)
+		}
+	}
+}
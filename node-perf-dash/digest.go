@@ -0,0 +1,386 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// digestMovers is the number of metrics listed as the biggest movers.
+	digestMovers = 5
+	// defaultSMTPPort is the SMTP submission port.
+	defaultSMTPPort = 587
+)
+
+// Trend verdicts.
+const (
+	trendImproving  = "improving"
+	trendRegressing = "regressing"
+	trendStable     = "stable"
+)
+
+// DigestConfig configures the email digests sent to the recipients configured
+// for each job.
+type DigestConfig struct {
+	// Period is how often the digests are sent, "day" or "week".
+	Period string `json:"period"`
+	// Hour is the hour of the day, in UTC, at which the digests are sent.
+	// Weekly digests are sent on Mondays.
+	Hour int `json:"hour,omitempty"`
+	// DashboardURL is the external URL of the dashboard, used for the links
+	// in the digests.
+	DashboardURL string `json:"dashboardURL,omitempty"`
+	// SMTP is the server the digests are sent through.
+	SMTP SMTPConfig `json:"smtp"`
+}
+
+// SMTPConfig is the configuration of an SMTP server.
+type SMTPConfig struct {
+	Host string `json:"host"`
+	// Port defaults to the submission port, 587.
+	Port int `json:"port,omitempty"`
+	// Username and PasswordFile are the credentials, if the server
+	// requires authentication.
+	Username     string `json:"username,omitempty"`
+	PasswordFile string `json:"passwordFile,omitempty"`
+	// From is the sender address of the digests.
+	From string `json:"from"`
+}
+
+// validate checks the digest configuration of the jobs.
+func (d *DigestConfig) validate(jobs []*JobConfig) []error {
+	var errs []error
+	if d == nil {
+		for _, job := range jobs {
+			if len(job.DigestTo) > 0 {
+				errs = append(errs, fmt.Errorf("job %q: digestTo is set but no digest is configured", job.Name))
+			}
+		}
+		return errs
+	}
+	if d.Period != rollupDay && d.Period != rollupWeek {
+		errs = append(errs, fmt.Errorf("digest: period must be %q or %q", rollupDay, rollupWeek))
+	}
+	if d.Hour < 0 || d.Hour > 23 {
+		errs = append(errs, fmt.Errorf("digest: hour must be between 0 and 23"))
+	}
+	if d.DashboardURL != "" {
+		if _, err := url.Parse(d.DashboardURL); err != nil {
+			errs = append(errs, fmt.Errorf("digest: invalid dashboard URL %q: %v", d.DashboardURL, err))
+		}
+	}
+	if d.SMTP.Host == "" {
+		errs = append(errs, fmt.Errorf("digest: smtp host must not be empty"))
+	}
+	if _, err := mail.ParseAddress(d.SMTP.From); err != nil {
+		errs = append(errs, fmt.Errorf("digest: invalid smtp from address %q: %v", d.SMTP.From, err))
+	}
+	for _, job := range jobs {
+		for _, to := range job.DigestTo {
+			if _, err := mail.ParseAddress(to); err != nil {
+				errs = append(errs, fmt.Errorf("job %q: invalid digest recipient %q: %v", job.Name, to, err))
+			}
+		}
+	}
+	return errs
+}
+
+// periodDuration returns the length of the digest period.
+func (d *DigestConfig) periodDuration() time.Duration {
+	if d.Period == rollupWeek {
+		return 7 * 24 * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// nextDigest returns the first time digests are sent after now.
+func (d *DigestConfig) nextDigest(now time.Time) time.Time {
+	next := periodStart(d.Period, now).Add(time.Duration(d.Hour) * time.Hour)
+	for !next.After(now) {
+		next = next.Add(d.periodDuration())
+	}
+	return next
+}
+
+// Trend is the change of the mean of a metric between two periods.
+type Trend struct {
+	Test   string            `json:"test"`
+	Node   string            `json:"node"`
+	Labels map[string]string `json:"labels"`
+	Bucket string            `json:"bucket"`
+	Unit   string            `json:"unit"`
+	Owner  string            `json:"owner,omitempty"`
+	// Previous and Current are the means of the metric in the previous
+	// and current periods.
+	Previous float64 `json:"previous"`
+	Current  float64 `json:"current"`
+	// Change is the relative change of Current compared to Previous.
+	Change  float64 `json:"change"`
+	Verdict string  `json:"verdict"`
+}
+
+// Digest summarizes the changes of the metrics of a job over a period.
+type Digest struct {
+	Job   string    `json:"job"`
+	Since time.Time `json:"since"`
+	Until time.Time `json:"until"`
+	// Regressions are the regressions of the builds of the period.
+	Regressions []Regression `json:"regressions"`
+	// Trends are the metrics improving or regressing compared to the
+	// previous period.
+	Trends []Trend `json:"trends"`
+	// Stable is the number of metrics which did not change significantly.
+	Stable int `json:"stable"`
+	// Movers are the metrics which changed the most.
+	Movers []Trend `json:"movers"`
+	// BuildInfo identifies the node-perf-dash which built the digest.
+	BuildInfo BuildInfo `json:"buildInfo"`
+}
+
+// meanInWindow returns the mean of the points in [since, until), and whether
+// there is any.
+func meanInWindow(points []Point, since, until time.Time) (float64, bool) {
+	sum, count := 0.0, 0
+	for _, point := range points {
+		if point.Timestamp >= since.Unix() && point.Timestamp < until.Unix() {
+			sum += point.Value
+			count++
+		}
+	}
+	if count == 0 {
+		return 0, false
+	}
+	return sum / float64(count), true
+}
+
+// buildDigest summarizes the series of the job over the period [since, until)
+// of length period.
+func buildDigest(job string, series []*Series, since, until time.Time, period time.Duration) Digest {
+	digest := Digest{Job: job, Since: since, Until: until, Regressions: []Regression{}, Trends: []Trend{}, Movers: []Trend{}, BuildInfo: buildInfo()}
+	for _, s := range series {
+		if len(s.Points) > 0 && s.Points[len(s.Points)-1].Timestamp >= since.Unix() {
+			digest.Regressions = append(digest.Regressions, detectRegressions(job, []*Series{s})...)
+		}
+	}
+
+	var all []Trend
+	for _, s := range series {
+		current, ok := meanInWindow(s.Points, since, until)
+		if !ok {
+			continue
+		}
+		previous, ok := meanInWindow(s.Points, since.Add(-period), since)
+		if !ok || previous == 0 {
+			continue
+		}
+		trend := Trend{Test: s.Test, Node: s.Node, Labels: s.Labels, Bucket: s.Bucket, Unit: s.Unit, Owner: s.Owner, Previous: previous, Current: current}
+		trend.Change = (current - previous) / math.Abs(previous)
+		worse := trend.Change
+		if higherIsBetter(s.Labels) {
+			worse = -worse
+		}
+		threshold := *thresholds.For(job, s.Test, s.Labels, s.Bucket).Trend
+		switch {
+		case worse > threshold:
+			trend.Verdict = trendRegressing
+		case worse < -threshold:
+			trend.Verdict = trendImproving
+		default:
+			trend.Verdict = trendStable
+			digest.Stable++
+		}
+		if trend.Verdict != trendStable {
+			digest.Trends = append(digest.Trends, trend)
+		}
+		all = append(all, trend)
+	}
+	sort.SliceStable(all, func(i, j int) bool { return math.Abs(all[i].Change) > math.Abs(all[j].Change) })
+	if len(all) > digestMovers {
+		all = all[:digestMovers]
+	}
+	digest.Movers = append(digest.Movers, all...)
+	return digest
+}
+
+// seriesLink returns the link to the series of the metric in the dashboard.
+func seriesLink(dashboardURL, job, test, node string) string {
+	query := url.Values{"job": {job}, "test": {test}, "node": {node}}
+	return strings.TrimSuffix(dashboardURL, "/") + "/api/series?" + query.Encode()
+}
+
+// formatDigest returns the subject and the plain text body of the email of
+// the digest.
+func formatDigest(digest Digest, dashboardURL string) (string, string) {
+	subject := fmt.Sprintf("[node-perf-dash] %s: %d regressions, %d trends", digest.Job, len(digest.Regressions), len(digest.Trends))
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "Performance digest of %s from %s to %s.\n", digest.Job, digest.Since.Format(time.RFC3339), digest.Until.Format(time.RFC3339))
+	if dashboardURL != "" {
+		fmt.Fprintf(&body, "Dashboard: %s\n", dashboardURL)
+	}
+	metric := func(test, node string, labels map[string]string, bucket, owner string) string {
+		s := fmt.Sprintf("%s on %s, %s %s", test, node, formatLabels(labels), bucket)
+		if owner != "" {
+			s += " (" + owner + ")"
+		}
+		return s
+	}
+	link := func(test, node string) {
+		if dashboardURL != "" {
+			fmt.Fprintf(&body, "    %s\n", seriesLink(dashboardURL, digest.Job, test, node))
+		}
+	}
+
+	fmt.Fprintf(&body, "\nNew regressions (%d):\n", len(digest.Regressions))
+	for _, r := range digest.Regressions {
+		fmt.Fprintf(&body, "  - %s: %g %s in build %s", metric(r.Test, r.Node, r.Labels, r.Bucket, r.Owner), r.Value, r.Unit, r.Build)
+		if r.Kind == regressionSLO {
+			fmt.Fprintf(&body, ", breaching SLO %s\n", r.SLO)
+		} else {
+			fmt.Fprintf(&body, ", %+.1f%% compared to %g %s\n", r.Change*100, r.Baseline, r.Unit)
+		}
+		link(r.Test, r.Node)
+	}
+	fmt.Fprintf(&body, "\nTrends compared to the previous period (%d, %d stable metrics):\n", len(digest.Trends), digest.Stable)
+	for _, t := range digest.Trends {
+		fmt.Fprintf(&body, "  - %s %s: %g -> %g %s (%+.1f%%)\n", t.Verdict, metric(t.Test, t.Node, t.Labels, t.Bucket, t.Owner), t.Previous, t.Current, t.Unit, t.Change*100)
+		link(t.Test, t.Node)
+	}
+	fmt.Fprintf(&body, "\nBiggest movers:\n")
+	for _, t := range digest.Movers {
+		fmt.Fprintf(&body, "  - %s: %+.1f%%\n", metric(t.Test, t.Node, t.Labels, t.Bucket, t.Owner), t.Change*100)
+		link(t.Test, t.Node)
+	}
+	fmt.Fprintf(&body, "\nGenerated by %s.\n", digest.BuildInfo)
+	return subject, body.String()
+}
+
+// jobDigest builds the digest of the job for the period ending at until.
+func jobDigest(job string, until time.Time, period time.Duration) (Digest, error) {
+	testData, err := jobData(job)
+	if err != nil {
+		return Digest{}, err
+	}
+	return buildDigest(job, extractSeries(job, testData, seriesFilter{}), until.Add(-period), until, period), nil
+}
+
+// sendMail sends the plain text email through the SMTP server.
+func sendMail(c SMTPConfig, to []string, subject, body string) error {
+	port := c.Port
+	if port == 0 {
+		port = defaultSMTPPort
+	}
+	var auth smtp.Auth
+	if c.Username != "" {
+		password, err := ioutil.ReadFile(c.PasswordFile)
+		if err != nil {
+			return fmt.Errorf("failed to read the SMTP password: %v", err)
+		}
+		auth = smtp.PlainAuth("", c.Username, strings.TrimSpace(string(password)), c.Host)
+	}
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", c.From)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&message, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&message, "MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	message.WriteString(strings.Replace(body, "\n", "\r\n", -1))
+	from, err := mail.ParseAddress(c.From)
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(net.JoinHostPort(c.Host, strconv.Itoa(port)), auth, from.Address, to, message.Bytes())
+}
+
+// runDigests sends the digest of each job with recipients at the configured
+// times until ctx is cancelled.
+func runDigests(ctx context.Context, digest *DigestConfig, jobs []*JobConfig) {
+	for {
+		next := digest.nextDigest(time.Now())
+		mainLog.Info("Scheduled the next digests", "at", next)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+		for _, job := range jobs {
+			if len(job.DigestTo) == 0 {
+				continue
+			}
+			d, err := jobDigest(job.Name, next, digest.periodDuration())
+			if err != nil {
+				mainLog.Error("Failed to build the digest", "job", job.Name, "err", err)
+				continue
+			}
+			subject, body := formatDigest(d, digest.DashboardURL)
+			if err := sendMail(digest.SMTP, job.DigestTo, subject, body); err != nil {
+				mainLog.Error("Failed to send the digest", "job", job.Name, "err", err)
+				continue
+			}
+			mainLog.Info("Sent the digest", "job", job.Name, "recipients", len(job.DigestTo))
+		}
+	}
+}
+
+// serveDigest is the HTTP handler previewing the digest of the job in the
+// "job" query parameter for the period ending now, as JSON or, with
+// "format=text", as the body of the email.
+func serveDigest(res http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	job := query.Get("job")
+	if _, ok := allTestData[job]; !ok {
+		writeError(res, http.StatusNotFound, fmt.Errorf("unknown job %q", job))
+		return
+	}
+	digestConfig := &DigestConfig{Period: rollupDay}
+	if config.Digest != nil {
+		digestConfig = config.Digest
+	}
+	if period := query.Get("period"); period != "" {
+		digestConfig = &DigestConfig{Period: period, DashboardURL: digestConfig.DashboardURL}
+	}
+	if digestConfig.Period != rollupDay && digestConfig.Period != rollupWeek {
+		writeError(res, http.StatusBadRequest, fmt.Errorf("invalid period %q", digestConfig.Period))
+		return
+	}
+	d, err := jobDigest(job, time.Now(), digestConfig.periodDuration())
+	if err != nil {
+		writeError(res, http.StatusInternalServerError, err)
+		return
+	}
+	if query.Get("format") == "text" {
+		subject, body := formatDigest(d, digestConfig.DashboardURL)
+		res.Header().Set("Content-type", "text/plain; charset=utf-8")
+		res.WriteHeader(http.StatusOK)
+		fmt.Fprintf(res, "Subject: %s\n\n%s", subject, body)
+		return
+	}
+	writeJSON(res, req, d)
+}
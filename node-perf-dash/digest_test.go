@@ -0,0 +1,133 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildDigest(t *testing.T) {
+	day := 24 * time.Hour
+	until := time.Date(2017, 3, 10, 0, 0, 0, 0, time.UTC)
+	since := until.Add(-day)
+	latency := map[string]string{"datatype": "latency"}
+	throughput := map[string]string{"datatype": "throughput"}
+	table := []struct {
+		name       string
+		labels     map[string]string
+		previous   []float64
+		current    []float64
+		verdict    string
+		regression bool
+	}{
+		{name: "stable", labels: latency, previous: []float64{100, 100, 100}, current: []float64{102}, verdict: trendStable},
+		{name: "latency increase", labels: latency, previous: []float64{100, 100, 100}, current: []float64{130}, verdict: trendRegressing, regression: true},
+		{name: "latency decrease", labels: latency, previous: []float64{100, 100, 100}, current: []float64{80}, verdict: trendImproving},
+		{name: "throughput increase", labels: throughput, previous: []float64{100, 100, 100}, current: []float64{110}, verdict: trendImproving},
+		{name: "throughput decrease", labels: throughput, previous: []float64{100, 100, 100}, current: []float64{90}, verdict: trendRegressing},
+		{name: "no previous period", labels: latency, current: []float64{100}},
+		{name: "no build in the period", labels: latency, previous: []float64{100, 100, 100, 130}},
+	}
+	for _, tt := range table {
+		s := &Series{Test: "test", Node: "node", Labels: tt.labels, Bucket: "Perc99"}
+		for i, value := range tt.previous {
+			s.Points = append(s.Points, Point{Build: strconv.Itoa(len(s.Points) + 1), Value: value, Timestamp: since.Add(-day).Unix() + int64(i)})
+		}
+		for i, value := range tt.current {
+			s.Points = append(s.Points, Point{Build: strconv.Itoa(len(s.Points) + 1), Value: value, Timestamp: since.Unix() + int64(i)})
+		}
+		digest := buildDigest("job", []*Series{s}, since, until, day)
+		verdict := ""
+		if len(digest.Movers) > 0 {
+			verdict = digest.Movers[0].Verdict
+		}
+		if verdict != tt.verdict {
+			t.Errorf("%s: expected verdict %q but got %q", tt.name, tt.verdict, verdict)
+		}
+		if trends := len(digest.Trends); (tt.verdict == trendImproving || tt.verdict == trendRegressing) != (trends == 1) {
+			t.Errorf("%s: expected verdict %q but got %d trends", tt.name, tt.verdict, trends)
+		}
+		if regression := len(digest.Regressions) > 0; regression != tt.regression {
+			t.Errorf("%s: expected regression %v but got %v", tt.name, tt.regression, digest.Regressions)
+		}
+	}
+}
+
+func TestDigestMovers(t *testing.T) {
+	day := 24 * time.Hour
+	until := time.Date(2017, 3, 10, 0, 0, 0, 0, time.UTC)
+	since := until.Add(-day)
+	var series []*Series
+	for i := 0; i < digestMovers+2; i++ {
+		series = append(series, &Series{Test: "test" + strconv.Itoa(i), Node: "node", Points: []Point{
+			{Build: "1", Value: 100, Timestamp: since.Add(-day).Unix()},
+			{Build: "2", Value: float64(100 + i), Timestamp: since.Unix()},
+		}})
+	}
+	digest := buildDigest("job", series, since, until, day)
+	if len(digest.Movers) != digestMovers {
+		t.Fatalf("expected %d movers but got %d", digestMovers, len(digest.Movers))
+	}
+	if expected := "test" + strconv.Itoa(digestMovers+1); digest.Movers[0].Test != expected {
+		t.Errorf("expected biggest mover %q but got %q", expected, digest.Movers[0].Test)
+	}
+}
+
+func TestNextDigest(t *testing.T) {
+	// 2017-03-08 is a Wednesday.
+	now := time.Date(2017, 3, 8, 10, 30, 0, 0, time.UTC)
+	table := []struct {
+		period string
+		hour   int
+		expect time.Time
+	}{
+		{period: rollupDay, hour: 8, expect: time.Date(2017, 3, 9, 8, 0, 0, 0, time.UTC)},
+		{period: rollupDay, hour: 12, expect: time.Date(2017, 3, 8, 12, 0, 0, 0, time.UTC)},
+		{period: rollupWeek, hour: 8, expect: time.Date(2017, 3, 13, 8, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range table {
+		d := &DigestConfig{Period: tt.period, Hour: tt.hour}
+		if next := d.nextDigest(now); !next.Equal(tt.expect) {
+			t.Errorf("%s at %d: expected %v but got %v", tt.period, tt.hour, tt.expect, next)
+		}
+	}
+}
+
+func TestFormatDigest(t *testing.T) {
+	digest := Digest{
+		Job:         "job",
+		Regressions: []Regression{{Test: "test", Node: "node", Bucket: "Perc99", Unit: "ms", Build: "2", Value: 130, Baseline: 100, Change: 0.3, Kind: regressionRelative}},
+		Trends:      []Trend{{Test: "test", Node: "node", Bucket: "Perc99", Unit: "ms", Previous: 100, Current: 130, Change: 0.3, Verdict: trendRegressing}},
+	}
+	subject, body := formatDigest(digest, "http://perf.example.com/")
+	if expected := "[node-perf-dash] job: 1 regressions, 1 trends"; subject != expected {
+		t.Errorf("expected subject %q but got %q", expected, subject)
+	}
+	for _, expected := range []string{
+		"130 ms in build 2, +30.0% compared to 100 ms",
+		"regressing test on node",
+		"http://perf.example.com/api/series?job=job&node=node&test=test",
+		"Generated by node-perf-dash",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("expected %q in the body but got %q", expected, body)
+		}
+	}
+}
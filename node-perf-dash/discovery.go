@@ -0,0 +1,97 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+)
+
+var maxBuildProbes = flag.Int("max-build-probes", 10, "The maximum number of builds probed after the build named by latest-build.txt, which may lag behind the last completed build")
+
+const (
+	// finishedFile is the file uploaded at the end of a build, by the
+	// test-infra conventions.
+	finishedFile = "finished.json"
+)
+
+// BuildLister is implemented by the data sources which can list the builds of
+// a job. Listing is expensive, so it is only used when the latest build can
+// not be found from the latest-build.txt pointer.
+type BuildLister interface {
+	ListBuilds(job string) ([]int, error)
+}
+
+// buildFinished returns true if the build has uploaded its finished.json.
+func buildFinished(source Downloader, job string, buildNumber int) bool {
+	body, err := source.GetFile(job, buildNumber, finishedFile)
+	if err != nil {
+		return false
+	}
+	body.Close()
+	return true
+}
+
+// probeBuilds returns the last of the builds after the given one, which
+// finished in sequence, probing at most --max-build-probes builds.
+func probeBuilds(source Downloader, job string, buildNumber int) int {
+	for i := 0; i < *maxBuildProbes && buildFinished(source, job, buildNumber+1); i++ {
+		buildNumber++
+	}
+	return buildNumber
+}
+
+// discoverLatestBuild returns the latest build of the job, following the
+// test-infra conventions: the build named by latest-build.txt, or by known,
+// the last build already fetched, if it is more recent, followed by the
+// builds which finished after it. The builds are only listed if there is
+// neither a pointer nor a known build.
+func discoverLatestBuild(source Downloader, job string, known int) (int, error) {
+	latest, err := source.GetLastestBuildNumber(job)
+	if err != nil || latest < 0 {
+		downloaderLog.Debug("No latest build pointer", "job", job, "err", err)
+		latest = -1
+	}
+	if known > latest {
+		latest = known
+	}
+	if latest <= 0 {
+		lister, ok := source.(BuildLister)
+		if !ok {
+			return -1, fmt.Errorf("failed to read the latest build pointer of job %q: %v", job, err)
+		}
+		downloaderLog.Info("Listing the builds of the job without a latest build pointer", "job", job)
+		builds, err := lister.ListBuilds(job)
+		if err != nil {
+			return -1, fmt.Errorf("failed to list the builds of job %q: %v", job, err)
+		}
+		for _, build := range builds {
+			if build > latest {
+				latest = build
+			}
+		}
+		if latest < 0 {
+			return -1, fmt.Errorf("no builds found for job %q", job)
+		}
+		return latest, nil
+	}
+	if probed := probeBuilds(source, job, latest); probed != latest {
+		downloaderLog.Debug("Found builds after the latest build pointer", "job", job, "pointer", latest, "build", probed)
+		latest = probed
+	}
+	return latest, nil
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// fakeBuildSource is a data source with the given finished builds and
+// latest-build.txt pointer, counting the listings of the builds.
+type fakeBuildSource struct {
+	pointer  int
+	finished map[int]bool
+	listed   int
+}
+
+func (s *fakeBuildSource) GetLastestBuildNumber(job string) (int, error) {
+	if s.pointer < 0 {
+		return -1, fmt.Errorf("%s not found", latestBuildFile)
+	}
+	return s.pointer, nil
+}
+
+func (s *fakeBuildSource) ListBuilds(job string) ([]int, error) {
+	s.listed++
+	var builds []int
+	for build := range s.finished {
+		builds = append(builds, build)
+	}
+	return builds, nil
+}
+
+func (s *fakeBuildSource) ListFilesInBuild(job string, build int, prefix string) ([]string, error) {
+	return nil, nil
+}
+
+func (s *fakeBuildSource) GetFile(job string, buildNumber int, filePath string) (io.ReadCloser, error) {
+	if filePath != finishedFile || !s.finished[buildNumber] {
+		return nil, fmt.Errorf("%d/%s not found", buildNumber, filePath)
+	}
+	return ioutil.NopCloser(strings.NewReader("{}")), nil
+}
+
+func TestDiscoverLatestBuild(t *testing.T) {
+	finished := map[int]bool{1: true, 2: true, 3: true, 4: true, 5: true, 7: true}
+	table := []struct {
+		name    string
+		pointer int
+		known   int
+		expect  int
+		listed  int
+	}{
+		{name: "up to date pointer", pointer: 5, expect: 5},
+		{name: "lagging pointer", pointer: 3, expect: 5},
+		{name: "known build after the pointer", pointer: 2, known: 4, expect: 5},
+		{name: "missing pointer with a known build", pointer: -1, known: 3, expect: 5},
+		{name: "missing pointer", pointer: -1, expect: 7, listed: 1},
+	}
+	for _, tt := range table {
+		source := &fakeBuildSource{pointer: tt.pointer, finished: finished}
+		build, err := discoverLatestBuild(source, "job", tt.known)
+		if err != nil || build != tt.expect {
+			t.Errorf("%s: expected build %d but got %d (%v)", tt.name, tt.expect, build, err)
+		}
+		if source.listed != tt.listed {
+			t.Errorf("%s: expected %d listings but got %d", tt.name, tt.listed, source.listed)
+		}
+	}
+}
+
+func TestProbeBuildsLimit(t *testing.T) {
+	finished := map[int]bool{}
+	for build := 1; build <= *maxBuildProbes+5; build++ {
+		finished[build] = true
+	}
+	source := &fakeBuildSource{pointer: 1, finished: finished}
+	if build := probeBuilds(source, "job", 1); build != 1+*maxBuildProbes {
+		t.Errorf("expected build %d but got %d", 1+*maxBuildProbes, build)
+	}
+}
@@ -18,10 +18,12 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
+	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"strconv"
@@ -63,15 +65,31 @@ func (d *LocalDownloader) GetLastestBuildNumber(job string) (int, error) {
 
 	i, err := strconv.Atoi(scanner.Text())
 	if err != nil {
-		log.Fatal(err)
-		return -1, err
+		return -1, fmt.Errorf("failed to parse the latest build number %q: %v", scanner.Text(), err)
 	}
+	downloaderLog.Debug("Read the latest build number", "job", job, "build", i)
 	return i, nil
 }
 
+// ListBuilds returns the numbered directories in the data directory.
+func (d *LocalDownloader) ListBuilds(job string) ([]int, error) {
+	filesInDir, err := ioutil.ReadDir(*localDataDir)
+	if err != nil {
+		return nil, err
+	}
+	var builds []int
+	for _, file := range filesInDir {
+		if n, err := strconv.Atoi(file.Name()); err == nil && file.IsDir() {
+			builds = append(builds, n)
+		}
+	}
+	return builds, nil
+}
+
 // ListFilesInBuild returns the contents of the files with the specified prefix
 // for the test job at the given buildNumber.
 func (d *LocalDownloader) ListFilesInBuild(job string, buildNumber int, prefix string) ([]string, error) {
+	downloaderLog.Debug("Listing files", "job", job, "build", buildNumber, "prefix", prefix)
 	prefixDir, prefixFile := path.Split(prefix)
 	filesInDir, err := ioutil.ReadDir(path.Join(*localDataDir, fmt.Sprintf("%d", buildNumber), prefixDir))
 	if err != nil {
@@ -88,18 +106,25 @@ func (d *LocalDownloader) ListFilesInBuild(job string, buildNumber int, prefix s
 
 // GetFile returns readcloser of the desired file.
 func (d *LocalDownloader) GetFile(job string, buildNumber int, filePath string) (io.ReadCloser, error) {
+	downloaderLog.Debug("Opening file", "job", job, "build", buildNumber, "path", filePath)
 	return os.Open(path.Join(*localDataDir, fmt.Sprintf("%d", buildNumber), filePath))
 }
 
 // GoogleGCSDownloader gets test data from Google Cloud Storage.
 type GoogleGCSDownloader struct {
 	GoogleGCSBucketUtils *utils.Utils
+	// bucket is used to list the builds, which utils.Utils does not
+	// support.
+	bucket *utils.Bucket
 }
 
-// NewGoogleGCSDownloader creates a new GoogleGCSDownloader
+// NewGoogleGCSDownloader creates a new GoogleGCSDownloader. The builds of the
+// presubmit jobs, whose name contains "pull", are found through the PR builder
+// directory.
 func NewGoogleGCSDownloader() *GoogleGCSDownloader {
 	return &GoogleGCSDownloader{
-		GoogleGCSBucketUtils: utils.NewUtils(utils.KubekinsBucket, utils.LogDir),
+		GoogleGCSBucketUtils: utils.NewWithPresubmitDetection(utils.KubekinsBucket, utils.LogDir, utils.PullKey, utils.PullLogDir),
+		bucket:               utils.NewBucket(utils.KubekinsBucket),
 	}
 }
 
@@ -109,17 +134,65 @@ func (d *GoogleGCSDownloader) GetLastestBuildNumber(job string) (int, error) {
 	return d.GoogleGCSBucketUtils.GetLastestBuildNumberFromJenkinsGoogleBucket(job)
 }
 
+// ListBuilds returns the numbered directories of the job in the bucket. Only
+// the directories are listed, not the files of the builds.
+func (d *GoogleGCSDownloader) ListBuilds(job string) ([]int, error) {
+	listURL := d.bucket.ExpandListURL(utils.LogDir, job)
+	prefix := listURL.Query().Get("prefix") + "/"
+	var builds []int
+	pageToken := ""
+	for {
+		query := url.Values{"prefix": {prefix}, "delimiter": {"/"}, "fields": {"prefixes,nextPageToken"}}
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+		listURL.RawQuery = query.Encode()
+		response, err := http.Get(listURL.String())
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Prefixes      []string `json:"prefixes"`
+			NextPageToken string   `json:"nextPageToken"`
+		}
+		if response.StatusCode != http.StatusOK {
+			response.Body.Close()
+			return nil, fmt.Errorf("got status code %d listing %q", response.StatusCode, prefix)
+		}
+		err = json.NewDecoder(response.Body).Decode(&page)
+		response.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the listing of %q: %v", prefix, err)
+		}
+		for _, dir := range page.Prefixes {
+			if n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(dir, prefix), "/")); err == nil {
+				builds = append(builds, n)
+			}
+		}
+		if page.NextPageToken == "" {
+			return builds, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
 // ListFilesInBuild returns the contents of the files with the specified prefix
 // for the test job at the given buildNumber.
 func (d *GoogleGCSDownloader) ListFilesInBuild(job string, buildNumber int, prefix string) ([]string, error) {
+	downloaderLog.Debug("Listing files", "job", job, "build", buildNumber, "prefix", prefix)
 	return d.GoogleGCSBucketUtils.ListFilesInBuild(job, buildNumber, prefix)
 }
 
 // GetFile returns readcloser of the desired file.
 func (d *GoogleGCSDownloader) GetFile(job string, buildNumber int, filePath string) (io.ReadCloser, error) {
+	downloaderLog.Debug("Downloading file", "job", job, "build", buildNumber, "path", filePath)
 	response, err := d.GoogleGCSBucketUtils.GetFileFromJenkinsGoogleBucket(job, buildNumber, filePath)
 	if err != nil {
 		return nil, err
 	}
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		return nil, fmt.Errorf("got status code %d getting %q", response.StatusCode, filePath)
+	}
 	return response.Body, nil
 }
@@ -0,0 +1,257 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"k8s.io/contrib/node-perf-dash/client"
+	"k8s.io/contrib/test-utils/utils"
+)
+
+// fakeGCS is a fake of the subset of the GCS JSON and XML APIs used by
+// GoogleGCSDownloader: listing the objects of a bucket and reading them.
+type fakeGCS struct {
+	bucket string
+	// objects is a map from the name of an object to its content.
+	objects map[string][]byte
+	// pageSize is the maximum number of objects and prefixes in a page of
+	// a listing.
+	pageSize int
+}
+
+func (f *fakeGCS) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/storage/v1/b/"+f.bucket+"/o" {
+		f.list(res, req)
+		return
+	}
+	content, ok := f.objects[strings.TrimPrefix(req.URL.Path, "/"+f.bucket+"/")]
+	if !ok {
+		http.NotFound(res, req)
+		return
+	}
+	res.Write(content)
+}
+
+func (f *fakeGCS) list(res http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	prefix, delimiter := query.Get("prefix"), query.Get("delimiter")
+	var names []string
+	for name := range f.objects {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	type item struct {
+		Name string `json:"name"`
+	}
+	var listing struct {
+		Items         []item   `json:"items,omitempty"`
+		Prefixes      []string `json:"prefixes,omitempty"`
+		NextPageToken string   `json:"nextPageToken,omitempty"`
+	}
+	// The objects and the prefixes are paged in order, the page token
+	// being the last one of the previous page.
+	token, listed, seen := query.Get("pageToken"), 0, map[string]bool{}
+	for _, name := range names {
+		entry, isPrefix := name, false
+		if rest := strings.TrimPrefix(name, prefix); delimiter != "" && strings.Contains(rest, delimiter) {
+			entry, isPrefix = prefix+rest[:strings.Index(rest, delimiter)+len(delimiter)], true
+		}
+		if seen[entry] || entry <= token {
+			continue
+		}
+		seen[entry] = true
+		if f.pageSize > 0 && listed == f.pageSize {
+			listing.NextPageToken = token
+			break
+		}
+		if isPrefix {
+			listing.Prefixes = append(listing.Prefixes, entry)
+		} else {
+			listing.Items = append(listing.Items, item{Name: entry})
+		}
+		token = entry
+		listed++
+	}
+	json.NewEncoder(res).Encode(listing)
+}
+
+// e2eArtifact returns a perf data artifact of the density test on the node,
+// run at the timestamp with the given latency.
+func e2eArtifact(node string, timestamp int64, latency float64) []byte {
+	return []byte(fmt.Sprintf(`{"version": "v2", "labels": {"test": "density_create_batch_105_0_0", "node": %q, "image": "cos-stable-60", "machine": "cpu:1core,memory:3.5GB", "timestamp": "%d", "desc": "create 105 pods with 0s interval [Benchmark]"}, "dataItems": [{"data": {"Perc50": %v, "Perc99": %v}, "unit": "ms", "labels": {"datatype": "latency", "latencytype": "create-pod"}}]}`, node, timestamp, latency/2, latency))
+}
+
+func gzipped(content []byte) []byte {
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	writer.Write(content)
+	writer.Close()
+	return buffer.Bytes()
+}
+
+// TestEndToEnd runs the pipeline of the dashboard against a fake GCS bucket:
+// the builds are discovered, their artifacts listed, downloaded and parsed,
+// and the metrics are served by the API.
+func TestEndToEnd(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping the end-to-end test in short mode")
+	}
+	job := "ci-kubernetes-node-kubelet-benchmark"
+	node := "tmp-node-e2e-1234-cos-stable-60-9592-84-0"
+	dir := filepath.Join(utils.LogDir, job)
+	gcs := &fakeGCS{bucket: utils.KubekinsBucket, pageSize: 1, objects: map[string][]byte{
+		// The pointer lags behind build 3, which is probed.
+		dir + "/latest-build.txt": []byte("2"),
+
+		dir + "/1/started.json":                               []byte(`{"version": "v1.8.0-alpha.1+0000000000000000000000000000000000abc001", "timestamp": 1500082800}`),
+		dir + "/1/finished.json":                              []byte(`{"result": "SUCCESS"}`),
+		dir + "/1/artifacts/performance-" + node + ".json":    e2eArtifact(node, 1500086400, 100),
+		dir + "/1/artifacts/build-log.txt":                    []byte("not an artifact of the dashboard"),
+		dir + "/2/started.json":                               []byte(`{"version": "v1.8.0-alpha.1+0000000000000000000000000000000000abc002", "timestamp": 1500169200}`),
+		dir + "/2/finished.json":                              []byte(`{"result": "SUCCESS"}`),
+		dir + "/2/artifacts/performance-" + node + ".json.gz": gzipped(e2eArtifact(node, 1500172800, 200)),
+		dir + "/3/started.json":                               []byte(`{"version": "v1.8.0-alpha.1+0000000000000000000000000000000000abc003", "timestamp": 1500255600}`),
+		dir + "/3/finished.json":                              []byte(`{"result": "SUCCESS"}`),
+		dir + "/3/artifacts/performance-" + node + ".json":    e2eArtifact(node, 1500259200, 300),
+		dir + "/3/artifacts/performance-broken.json":          []byte(`{"version": "v3", "dataItems": []}`),
+	}}
+	gcsServer := httptest.NewServer(gcs)
+	defer gcsServer.Close()
+	downloader := &GoogleGCSDownloader{
+		GoogleGCSBucketUtils: utils.NewTestUtils(utils.KubekinsBucket, utils.LogDir, gcsServer.URL),
+		bucket:               utils.NewTestBucket(utils.KubekinsBucket, gcsServer.URL),
+	}
+
+	defer func(c *Config, source string) {
+		config, *datasource = c, source
+		dataLock.Lock()
+		delete(allTestData, job)
+		delete(allGrabbedLastBuild, job)
+		delete(allUnparsed, job)
+		delete(allScans, job)
+		dataLock.Unlock()
+		nodeNameCacheLock.Lock()
+		nodeNameCache = map[string]string{}
+		nodeNameCacheLock.Unlock()
+	}(config, *datasource)
+	config = &Config{Jobs: []*JobConfig{{Name: job}}}
+	*datasource = "google-gcs"
+	allTestData[job] = TestToBuildData{}
+
+	listed, err := downloader.ListBuilds(job)
+	if expect := []int{1, 2, 3}; err != nil || !reflect.DeepEqual(listed, expect) {
+		t.Errorf("expected the builds %v to be listed over several pages but got %v (%v)", expect, listed, err)
+	}
+	if err := Parse(context.Background(), allTestData, &allTestInfo, job, downloader); err != nil {
+		t.Fatalf("failed to parse the builds: %v", err)
+	}
+
+	mux := newMux(JobList{job}, downloader)
+	document := getOpenAPIDocument(t, mux)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	// get gets the path, checking the response against the OpenAPI document.
+	get := func(path string, v interface{}) {
+		response, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("failed to get %s: %v", path, err)
+		}
+		defer response.Body.Close()
+		body, _ := ioutil.ReadAll(response.Body)
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("expected status 200 for %s but got %d: %s", path, response.StatusCode, body)
+		}
+		checkContract(t, document, path, body)
+		if err := json.Unmarshal(body, v); err != nil {
+			t.Fatalf("failed to decode the response to %s: %v: %s", path, err, body)
+		}
+	}
+
+	var jobDetails []JobDetails
+	get("/api/jobs", &jobDetails)
+	if len(jobDetails) != 1 || jobDetails[0].Name != job {
+		t.Errorf("expected the job %s to be served but got %+v", job, jobDetails)
+	}
+
+	var series SeriesResponse
+	get("/api/series?job="+job+"&bucket=Perc99&metric=datatype=latency", &series)
+	if len(series.Series) != 1 {
+		t.Fatalf("expected 1 series but got %+v", series.Series)
+	}
+	var values []string
+	for _, point := range series.Series[0].Points {
+		values = append(values, fmt.Sprintf("%s=%v", point.Build, point.Value))
+	}
+	// The gzipped artifact of build 2 is parsed like the others.
+	if expect := []string{"1=100", "2=200", "3=300"}; !reflect.DeepEqual(values, expect) {
+		t.Errorf("expected the points %v but got %v", expect, values)
+	}
+
+	// The client library decodes the responses of the API.
+	c := client.NewClient(server.URL)
+	fetched, err := c.GetSeries(context.Background(), job, &client.SeriesOptions{Filter: client.Filter{Bucket: "Perc99", Metrics: map[string]string{"datatype": "latency"}}})
+	if err != nil || len(fetched.Series) != 1 || len(fetched.Series[0].Points) != 3 || fetched.Series[0].Points[2].Value != 300 {
+		t.Errorf("expected the client to get the series but got %+v (%v)", fetched, err)
+	}
+	if _, err := c.GetSeries(context.Background(), "unknown", nil); !isStatus(err, http.StatusNotFound) {
+		t.Errorf("expected the client to fail with status 404 for an unknown job but got %v", err)
+	}
+
+	// The other responses match the OpenAPI document too.
+	for _, path := range []string{"/version", "/api/regressions", "/api/rollups?job=" + job + "&period=day", "/api/annotations?job=" + job, "/api/golden?job=" + job, "/api/pulls", "/api/variants"} {
+		var v interface{}
+		get(path, &v)
+	}
+
+	var unparsed UnparsedBuilds
+	get("/api/unparsed?job="+job, &unparsed)
+	if len(unparsed.Artifacts) != 1 || unparsed.Artifacts[0].Build != "3" || unparsed.Artifacts[0].Version != "v3" {
+		t.Errorf("expected the artifact of schema v3 of build 3 to be reported but got %+v", unparsed.Artifacts)
+	}
+
+	response, err := http.Get(server.URL + "/api/artifact?job=" + job + "&build=3&path=artifacts/performance-" + node + ".json")
+	if err != nil {
+		t.Fatalf("failed to get the artifact: %v", err)
+	}
+	defer response.Body.Close()
+	if body, _ := ioutil.ReadAll(response.Body); !bytes.Equal(body, gcs.objects[dir+"/3/artifacts/performance-"+node+".json"]) {
+		t.Errorf("expected the artifact to be proxied but got status %d: %s", response.StatusCode, body)
+	}
+}
+
+// isStatus returns whether err is an error of the client for a response with
+// the status code.
+func isStatus(err error, code int) bool {
+	apiErr, ok := err.(*client.APIError)
+	return ok && apiErr.StatusCode == code
+}
@@ -0,0 +1,374 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+// quotaDatasources are the data sources a quota can be configured for.
+var quotaDatasources = map[string]bool{"local": true, "google-gcs": true, "http": true, "jenkins": true, "bigquery": true}
+
+// QuotaConfig is the fetch quota of a data source, shared by the listings and
+// downloads of all the jobs.
+type QuotaConfig struct {
+	// Datasource is the data source the quota applies to, e.g.
+	// "google-gcs". The quotas of the other data sources are ignored.
+	Datasource string `json:"datasource"`
+	// RequestsPerSecond is the sustained rate of the requests to the data
+	// source. The requests are not limited if it is 0.
+	RequestsPerSecond float64 `json:"requestsPerSecond,omitempty"`
+	// Burst is the number of requests which can be sent at once after a
+	// pause, RequestsPerSecond rounded up by default.
+	Burst int `json:"burst,omitempty"`
+	// BytesPerDay is the volume of the downloads per day. It is spread
+	// over the day: at most an hour's share is downloaded in a burst. The
+	// downloads are not limited if it is 0.
+	BytesPerDay int64 `json:"bytesPerDay,omitempty"`
+}
+
+func (q *QuotaConfig) validate() []error {
+	if !quotaDatasources[q.Datasource] {
+		return []error{fmt.Errorf("quota %q: unknown data source", q.Datasource)}
+	}
+	var errs []error
+	if q.RequestsPerSecond < 0 {
+		errs = append(errs, fmt.Errorf("quota %q: requests per second must not be negative", q.Datasource))
+	}
+	if q.Burst < 0 {
+		errs = append(errs, fmt.Errorf("quota %q: burst must not be negative", q.Datasource))
+	}
+	if q.Burst > 0 && q.RequestsPerSecond == 0 {
+		errs = append(errs, fmt.Errorf("quota %q: burst requires requests per second", q.Datasource))
+	}
+	if q.BytesPerDay < 0 {
+		errs = append(errs, fmt.Errorf("quota %q: bytes per day must not be negative", q.Datasource))
+	}
+	if q.RequestsPerSecond == 0 && q.BytesPerDay == 0 {
+		errs = append(errs, fmt.Errorf("quota %q: requests per second or bytes per day must be set", q.Datasource))
+	}
+	return errs
+}
+
+// Quota returns the quota of the data source, or nil if it has none.
+func (c *Config) Quota(datasource string) *QuotaConfig {
+	for _, quota := range c.Quotas {
+		if quota.Datasource == datasource {
+			return quota
+		}
+	}
+	return nil
+}
+
+// fetchQuota is the budget of the data source, nil if it has no quota.
+var fetchQuota *fetchBudget
+
+// fetchBudget spreads the requests of the jobs to a data source over time
+// according to its quota. The waiting requests are granted first to the jobs
+// with the newest unprocessed builds: the requests for the builds closest to
+// the latest build of their job go first, in the order they were made, so
+// that a job backfilling many builds does not delay the new builds of the
+// others.
+type fetchBudget struct {
+	lock sync.Mutex
+	// requests is the bucket of the requests, refilled at qps up to
+	// burst.
+	requests   tokenBucket
+	qps, burst float64
+	// bytes is the bucket of the bytes downloaded, refilled at
+	// bytesPerSecond up to maxBytes. The downloads are charged as they are
+	// read, so it goes negative after a large download, and the next
+	// requests wait until it is paid back.
+	bytes                    tokenBucket
+	bytesPerSecond, maxBytes float64
+	// latest is the latest build seen of each job.
+	latest  map[string]int
+	waiters fetchWaiters
+	seq     int
+	// scheduled is true if a call to dispatch is scheduled, after the
+	// wait for the next request.
+	scheduled bool
+	now       func() time.Time
+	after     func(time.Duration, func())
+}
+
+// newFetchBudget returns the budget of the quota, or nil if there is no quota.
+func newFetchBudget(quota *QuotaConfig) *fetchBudget {
+	if quota == nil {
+		return nil
+	}
+	now := time.Now()
+	b := &fetchBudget{
+		qps:            quota.RequestsPerSecond,
+		burst:          float64(quota.Burst),
+		bytesPerSecond: float64(quota.BytesPerDay) / (24 * time.Hour).Seconds(),
+		maxBytes:       float64(quota.BytesPerDay) / 24,
+		latest:         map[string]int{},
+		now:            time.Now,
+		after:          func(d time.Duration, f func()) { time.AfterFunc(d, f) },
+	}
+	if b.burst == 0 {
+		b.burst = math.Max(1, math.Ceil(b.qps))
+	}
+	b.requests = tokenBucket{tokens: b.burst, last: now}
+	b.bytes = tokenBucket{tokens: b.maxBytes, last: now}
+	return b
+}
+
+// fetchWaiter is a request waiting for the budget.
+type fetchWaiter struct {
+	job string
+	// behind is the number of builds between the build of the request and
+	// the latest build of the job.
+	behind int
+	seq    int
+	// index is the index of the waiter in the heap, -1 once it is granted.
+	index int
+	ready chan struct{}
+}
+
+// fetchWaiters is a heap of the waiters, the next one to be granted first.
+type fetchWaiters []*fetchWaiter
+
+func (w fetchWaiters) Len() int { return len(w) }
+
+func (w fetchWaiters) Less(i, j int) bool {
+	if w[i].behind != w[j].behind {
+		return w[i].behind < w[j].behind
+	}
+	return w[i].seq < w[j].seq
+}
+
+func (w fetchWaiters) Swap(i, j int) {
+	w[i], w[j] = w[j], w[i]
+	w[i].index = i
+	w[j].index = j
+}
+
+func (w *fetchWaiters) Push(x interface{}) {
+	waiter := x.(*fetchWaiter)
+	waiter.index = len(*w)
+	*w = append(*w, waiter)
+}
+
+func (w *fetchWaiters) Pop() interface{} {
+	old := *w
+	waiter := old[len(old)-1]
+	waiter.index = -1
+	*w = old[:len(old)-1]
+	return waiter
+}
+
+// acquire waits until the budget allows a request of the job for the build,
+// or -1 for the requests finding the builds of the job. It fails if ctx is
+// done first.
+func (b *fetchBudget) acquire(ctx context.Context, job string, build int) error {
+	start := b.now()
+	waiter := &fetchWaiter{job: job, ready: make(chan struct{})}
+	b.lock.Lock()
+	if latest, ok := b.latest[job]; ok && build >= 0 && build < latest {
+		waiter.behind = latest - build
+	}
+	waiter.seq = b.seq
+	b.seq++
+	heap.Push(&b.waiters, waiter)
+	fetchBudgetWaiting.Inc()
+	b.dispatch()
+	b.lock.Unlock()
+
+	select {
+	case <-waiter.ready:
+		fetchBudgetWait.WithLabelValues(job).Observe(b.now().Sub(start).Seconds())
+		return nil
+	case <-ctx.Done():
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		if waiter.index >= 0 {
+			heap.Remove(&b.waiters, waiter.index)
+			fetchBudgetWaiting.Dec()
+		}
+		return ctx.Err()
+	}
+}
+
+// dispatch grants the waiters in order while the budget allows, and schedules
+// itself again for the next one. b.lock must be held.
+func (b *fetchBudget) dispatch() {
+	for b.waiters.Len() > 0 {
+		wait := b.take(b.now())
+		if wait > 0 {
+			if !b.scheduled {
+				b.scheduled = true
+				b.after(wait, b.wake)
+			}
+			return
+		}
+		waiter := heap.Pop(&b.waiters).(*fetchWaiter)
+		fetchBudgetWaiting.Dec()
+		close(waiter.ready)
+	}
+}
+
+// wake dispatches the waiters when the wait scheduled by dispatch is over.
+func (b *fetchBudget) wake() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.scheduled = false
+	b.dispatch()
+}
+
+// take takes a request from the budget at now, or returns how long to wait
+// until it can. b.lock must be held.
+func (b *fetchBudget) take(now time.Time) time.Duration {
+	b.refill(now)
+	var wait float64
+	if b.qps > 0 && b.requests.tokens < 1 {
+		wait = (1 - b.requests.tokens) / b.qps
+	}
+	if b.bytesPerSecond > 0 && b.bytes.tokens < 0 {
+		wait = math.Max(wait, -b.bytes.tokens/b.bytesPerSecond)
+	}
+	if wait > 0 {
+		return time.Duration(math.Ceil(wait * float64(time.Second)))
+	}
+	if b.qps > 0 {
+		b.requests.tokens--
+	}
+	return 0
+}
+
+// refill adds the tokens earned since the last refill to the buckets. b.lock
+// must be held.
+func (b *fetchBudget) refill(now time.Time) {
+	b.requests.tokens = math.Min(b.burst, b.requests.tokens+now.Sub(b.requests.last).Seconds()*b.qps)
+	b.requests.last = now
+	b.bytes.tokens = math.Min(b.maxBytes, b.bytes.tokens+now.Sub(b.bytes.last).Seconds()*b.bytesPerSecond)
+	b.bytes.last = now
+}
+
+// charge charges the bytes downloaded to the budget.
+func (b *fetchBudget) charge(n int) {
+	if b.bytesPerSecond == 0 || n == 0 {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.refill(b.now())
+	b.bytes.tokens -= float64(n)
+}
+
+// observe records that the build of the job exists.
+func (b *fetchBudget) observe(job string, build int) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if latest, ok := b.latest[job]; !ok || build > latest {
+		b.latest[job] = build
+	}
+}
+
+// budgetedDownloader wraps a Downloader to take each of its calls from the
+// budget, waiting until ctx is done at most.
+type budgetedDownloader struct {
+	ctx    context.Context
+	budget *fetchBudget
+	Downloader
+}
+
+// budgetedLister is a budgetedDownloader of a data source which can list the
+// builds.
+type budgetedLister struct {
+	*budgetedDownloader
+	lister BuildLister
+}
+
+// withBudget returns source taking its calls from the budget, or source itself
+// if there is no budget.
+func withBudget(ctx context.Context, source Downloader, budget *fetchBudget) Downloader {
+	if budget == nil {
+		return source
+	}
+	d := &budgetedDownloader{ctx: ctx, budget: budget, Downloader: source}
+	if lister, ok := source.(BuildLister); ok {
+		return &budgetedLister{budgetedDownloader: d, lister: lister}
+	}
+	return d
+}
+
+// GetLastestBuildNumber returns the latest build number.
+func (d *budgetedDownloader) GetLastestBuildNumber(job string) (int, error) {
+	if err := d.budget.acquire(d.ctx, job, -1); err != nil {
+		return -1, err
+	}
+	build, err := d.Downloader.GetLastestBuildNumber(job)
+	if err == nil && build >= 0 {
+		d.budget.observe(job, build)
+	}
+	return build, err
+}
+
+// ListFilesInBuild returns the files with the specified prefix for the test
+// job at the given buildNumber.
+func (d *budgetedDownloader) ListFilesInBuild(job string, buildNumber int, prefix string) ([]string, error) {
+	if err := d.budget.acquire(d.ctx, job, buildNumber); err != nil {
+		return nil, err
+	}
+	return d.Downloader.ListFilesInBuild(job, buildNumber, prefix)
+}
+
+// GetFile returns readcloser of the desired file, charging the bytes read to
+// the budget.
+func (d *budgetedDownloader) GetFile(job string, buildNumber int, filePath string) (io.ReadCloser, error) {
+	if err := d.budget.acquire(d.ctx, job, buildNumber); err != nil {
+		return nil, err
+	}
+	body, err := d.Downloader.GetFile(job, buildNumber, filePath)
+	if err != nil {
+		return nil, err
+	}
+	d.budget.observe(job, buildNumber)
+	return &budgetedBody{ReadCloser: body, budget: d.budget}, nil
+}
+
+// ListBuilds returns the builds of the job.
+func (d *budgetedLister) ListBuilds(job string) ([]int, error) {
+	if err := d.budget.acquire(d.ctx, job, -1); err != nil {
+		return nil, err
+	}
+	builds, err := d.lister.ListBuilds(job)
+	for _, build := range builds {
+		d.budget.observe(job, build)
+	}
+	return builds, err
+}
+
+// budgetedBody charges the bytes read from a download to the budget.
+type budgetedBody struct {
+	io.ReadCloser
+	budget *fetchBudget
+}
+
+func (b *budgetedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.budget.charge(n)
+	return n, err
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// newTestFetchBudget returns the budget of the quota on a fake clock, which
+// only dispatches the waiters when wake is called.
+func newTestFetchBudget(quota *QuotaConfig, now *time.Time) *fetchBudget {
+	b := newFetchBudget(quota)
+	b.now = func() time.Time { return *now }
+	b.after = func(time.Duration, func()) {}
+	b.requests.last, b.bytes.last = *now, *now
+	return b
+}
+
+func TestFetchBudgetTake(t *testing.T) {
+	table := []struct {
+		name  string
+		quota QuotaConfig
+		// charged is charged before the requests.
+		charged int
+		// waits are the waits of the requests taken in a row.
+		waits []time.Duration
+	}{
+		{
+			name:  "burst then rate",
+			quota: QuotaConfig{RequestsPerSecond: 2, Burst: 2},
+			waits: []time.Duration{0, 0, 500 * time.Millisecond},
+		},
+		{
+			name:  "default burst",
+			quota: QuotaConfig{RequestsPerSecond: 0.5},
+			waits: []time.Duration{0, 2 * time.Second},
+		},
+		{
+			// 100 bytes per second, with bursts of an hour's share.
+			name:    "bytes paid back",
+			quota:   QuotaConfig{BytesPerDay: 8640000},
+			charged: 360000 + 200,
+			waits:   []time.Duration{2 * time.Second},
+		},
+		{
+			name:    "bytes within the burst",
+			quota:   QuotaConfig{BytesPerDay: 8640000},
+			charged: 360000,
+			waits:   []time.Duration{0, 0},
+		},
+	}
+	for _, tt := range table {
+		now := time.Unix(1500000000, 0)
+		b := newTestFetchBudget(&tt.quota, &now)
+		b.charge(tt.charged)
+		for i, expect := range tt.waits {
+			if wait := b.take(now); wait != expect {
+				t.Errorf("%s: expected request %d to wait %v but got %v", tt.name, i, expect, wait)
+			}
+		}
+	}
+}
+
+func TestFetchBudgetPriority(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	b := newTestFetchBudget(&QuotaConfig{RequestsPerSecond: 1}, &now)
+	b.observe("backfill", 20)
+	b.observe("fresh", 10)
+	if err := b.acquire(context.Background(), "fresh", -1); err != nil {
+		t.Fatalf("expected the burst to be granted but got %v", err)
+	}
+
+	granted := make(chan string)
+	requests := []struct {
+		job   string
+		build int
+	}{
+		{"backfill", 5},
+		{"fresh", 10},
+		{"backfill", 19},
+		{"fresh", -1},
+	}
+	for i, r := range requests {
+		go func(job string, build int) {
+			b.acquire(context.Background(), job, build)
+			granted <- fmt.Sprintf("%s/%d", job, build)
+		}(r.job, r.build)
+		// Wait for the request to be queued after the previous ones.
+		for queued := false; !queued; time.Sleep(time.Millisecond) {
+			b.lock.Lock()
+			queued = b.seq == i+2
+			b.lock.Unlock()
+		}
+	}
+
+	// The latest builds of the jobs go first, then the requests finding
+	// the builds in order, then the older builds.
+	for _, expect := range []string{"fresh/10", "fresh/-1", "backfill/19", "backfill/5"} {
+		now = now.Add(time.Second)
+		b.wake()
+		select {
+		case got := <-granted:
+			if got != expect {
+				t.Errorf("expected %s to be granted but got %s", expect, got)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("expected %s to be granted", expect)
+		}
+	}
+}
+
+func TestFetchBudgetCancel(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	b := newTestFetchBudget(&QuotaConfig{RequestsPerSecond: 1}, &now)
+	b.take(now)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := b.acquire(ctx, "job", 1); err != context.Canceled {
+		t.Errorf("expected the request to be canceled but got %v", err)
+	}
+	if len(b.waiters) != 0 {
+		t.Errorf("expected the canceled request to be removed but got %d waiters", len(b.waiters))
+	}
+}
+
+func TestWithBudget(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	b := newTestFetchBudget(&QuotaConfig{RequestsPerSecond: 100, BytesPerDay: 8640000}, &now)
+	source := withBudget(context.Background(), &fakeBuildSource{pointer: 3, finished: map[int]bool{1: true, 2: true, 3: true, 4: true}}, b)
+	if _, ok := source.(BuildLister); !ok {
+		t.Errorf("expected the budgeted source to list the builds")
+	}
+	if build, err := discoverLatestBuild(source, "job", 0); err != nil || build != 4 {
+		t.Errorf("expected the latest build 4 but got %d (%v)", build, err)
+	}
+	body, err := source.GetFile("job", 4, finishedFile)
+	if err != nil {
+		t.Fatalf("failed to get the finished.json of build 4: %v", err)
+	}
+	ioutil.ReadAll(body)
+	body.Close()
+	// The pointer, the probes of builds 4 and 5 and the download.
+	if expect := 100 - 4.0; b.requests.tokens != expect {
+		t.Errorf("expected %v requests left but got %v", expect, b.requests.tokens)
+	}
+	if expect := 360000 - 2.0; b.bytes.tokens != expect {
+		t.Errorf("expected %v bytes left but got %v", expect, b.bytes.tokens)
+	}
+	if b.latest["job"] != 4 {
+		t.Errorf("expected the latest build 4 to be observed but got %d", b.latest["job"])
+	}
+	if _, ok := withBudget(context.Background(), &fakeJobSource{}, b).(BuildLister); ok {
+		t.Errorf("expected the budgeted source not to list the builds of a source which can not")
+	}
+}
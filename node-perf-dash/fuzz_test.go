@@ -0,0 +1,111 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// The fuzz targets feed the parsers with malformed and truncated artifacts,
+// seeded with the samples in testdata/artifacts. Run them with e.g.
+// "go test -fuzz=FuzzPerformanceArtifact".
+
+// addSeeds adds the samples matching the pattern in testdata/artifacts to the
+// seed corpus, also gzipped and truncated in half.
+func addSeeds(f *testing.F, pattern string) {
+	files, err := filepath.Glob(filepath.Join("testdata", "artifacts", pattern))
+	if err != nil || len(files) == 0 {
+		f.Fatalf("failed to find the samples %s: %v", pattern, err)
+	}
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(content)
+		f.Add(gzipped(content))
+		f.Add(content[:len(content)/2])
+	}
+}
+
+// fuzzParser fuzzes the registered parser with the artifacts, and the
+// ingestion of the results it parses.
+func fuzzParser(f *testing.F, name, pattern string) {
+	var registration *parserRegistration
+	for i := range parsers {
+		if parsers[i].name == name {
+			registration = &parsers[i]
+		}
+	}
+	if registration == nil {
+		f.Fatalf("parser %q is not registered", name)
+	}
+	addSeeds(f, pattern)
+	f.Fuzz(func(t *testing.T, content []byte) {
+		content, err := decompressArtifact(content)
+		if err != nil {
+			return
+		}
+		results, err := registration.parser.Parse(content)
+		if err != nil {
+			return
+		}
+		testData, testInfo, testTime := TestToBuildData{}, &TestInfo{Info: map[string]string{}}, &TestTime{}
+		for _, result := range results {
+			if result.Version != supportedMetricVersion {
+				continue
+			}
+			if err := addParsedArtifact(testData, testInfo, testTime, "fuzz", "1", result); err != nil {
+				continue
+			}
+			extractSeries("fuzz", testData, seriesFilter{})
+		}
+	})
+}
+
+func FuzzPerformanceArtifact(f *testing.F) {
+	fuzzParser(f, "performance", "performance-*.json")
+}
+
+func FuzzTimeSeriesArtifact(f *testing.F) {
+	fuzzParser(f, "time_series", "time_series-*.json")
+}
+
+func FuzzKubeletLog(f *testing.F) {
+	addSeeds(f, "kubelet.log")
+	f.Fuzz(func(t *testing.T, content []byte) {
+		statePerPod := map[string]*PodState{}
+		scanner := bufio.NewScanner(bytes.NewReader(content))
+		for scanner.Scan() {
+			parseLogEntry(scanner.Bytes(), statePerPod)
+		}
+	})
+}
+
+func FuzzTracingData(f *testing.F) {
+	f.Add([]byte(timeSeriesTag + `{"op_series": {"pod_running": [1500086392000000000]}, "labels": {"test": "density", "node": "tmp-node-e2e-7a3b1-cos-stable-60-9592-84-0"}}` + "\n\n" + timeSeriesEnd + "\n"))
+	f.Add([]byte(timeSeriesTag + "\n" + timeSeriesEnd))
+	f.Fuzz(func(t *testing.T, content []byte) {
+		testData := TestToBuildData{}
+		testData.GetDataPerBuild("fuzz", "1", "density", "cos-stable-60-9592-84-0_tmp-node-e2e")
+		parseTracingData(bufio.NewScanner(bytes.NewReader(content)), "fuzz", 1, testData)
+	})
+}
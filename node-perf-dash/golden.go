@@ -0,0 +1,387 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	// allGolden is a map from job to the builds marked as golden baselines
+	// through the API, in the order they were marked. The last one is the
+	// active baseline. It is protected by dataLock.
+	allGolden = map[string][]*goldenSnapshot{}
+
+	// configGolden is a map from job to the golden build configured for the
+	// job, once its data has been fetched. It is protected by dataLock.
+	configGolden = map[string]*goldenSnapshot{}
+)
+
+func goldenKey(job string) string {
+	return "golden/" + job
+}
+
+// GoldenBaseline is a build pinned as the reference of its job, which the
+// other builds are compared with instead of the previous builds.
+type GoldenBaseline struct {
+	Build string `json:"build"`
+	// Note describes why the build was chosen, e.g. "v1.8.0 release".
+	Note string `json:"note,omitempty"`
+	// MarkedAt is when the build was marked, or zero for the golden build
+	// of the configuration.
+	MarkedAt time.Time `json:"markedAt,omitempty"`
+	// Configured is true for the golden build of the configuration.
+	Configured bool `json:"configured,omitempty"`
+}
+
+// goldenSnapshot is a golden baseline together with a copy of the data of its
+// build, so that it can be compared with once the build is dropped from the
+// --builds window.
+type goldenSnapshot struct {
+	GoldenBaseline
+	Data buildSnapshot `json:"data"`
+}
+
+// addSnapshot adds the data of the build to testData.
+func addSnapshot(testData TestToBuildData, job, build string, snapshot buildSnapshot) {
+	for test, dataPerNode := range snapshot {
+		for node, data := range dataPerNode {
+			testData.GetDataPerBuild(job, build, test, node)
+			testData[test].Data[node][build] = data
+		}
+	}
+}
+
+// buildDataOf returns the data of the build of the job, from memory if it is
+// within the --builds window and else from the data source.
+func buildDataOf(ctx context.Context, job string, buildNumber int, source Downloader) (buildSnapshot, error) {
+	build := strconv.Itoa(buildNumber)
+	testData, err := jobBuildData(job, map[string]bool{build: true})
+	if err != nil {
+		return nil, err
+	}
+	if snapshot := snapshotBuild(testData, build); len(snapshot) > 0 {
+		return snapshot, nil
+	}
+	fetched := TestToBuildData{}
+	if err := populateDataForOneBuild(ctx, fetched, &TestInfo{Info: map[string]string{}}, job, buildNumber, source); err != nil {
+		return nil, fmt.Errorf("failed to fetch build %d of job %q: %v", buildNumber, job, err)
+	}
+	snapshot := snapshotBuild(fetched, build)
+	if len(snapshot) == 0 {
+		return nil, fmt.Errorf("build %d of job %q has no data", buildNumber, job)
+	}
+	return snapshot, nil
+}
+
+// goldenBaselines returns the golden baselines marked for the job, the active
+// one last.
+func goldenBaselines(job string) []GoldenBaseline {
+	dataLock.RLock()
+	defer dataLock.RUnlock()
+	baselines := []GoldenBaseline{}
+	for _, g := range allGolden[job] {
+		baselines = append(baselines, g.GoldenBaseline)
+	}
+	return baselines
+}
+
+// activeGolden returns the active golden baseline of the job: the last build
+// marked through the API, or else the golden build of the configuration. It
+// returns nil if the job has no golden baseline.
+func activeGolden(ctx context.Context, job string, source Downloader) (*goldenSnapshot, error) {
+	dataLock.RLock()
+	marked := allGolden[job]
+	configured := configGolden[job]
+	dataLock.RUnlock()
+	if len(marked) > 0 {
+		return marked[len(marked)-1], nil
+	}
+	jobConfig := config.Job(job)
+	if jobConfig == nil || jobConfig.GoldenBuild == 0 {
+		return nil, nil
+	}
+	if configured != nil && configured.Build == strconv.Itoa(jobConfig.GoldenBuild) {
+		return configured, nil
+	}
+	snapshot, err := buildDataOf(ctx, job, jobConfig.GoldenBuild, source)
+	if err != nil {
+		return nil, err
+	}
+	configured = &goldenSnapshot{GoldenBaseline: GoldenBaseline{Build: strconv.Itoa(jobConfig.GoldenBuild), Configured: true}, Data: snapshot}
+	dataLock.Lock()
+	configGolden[job] = configured
+	dataLock.Unlock()
+	return configured, nil
+}
+
+// saveGolden persists the golden baselines of the job. It must be called with
+// dataLock held.
+func saveGolden(job string) error {
+	if store == nil {
+		return nil
+	}
+	return store.Put(goldenKey(job), allGolden[job])
+}
+
+// loadGolden loads the golden baselines of the job from the store. It must be
+// called with dataLock held.
+func loadGolden(job string) error {
+	var golden []*goldenSnapshot
+	if err := store.Get(goldenKey(job), &golden); err != nil && err != errNotFound {
+		return err
+	}
+	if len(golden) > 0 {
+		allGolden[job] = golden
+	} else {
+		delete(allGolden, job)
+	}
+	return nil
+}
+
+// markGolden makes the build the active golden baseline of the job.
+func markGolden(job, build, note string, snapshot buildSnapshot) (GoldenBaseline, error) {
+	dataLock.Lock()
+	defer dataLock.Unlock()
+	var golden []*goldenSnapshot
+	for _, g := range allGolden[job] {
+		if g.Build != build {
+			golden = append(golden, g)
+		}
+	}
+	marked := &goldenSnapshot{GoldenBaseline: GoldenBaseline{Build: build, Note: note, MarkedAt: time.Now().UTC()}, Data: snapshot}
+	allGolden[job] = append(golden, marked)
+	return marked.GoldenBaseline, saveGolden(job)
+}
+
+// unmarkGolden removes the build from the golden baselines of the job. It
+// returns false if the build is not a golden baseline.
+func unmarkGolden(job, build string) (bool, error) {
+	dataLock.Lock()
+	defer dataLock.Unlock()
+	var golden []*goldenSnapshot
+	for _, g := range allGolden[job] {
+		if g.Build != build {
+			golden = append(golden, g)
+		}
+	}
+	if len(golden) == len(allGolden[job]) {
+		return false, nil
+	}
+	allGolden[job] = golden
+	return true, saveGolden(job)
+}
+
+// MetricComparison compares a metric of a build with the golden baseline.
+type MetricComparison struct {
+	Test   string            `json:"test"`
+	Node   string            `json:"node"`
+	Labels map[string]string `json:"labels"`
+	Bucket string            `json:"bucket"`
+	Unit   string            `json:"unit"`
+	Owner  string            `json:"owner,omitempty"`
+	Golden float64           `json:"golden"`
+	Value  float64           `json:"value"`
+	// Change is the relative change of Value compared to Golden.
+	Change float64 `json:"change"`
+	// Regressed is true if the change exceeds the threshold of the metric.
+	Regressed bool `json:"regressed"`
+}
+
+// Comparison is the comparison of a build with a golden baseline.
+type Comparison struct {
+	Job     string             `json:"job"`
+	Build   string             `json:"build"`
+	Golden  GoldenBaseline     `json:"golden"`
+	Metrics []MetricComparison `json:"metrics"`
+}
+
+// compareBuilds compares the metrics of the build in testData with the golden
+// baseline, for the metrics present in both.
+func compareBuilds(job, build string, testData TestToBuildData, golden *goldenSnapshot, filter seriesFilter) Comparison {
+	combined := TestToBuildData{}
+	addSnapshot(combined, job, build, snapshotBuild(testData, build))
+	// The golden build is added under a distinct name, in case it is the
+	// compared build.
+	addSnapshot(combined, job, "golden", golden.Data)
+
+	comparison := Comparison{Job: job, Build: build, Golden: golden.GoldenBaseline, Metrics: []MetricComparison{}}
+	for _, s := range extractSeries(job, combined, filter) {
+		var value, goldenValue *float64
+		for i := range s.Points {
+			if s.Points[i].Build == build {
+				value = &s.Points[i].Value
+			} else {
+				goldenValue = &s.Points[i].Value
+			}
+		}
+		if value == nil || goldenValue == nil {
+			continue
+		}
+		metric := MetricComparison{Test: s.Test, Node: s.Node, Labels: s.Labels, Bucket: s.Bucket, Unit: s.Unit, Owner: s.Owner, Golden: *goldenValue, Value: *value}
+		if *goldenValue != 0 {
+			metric.Change = (*value - *goldenValue) / math.Abs(*goldenValue)
+			worse, worseBy := metric.Change, *value-*goldenValue
+			if higherIsBetter(s.Labels) {
+				worse, worseBy = -worse, -worseBy
+			}
+			metric.Regressed = thresholds.For(job, s.Test, s.Labels, s.Bucket).Exceeded(worse, worseBy)
+		}
+		comparison.Metrics = append(comparison.Metrics, metric)
+	}
+	return comparison
+}
+
+// latestRetainedBuild returns the latest build of the job within the --builds
+// window, or "" if there is none.
+func latestRetainedBuild(job string) string {
+	dataLock.RLock()
+	defer dataLock.RUnlock()
+	latest := -1
+	for build := range retainedBuilds(job) {
+		if n, err := strconv.Atoi(build); err == nil && n > latest {
+			latest = n
+		}
+	}
+	if latest < 0 {
+		return ""
+	}
+	return strconv.Itoa(latest)
+}
+
+// goldenHandler serves the golden baselines of the jobs, fetching the builds
+// which are not in memory from the data source.
+type goldenHandler struct {
+	source Downloader
+}
+
+// serveGolden is the HTTP handler for the golden baselines of the job in the
+// "job" query parameter. GET lists them, POST marks the build in the "build"
+// parameter as the active baseline, with an optional "note", and DELETE
+// unmarks it. POST and DELETE require an authenticated user.
+func (h *goldenHandler) serveGolden(res http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	job := query.Get("job")
+	if _, ok := allTestData[job]; !ok {
+		writeError(res, http.StatusNotFound, fmt.Errorf("unknown job %q", job))
+		return
+	}
+	if req.Method == http.MethodPost || req.Method == http.MethodDelete {
+		if _, ok := requireUser(res, req); !ok {
+			return
+		}
+	}
+	switch req.Method {
+	case http.MethodGet:
+		writeJSON(res, req, goldenBaselines(job))
+	case http.MethodPost:
+		buildNumber, err := strconv.Atoi(query.Get("build"))
+		if err != nil || buildNumber <= 0 {
+			writeError(res, http.StatusBadRequest, fmt.Errorf("invalid build %q", query.Get("build")))
+			return
+		}
+		snapshot, err := buildDataOf(req.Context(), job, buildNumber, h.source)
+		if err != nil {
+			writeError(res, http.StatusNotFound, err)
+			return
+		}
+		marked, err := markGolden(job, strconv.Itoa(buildNumber), query.Get("note"), snapshot)
+		if err != nil {
+			writeError(res, http.StatusInternalServerError, fmt.Errorf("failed to persist the golden baseline: %v", err))
+			return
+		}
+		serverLog.Info("Marked the golden baseline", "job", job, "build", buildNumber)
+		writeJSON(res, req, marked)
+	case http.MethodDelete:
+		build := query.Get("build")
+		found, err := unmarkGolden(job, build)
+		if err != nil {
+			writeError(res, http.StatusInternalServerError, fmt.Errorf("failed to persist the golden baselines: %v", err))
+			return
+		}
+		if !found {
+			writeError(res, http.StatusNotFound, fmt.Errorf("build %q is not a golden baseline of job %q", build, job))
+			return
+		}
+		serverLog.Info("Unmarked the golden baseline", "job", job, "build", build)
+		writeJSON(res, req, goldenBaselines(job))
+	default:
+		res.Header().Set("Allow", "GET, POST, DELETE")
+		writeError(res, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", req.Method))
+	}
+}
+
+// serveCompare is the HTTP handler comparing the build in the "build" query
+// parameter, or the latest build, of the job in the "job" parameter with its
+// active golden baseline. The metrics can be filtered like in the series API.
+// Fetching a build which is not in the --builds window from the data source
+// requires an authenticated user.
+func (h *goldenHandler) serveCompare(res http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	job := query.Get("job")
+	if _, ok := allTestData[job]; !ok {
+		writeError(res, http.StatusNotFound, fmt.Errorf("unknown job %q", job))
+		return
+	}
+	filter, err := parseSeriesFilter(req)
+	if err != nil {
+		writeError(res, http.StatusBadRequest, err)
+		return
+	}
+	golden, err := activeGolden(req.Context(), job, h.source)
+	if err != nil {
+		writeError(res, http.StatusInternalServerError, err)
+		return
+	}
+	if golden == nil {
+		writeError(res, http.StatusNotFound, fmt.Errorf("job %q has no golden baseline", job))
+		return
+	}
+
+	build := query.Get("build")
+	if build == "" {
+		build = latestRetainedBuild(job)
+	}
+	testData, err := jobBuildData(job, map[string]bool{build: true})
+	if err != nil {
+		writeError(res, http.StatusInternalServerError, err)
+		return
+	}
+	if len(snapshotBuild(testData, build)) == 0 && query.Get("build") != "" {
+		buildNumber, err := strconv.Atoi(build)
+		if err != nil || buildNumber <= 0 {
+			writeError(res, http.StatusBadRequest, fmt.Errorf("invalid build %q", build))
+			return
+		}
+		if _, ok := requireUser(res, req); !ok {
+			return
+		}
+		snapshot, err := buildDataOf(req.Context(), job, buildNumber, h.source)
+		if err != nil {
+			writeError(res, http.StatusNotFound, err)
+			return
+		}
+		testData = TestToBuildData{}
+		addSnapshot(testData, job, build, snapshot)
+	}
+	writeJSON(res, req, compareBuilds(job, build, testData, golden, filter))
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"k8s.io/kubernetes/test/e2e/perftype"
+)
+
+// perfSnapshot returns the snapshot of a build with the given Perc99 latency
+// and throughput.
+func perfSnapshot(latency, throughput float64) buildSnapshot {
+	return buildSnapshot{"test": {"node": &DataPerBuild{Perf: []perftype.DataItem{
+		{Data: map[string]float64{"Perc99": latency}, Unit: "ms", Labels: map[string]string{"datatype": "latency"}},
+		{Data: map[string]float64{"Perc99": throughput}, Unit: "pods/s", Labels: map[string]string{"datatype": "throughput"}},
+	}}}}
+}
+
+func TestCompareBuilds(t *testing.T) {
+	golden := &goldenSnapshot{GoldenBaseline: GoldenBaseline{Build: "1"}, Data: perfSnapshot(100, 10)}
+	testData := TestToBuildData{}
+	addSnapshot(testData, "job", "5", perfSnapshot(130, 12))
+	comparison := compareBuilds("job", "5", testData, golden, seriesFilter{})
+	if len(comparison.Metrics) != 2 {
+		t.Fatalf("expected 2 metrics but got %v", comparison.Metrics)
+	}
+	for _, metric := range comparison.Metrics {
+		switch metric.Labels["datatype"] {
+		case "latency":
+			if metric.Golden != 100 || metric.Value != 130 || !metric.Regressed {
+				t.Errorf("expected a latency regression from 100 to 130 but got %+v", metric)
+			}
+		case "throughput":
+			if metric.Golden != 10 || metric.Value != 12 || metric.Regressed {
+				t.Errorf("expected a throughput improvement from 10 to 12 but got %+v", metric)
+			}
+		}
+	}
+
+	// A build compared with itself does not change.
+	addSnapshot(testData, "job", "1", golden.Data)
+	for _, metric := range compareBuilds("job", "1", testData, golden, seriesFilter{}).Metrics {
+		if metric.Change != 0 || metric.Regressed {
+			t.Errorf("expected no change comparing the golden build with itself but got %+v", metric)
+		}
+	}
+}
+
+func TestMarkGolden(t *testing.T) {
+	dir, err := ioutil.TempDir("", "node-perf-dash-golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if store, err = NewFileStore(dir); err != nil {
+		t.Fatal(err)
+	}
+	job := "golden"
+	defer func() {
+		store = nil
+		delete(allGolden, job)
+	}()
+
+	for _, build := range []string{"1", "2", "1"} {
+		if _, err := markGolden(job, build, "", perfSnapshot(100, 10)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if found, err := unmarkGolden(job, "3"); found || err != nil {
+		t.Errorf("expected build 3 not to be found but got %v (%v)", found, err)
+	}
+	delete(allGolden, job)
+	if err := loadGolden(job); err != nil {
+		t.Fatal(err)
+	}
+	baselines := goldenBaselines(job)
+	if len(baselines) != 2 || baselines[0].Build != "2" || baselines[1].Build != "1" {
+		t.Errorf("expected golden builds [2 1] but got %+v", baselines)
+	}
+	if active := allGolden[job][len(allGolden[job])-1]; len(active.Data) != 1 {
+		t.Errorf("expected the data of the golden build to be persisted but got %+v", active.Data)
+	}
+	if found, err := unmarkGolden(job, "1"); !found || err != nil {
+		t.Errorf("expected build 1 to be unmarked but got %v (%v)", found, err)
+	}
+	if baselines := goldenBaselines(job); len(baselines) != 1 || baselines[0].Build != "2" {
+		t.Errorf("expected golden builds [2] but got %+v", baselines)
+	}
+}
+
+func TestGoldenAuth(t *testing.T) {
+	apiTokens = map[string]string{"alice-token": "alice"}
+	job := "golden-auth"
+	allTestData[job] = TestToBuildData{}
+	addSnapshot(allTestData[job], job, "1", perfSnapshot(100, 10))
+	defer func() {
+		apiTokens = nil
+		delete(allTestData, job)
+		delete(allGolden, job)
+	}()
+
+	source := &fakeJobSource{}
+	h := &goldenHandler{source: source}
+	for _, tt := range []struct {
+		name, method, path, token string
+		code                      int
+	}{
+		{name: "mark unauthenticated", method: "POST", path: "/api/golden?job=" + job + "&build=1", code: http.StatusUnauthorized},
+		{name: "mark", method: "POST", path: "/api/golden?job=" + job + "&build=1", token: "alice-token", code: http.StatusOK},
+		{name: "list", method: "GET", path: "/api/golden?job=" + job, code: http.StatusOK},
+		{name: "compare in memory", method: "GET", path: "/api/compare?job=" + job + "&build=1", code: http.StatusOK},
+		{name: "compare fetched unauthenticated", method: "GET", path: "/api/compare?job=" + job + "&build=7", code: http.StatusUnauthorized},
+		{name: "compare fetched", method: "GET", path: "/api/compare?job=" + job + "&build=7", token: "alice-token", code: http.StatusNotFound},
+		{name: "unmark unauthenticated", method: "DELETE", path: "/api/golden?job=" + job + "&build=1", code: http.StatusUnauthorized},
+		{name: "unmark", method: "DELETE", path: "/api/golden?job=" + job + "&build=1", token: "alice-token", code: http.StatusOK},
+	} {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		res := httptest.NewRecorder()
+		if strings.HasPrefix(tt.path, "/api/compare") {
+			h.serveCompare(res, req)
+		} else {
+			h.serveGolden(res, req)
+		}
+		if res.Code != tt.code {
+			t.Errorf("%s: expected status %d but got %d: %s", tt.name, tt.code, res.Code, res.Body)
+		}
+	}
+}
@@ -0,0 +1,306 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The Grafana SimpleJSON datasource protocol is served under /grafana/. The
+// targets are the query strings of the series API, e.g.
+// "job=ci-kubernetes-node-kubelet-benchmark&bucket=Perc99&metric=datatype=latency",
+// optionally with "aggregate" and "fn".
+
+// maxGrafanaSearchResults bounds the targets returned by a search.
+const maxGrafanaSearchResults = 1000
+
+// GrafanaRange is the time range of a Grafana request.
+type GrafanaRange struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// contains returns whether the time in milliseconds since the epoch is in the
+// range. The empty bounds are open.
+func (r GrafanaRange) contains(ms int64) bool {
+	t := time.Unix(0, ms*int64(time.Millisecond))
+	return (r.From.IsZero() || !t.Before(r.From)) && (r.To.IsZero() || !t.After(r.To))
+}
+
+// GrafanaSearchRequest is the body of /grafana/search.
+type GrafanaSearchRequest struct {
+	// Target filters the targets: a query string like the targets, or a
+	// text they contain.
+	Target string `json:"target"`
+}
+
+// GrafanaTarget is a target of a Grafana query.
+type GrafanaTarget struct {
+	Target string `json:"target"`
+	RefID  string `json:"refId"`
+	Type   string `json:"type,omitempty"`
+}
+
+// GrafanaQueryRequest is the body of /grafana/query.
+type GrafanaQueryRequest struct {
+	Range         GrafanaRange    `json:"range"`
+	Targets       []GrafanaTarget `json:"targets"`
+	MaxDataPoints int             `json:"maxDataPoints,omitempty"`
+}
+
+// GrafanaTimeSeries is a series of a Grafana query, with the datapoints as
+// [value, milliseconds since the epoch] in ascending time order.
+type GrafanaTimeSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+// GrafanaAnnotationQuery is the annotation of a /grafana/annotations request.
+type GrafanaAnnotationQuery struct {
+	Name string `json:"name"`
+	// Query is a target selecting the series whose annotations are
+	// returned.
+	Query  string `json:"query"`
+	Enable bool   `json:"enable"`
+}
+
+// GrafanaAnnotationRequest is the body of /grafana/annotations.
+type GrafanaAnnotationRequest struct {
+	Range GrafanaRange `json:"range"`
+	// Annotation is echoed in the response, as required by the protocol.
+	Annotation json.RawMessage `json:"annotation"`
+}
+
+// GrafanaAnnotation is an annotation of a build returned to Grafana.
+type GrafanaAnnotation struct {
+	Annotation json.RawMessage `json:"annotation"`
+	// Time is when the build ended, in milliseconds since the epoch.
+	Time  int64    `json:"time"`
+	Title string   `json:"title"`
+	Text  string   `json:"text"`
+	Tags  []string `json:"tags"`
+}
+
+// grafanaTarget returns the target of the series of the job.
+func grafanaTarget(job string, s *Series) string {
+	parts := []string{"job=" + url.QueryEscape(job), "test=" + url.QueryEscape(s.Test), "node=" + url.QueryEscape(s.Node), "bucket=" + url.QueryEscape(s.Bucket)}
+	var keys []string
+	for key := range s.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		parts = append(parts, "metric="+url.QueryEscape(key)+"="+url.QueryEscape(s.Labels[key]))
+	}
+	return strings.Join(parts, "&")
+}
+
+// parseGrafanaTarget parses a target into its job, series filter and
+// aggregation. The job is required and must be configured.
+func parseGrafanaTarget(target string) (string, seriesFilter, aggregation, error) {
+	query, err := url.ParseQuery(target)
+	if err != nil {
+		return "", seriesFilter{}, aggregation{}, fmt.Errorf("invalid target %q: %v", target, err)
+	}
+	job := query.Get("job")
+	if config.Job(job) == nil {
+		return "", seriesFilter{}, aggregation{}, fmt.Errorf("target %q: unknown job %q", target, job)
+	}
+	filter, err := parseSeriesFilterQuery(query)
+	if err != nil {
+		return "", seriesFilter{}, aggregation{}, fmt.Errorf("target %q: %v", target, err)
+	}
+	a, err := parseAggregationQuery(query)
+	if err != nil {
+		return "", seriesFilter{}, aggregation{}, fmt.Errorf("target %q: %v", target, err)
+	}
+	return job, filter, a, nil
+}
+
+// grafanaSeries returns the series of the target.
+func grafanaSeries(target string) (string, []*Series, error) {
+	job, filter, a, err := parseGrafanaTarget(target)
+	if err != nil {
+		return "", nil, err
+	}
+	testData, err := jobData(job)
+	if err != nil {
+		return "", nil, err
+	}
+	series := extractSeries(job, testData, filter)
+	if a.Period != "" {
+		series = aggregateSeries(job, series, filter, a)
+	}
+	return job, series, nil
+}
+
+// serveGrafana is the HTTP handler of /grafana/, which Grafana gets to test
+// the datasource.
+func serveGrafana(res http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/grafana/" {
+		http.NotFound(res, req)
+		return
+	}
+	res.Header().Set("Content-type", "text/plain")
+	res.Write([]byte("OK"))
+}
+
+// decodeGrafanaRequest decodes the JSON body of a POST request from Grafana,
+// writing an error response and returning false if it can not.
+func decodeGrafanaRequest(res http.ResponseWriter, req *http.Request, v interface{}) bool {
+	if req.Method != http.MethodPost {
+		writeError(res, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", req.Method))
+		return false
+	}
+	if err := json.NewDecoder(req.Body).Decode(v); err != nil {
+		writeError(res, http.StatusBadRequest, fmt.Errorf("failed to decode the request: %v", err))
+		return false
+	}
+	return true
+}
+
+// serveGrafanaSearch is the HTTP handler of /grafana/search, listing the
+// targets of the series of all the jobs matching the target of the request.
+func serveGrafanaSearch(res http.ResponseWriter, req *http.Request) {
+	var search GrafanaSearchRequest
+	if !decodeGrafanaRequest(res, req, &search) {
+		return
+	}
+	jobs := config.JobNames()
+	filter, text := seriesFilter{}, search.Target
+	if strings.Contains(search.Target, "=") {
+		query, err := url.ParseQuery(search.Target)
+		if err == nil {
+			filter, err = parseSeriesFilterQuery(query)
+		}
+		if err != nil {
+			writeError(res, http.StatusBadRequest, fmt.Errorf("invalid target %q: %v", search.Target, err))
+			return
+		}
+		if job := query.Get("job"); job != "" {
+			jobs = JobList{job}
+		}
+		text = ""
+	}
+
+	targets := []string{}
+	for _, job := range jobs {
+		if config.Job(job) == nil {
+			continue
+		}
+		testData, err := jobData(job)
+		if err != nil {
+			writeError(res, http.StatusInternalServerError, err)
+			return
+		}
+		for _, s := range extractSeries(job, testData, filter) {
+			if target := grafanaTarget(job, s); strings.Contains(target, text) {
+				targets = append(targets, target)
+			}
+		}
+	}
+	sort.Strings(targets)
+	if len(targets) > maxGrafanaSearchResults {
+		targets = targets[:maxGrafanaSearchResults]
+	}
+	writeJSON(res, req, targets)
+}
+
+// serveGrafanaQuery is the HTTP handler of /grafana/query, returning the
+// datapoints of the series of the targets in the range. The points of the
+// builds whose end is unknown are omitted.
+func serveGrafanaQuery(res http.ResponseWriter, req *http.Request) {
+	var query GrafanaQueryRequest
+	if !decodeGrafanaRequest(res, req, &query) {
+		return
+	}
+	result := []GrafanaTimeSeries{}
+	for _, target := range query.Targets {
+		job, series, err := grafanaSeries(target.Target)
+		if err != nil {
+			writeError(res, http.StatusBadRequest, err)
+			return
+		}
+		for _, s := range series {
+			datapoints := [][2]float64{}
+			for _, point := range s.Points {
+				ms := point.Timestamp * 1000
+				if point.Timestamp != 0 && query.Range.contains(ms) {
+					datapoints = append(datapoints, [2]float64{point.Value, float64(ms)})
+				}
+			}
+			sort.Slice(datapoints, func(i, j int) bool { return datapoints[i][1] < datapoints[j][1] })
+			if query.MaxDataPoints > 0 && len(datapoints) > query.MaxDataPoints {
+				datapoints = datapoints[len(datapoints)-query.MaxDataPoints:]
+			}
+			result = append(result, GrafanaTimeSeries{Target: job + "/" + s.Key(), Datapoints: datapoints})
+		}
+	}
+	writeJSON(res, req, result)
+}
+
+// serveGrafanaAnnotations is the HTTP handler of /grafana/annotations,
+// returning the annotations of the builds of the series selected by the query
+// of the annotation, at the end of the builds.
+func serveGrafanaAnnotations(res http.ResponseWriter, req *http.Request) {
+	var request GrafanaAnnotationRequest
+	if !decodeGrafanaRequest(res, req, &request) {
+		return
+	}
+	var annotation GrafanaAnnotationQuery
+	if err := json.Unmarshal(request.Annotation, &annotation); err != nil {
+		writeError(res, http.StatusBadRequest, fmt.Errorf("failed to decode the annotation: %v", err))
+		return
+	}
+	job, series, err := grafanaSeries(annotation.Query)
+	if err != nil {
+		writeError(res, http.StatusBadRequest, err)
+		return
+	}
+	annotateSeries(job, series)
+
+	result := []GrafanaAnnotation{}
+	seen := map[string]bool{}
+	for _, s := range series {
+		ended := map[string]int64{}
+		for _, point := range s.Points {
+			ended[point.Build] = point.Timestamp
+		}
+		for _, a := range s.Annotations {
+			ms := ended[a.Build] * 1000
+			if seen[a.ID] || ms == 0 || !request.Range.contains(ms) {
+				continue
+			}
+			seen[a.ID] = true
+			result = append(result, GrafanaAnnotation{
+				Annotation: request.Annotation,
+				Time:       ms,
+				Title:      fmt.Sprintf("%s build %s", job, a.Build),
+				Text:       fmt.Sprintf("%s (%s)", a.Text, a.Author),
+				Tags:       []string{job, a.Test},
+			})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Time < result[j].Time })
+	writeJSON(res, req, result)
+}
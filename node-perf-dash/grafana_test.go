@@ -0,0 +1,122 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGrafana(t *testing.T) {
+	job := "grafana"
+	latency := map[string]string{"datatype": "latency", "latencytype": "create-pod"}
+	throughput := map[string]string{"datatype": "throughput"}
+	testData := TestToBuildData{}
+	for build, timestamp := range map[string]int64{"1": 1500000000, "2": 1500003600, "3": 1500007200, "4": 0} {
+		*testData.GetDataPerBuild(job, build, "density", "node") = *perfData(latency, float64(timestamp%10000), timestamp)
+	}
+	testData.GetDataPerBuild(job, "1", "resource", "node").Perf = perfData(throughput, 5, 0).Perf
+
+	defer func(c *Config) {
+		config = c
+		dataLock.Lock()
+		delete(allTestData, job)
+		delete(allAnnotations, job)
+		dataLock.Unlock()
+	}(config)
+	config = &Config{Jobs: []*JobConfig{{Name: job}}}
+	dataLock.Lock()
+	allTestData[job] = testData
+	allAnnotations[job] = []*Annotation{
+		{ID: "a1", Build: "2", Test: "density", Text: "infra outage", Author: "alice"},
+		{ID: "a2", Build: "3", Test: "resource", Text: "other test", Author: "bob"},
+	}
+	dataLock.Unlock()
+	mux := newMux(JobList{job}, nil)
+
+	post := func(path, body string) (int, string) {
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, httptest.NewRequest("POST", path, strings.NewReader(body)))
+		return res.Code, strings.TrimSpace(res.Body.String())
+	}
+
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, httptest.NewRequest("GET", "/grafana/", nil))
+	if res.Code != http.StatusOK || res.Body.String() != "OK" {
+		t.Errorf("expected the datasource test to succeed but got %d %q", res.Code, res.Body.String())
+	}
+
+	density := "job=grafana&test=density&node=node&bucket=Perc99&metric=datatype=latency&metric=latencytype=create-pod"
+	for _, tt := range []struct {
+		name, path, body string
+		code             int
+		expected         string
+	}{
+		{
+			name: "search all", path: "/grafana/search", body: `{"target": ""}`, code: http.StatusOK,
+			expected: `["` + density + `","job=grafana&test=resource&node=node&bucket=Perc99&metric=datatype=throughput"]`,
+		},
+		{name: "search text", path: "/grafana/search", body: `{"target": "throughput"}`, code: http.StatusOK, expected: `["job=grafana&test=resource&node=node&bucket=Perc99&metric=datatype=throughput"]`},
+		{name: "search filter", path: "/grafana/search", body: `{"target": "job=grafana&metric=latencytype=create-pod"}`, code: http.StatusOK, expected: `["` + density + `"]`},
+		{
+			// Build 1 is before the range and build 4 has no timestamp.
+			name: "query", path: "/grafana/query", code: http.StatusOK,
+			body:     `{"range": {"from": "2017-07-14T03:00:00Z", "to": "2017-07-14T06:00:00Z"}, "targets": [{"target": "` + density + `", "refId": "A"}]}`,
+			expected: `[{"target":"grafana/density/node/datatype=latency,latencytype=create-pod/Perc99","datapoints":[[3600,1500003600000],[7200,1500007200000]]}]`,
+		},
+		{
+			name: "max data points", path: "/grafana/query", code: http.StatusOK,
+			body:     `{"targets": [{"target": "` + density + `"}], "maxDataPoints": 1}`,
+			expected: `[{"target":"grafana/density/node/datatype=latency,latencytype=create-pod/Perc99","datapoints":[[7200,1500007200000]]}]`,
+		},
+		{name: "unknown job", path: "/grafana/query", body: `{"targets": [{"target": "job=unknown"}]}`, code: http.StatusBadRequest},
+		{name: "invalid aggregation", path: "/grafana/query", body: `{"targets": [{"target": "job=grafana&aggregate=yearly"}]}`, code: http.StatusBadRequest},
+		{
+			name: "annotations", path: "/grafana/annotations", code: http.StatusOK,
+			body:     `{"range": {"from": "2017-07-14T00:00:00Z", "to": "2017-07-15T00:00:00Z"}, "annotation": {"name": "outages", "query": "job=grafana&test=density", "enable": true}}`,
+			expected: `[{"annotation":{"name":"outages","query":"job=grafana&test=density","enable":true},"time":1500003600000,"title":"grafana build 2","text":"infra outage (alice)","tags":["grafana","density"]}]`,
+		},
+	} {
+		code, body := post(tt.path, tt.body)
+		var got, expected interface{}
+		json.Unmarshal([]byte(body), &got)
+		json.Unmarshal([]byte(tt.expected), &expected)
+		if code != tt.code || (tt.expected != "" && !reflect.DeepEqual(got, expected)) {
+			t.Errorf("%s: expected %d %s but got %d %s", tt.name, tt.code, tt.expected, code, body)
+		}
+	}
+
+	// The targets returned by the search select their series.
+	var targets []string
+	_, body := post("/grafana/search", `{"target": ""}`)
+	if err := json.Unmarshal([]byte(body), &targets); err != nil {
+		t.Fatal(err)
+	}
+	for _, target := range targets {
+		_, series, err := grafanaSeries(target)
+		if err != nil || len(series) != 1 || grafanaTarget(job, series[0]) != target {
+			t.Errorf("%s: expected to select its series but got %v (%v)", target, series, err)
+		}
+	}
+	if code, _ := post("/grafana/missing", `{}`); code != http.StatusNotFound {
+		t.Errorf("expected the unknown paths to be not found but got %d", code)
+	}
+}
@@ -0,0 +1,282 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	httpBaseURL      = flag.String("http-base-url", "", "The URL of the directory containing one directory per job for the 'http' data source, e.g. https://ci.example.com/logs")
+	jenkinsURL       = flag.String("jenkins-url", "", "The URL of the Jenkins server for the 'jenkins' data source, e.g. https://jenkins.example.com")
+	jenkinsUser      = flag.String("jenkins-user", "", "If non-empty, the user to authenticate to Jenkins as")
+	jenkinsTokenFile = flag.String("jenkins-token-file", "", "If non-empty, the path to the API token of --jenkins-user")
+)
+
+const (
+	// httpTimeout is the timeout of the requests to HTTP data sources.
+	httpTimeout = time.Minute
+	// maxIndexBytes is the maximum size of an index page or API response.
+	maxIndexBytes = 16 << 20
+)
+
+// hrefRegexp extracts the links from an index page.
+var hrefRegexp = regexp.MustCompile(`(?i)href\s*=\s*["']([^"']+)["']`)
+
+// httpSource fetches URLs from an HTTP data source.
+type httpSource struct {
+	client *http.Client
+	// user and token are the basic authentication credentials, if any.
+	user  string
+	token string
+}
+
+// get returns the body of the URL. It fails if the response is not a
+// success.
+func (s *httpSource) get(rawurl string) (io.ReadCloser, error) {
+	req, err := http.NewRequest("GET", rawurl, nil)
+	if err != nil {
+		return nil, err
+	}
+	if s.user != "" {
+		req.SetBasicAuth(s.user, s.token)
+	}
+	response, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		return nil, fmt.Errorf("got status code %d getting %q", response.StatusCode, rawurl)
+	}
+	return response.Body, nil
+}
+
+// getAll returns the whole body of the URL, which must be smaller than
+// maxIndexBytes.
+func (s *httpSource) getAll(rawurl string) ([]byte, error) {
+	body, err := s.get(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(body, maxIndexBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %q: %v", rawurl, err)
+	}
+	if len(data) > maxIndexBytes {
+		return nil, fmt.Errorf("the response of %q exceeds %d bytes", rawurl, maxIndexBytes)
+	}
+	return data, nil
+}
+
+// parseIndex returns the names of the entries linked from an index page, such
+// as the ones generated by Apache or nginx. Directories keep their trailing
+// "/". Links to other pages, sorting links and parent directories are ignored.
+func parseIndex(page []byte) []string {
+	seen := map[string]bool{}
+	var entries []string
+	for _, match := range hrefRegexp.FindAllSubmatch(page, -1) {
+		href := string(match[1])
+		if strings.ContainsAny(href, "?#") || strings.Contains(href, "://") {
+			continue
+		}
+		dir := strings.HasSuffix(href, "/")
+		name, err := url.PathUnescape(path.Base(strings.TrimSuffix(href, "/")))
+		if err != nil || name == "." || name == ".." || name == "/" || name == "" {
+			continue
+		}
+		if dir {
+			name += "/"
+		}
+		if !seen[name] {
+			seen[name] = true
+			entries = append(entries, name)
+		}
+	}
+	return entries
+}
+
+// HTTPDownloader gets test data from a plain HTTP(S) server serving index
+// pages, with the same layout as the local data source:
+// <base>/<job>/<build>/artifacts/... The builds are listed from the index of
+// the job if it has no latest-build.txt.
+type HTTPDownloader struct {
+	httpSource
+	baseURL string
+}
+
+// NewHTTPDownloader creates a new HTTPDownloader for the given base URL.
+func NewHTTPDownloader(baseURL string) *HTTPDownloader {
+	return &HTTPDownloader{
+		httpSource: httpSource{client: &http.Client{Timeout: httpTimeout}},
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+	}
+}
+
+func (d *HTTPDownloader) jobURL(job string) string {
+	return d.baseURL + "/" + url.PathEscape(job)
+}
+
+func (d *HTTPDownloader) buildURL(job string, buildNumber int) string {
+	return fmt.Sprintf("%s/%d", d.jobURL(job), buildNumber)
+}
+
+// GetLastestBuildNumber returns the latest build number, read from
+// latest-build.txt in the directory of the job.
+func (d *HTTPDownloader) GetLastestBuildNumber(job string) (int, error) {
+	data, err := d.getAll(d.jobURL(job) + "/" + latestBuildFile)
+	if err != nil {
+		return -1, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return -1, fmt.Errorf("failed to parse the latest build number %q: %v", data, err)
+	}
+	downloaderLog.Debug("Read the latest build number", "job", job, "build", n)
+	return n, nil
+}
+
+// ListBuilds returns the numbered directories in the index of the job.
+func (d *HTTPDownloader) ListBuilds(job string) ([]int, error) {
+	page, err := d.getAll(d.jobURL(job) + "/")
+	if err != nil {
+		return nil, err
+	}
+	var builds []int
+	for _, entry := range parseIndex(page) {
+		if n, err := strconv.Atoi(strings.TrimSuffix(entry, "/")); err == nil && strings.HasSuffix(entry, "/") {
+			builds = append(builds, n)
+		}
+	}
+	return builds, nil
+}
+
+// ListFilesInBuild returns the files with the specified prefix for the test
+// job at the given buildNumber, from the index page of their directory.
+func (d *HTTPDownloader) ListFilesInBuild(job string, buildNumber int, prefix string) ([]string, error) {
+	downloaderLog.Debug("Listing files", "job", job, "build", buildNumber, "prefix", prefix)
+	prefixDir, prefixFile := path.Split(prefix)
+	page, err := d.getAll(d.buildURL(job, buildNumber) + "/" + prefixDir)
+	if err != nil {
+		return nil, err
+	}
+	filesInBuild := []string{}
+	for _, entry := range parseIndex(page) {
+		if !strings.HasSuffix(entry, "/") && strings.HasPrefix(entry, prefixFile) {
+			filesInBuild = append(filesInBuild, path.Join(prefixDir, entry))
+		}
+	}
+	return filesInBuild, nil
+}
+
+// GetFile returns readcloser of the desired file.
+func (d *HTTPDownloader) GetFile(job string, buildNumber int, filePath string) (io.ReadCloser, error) {
+	downloaderLog.Debug("Downloading file", "job", job, "build", buildNumber, "path", filePath)
+	return d.get(d.buildURL(job, buildNumber) + "/" + filePath)
+}
+
+// JenkinsDownloader gets test data from the archived artifacts of the builds
+// of a Jenkins server.
+type JenkinsDownloader struct {
+	httpSource
+	baseURL string
+}
+
+// NewJenkinsDownloader creates a new JenkinsDownloader for the Jenkins server
+// at the given URL, authenticating with user and token if user is non-empty.
+func NewJenkinsDownloader(baseURL, user, token string) *JenkinsDownloader {
+	return &JenkinsDownloader{
+		httpSource: httpSource{client: &http.Client{Timeout: httpTimeout}, user: user, token: token},
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+	}
+}
+
+// jobURL returns the URL of the job. Jobs in folders are named with "/", e.g.
+// "team/benchmark".
+func (d *JenkinsDownloader) jobURL(job string) string {
+	u := d.baseURL
+	for _, part := range strings.Split(job, "/") {
+		u += "/job/" + url.PathEscape(part)
+	}
+	return u
+}
+
+// GetLastestBuildNumber returns the number of the last completed build.
+func (d *JenkinsDownloader) GetLastestBuildNumber(job string) (int, error) {
+	data, err := d.getAll(d.jobURL(job) + "/lastCompletedBuild/buildNumber")
+	if err != nil {
+		return -1, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return -1, fmt.Errorf("failed to parse the latest build number %q: %v", data, err)
+	}
+	downloaderLog.Debug("Read the latest build number", "job", job, "build", n)
+	return n, nil
+}
+
+// ListFilesInBuild returns the archived artifacts with the specified prefix
+// for the test job at the given buildNumber. The artifacts may be archived in
+// an "artifacts/" directory like in the other data sources, or at the root of
+// the archived artifacts.
+func (d *JenkinsDownloader) ListFilesInBuild(job string, buildNumber int, prefix string) ([]string, error) {
+	downloaderLog.Debug("Listing files", "job", job, "build", buildNumber, "prefix", prefix)
+	data, err := d.getAll(fmt.Sprintf("%s/%d/api/json?tree=artifacts[relativePath]", d.jobURL(job), buildNumber))
+	if err != nil {
+		return nil, err
+	}
+	var build struct {
+		Artifacts []struct {
+			RelativePath string `json:"relativePath"`
+		} `json:"artifacts"`
+	}
+	if err := json.Unmarshal(data, &build); err != nil {
+		return nil, fmt.Errorf("failed to parse the artifacts of build %d: %v", buildNumber, err)
+	}
+	filesInBuild := []string{}
+	for _, artifact := range build.Artifacts {
+		name := "artifacts/" + strings.TrimPrefix(artifact.RelativePath, "artifacts/")
+		if strings.HasPrefix(name, prefix) {
+			filesInBuild = append(filesInBuild, name)
+		}
+	}
+	return filesInBuild, nil
+}
+
+// GetFile returns readcloser of the desired file. Files in the "artifacts/"
+// directory are also looked up at the root of the archived artifacts.
+func (d *JenkinsDownloader) GetFile(job string, buildNumber int, filePath string) (io.ReadCloser, error) {
+	downloaderLog.Debug("Downloading file", "job", job, "build", buildNumber, "path", filePath)
+	artifactURL := fmt.Sprintf("%s/%d/artifact/", d.jobURL(job), buildNumber)
+	body, err := d.get(artifactURL + filePath)
+	if err != nil && strings.HasPrefix(filePath, "artifacts/") {
+		return d.get(artifactURL + strings.TrimPrefix(filePath, "artifacts/"))
+	}
+	return body, err
+}
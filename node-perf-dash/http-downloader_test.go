@@ -0,0 +1,83 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParseIndex(t *testing.T) {
+	page := `<html><body><h1>Index of /logs/job/</h1>
+<a href="?C=N;O=D">Name</a>
+<a href="../">Parent Directory</a>
+<a href="9/">9/</a>
+<a href="/logs/job/10/">10/</a>
+<a HREF='latest-build.txt'>latest-build.txt</a>
+<a href="https://example.com/">elsewhere</a>
+<a href="performance-a%20b.json">performance-a b.json</a>
+<a href="9/">9/</a>
+</body></html>`
+	expect := []string{"9/", "10/", "latest-build.txt", "performance-a b.json"}
+	if got := parseIndex([]byte(page)); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Expected %v but got %v", expect, got)
+	}
+}
+
+func TestHTTPDownloader(t *testing.T) {
+	files := map[string]string{
+		"/job/":              `<a href="../">..</a><a href="11/">11/</a><a href="12/">12/</a><a href="9/">9/</a>`,
+		"/job/12/artifacts/": `<a href="performance-node1.json">x</a><a href="time_series-node1.json">x</a><a href="logs/">logs/</a>`,
+		"/job/12/artifacts/performance-node1.json": `{"version":"v2"}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		content, ok := files[req.URL.Path]
+		if !ok {
+			http.NotFound(res, req)
+			return
+		}
+		res.Write([]byte(content))
+	}))
+	defer server.Close()
+	d := NewHTTPDownloader(server.URL + "/")
+
+	// There is no latest-build.txt, the latest build is found in the index.
+	if _, err := d.GetLastestBuildNumber("job"); err == nil {
+		t.Errorf("Expected an error reading a missing latest-build.txt")
+	}
+	if build, err := discoverLatestBuild(d, "job", 0); err != nil || build != 12 {
+		t.Errorf("Expected latest build 12 but got %d (%v)", build, err)
+	}
+	listed, err := d.ListFilesInBuild("job", 12, "artifacts/performance-")
+	if expect := []string{"artifacts/performance-node1.json"}; err != nil || !reflect.DeepEqual(listed, expect) {
+		t.Errorf("Expected files %v but got %v (%v)", expect, listed, err)
+	}
+	body, err := d.GetFile("job", 12, "artifacts/performance-node1.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	if data, _ := ioutil.ReadAll(body); string(data) != `{"version":"v2"}` {
+		t.Errorf("Unexpected content %q", data)
+	}
+	if _, err := d.GetFile("job", 12, "artifacts/missing.json"); err == nil {
+		t.Errorf("Expected an error getting a missing file")
+	}
+}
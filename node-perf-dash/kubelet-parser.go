@@ -20,8 +20,6 @@ import (
 	"bufio"
 	"encoding/json"
 	"fmt"
-	"log"
-	"os"
 	"path"
 	"regexp"
 	"sort"
@@ -39,8 +37,6 @@ const (
 // TODO(coufon): we plan to adopt event for tracing in future.
 
 const (
-	// Timestamp format of test result log (build-log.txt).
-	testLogTimeFormat = "2006 Jan 2 15:04:05.000"
 	// Timestamp format of kubelet log (kubelet.log).
 	kubeletLogTimeFormat = "2006 0102 15:04:05.000000"
 
@@ -104,15 +100,11 @@ type SortedTestTimePerNode map[string](SortedTestTime)
 type TestTime map[string](map[string]time.Time)
 
 // Add adds one end time into TestTime.
-func (ete TestTime) Add(testName, nodeName, Endtime string) {
+func (ete TestTime) Add(testName, nodeName string, end time.Time) {
 	if _, ok := ete[nodeName]; !ok {
 		ete[nodeName] = make(map[string]time.Time)
 	}
 	if _, ok := ete[nodeName][testName]; !ok {
-		end, err := time.Parse(testLogTimeFormat, Endtime)
-		if err != nil {
-			log.Fatal(err)
-		}
 		ete[nodeName][testName] = end
 	}
 }
@@ -178,8 +170,7 @@ func GrabTracingKubelet(d Downloader, job string, buildNumber int, nodeName stri
 
 	file, err := d.GetFile(job, buildNumber, path.Join("artifacts", nodeName, kubeletLogFile))
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error while fetching tracing data event: %v\n", err)
-		log.Fatal(err)
+		logFatal(parserLog, "Error while fetching tracing data event", "job", job, "build", buildNumber, "node", nodeName, "err", err)
 	}
 	defer file.Close()
 	scanner := bufio.NewScanner(file)
@@ -239,7 +230,7 @@ func GrabTracingKubelet(d Downloader, job string, buildNumber int, nodeName stri
 	}
 
 	if err := scanner.Err(); err != nil {
-		log.Fatal(err)
+		logFatal(parserLog, "Failed to read kubelet log", "job", job, "build", buildNumber, "node", nodeName, "err", err)
 	}
 
 	tracingData.SortData()
@@ -261,7 +252,9 @@ func parseLogEntry(line []byte, statePerPod map[string]*PodState) *DetectedEntry
 			if matchResult != nil {
 				ts, err := time.Parse(kubeletLogTimeFormat, currentYear+" "+string(matchResult[1]))
 				if err != nil {
-					log.Fatal("Error: can not parse log timestamp in kubelet.log")
+					// A corrupted line must not stop the ingestion.
+					parserLog.Warn("Can not parse log timestamp in kubelet.log", "line", string(line), "err", err)
+					return nil
 				}
 				switch probe {
 				// 'container starts' reported by PLEG event.
@@ -339,7 +332,7 @@ func (td *TracingData) SortData() {
 func (td *TracingData) ToSeriesData() string {
 	seriesData, err := json.Marshal(td)
 	if err != nil {
-		log.Fatal(err)
+		logFatal(parserLog, "Failed to marshal tracing data", "err", err)
 	}
 	return string(seriesData)
 }
@@ -0,0 +1,231 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	leaderElectLock      = flag.String("leader-elect-lock", "store", "Where the leader lease is kept: store, in --store-dir, or kubernetes, in a coordination.k8s.io Lease of the cluster node-perf-dash runs in")
+	leaderElectLease     = flag.String("leader-elect-lease", "node-perf-dash", "The name of the Lease with --leader-elect-lock=kubernetes")
+	leaderElectNamespace = flag.String("leader-elect-namespace", "", "The namespace of the Lease with --leader-elect-lock=kubernetes. It defaults to the namespace of the pod")
+)
+
+// serviceAccountDir is where the credentials of the service account of the
+// pod are mounted.
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// microTime is the format of the timestamps of a Lease.
+const microTime = "2006-01-02T15:04:05.000000Z07:00"
+
+// kubernetesLease is a coordination.k8s.io/v1 Lease. The metadata is kept as
+// is, so that its resourceVersion makes the updates conditional and the
+// fields set by others are preserved.
+type kubernetesLease struct {
+	APIVersion string                 `json:"apiVersion"`
+	Kind       string                 `json:"kind"`
+	Metadata   map[string]interface{} `json:"metadata"`
+	Spec       struct {
+		HolderIdentity       string `json:"holderIdentity,omitempty"`
+		LeaseDurationSeconds int    `json:"leaseDurationSeconds,omitempty"`
+		AcquireTime          string `json:"acquireTime,omitempty"`
+		RenewTime            string `json:"renewTime,omitempty"`
+		LeaseTransitions     int    `json:"leaseTransitions,omitempty"`
+	} `json:"spec"`
+}
+
+// expired returns whether the lease has no holder or was not renewed in time.
+func (l *kubernetesLease) expired(now time.Time) bool {
+	if l.Spec.HolderIdentity == "" {
+		return true
+	}
+	renewed, err := time.Parse(time.RFC3339Nano, l.Spec.RenewTime)
+	if err != nil {
+		return true
+	}
+	return !now.Before(renewed.Add(time.Duration(l.Spec.LeaseDurationSeconds) * time.Second))
+}
+
+// kubernetesLeaseLock keeps the lease in a Lease of the Kubernetes API, like
+// the leader election of the Kubernetes components. The conflicts of the
+// updates ensure that a single replica takes over an expired lease.
+type kubernetesLeaseLock struct {
+	// url is the URL of the Lease in the API server.
+	url string
+	// tokenFile is read for each request, as the tokens of the service
+	// accounts are rotated.
+	tokenFile string
+	client    *http.Client
+}
+
+// newInClusterLeaseLock returns the lock of the Lease named by the flags, in
+// the API server of the cluster the pod runs in.
+func newInClusterLeaseLock() (*kubernetesLeaseLock, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("--leader-elect-lock=kubernetes requires running in a Kubernetes pod")
+	}
+	namespace := *leaderElectNamespace
+	if namespace == "" {
+		content, err := ioutil.ReadFile(serviceAccountDir + "/namespace")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the namespace of the pod: %v", err)
+		}
+		namespace = strings.TrimSpace(string(content))
+	}
+	ca, err := ioutil.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the CA of the API server: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("failed to parse the CA of the API server")
+	}
+	client := &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+	}
+	return newKubernetesLeaseLock("https://"+net.JoinHostPort(host, port), namespace, *leaderElectLease, serviceAccountDir+"/token", client), nil
+}
+
+func newKubernetesLeaseLock(apiURL, namespace, name, tokenFile string, client *http.Client) *kubernetesLeaseLock {
+	return &kubernetesLeaseLock{
+		url:       fmt.Sprintf("%s/apis/coordination.k8s.io/v1/namespaces/%s/leases/%s", strings.TrimSuffix(apiURL, "/"), namespace, name),
+		tokenFile: tokenFile,
+		client:    client,
+	}
+}
+
+// do sends a request for the Lease to the API server and decodes the Lease of
+// the response. It returns the status code of the response.
+func (l *kubernetesLeaseLock) do(method, url string, lease *kubernetesLease) (*kubernetesLease, int, error) {
+	var body []byte
+	if lease != nil {
+		var err error
+		if body, err = json.Marshal(lease); err != nil {
+			return nil, 0, err
+		}
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	if l.tokenFile != "" {
+		token, err := ioutil.ReadFile(l.tokenFile)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to read the service account token: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	response, err := l.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer response.Body.Close()
+	content, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, response.StatusCode, err
+	}
+	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusCreated {
+		return nil, response.StatusCode, fmt.Errorf("got status code %d from the API server for %s %s: %s", response.StatusCode, method, url, strings.TrimSpace(string(content)))
+	}
+	result := &kubernetesLease{}
+	if err := json.Unmarshal(content, result); err != nil {
+		return nil, response.StatusCode, fmt.Errorf("failed to decode the Lease: %v", err)
+	}
+	return result, response.StatusCode, nil
+}
+
+func (l *kubernetesLeaseLock) acquireOrRenew(id string, now time.Time, duration time.Duration) (bool, error) {
+	lease, status, err := l.do("GET", l.url, nil)
+	if status == http.StatusNotFound {
+		lease = &kubernetesLease{APIVersion: "coordination.k8s.io/v1", Kind: "Lease", Metadata: map[string]interface{}{"name": l.name()}}
+		lease.Spec.HolderIdentity = id
+		lease.Spec.LeaseDurationSeconds = leaseSeconds(duration)
+		lease.Spec.AcquireTime = now.UTC().Format(microTime)
+		lease.Spec.RenewTime = lease.Spec.AcquireTime
+		_, status, err = l.do("POST", l.url[:strings.LastIndex(l.url, "/")], lease)
+		if status == http.StatusConflict {
+			// Another replica created the Lease first.
+			return false, nil
+		}
+		return err == nil, err
+	}
+	if err != nil {
+		return false, err
+	}
+
+	held := lease.Spec.HolderIdentity == id
+	if !held && !lease.expired(now) {
+		return false, nil
+	}
+	if !held {
+		lease.Spec.HolderIdentity = id
+		lease.Spec.AcquireTime = now.UTC().Format(microTime)
+		lease.Spec.LeaseTransitions++
+	}
+	lease.Spec.LeaseDurationSeconds = leaseSeconds(duration)
+	lease.Spec.RenewTime = now.UTC().Format(microTime)
+	_, status, err = l.do("PUT", l.url, lease)
+	if status == http.StatusConflict {
+		// The Lease was updated by another replica since it was read.
+		return false, nil
+	}
+	if err != nil {
+		return held, err
+	}
+	return true, nil
+}
+
+func (l *kubernetesLeaseLock) release(id string) error {
+	lease, _, err := l.do("GET", l.url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to release the leader lease: %v", err)
+	}
+	if lease.Spec.HolderIdentity != id {
+		return nil
+	}
+	lease.Spec.HolderIdentity = ""
+	if _, _, err := l.do("PUT", l.url, lease); err != nil {
+		return fmt.Errorf("failed to release the leader lease: %v", err)
+	}
+	return nil
+}
+
+// name returns the name of the Lease.
+func (l *kubernetesLeaseLock) name() string {
+	return l.url[strings.LastIndex(l.url, "/")+1:]
+}
+
+// leaseSeconds rounds the duration up to whole seconds.
+func leaseSeconds(duration time.Duration) int {
+	return int((duration + time.Second - 1) / time.Second)
+}
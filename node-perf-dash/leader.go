@@ -0,0 +1,294 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultLeaseDuration       = 15 * time.Second
+	defaultStandbySyncInterval = time.Minute
+
+	leasesKey = "leader"
+)
+
+var (
+	leaderElect         = flag.Bool("leader-elect", false, "If true, elect a leader among the replicas sharing --store-dir. Only the leader fetches new builds and sends the digests, the standby replicas serve the data it persists")
+	leaderID            = flag.String("leader-id", "", "The identity of this replica in the leader election. It defaults to the hostname")
+	leaderLeaseDuration = flag.Duration("leader-lease-duration", defaultLeaseDuration, "The time after which the standby replicas take over if the leader does not renew its lease")
+	standbySyncInterval = flag.Duration("standby-sync-interval", defaultStandbySyncInterval, "How often the standby replicas load the data persisted by the leader")
+)
+
+// leaderLease is the lease held by the leader in the store. Each takeover
+// creates a new generation of the lease under "leader/<generation>", so that a
+// single replica wins when several of them take over an expired lease at the
+// same time.
+type leaderLease struct {
+	Holder     string    `json:"holder"`
+	Generation int       `json:"generation"`
+	RenewTime  time.Time `json:"renewTime"`
+	Duration   Duration  `json:"duration"`
+}
+
+func leaseKey(generation int) string {
+	return leasesKey + "/" + strconv.Itoa(generation)
+}
+
+// expired returns whether the lease has not been renewed in time.
+func (l *leaderLease) expired(now time.Time) bool {
+	return !now.Before(l.RenewTime.Add(l.Duration.Duration))
+}
+
+// leaseLock records the lease of the leader shared by the replicas.
+type leaseLock interface {
+	// acquireOrRenew renews the lease if it is held by id, or acquires it
+	// for id if it is free or has expired at now. It returns whether id
+	// holds the lease, which is its last known state on error.
+	acquireOrRenew(id string, now time.Time, duration time.Duration) (bool, error)
+	// release gives up the lease if it is held by id.
+	release(id string) error
+}
+
+// storeLeaseLock keeps the lease in the store shared by the replicas.
+type storeLeaseLock struct {
+	store Store
+	// held is the lease held by this replica, or nil if it is a standby.
+	held *leaderLease
+}
+
+func newStoreLeaseLock(store Store) *storeLeaseLock {
+	return &storeLeaseLock{store: store}
+}
+
+// current returns the newest generation of the lease, or nil if there is
+// none.
+func (l *storeLeaseLock) current() (*leaderLease, error) {
+	keys, err := l.store.List(leasesKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	sortBuildKeys(keys)
+	lease := &leaderLease{}
+	if err := l.store.Get(keys[len(keys)-1], lease); err != nil {
+		return nil, err
+	}
+	return lease, nil
+}
+
+func (l *storeLeaseLock) acquireOrRenew(id string, now time.Time, duration time.Duration) (bool, error) {
+	lease, err := l.current()
+	if err != nil {
+		return l.held != nil, err
+	}
+	switch {
+	case lease != nil && lease.Holder == id:
+		lease.RenewTime = now
+		lease.Duration = Duration{duration}
+		if err := l.store.Put(leaseKey(lease.Generation), lease); err != nil {
+			return l.held != nil, err
+		}
+		// Another replica may have taken over while the lease was
+		// being renewed.
+		newest, err := l.current()
+		if err != nil {
+			return l.held != nil, err
+		}
+		if newest.Generation != lease.Generation {
+			l.held = nil
+			return false, nil
+		}
+		l.held = lease
+		return true, nil
+	case lease == nil || lease.expired(now):
+		next := &leaderLease{Holder: id, Generation: 1, RenewTime: now, Duration: Duration{duration}}
+		if lease != nil {
+			next.Generation = lease.Generation + 1
+		}
+		if err := l.store.Create(leaseKey(next.Generation), next); err != nil {
+			l.held = nil
+			if err == errExists {
+				return false, nil
+			}
+			return false, err
+		}
+		l.held = next
+		l.removeOldLeases()
+		return true, nil
+	default:
+		l.held = nil
+		return false, nil
+	}
+}
+
+// removeOldLeases removes the generations of the lease older than the one
+// held.
+func (l *storeLeaseLock) removeOldLeases() {
+	keys, err := l.store.List(leasesKey)
+	if err != nil {
+		mainLog.Warn("Failed to list the old leader leases", "err", err)
+		return
+	}
+	for _, key := range keys {
+		if generation, _ := strconv.Atoi(path.Base(key)); generation < l.held.Generation {
+			if err := l.store.Delete(key); err != nil {
+				mainLog.Warn("Failed to remove the old leader lease", "key", key, "err", err)
+			}
+		}
+	}
+}
+
+func (l *storeLeaseLock) release(id string) error {
+	if l.held == nil {
+		return nil
+	}
+	lease := *l.held
+	lease.RenewTime = time.Time{}
+	l.held = nil
+	current, err := l.current()
+	if err != nil {
+		return err
+	}
+	if current == nil || current.Generation != lease.Generation {
+		return nil
+	}
+	if err := l.store.Put(leaseKey(lease.Generation), lease); err != nil {
+		return fmt.Errorf("failed to release the leader lease: %v", err)
+	}
+	return nil
+}
+
+// leaderElector elects a leader among the replicas of node-perf-dash sharing
+// a lease lock. The clocks of the replicas are assumed to be roughly in sync.
+type leaderElector struct {
+	leaseLock     leaseLock
+	id            string
+	leaseDuration time.Duration
+	now           func() time.Time
+
+	lock   sync.Mutex
+	leader bool
+}
+
+func newLeaderElector(leaseLock leaseLock, id string, leaseDuration time.Duration) *leaderElector {
+	return &leaderElector{leaseLock: leaseLock, id: id, leaseDuration: leaseDuration, now: time.Now}
+}
+
+// isLeader returns whether this replica holds the lease.
+func (e *leaderElector) isLeader() bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.leader
+}
+
+// tryAcquireOrRenew renews the lease if this replica holds it, or acquires it
+// if it has expired. It returns whether this replica holds the lease.
+func (e *leaderElector) tryAcquireOrRenew() (bool, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	leader, err := e.leaseLock.acquireOrRenew(e.id, e.now(), e.leaseDuration)
+	e.leader = leader
+	return leader, err
+}
+
+// release gives up the lease if this replica holds it, so that a standby
+// replica takes over without waiting for the lease to expire.
+func (e *leaderElector) release() error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.leader = false
+	return e.leaseLock.release(e.id)
+}
+
+// run takes part in the election until ctx is cancelled. While this replica
+// holds the lease, lead is run with a context cancelled when the lease is
+// lost; otherwise standby is called every --standby-sync-interval. run returns
+// once lead has returned.
+func (e *leaderElector) run(ctx context.Context, lead func(ctx context.Context), standby func()) {
+	retryPeriod := e.leaseDuration / 3
+	var (
+		stopLeading context.CancelFunc
+		leading     chan struct{}
+		lastRenew   time.Time
+		lastSync    time.Time
+	)
+	stop := func() {
+		if stopLeading == nil {
+			return
+		}
+		stopLeading()
+		<-leading
+		stopLeading = nil
+	}
+	defer stop()
+
+	for {
+		isLeader, err := e.tryAcquireOrRenew()
+		if err != nil {
+			mainLog.Error("Failed to update the leader lease", "id", e.id, "err", err)
+			// Step down once the lease expired, as another replica
+			// may take over.
+			if isLeader && e.now().Sub(lastRenew) >= e.leaseDuration {
+				e.lock.Lock()
+				e.leader = false
+				e.lock.Unlock()
+				isLeader = false
+			}
+		} else if isLeader {
+			lastRenew = e.now()
+		}
+
+		switch {
+		case isLeader && stopLeading == nil:
+			mainLog.Info("Became the leader", "id", e.id)
+			isLeaderMetric.Set(1)
+			// Load what the previous leader persisted, so that its
+			// builds are not fetched again.
+			standby()
+			leaderCtx, cancel := context.WithCancel(ctx)
+			stopLeading, leading = cancel, make(chan struct{})
+			go func(done chan struct{}) {
+				defer close(done)
+				defer cancel()
+				lead(leaderCtx)
+			}(leading)
+		case !isLeader && stopLeading != nil:
+			mainLog.Warn("Lost the leadership", "id", e.id)
+			isLeaderMetric.Set(0)
+			stop()
+			fallthrough
+		case !isLeader && e.now().Sub(lastSync) >= *standbySyncInterval:
+			standby()
+			lastSync = e.now()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryPeriod):
+		}
+	}
+}
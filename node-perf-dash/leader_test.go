@@ -0,0 +1,183 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLeaderElector(t *testing.T) {
+	dir, err := ioutil.TempDir("", "node-perf-dash-leader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testElection(t, newStoreLeaseLock(s), newStoreLeaseLock(s))
+
+	// Only the newest generation of the lease is kept.
+	keys, err := s.List(leasesKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != leaseKey(3) {
+		t.Errorf("Expected keys [%s] but got %v", leaseKey(3), keys)
+	}
+}
+
+// testElection runs an election between two replicas with the lease locks.
+func testElection(t *testing.T, lockA, lockB leaseLock) {
+	now := time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	a := newLeaderElector(lockA, "a", 15*time.Second)
+	b := newLeaderElector(lockB, "b", 15*time.Second)
+	a.now, b.now = clock, clock
+
+	steps := []struct {
+		desc     string
+		advance  time.Duration
+		elector  *leaderElector
+		release  bool
+		expected bool
+	}{
+		{desc: "a acquires the free lease", elector: a, expected: true},
+		{desc: "b waits for the lease held by a", elector: b, expected: false},
+		{desc: "a renews its lease", advance: 10 * time.Second, elector: a, expected: true},
+		{desc: "b waits for the renewed lease", advance: 10 * time.Second, elector: b, expected: false},
+		{desc: "b takes over the expired lease", advance: 5 * time.Second, elector: b, expected: true},
+		{desc: "a lost the lease", elector: a, expected: false},
+		{desc: "b releases the lease", elector: b, release: true, expected: false},
+		{desc: "a takes over the released lease", elector: a, expected: true},
+	}
+	for _, step := range steps {
+		now = now.Add(step.advance)
+		if step.release {
+			if err := step.elector.release(); err != nil {
+				t.Fatalf("%s: %v", step.desc, err)
+			}
+		} else if _, err := step.elector.tryAcquireOrRenew(); err != nil {
+			t.Fatalf("%s: %v", step.desc, err)
+		}
+		if isLeader := step.elector.isLeader(); isLeader != step.expected {
+			t.Errorf("%s: expected leader %v but got %v", step.desc, step.expected, isLeader)
+		}
+	}
+}
+
+// fakeLeaseServer is a fake of the API server storing a single Lease, which
+// rejects the updates of stale versions of the Lease.
+type fakeLeaseServer struct {
+	lock    sync.Mutex
+	lease   *kubernetesLease
+	version int
+	// conflicts is the number of the next updates rejected as if the
+	// Lease had been updated by another replica.
+	conflicts int
+}
+
+func (f *fakeLeaseServer) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if req.Header.Get("Authorization") != "Bearer token" {
+		http.Error(res, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var lease *kubernetesLease
+	if req.Method == "POST" || req.Method == "PUT" {
+		lease = &kubernetesLease{}
+		if err := json.NewDecoder(req.Body).Decode(lease); err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	switch {
+	case req.Method == "GET" && strings.HasSuffix(req.URL.Path, "/leases/node-perf-dash"):
+		if f.lease == nil {
+			http.NotFound(res, req)
+			return
+		}
+	case req.Method == "POST" && strings.HasSuffix(req.URL.Path, "/leases"):
+		if f.lease != nil {
+			http.Error(res, "already exists", http.StatusConflict)
+			return
+		}
+		f.store(lease)
+		res.WriteHeader(http.StatusCreated)
+	case req.Method == "PUT" && strings.HasSuffix(req.URL.Path, "/leases/node-perf-dash"):
+		if f.conflicts > 0 || f.lease == nil || lease.Metadata["resourceVersion"] != f.lease.Metadata["resourceVersion"] {
+			if f.conflicts > 0 {
+				f.conflicts--
+			}
+			http.Error(res, "conflict", http.StatusConflict)
+			return
+		}
+		f.store(lease)
+	default:
+		http.Error(res, "unexpected request", http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(res).Encode(f.lease)
+}
+
+func (f *fakeLeaseServer) store(lease *kubernetesLease) {
+	f.version++
+	lease.Metadata["resourceVersion"] = strconv.Itoa(f.version)
+	f.lease = lease
+}
+
+func TestKubernetesLeaseLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "node-perf-dash-lease")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := dir + "/token"
+	if err := ioutil.WriteFile(tokenFile, []byte("token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeLeaseServer{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	newLock := func() leaseLock {
+		return newKubernetesLeaseLock(server.URL, "default", "node-perf-dash", tokenFile, http.DefaultClient)
+	}
+
+	testElection(t, newLock(), newLock())
+	if spec := fake.lease.Spec; spec.HolderIdentity != "a" || spec.LeaseTransitions != 2 || spec.LeaseDurationSeconds != 15 {
+		t.Errorf("unexpected lease %+v", spec)
+	}
+
+	// The Lease is updated by another replica between the read and the
+	// renewal.
+	fake.conflicts = 1
+	if held, err := newLock().acquireOrRenew("a", time.Now(), 15*time.Second); err != nil || held {
+		t.Errorf("expected the conflicting renewal to lose the lease but got %v (%v)", held, err)
+	}
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+var (
+	logFormat = flag.String("log-format", "text", "Format of the log output. Options include 'text', 'json'")
+	logLevel  = flag.String("log-level", "info", "Default log verbosity. Options include 'debug', 'info', 'warn', 'error'")
+	logLevels = flag.String("log-levels", "", "Per-subsystem log verbosity overriding --log-level, e.g. 'downloader=debug,server=warn'")
+)
+
+// Subsystems which have their own logger. Each log record carries the name of
+// its subsystem in the "subsystem" attribute.
+const (
+	subsystemMain       = "main"
+	subsystemDownloader = "downloader"
+	subsystemParser     = "parser"
+	subsystemServer     = "server"
+)
+
+var (
+	// logLevelVars holds the verbosity of each subsystem logger.
+	logLevelVars = map[string]*slog.LevelVar{}
+
+	mainLog       = newSubsystemLogger(os.Stderr, "text", subsystemMain)
+	downloaderLog = newSubsystemLogger(os.Stderr, "text", subsystemDownloader)
+	parserLog     = newSubsystemLogger(os.Stderr, "text", subsystemParser)
+	serverLog     = newSubsystemLogger(os.Stderr, "text", subsystemServer)
+)
+
+// newSubsystemLogger creates a logger writing to w in the given format for the
+// given subsystem. The verbosity of the logger is controlled by the level
+// registered in logLevelVars.
+func newSubsystemLogger(w io.Writer, format, subsystem string) *slog.Logger {
+	level, ok := logLevelVars[subsystem]
+	if !ok {
+		level = new(slog.LevelVar)
+		logLevelVars[subsystem] = level
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	if format == "json" {
+		handler = slog.NewJSONHandler(w, opts)
+	} else {
+		handler = slog.NewTextHandler(w, opts)
+	}
+	return slog.New(handler).With("subsystem", subsystem)
+}
+
+// initLogging configures the subsystem loggers using the --log-format,
+// --log-level and --log-levels flags. It must be called after flag.Parse().
+func initLogging() error {
+	if *logFormat != "text" && *logFormat != "json" {
+		return fmt.Errorf("unsupported log format %q", *logFormat)
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		return fmt.Errorf("invalid log level %q: %v", *logLevel, err)
+	}
+	for _, lv := range logLevelVars {
+		lv.Set(level)
+	}
+	if *logLevels != "" {
+		for _, override := range strings.Split(*logLevels, ",") {
+			parts := strings.SplitN(override, "=", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("invalid log level override %q, expected <subsystem>=<level>", override)
+			}
+			lv, ok := logLevelVars[parts[0]]
+			if !ok {
+				return fmt.Errorf("unknown subsystem %q in log level override", parts[0])
+			}
+			if err := level.UnmarshalText([]byte(parts[1])); err != nil {
+				return fmt.Errorf("invalid log level %q for subsystem %q: %v", parts[1], parts[0], err)
+			}
+			lv.Set(level)
+		}
+	}
+
+	mainLog = newSubsystemLogger(os.Stderr, *logFormat, subsystemMain)
+	downloaderLog = newSubsystemLogger(os.Stderr, *logFormat, subsystemDownloader)
+	parserLog = newSubsystemLogger(os.Stderr, *logFormat, subsystemParser)
+	serverLog = newSubsystemLogger(os.Stderr, *logFormat, subsystemServer)
+	return nil
+}
+
+// logFatal logs msg at the error level using logger and exits the program.
+func logFatal(logger *slog.Logger, msg string, args ...interface{}) {
+	logger.Error(msg, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,316 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"container/list"
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+var (
+	maxCachedBuilds = flag.Int("max-cached-builds", 0, "If positive, the maximum number of builds kept in memory across all jobs. The least recently used builds are evicted to the store set by --store-dir and read back on demand")
+	maxLoadedBuilds = flag.Int("max-loaded-builds", 50, "The maximum number of builds evicted by --max-cached-builds which are read back from the store for a request; the older ones are left out of its response")
+)
+
+// maxUsedBuilds bounds the builds read which are recorded between two updates
+// of the order of the builds in memory.
+const maxUsedBuilds = 100000
+
+// buildRef identifies a build of a job.
+type buildRef struct {
+	Job   string
+	Build string
+}
+
+// testNode identifies the data of a test on a node.
+type testNode struct {
+	Test string
+	Node string
+}
+
+// buildCache tracks the builds kept in memory. It is protected by dataLock.
+var buildCache = newBuildLRU()
+
+// buildLRU tracks the builds kept in memory in least recently used order, and
+// the builds which have been evicted from memory to the store but are still
+// within the --builds window.
+type buildLRU struct {
+	// capacity is the maximum number of builds kept in memory, or 0 if it
+	// is unlimited.
+	capacity int
+	// order lists the builds in memory, from the most to the least
+	// recently used.
+	order    *list.List
+	elements map[buildRef]*list.Element
+	// evicted is a map from each evicted build to the tests and nodes it
+	// has data for.
+	evicted map[buildRef]map[testNode]bool
+
+	// usedLock protects used, the builds read since the order was last
+	// updated. The readers only hold dataLock for reading, so they record
+	// the builds they use there rather than in order.
+	usedLock sync.Mutex
+	used     []buildRef
+}
+
+func newBuildLRU() *buildLRU {
+	return &buildLRU{
+		order:    list.New(),
+		elements: map[buildRef]*list.Element{},
+		evicted:  map[buildRef]map[testNode]bool{},
+	}
+}
+
+// markUsed records that the builds of the job in memory have been read. It
+// does not require dataLock.
+func (c *buildLRU) markUsed(job string, builds map[string]bool) {
+	c.usedLock.Lock()
+	defer c.usedLock.Unlock()
+	for build := range builds {
+		c.used = append(c.used, buildRef{Job: job, Build: build})
+	}
+	// Without new builds, e.g. on a standby, only the latest uses matter.
+	if len(c.used) > maxUsedBuilds {
+		c.used = append([]buildRef(nil), c.used[len(c.used)-maxUsedBuilds/2:]...)
+	}
+}
+
+// applyUsed moves the builds read since the last call to the front of the
+// order, in the order they were read. It must be called with dataLock held
+// for writing.
+func (c *buildLRU) applyUsed() {
+	c.usedLock.Lock()
+	used := c.used
+	c.used = nil
+	c.usedLock.Unlock()
+	for _, ref := range used {
+		if element, ok := c.elements[ref]; ok {
+			c.order.MoveToFront(element)
+		}
+	}
+}
+
+// touch records that the build in memory has just been used, after the
+// builds read before.
+func (c *buildLRU) touch(ref buildRef) {
+	c.applyUsed()
+	if element, ok := c.elements[ref]; ok {
+		c.order.MoveToFront(element)
+		return
+	}
+	c.elements[ref] = c.order.PushFront(ref)
+}
+
+// remove stops tracking the build in memory.
+func (c *buildLRU) remove(ref buildRef) {
+	if element, ok := c.elements[ref]; ok {
+		c.order.Remove(element)
+		delete(c.elements, ref)
+	}
+}
+
+// forget records that the data of the build for the test on the node has
+// been dropped from the --builds window.
+func (c *buildLRU) forget(testData TestToBuildData, ref buildRef, key testNode) {
+	if pairs, ok := c.evicted[ref]; ok {
+		delete(pairs, key)
+		if len(pairs) == 0 {
+			delete(c.evicted, ref)
+		}
+	}
+	if _, ok := c.elements[ref]; ok && len(snapshotBuild(testData, ref.Build)) == 0 {
+		c.remove(ref)
+	}
+}
+
+// evictedData returns the data of the evicted build for the test on the node,
+// or nil if there is none or it can not be loaded from the store.
+func (c *buildLRU) evictedData(ref buildRef, key testNode) *DataPerBuild {
+	if !c.evicted[ref][key] {
+		return nil
+	}
+	snapshot := buildSnapshot{}
+	if err := store.Get(buildKey(ref.Job, ref.Build), &snapshot); err != nil {
+		parserLog.Warn("Failed to load the evicted build", "job", ref.Job, "build", ref.Build, "err", err)
+		return nil
+	}
+	data := snapshot[key.Test][key.Node]
+	if data != nil {
+		// Like in loadBuild, the build may have been persisted before
+		// the unit configuration changed.
+		for i := range data.Perf {
+			normalizeDataItem(&data.Perf[i])
+		}
+	}
+	return data
+}
+
+// evictedBuilds returns the evicted builds of the job.
+func (c *buildLRU) evictedBuilds(job string) []string {
+	var result []string
+	for ref := range c.evicted {
+		if ref.Job == job {
+			result = append(result, ref.Build)
+		}
+	}
+	return result
+}
+
+// evictExcess evicts the least recently used builds until at most capacity
+// builds are left in memory. The builds which have not been persisted yet are
+// written to the store first; a build which can not be written is kept in
+// memory. It is only called when builds are added, not when they are read.
+func (c *buildLRU) evictExcess() {
+	if c.capacity <= 0 || store == nil {
+		return
+	}
+	for element := c.order.Back(); element != nil && c.order.Len() > c.capacity; {
+		ref := element.Value.(buildRef)
+		previous := element.Prev()
+		if err := c.evict(ref); err != nil {
+			parserLog.Warn("Failed to evict the build", "job", ref.Job, "build", ref.Build, "err", err)
+		}
+		element = previous
+	}
+}
+
+// evict moves the data of the build from memory to the store.
+func (c *buildLRU) evict(ref buildRef) error {
+	testData := allTestData[ref.Job]
+	snapshot := snapshotBuild(testData, ref.Build)
+	if dirtyBuilds[ref.Job][ref.Build] && len(snapshot) > 0 {
+		if err := store.Put(buildKey(ref.Job, ref.Build), snapshot); err != nil {
+			return err
+		}
+		delete(dirtyBuilds[ref.Job], ref.Build)
+	}
+	pairs := map[testNode]bool{}
+	for test, dataPerNode := range snapshot {
+		for node := range dataPerNode {
+			delete(testData[test].Data[node], ref.Build)
+			pairs[testNode{Test: test, Node: node}] = true
+		}
+	}
+	if len(pairs) > 0 {
+		c.evicted[ref] = pairs
+	}
+	c.remove(ref)
+	parserLog.Debug("Evicted the build from memory", "job", ref.Job, "build", ref.Build)
+	return nil
+}
+
+// retainedBuilds returns the builds of the job within the --builds window,
+// whether they are in memory or evicted to the store. It must be called with
+// dataLock held.
+func retainedBuilds(job string) map[string]bool {
+	result := buildsInMemory(allTestData[job])
+	for _, build := range buildCache.evictedBuilds(job) {
+		result[build] = true
+	}
+	return result
+}
+
+// jobData returns a copy of the data of all the builds of the job within the
+// --builds window, like jobBuildData.
+func jobData(job string) (TestToBuildData, error) {
+	return jobBuildData(job, nil)
+}
+
+// jobBuildData returns a copy of the data of the given builds of the job, or
+// of all its builds within the --builds window if builds is nil. The copy
+// shares the data of the builds in memory, so it is cheap, and can be read
+// without holding dataLock. The evicted builds are read from the store into
+// the copy only, the latest first and at most --max-loaded-builds of them, so
+// that a request does not bring the whole window back into memory; the older
+// ones are left out. dataLock is only held for reading, and not while the
+// store is read.
+func jobBuildData(job string, builds map[string]bool) (TestToBuildData, error) {
+	dataLock.RLock()
+	testData, ok := allTestData[job]
+	if !ok {
+		dataLock.RUnlock()
+		return nil, fmt.Errorf("unknown job %q", job)
+	}
+	result := TestToBuildData{}
+	resident := map[string]bool{}
+	for test, dataPerTest := range testData {
+		copied := &DataPerTest{Data: map[string]DataPerNode{}, Job: dataPerTest.Job, Labels: dataPerTest.Labels}
+		for node, dataPerNode := range dataPerTest.Data {
+			copied.Data[node] = DataPerNode{}
+			for build, data := range dataPerNode {
+				if builds == nil || builds[build] {
+					copied.Data[node][build] = data
+					resident[build] = true
+				}
+			}
+		}
+		result[test] = copied
+	}
+	evicted := map[string][]testNode{}
+	for _, build := range buildCache.evictedBuilds(job) {
+		if builds == nil || builds[build] {
+			for key := range buildCache.evicted[buildRef{Job: job, Build: build}] {
+				evicted[build] = append(evicted[build], key)
+			}
+		}
+	}
+	dataLock.RUnlock()
+
+	buildCache.markUsed(job, resident)
+	buildCacheRequests.WithLabelValues("hit").Add(float64(len(resident)))
+	var load []string
+	for build := range evicted {
+		load = append(load, build)
+	}
+	sort.Slice(load, func(i, j int) bool { return buildNumberLess(load[j], load[i]) })
+	if len(load) > *maxLoadedBuilds {
+		parserLog.Debug("Leaving out the oldest evicted builds", "job", job, "builds", len(load)-*maxLoadedBuilds)
+		load = load[:*maxLoadedBuilds]
+	}
+	buildCacheRequests.WithLabelValues("miss").Add(float64(len(load)))
+	for _, build := range load {
+		snapshot := buildSnapshot{}
+		if err := store.Get(buildKey(job, build), &snapshot); err != nil {
+			// The build may have left the window since the lock
+			// was released.
+			parserLog.Warn("Failed to load the evicted build", "job", job, "build", build, "err", err)
+			continue
+		}
+		for _, key := range evicted[build] {
+			if data, ok := snapshot[key.Test][key.Node]; ok {
+				result.GetDataPerBuild(job, build, key.Test, key.Node)
+				result[key.Test].Data[key.Node][build] = data
+			}
+		}
+	}
+	return result, nil
+}
+
+// buildNumberLess orders the builds by number, and the builds which are not
+// numbers after the others.
+func buildNumberLess(a, b string) bool {
+	m, errA := strconv.Atoi(a)
+	n, errB := strconv.Atoi(b)
+	if errA != nil || errB != nil {
+		return errA == nil || (errB != nil && a < b)
+	}
+	return m < n
+}
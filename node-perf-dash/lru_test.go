@@ -0,0 +1,109 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestBuildLRU(t *testing.T) {
+	dir, err := ioutil.TempDir("", "node-perf-dash-lru")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if store, err = NewFileStore(dir); err != nil {
+		t.Fatal(err)
+	}
+	buildCache = newBuildLRU()
+	buildCache.capacity = 2
+	defer func() {
+		store = nil
+		buildCache = newBuildLRU()
+	}()
+
+	job := "lru"
+	allTestData[job] = TestToBuildData{}
+	defer delete(allTestData, job)
+	for _, build := range []string{"1", "2", "3"} {
+		buildData := TestToBuildData{}
+		buildData.GetDataPerBuild(job, build, "test", "node").Version = "v" + build
+		mergeBuildData(allTestData[job], &TestInfo{Info: map[string]string{}}, job, build, buildData, &TestInfo{})
+	}
+
+	inMemory := func() []string {
+		var result []string
+		for build := range buildsInMemory(allTestData[job]) {
+			result = append(result, build)
+		}
+		sort.Strings(result)
+		return result
+	}
+	if expected := []string{"2", "3"}; !reflect.DeepEqual(inMemory(), expected) {
+		t.Errorf("Expected builds %v in memory but got %v", expected, inMemory())
+	}
+	if retained := retainedBuilds(job); len(retained) != 3 {
+		t.Errorf("Expected 3 retained builds but got %v", retained)
+	}
+
+	testData, err := jobData(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, build := range []string{"1", "2", "3"} {
+		if data := testData["test"].Data["node"][build]; data == nil || data.Version != "v"+build {
+			t.Errorf("Expected version v%s for build %s but got %+v", build, build, data)
+		}
+	}
+	if len(inMemory()) != 2 {
+		t.Errorf("Expected 2 builds in memory after reading but got %v", inMemory())
+	}
+
+	// Only the requested builds are copied.
+	testData, err = jobBuildData(job, map[string]bool{"1": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data := testData["test"].Data["node"]; len(data) != 1 || data["1"] == nil {
+		t.Errorf("Expected only build 1 but got %v", data)
+	}
+
+	// The evicted builds beyond --max-loaded-builds are left out.
+	defer func(n int) { *maxLoadedBuilds = n }(*maxLoadedBuilds)
+	*maxLoadedBuilds = 0
+	if testData, err = jobData(job); err != nil {
+		t.Fatal(err)
+	}
+	if data := testData["test"].Data["node"]; len(data) != 2 || data["1"] != nil {
+		t.Errorf("Expected the evicted build to be left out but got %v", data)
+	}
+
+	// The builds read are the most recently used once the next build is
+	// merged: build 2 was read after build 3, so build 3 is evicted.
+	jobBuildData(job, map[string]bool{"3": true})
+	jobBuildData(job, map[string]bool{"2": true})
+	buildData := TestToBuildData{}
+	buildData.GetDataPerBuild(job, "4", "test", "node").Version = "v4"
+	mergeBuildData(allTestData[job], &TestInfo{Info: map[string]string{}}, job, "4", buildData, &TestInfo{})
+	if expected := []string{"2", "4"}; !reflect.DeepEqual(inMemory(), expected) {
+		t.Errorf("Expected builds %v in memory but got %v", expected, inMemory())
+	}
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	debugAddr = flag.String("debug-address", "", "If non-empty, serve the net/http/pprof profiles of node-perf-dash under /debug/pprof/ on this address, e.g. localhost:6060, separate from --address")
+)
+
+const metricsNamespace = "node_perf_dash"
+
+// The operational metrics of node-perf-dash, exported on /metrics together
+// with the memory and goroutines of the Go runtime and the process.
+var (
+	refreshDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "refresh_duration_seconds",
+		Help:      "Duration of the refreshes of the data of the jobs.",
+		Buckets:   prometheus.ExponentialBuckets(0.5, 2, 12),
+	}, []string{"job", "result"})
+	buildsFetched = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "builds_fetched_total",
+		Help:      "Number of builds fetched from the data source.",
+	}, []string{"job"})
+	artifactsFetched = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "artifacts_fetched_total",
+		Help:      "Number of artifacts fetched from the data source, by parser.",
+	}, []string{"job", "parser"})
+	artifactBytesFetched = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "artifact_bytes_fetched_total",
+		Help:      "Size of the artifacts fetched from the data source, by parser.",
+	}, []string{"job", "parser"})
+	parseErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "parse_errors_total",
+		Help:      "Number of artifacts which could not be fetched or parsed, by parser.",
+	}, []string{"job", "parser"})
+	buildCacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "build_cache_requests_total",
+		Help:      "Number of builds requested from memory, by result: hit if the build was in memory, miss if it was loaded back from the store.",
+	}, []string{"result"})
+	buildsInCache = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "builds_in_memory",
+		Help:      "Number of builds in memory, across all jobs.",
+	}, func() float64 {
+		dataLock.RLock()
+		defer dataLock.RUnlock()
+		return float64(buildCache.order.Len())
+	})
+	isLeaderMetric = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "is_leader",
+		Help:      "1 if this replica is the leader fetching new builds, 0 if it is a standby. Always 1 without --leader-elect.",
+	})
+	fetchBudgetWait = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "fetch_budget_wait_seconds",
+		Help:      "Time the requests to the data source waited for the quota.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 4, 10),
+	}, []string{"job"})
+	fetchBudgetWaiting = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "fetch_budget_waiting_requests",
+		Help:      "Number of requests to the data source waiting for the quota.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(refreshDuration, buildsFetched, artifactsFetched, artifactBytesFetched, parseErrors, buildCacheRequests, buildsInCache, isLeaderMetric, fetchBudgetWait, fetchBudgetWaiting)
+}
+
+// registerMetricsHandler registers the /metrics endpoint on mux.
+func registerMetricsHandler(mux *http.ServeMux) {
+	mux.Handle("/metrics", promhttp.Handler())
+}
+
+// newDebugMux returns the handler of the pprof profiles, served on
+// --debug-address only: the profiles reveal the command line and the memory
+// of the process, and a CPU profile or trace keeps it busy, so they are not
+// served where the dashboard is public.
+func newDebugMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// scrapeMetrics returns the values of the series served on /metrics by mux,
+// by name and labels as they are exported, e.g.
+// `node_perf_dash_build_cache_requests_total{result="hit"}`.
+func scrapeMetrics(t *testing.T, mux *http.ServeMux) map[string]float64 {
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, httptest.NewRequest("GET", "/metrics", nil))
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected status %d but got %d: %s", http.StatusOK, res.Code, res.Body)
+	}
+	values := map[string]float64{}
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndex(line, " ")
+		if i < 0 {
+			continue
+		}
+		value, err := strconv.ParseFloat(line[i+1:], 64)
+		if err != nil {
+			t.Fatalf("failed to parse the metric %q: %v", line, err)
+		}
+		values[line[:i]] = value
+	}
+	return values
+}
+
+func TestMetrics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "node-perf-dash-metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if store, err = NewFileStore(dir); err != nil {
+		t.Fatal(err)
+	}
+	buildCache = newBuildLRU()
+	buildCache.capacity = 2
+	defer func() {
+		store = nil
+		buildCache = newBuildLRU()
+	}()
+	mux := http.NewServeMux()
+	registerMetricsHandler(mux)
+
+	job := "metrics"
+	allTestData[job] = TestToBuildData{}
+	defer delete(allTestData, job)
+	for _, build := range []string{"1", "2", "3"} {
+		buildData := TestToBuildData{}
+		buildData.GetDataPerBuild(job, build, "test", "node").Version = "v" + build
+		mergeBuildData(allTestData[job], &TestInfo{Info: map[string]string{}}, job, build, buildData, &TestInfo{})
+	}
+
+	// The hits and misses are counted per build the request needs: builds
+	// 2 and 3 are in memory, build 1 is read back from the store.
+	before := scrapeMetrics(t, mux)
+	if _, err := jobData(job); err != nil {
+		t.Fatal(err)
+	}
+	after := scrapeMetrics(t, mux)
+	for name, expect := range map[string]float64{
+		`node_perf_dash_build_cache_requests_total{result="hit"}`:  2,
+		`node_perf_dash_build_cache_requests_total{result="miss"}`: 1,
+	} {
+		if got := after[name] - before[name]; got != expect {
+			t.Errorf("expected %s to increase by %v but got %v", name, expect, got)
+		}
+	}
+	if got := after["node_perf_dash_builds_in_memory"]; got != 2 {
+		t.Errorf("expected 2 builds in memory but got %v", got)
+	}
+	if _, ok := after["go_goroutines"]; !ok {
+		t.Errorf("expected the metrics of the Go runtime to be exported")
+	}
+
+	// The profiles are only served by the debug handler.
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, httptest.NewRequest("GET", "/debug/pprof/", nil))
+	if res.Code != http.StatusNotFound {
+		t.Errorf("expected the profiles not to be served with the metrics but got %d", res.Code)
+	}
+	res = httptest.NewRecorder()
+	newDebugMux().ServeHTTP(res, httptest.NewRequest("GET", "/debug/pprof/", nil))
+	if res.Code != http.StatusOK {
+		t.Errorf("expected the profiles to be served by the debug handler but got %d", res.Code)
+	}
+}
@@ -17,13 +17,20 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
+
+	"k8s.io/contrib/github-utils/github"
 )
 
 // TODO(yguo0905): Put each component into its own package.
@@ -33,17 +40,24 @@ const (
 	pollDuration = 10 * time.Minute
 	errorDelay   = 10 * time.Second
 	maxBuilds    = 100
+
+	// defaultShutdownTimeout is the default time allowed for finishing in-flight
+	// requests and parses when shutting down.
+	defaultShutdownTimeout = 30 * time.Second
 )
 
 var (
 	addr         = flag.String("address", ":8080", "The address to serve web data on")
 	www          = flag.Bool("www", true, "If true, start a web-server to server performance data")
-	wwwDir       = flag.String("dir", "www", "If non-empty, add a file server for this directory at the root of the web server")
 	builds       = flag.Int("builds", maxBuilds, "Total builds number")
-	datasource   = flag.String("datasource", "google-gcs", "Source of test data. Options include 'local', 'google-gcs'")
+	datasource   = flag.String("datasource", "google-gcs", "Source of test data. Options include 'local', 'google-gcs', 'http', 'jenkins', 'bigquery'")
 	localDataDir = flag.String("local-data-dir", "", "The path to test data directory")
 	tracing      = flag.Bool("tracing", false, "If true, try to get tracing data from Kubelet log")
 	jenkinsJob   = flag.String("jenkins-job", "kubelet-benchmark-gce-e2e-ci", "The Jenkins projects to display, separated by ,")
+	configFile   = flag.String("config", "", "The path to the YAML configuration of the jobs to display. If empty, --jenkins-job is used")
+
+	refreshInterval = flag.Duration("refresh-interval", pollDuration, "How often new builds are fetched for the jobs which do not configure their own refresh interval")
+	shutdownTimeout = flag.Duration("shutdown-timeout", defaultShutdownTimeout, "The time allowed for finishing in-flight requests and parses on SIGTERM")
 )
 
 var (
@@ -53,6 +67,9 @@ var (
 
 	// allTestInfo stores the test description of all tests.
 	allTestInfo = TestInfo{Info: make(map[string]string)}
+
+	// config is the validated configuration of node-perf-dash.
+	config *Config
 )
 
 func main() {
@@ -62,89 +79,361 @@ func main() {
 		err        error
 	)
 
-	fmt.Print("Starting Node Performance Dashboard...\n")
+	if len(os.Args) > 1 && os.Args[1] == "validate-config" {
+		os.Exit(validateConfigCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		os.Exit(checkCommand(os.Args[2:]))
+	}
+
 	flag.Parse()
+	if *showVersion {
+		fmt.Println(buildInfo())
+		return
+	}
+	if err := initLogging(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to initialize logging: %v\n", err)
+		os.Exit(1)
+	}
+	info := buildInfo()
+	mainLog.Info("Starting Node Performance Dashboard", "version", info.Version, "commit", info.GitCommit, "built", info.BuildDate)
 
 	if *builds > maxBuilds || *builds < 0 {
-		fmt.Printf("Invalid builds number: %d", *builds)
+		mainLog.Warn("Invalid builds number, using the maximum instead", "builds", *builds, "max", maxBuilds)
 		*builds = maxBuilds
 	}
 
-	switch *datasource {
-	case "local":
-		downloader = NewLocalDownloader()
-	case "google-gcs":
-		downloader = NewGoogleGCSDownloader()
-	default:
-		fmt.Fprintf(os.Stderr, "Unsupported data source: %s.\n", *datasource)
+	downloader, err = newDownloader(*datasource)
+	if err != nil {
+		logFatal(mainLog, "Failed to create the downloader", "err", err)
+	}
+
+	config, err = loadConfigFromFlags()
+	if err != nil {
+		logFatal(mainLog, "Failed to load the configuration", "err", err)
+	}
+	if errs := config.Validate(); len(errs) > 0 {
+		for _, err := range errs {
+			mainLog.Error("Invalid configuration", "err", err)
+		}
 		os.Exit(1)
 	}
+	registerPlugins(config.Plugins)
+	fetchQuota = newFetchBudget(config.Quota(*datasource))
+	if thresholds, err = loadThresholdsFromFlags(); err != nil {
+		logFatal(mainLog, "Failed to load the thresholds", "err", err)
+	}
+
+	if apiTokens, err = loadAPITokensFromFlags(); err != nil {
+		logFatal(mainLog, "Failed to load the API tokens", "err", err)
+	}
+	if commitResolver.client, err = github.NewClientFromTokenFile(*githubTokenFile); err != nil {
+		logFatal(mainLog, "Failed to create the GitHub client", "err", err)
+	}
 
-	jobs = strings.Split(*jenkinsJob, ",")
-	fmt.Printf("The Jenkins jobs to display: %v\n", jobs)
+	jobs = config.JobNames()
+	mainLog.Info("Jenkins jobs to display", "jobs", jobs)
 
 	// Initialize test result map.
 	for _, job := range jobs {
 		allTestData[job] = TestToBuildData{}
 	}
 
+	if *maxCachedBuilds > 0 && *storeDir == "" {
+		logFatal(mainLog, "--max-cached-builds requires --store-dir to evict the builds to")
+	}
+	if *leaderElect && *storeDir == "" {
+		logFatal(mainLog, "--leader-elect requires --store-dir to be shared by the replicas")
+	}
+	if *leaderElectLock != "store" && *leaderElectLock != "kubernetes" {
+		logFatal(mainLog, "--leader-elect-lock must be store or kubernetes", "lock", *leaderElectLock)
+	}
+	if *leaderLeaseDuration <= 0 {
+		logFatal(mainLog, "--leader-lease-duration must be positive")
+	}
+	buildCache.capacity = *maxCachedBuilds
+	if *storeDir != "" {
+		if store, err = NewFileStore(*storeDir); err != nil {
+			logFatal(mainLog, "Failed to open the persistent cache", "err", err)
+		}
+		if err := loadCache(jobs); err != nil {
+			logFatal(mainLog, "Failed to load the persistent cache", "err", err)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	shutdownTracing, err := initTracing(ctx)
+	if err != nil {
+		logFatal(mainLog, "Failed to initialize tracing", "err", err)
+	}
+	// The server, the in-flight parses and the tracing share the deadline
+	// of the shutdown, so that together they take at most
+	// --shutdown-timeout.
+	deadline := &shutdownDeadline{timeout: *shutdownTimeout}
+	defer deadline.stop()
+	defer func() {
+		if err := shutdownTracing(deadline.context()); err != nil {
+			mainLog.Warn("Failed to flush the OpenTelemetry spans", "err", err)
+		}
+	}()
+
 	// Grab test results but not start webserver.
 	if !*www {
+		source := withBudget(ctx, downloader, fetchQuota)
 		for _, job := range jobs {
-			err = Parse(allTestData, &allTestInfo, job, downloader)
+			err = Parse(ctx, allTestData, &allTestInfo, job, source)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error fetching data: %v\n", err)
-				os.Exit(1)
+				logFatal(mainLog, "Error fetching data", "job", job, "err", err)
 			}
 			prettyResult, err := json.MarshalIndent(allTestData, "", " ")
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error formatting data: %v\n", err)
-				os.Exit(1)
+				logFatal(mainLog, "Error formatting data", "job", job, "err", err)
 			}
 			fmt.Printf("Result: %v\n", string(prettyResult))
 		}
+		if err := flushCache(); err != nil {
+			logFatal(mainLog, "Failed to flush the persistent cache", "err", err)
+		}
 		return
 	}
 
-	// Create a data collection goroutine for each Jenkins Job.
-	for _, job := range jobs {
-		// TODO(coufon): currently we do not need lock, but be aware of that.
-		go func(job string) {
-			for {
-				fmt.Printf("Fetching new data...\n")
-				err = Parse(allTestData, &allTestInfo, job, downloader)
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "Error fetching data: %v\n", err)
-					time.Sleep(errorDelay)
-					continue
+	// Fetch the new builds of the jobs, or only follow the leader if this
+	// replica is a standby.
+	lead := func(ctx context.Context) {
+		source := withBudget(ctx, downloader, fetchQuota)
+		var collectors sync.WaitGroup
+		// Create a data collection goroutine for each Jenkins Job.
+		for _, job := range config.Jobs {
+			collectors.Add(1)
+			go func(job *JobConfig) {
+				defer collectors.Done()
+				collectJob(ctx, job, source)
+			}(job)
+		}
+		if *prWatcher {
+			for _, presubmit := range config.Presubmits {
+				collectors.Add(1)
+				go func(presubmit *PresubmitConfig) {
+					defer collectors.Done()
+					watchPresubmit(ctx, presubmit, source)
+				}(presubmit)
+			}
+		}
+		if config.Digest != nil {
+			collectors.Add(1)
+			go func() {
+				defer collectors.Done()
+				runDigests(ctx, config.Digest, config.Jobs)
+			}()
+		}
+		collectors.Wait()
+	}
+	var elector *leaderElector
+	collected := make(chan struct{})
+	if *leaderElect {
+		id := *leaderID
+		if id == "" {
+			if id, err = os.Hostname(); err != nil {
+				logFatal(mainLog, "Failed to get the hostname for --leader-id", "err", err)
+			}
+		}
+		var lock leaseLock = newStoreLeaseLock(store)
+		if *leaderElectLock == "kubernetes" {
+			if lock, err = newInClusterLeaseLock(); err != nil {
+				logFatal(mainLog, "Failed to create the Kubernetes lease lock", "err", err)
+			}
+		}
+		elector = newLeaderElector(lock, id, *leaderLeaseDuration)
+		go func() {
+			defer close(collected)
+			elector.run(ctx, lead, func() {
+				if err := syncCache(jobs); err != nil {
+					mainLog.Error("Failed to load the data persisted by the leader", "err", err)
 				}
-				time.Sleep(pollDuration)
+			})
+		}()
+	} else {
+		isLeaderMetric.Set(1)
+		go func() {
+			defer close(collected)
+			lead(ctx)
+		}()
+	}
+
+	mux := newMux(jobs, downloader)
+	registerMetricsHandler(mux)
+	server := &http.Server{Addr: *addr, Handler: limitRequests(mux)}
+	var debugServer *http.Server
+	if *debugAddr != "" {
+		debugServer = &http.Server{Addr: *debugAddr, Handler: newDebugMux()}
+		go func() {
+			serverLog.Info("Serving the pprof profiles", "address", *debugAddr)
+			if err := debugServer.ListenAndServe(); err != http.ErrServerClosed {
+				logFatal(serverLog, "Debug server failed", "err", err)
 			}
-		}(job)
+		}()
+	}
+	go func() {
+		<-ctx.Done()
+		serverLog.Info("Shutting down the web server")
+		// The profiles in progress are not worth waiting for.
+		if debugServer != nil {
+			debugServer.Close()
+		}
+		if err := server.Shutdown(deadline.context()); err != nil {
+			serverLog.Error("Failed to shut down the web server gracefully", "err", err)
+		}
+	}()
+	serverLog.Info("Serving performance data", "address", *addr)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		logFatal(serverLog, "Web server failed", "err", err)
+	}
+
+	// Wait for the in-flight parses before flushing the persistent cache.
+	select {
+	case <-collected:
+	case <-deadline.context().Done():
+		mainLog.Warn("Timed out waiting for the in-flight parses")
+	}
+	if elector == nil || elector.isLeader() {
+		if err := flushCache(); err != nil {
+			logFatal(mainLog, "Failed to flush the persistent cache", "err", err)
+		}
+	}
+	if elector != nil {
+		if err := elector.release(); err != nil {
+			mainLog.Error("Failed to release the leadership", "err", err)
+		}
 	}
+	mainLog.Info("Node Performance Dashboard stopped")
+}
+
+// shutdownDeadline is the deadline of the graceful shutdown, shared by all its
+// steps. It starts with the first step.
+type shutdownDeadline struct {
+	timeout time.Duration
+	once    sync.Once
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// context returns the context of the shutdown, which expires timeout after
+// its first call.
+func (d *shutdownDeadline) context() context.Context {
+	d.once.Do(func() {
+		d.ctx, d.cancel = context.WithTimeout(context.Background(), d.timeout)
+	})
+	return d.ctx
+}
+
+// stop releases the resources of the deadline.
+func (d *shutdownDeadline) stop() {
+	d.context()
+	d.cancel()
+}
 
+// newMux returns the handler of the web server, serving the data and the API
+// of the jobs and the web UI.
+func newMux(jobs JobList, downloader Downloader) *http.ServeMux {
 	// Create a http handler for each Jenkins Job.
+	mux := http.NewServeMux()
 	for _, job := range jobs {
-		http.Handle(fmt.Sprintf("/data/%s", job), allTestData[job])
+		mux.Handle(fmt.Sprintf("/data/%s", job), jobDataHandler(job))
+	}
+	mux.Handle("/testinfo", &allTestInfo)
+	mux.Handle("/jobs", &jobs)
+	endpoints := apiEndpoints(downloader)
+	for _, endpoint := range endpoints {
+		mux.Handle(endpoint.Path, audited(endpoint))
 	}
-	http.Handle("/testinfo", &allTestInfo)
-	http.Handle("/jobs", &jobs)
-	http.Handle("/", http.FileServer(http.Dir(*wwwDir)))
-	http.ListenAndServe(*addr, nil)
+	mux.HandleFunc("/api/openapi.json", serveOpenAPI(endpoints))
+	mux.Handle("/", wwwHandler())
+	return mux
+}
+
+// collectJob fetches the new builds of the job every refresh interval until
+// ctx is cancelled.
+func collectJob(ctx context.Context, job *JobConfig, downloader Downloader) {
+	for {
+		mainLog.Info("Fetching new data", "job", job.Name)
+		delay := job.Interval()
+		start := time.Now()
+		if err := Parse(ctx, allTestData, &allTestInfo, job.Name, downloader); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			mainLog.Error("Error fetching data", "job", job.Name, "err", err)
+			refreshDuration.WithLabelValues(job.Name, "error").Observe(time.Since(start).Seconds())
+			delay = errorDelay
+		} else {
+			refreshDuration.WithLabelValues(job.Name, "success").Observe(time.Since(start).Seconds())
+		}
+		if err := flushCache(); err != nil {
+			mainLog.Error("Failed to flush the persistent cache", "job", job.Name, "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// newDownloader creates the Downloader for the given data source.
+func newDownloader(datasource string) (Downloader, error) {
+	switch datasource {
+	case "local":
+		if *localDataDir == "" {
+			return nil, fmt.Errorf("--local-data-dir must be set for the local data source")
+		}
+		return NewLocalDownloader(), nil
+	case "google-gcs":
+		return NewGoogleGCSDownloader(), nil
+	case "http":
+		if *httpBaseURL == "" {
+			return nil, fmt.Errorf("--http-base-url must be set for the http data source")
+		}
+		return NewHTTPDownloader(*httpBaseURL), nil
+	case "jenkins":
+		if *jenkinsURL == "" {
+			return nil, fmt.Errorf("--jenkins-url must be set for the jenkins data source")
+		}
+		token := ""
+		if *jenkinsTokenFile != "" {
+			data, err := ioutil.ReadFile(*jenkinsTokenFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read the Jenkins token: %v", err)
+			}
+			token = strings.TrimSpace(string(data))
+		}
+		return NewJenkinsDownloader(*jenkinsURL, *jenkinsUser, token), nil
+	case "bigquery":
+		if *bigqueryProject == "" || *bigqueryTable == "" {
+			return nil, fmt.Errorf("--bigquery-project and --bigquery-table must be set for the bigquery data source")
+		}
+		if strings.ContainsAny(*bigqueryTable, "`\\ ") {
+			return nil, fmt.Errorf("invalid BigQuery table %q", *bigqueryTable)
+		}
+		return NewBigQueryDownloader(*bigqueryProject, *bigqueryTable, *bigqueryTokenFile), nil
+	default:
+		return nil, fmt.Errorf("unsupported data source %q", datasource)
+	}
+}
+
+// loadConfigFromFlags loads the configuration from --config, or builds it from
+// --jenkins-job if no configuration file is specified.
+func loadConfigFromFlags() (*Config, error) {
+	if *configFile != "" {
+		return loadConfig(*configFile)
+	}
+	return configFromJobList(strings.Split(*jenkinsJob, ",")), nil
 }
 
 // JobList is the list containing all Jenkins projects.
 type JobList []string
 
 func (j *JobList) ServeHTTP(res http.ResponseWriter, req *http.Request) {
-	data, err := json.Marshal(j)
-	if err != nil {
-		res.Header().Set("Content-type", "text/html")
-		res.WriteHeader(http.StatusInternalServerError)
-		res.Write([]byte(fmt.Sprintf("<h3>Internal Error</h3><p>%v", err)))
-		return
-	}
-	res.Header().Set("Content-type", "application/json")
-	res.WriteHeader(http.StatusOK)
-	res.Write(data)
+	writeJSON(res, req, j)
 }
@@ -0,0 +1,500 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// apiEndpoint is an endpoint of the REST API. The endpoints are both routed
+// and documented in the OpenAPI document from their definitions, so that the
+// document does not drift from the routes.
+type apiEndpoint struct {
+	Path    string
+	Handler http.Handler
+	// Operations are the operations of the endpoint by method.
+	Operations map[string]*apiOperation
+}
+
+// apiOperation documents an operation of the REST API.
+type apiOperation struct {
+	Summary    string
+	Parameters []apiParameter
+	// Body is a value of the type of the JSON request body, nil if none.
+	Body interface{}
+	// Response is a value of the type of the JSON response, or a oneOf of
+	// the types of the responses, nil if the response is not JSON.
+	Response interface{}
+	// ContentType is the type of the response if it is not JSON, or of its
+	// alternative to JSON.
+	ContentType string
+	// Auth is true if the operation requires a bearer token from
+	// --api-tokens-file, optional if the operation works without one.
+	// The authenticated requests of both are recorded in the audit log.
+	Auth, OptionalAuth bool
+}
+
+// apiParameter is a query parameter of an operation.
+type apiParameter struct {
+	Name        string
+	Description string
+	Required    bool
+	// Repeated is true if the parameter can be given several times.
+	Repeated bool
+	Enum     []string
+}
+
+var (
+	jobParameter = apiParameter{Name: "job", Description: "The name of the job.", Required: true}
+	// seriesFilterParameters are the parameters of parseSeriesFilter.
+	seriesFilterParameters = []apiParameter{
+		{Name: "test", Description: "Only the series of the test."},
+		{Name: "node", Description: "Only the series of the node."},
+		{Name: "bucket", Description: "Only the series of the bucket, e.g. Perc99."},
+		{Name: "owner", Description: "Only the series of the tests owned by the team."},
+		{Name: "metric", Description: "Only the series with the label, as <key>=<value>.", Repeated: true},
+	}
+)
+
+// oneOf is the response of the operations returning one of the values of
+// the types depending on their parameters.
+type oneOf []interface{}
+
+// parameters joins lists of parameters.
+func parameters(lists ...[]apiParameter) []apiParameter {
+	var result []apiParameter
+	for _, list := range lists {
+		result = append(result, list...)
+	}
+	return result
+}
+
+// apiEndpoints returns the endpoints of the REST API, fetching the artifacts
+// from the downloader.
+func apiEndpoints(downloader Downloader) []apiEndpoint {
+	golden := &goldenHandler{source: downloader}
+	return []apiEndpoint{
+		{Path: "/version", Handler: http.HandlerFunc(serveVersion), Operations: map[string]*apiOperation{
+			http.MethodGet: {Summary: "Get the build information of the server.", Response: BuildInfo{}},
+		}},
+		{Path: "/api/jobs", Handler: http.HandlerFunc(serveJobs), Operations: map[string]*apiOperation{
+			http.MethodGet: {
+				Summary:    "List the configured jobs.",
+				Parameters: []apiParameter{{Name: "label", Description: "Only the jobs with the label, as <key>=<value>.", Repeated: true}},
+				Response:   []JobDetails{},
+			},
+		}},
+		{Path: "/api/commits", Handler: http.HandlerFunc(serveCommits), Operations: map[string]*apiOperation{
+			http.MethodGet: {
+				Summary: "Get the commits tested by a build and not by the previous one.",
+				Parameters: []apiParameter{
+					jobParameter,
+					{Name: "build", Description: "The build number.", Required: true},
+					{Name: "base", Description: "The build compared with, the previous build with a known version by default."},
+					{Name: "titles", Description: "Whether to fetch the titles of the commits from GitHub.", Enum: []string{"true", "false"}},
+				},
+				Response: CommitRange{},
+			},
+		}},
+		{Path: "/api/series", Handler: http.HandlerFunc(serveSeries), Operations: map[string]*apiOperation{
+			http.MethodGet: {
+				Summary: "Get the time series of the metrics of a job.",
+				Parameters: parameters([]apiParameter{jobParameter}, seriesFilterParameters, []apiParameter{
+					{Name: "aggregate", Description: "Downsamples the series to one point per period.", Enum: []string{"daily", "weekly"}},
+					{Name: "fn", Description: "The aggregation function, mean by default.", Enum: []string{"mean", "min", "max", "p50", "p90", "p99"}},
+				}),
+				Response: SeriesResponse{},
+			},
+		}},
+		{Path: "/api/regressions", Handler: http.HandlerFunc(serveRegressions), Operations: map[string]*apiOperation{
+			http.MethodGet: {
+				Summary: "Get the regressions of the latest builds.",
+				Parameters: parameters([]apiParameter{
+					{Name: "job", Description: "The name of the job, all the jobs if empty."},
+					{Name: "label", Description: "Only the jobs with the label, as <key>=<value>, including the named job.", Repeated: true},
+				}, seriesFilterParameters),
+				Response: []Regression{},
+			},
+		}},
+		{Path: "/api/rollups", Handler: http.HandlerFunc(serveRollups), Operations: map[string]*apiOperation{
+			http.MethodGet: {
+				Summary:    "Get the rollups of the metrics of a job.",
+				Parameters: parameters([]apiParameter{jobParameter, {Name: "period", Description: "The period of the rollups.", Required: true, Enum: rollupPeriods}}, seriesFilterParameters),
+				Response:   []*RollupSeries{},
+			},
+		}},
+		{Path: "/api/unparsed", Handler: http.HandlerFunc(serveUnparsed), Operations: map[string]*apiOperation{
+			http.MethodGet: {Summary: "List the artifacts of a job which could not be parsed.", Parameters: []apiParameter{jobParameter}, Response: UnparsedBuilds{}},
+		}},
+		{Path: "/api/pulls", Handler: http.HandlerFunc(servePulls), Operations: map[string]*apiOperation{
+			http.MethodGet: {
+				Summary: "List the verdicts of the presubmit builds.",
+				Parameters: []apiParameter{
+					{Name: "job", Description: "The presubmit job, all the presubmit jobs if empty."},
+					{Name: "pull", Description: "Only the builds of the pull request number."},
+				},
+				Response: []*PullVerdict{},
+			},
+		}},
+		{Path: "/api/digest", Handler: http.HandlerFunc(serveDigest), Operations: map[string]*apiOperation{
+			http.MethodGet: {
+				Summary: "Preview the digest of a job for the period ending now.",
+				Parameters: []apiParameter{
+					jobParameter,
+					{Name: "period", Description: "The period of the digest, the configured one by default, e.g. 168h."},
+					{Name: "format", Description: "text for the body of the email instead of JSON.", Enum: []string{"text"}},
+				},
+				Response:    Digest{},
+				ContentType: "text/plain",
+			},
+		}},
+		{Path: "/api/variants", Handler: http.HandlerFunc(serveVariants), Operations: map[string]*apiOperation{
+			http.MethodGet: {
+				Summary:    "Compare the series of a variant with its control, or list the variants if the name is empty.",
+				Parameters: parameters([]apiParameter{{Name: "name", Description: "The name of the variant."}}, seriesFilterParameters),
+				Response:   oneOf{VariantComparison{}, []*VariantConfig{}},
+			},
+		}},
+		{Path: "/api/annotations", Handler: http.HandlerFunc(serveAnnotations), Operations: map[string]*apiOperation{
+			http.MethodGet: {
+				Summary:    "List the annotations of a job.",
+				Parameters: []apiParameter{jobParameter, {Name: "build", Description: "Only the annotations of the build."}},
+				Response:   []*Annotation{},
+			},
+			http.MethodPost: {Summary: "Annotate a job.", Parameters: []apiParameter{jobParameter}, Body: Annotation{}, Response: Annotation{}, Auth: true},
+			http.MethodDelete: {
+				Summary:    "Remove an annotation, returning the remaining ones.",
+				Parameters: []apiParameter{jobParameter, {Name: "id", Description: "The ID of the annotation.", Required: true}},
+				Response:   []*Annotation{},
+				Auth:       true,
+			},
+		}},
+		{Path: "/api/views", Handler: http.HandlerFunc(serveViews), Operations: map[string]*apiOperation{
+			http.MethodGet: {
+				Summary:      "List the views visible to the user, or get the view of the name.",
+				Parameters:   []apiParameter{{Name: "name", Description: "The name of the view."}},
+				Response:     oneOf{[]*View{}, View{}},
+				OptionalAuth: true,
+			},
+			http.MethodPut: {
+				Summary: "Save a view.",
+				Parameters: []apiParameter{
+					{Name: "name", Description: "The name of the view.", Required: true},
+					{Name: "scope", Description: "The owner of the view, the user by default.", Enum: []string{"user", "global"}},
+				},
+				Body:     View{},
+				Response: View{},
+				Auth:     true,
+			},
+			http.MethodDelete: {
+				Summary: "Remove a view, returning the remaining visible ones.",
+				Parameters: []apiParameter{
+					{Name: "name", Description: "The name of the view.", Required: true},
+					{Name: "scope", Description: "The owner of the view, the user by default.", Enum: []string{"user", "global"}},
+				},
+				Response: []*View{},
+				Auth:     true,
+			},
+		}},
+		{Path: "/api/preferences", Handler: http.HandlerFunc(servePreferences), Operations: map[string]*apiOperation{
+			http.MethodGet: {Summary: "Get the preferences of the user.", Response: Preferences{}, Auth: true},
+			http.MethodPut: {Summary: "Save the preferences of the user.", Body: Preferences{}, Response: Preferences{}, Auth: true},
+		}},
+		{Path: "/api/audit", Handler: http.HandlerFunc(serveAudit), Operations: map[string]*apiOperation{
+			http.MethodGet: {
+				Summary: "List the latest entries of the audit log, newest first, for the users of --admin-users.",
+				Parameters: []apiParameter{
+					{Name: "user", Description: "Only the requests of the user."},
+					{Name: "path", Description: "Only the requests to the path, e.g. /api/golden."},
+					{Name: "since", Description: "Only the requests at or after the RFC 3339 time."},
+					{Name: "until", Description: "Only the requests before the RFC 3339 time."},
+					{Name: "limit", Description: "The maximum number of entries, 100 by default and at most 1000."},
+				},
+				Response: []*AuditEntry{},
+				Auth:     true,
+			},
+		}},
+		{Path: "/api/artifact", Handler: &artifactProxy{source: downloader}, Operations: map[string]*apiOperation{
+			http.MethodGet: {
+				Summary: "Get an artifact of a build.",
+				Parameters: []apiParameter{
+					jobParameter,
+					{Name: "build", Description: "The build number.", Required: true},
+					{Name: "path", Description: "The path of the artifact in the build, e.g. artifacts/performance.json.", Required: true},
+				},
+				ContentType: "application/octet-stream",
+			},
+		}},
+		{Path: "/api/golden", Handler: http.HandlerFunc(golden.serveGolden), Operations: map[string]*apiOperation{
+			http.MethodGet: {Summary: "List the golden baselines of a job.", Parameters: []apiParameter{jobParameter}, Response: []GoldenBaseline{}},
+			http.MethodPost: {
+				Summary: "Mark a build as the golden baseline of a job.",
+				Parameters: []apiParameter{
+					jobParameter,
+					{Name: "build", Description: "The build number.", Required: true},
+					{Name: "note", Description: "Why the build is the baseline."},
+				},
+				Response: GoldenBaseline{},
+				Auth:     true,
+			},
+			http.MethodDelete: {
+				Summary:    "Unmark a golden baseline, returning the remaining ones.",
+				Parameters: []apiParameter{jobParameter, {Name: "build", Description: "The build number.", Required: true}},
+				Response:   []GoldenBaseline{},
+				Auth:       true,
+			},
+		}},
+		{Path: "/grafana/", Handler: http.HandlerFunc(serveGrafana), Operations: map[string]*apiOperation{
+			http.MethodGet: {Summary: "Test the Grafana SimpleJSON datasource.", ContentType: "text/plain"},
+		}},
+		{Path: "/grafana/search", Handler: http.HandlerFunc(serveGrafanaSearch), Operations: map[string]*apiOperation{
+			http.MethodPost: {Summary: "List the Grafana targets of the series.", Body: GrafanaSearchRequest{}, Response: []string{}},
+		}},
+		{Path: "/grafana/query", Handler: http.HandlerFunc(serveGrafanaQuery), Operations: map[string]*apiOperation{
+			http.MethodPost: {Summary: "Get the datapoints of the Grafana targets.", Body: GrafanaQueryRequest{}, Response: []GrafanaTimeSeries{}},
+		}},
+		{Path: "/grafana/annotations", Handler: http.HandlerFunc(serveGrafanaAnnotations), Operations: map[string]*apiOperation{
+			http.MethodPost: {Summary: "Get the annotations of the series of a Grafana target.", Body: GrafanaAnnotationRequest{}, Response: []GrafanaAnnotation{}},
+		}},
+		{Path: "/api/compare", Handler: http.HandlerFunc(golden.serveCompare), Operations: map[string]*apiOperation{
+			http.MethodGet: {
+				Summary:      "Compare a build with the golden baseline of its job.",
+				Parameters:   parameters([]apiParameter{jobParameter, {Name: "build", Description: "The build number, the latest build by default. A build out of the --builds window requires authentication."}}, seriesFilterParameters),
+				Response:     Comparison{},
+				OptionalAuth: true,
+			},
+		}},
+	}
+}
+
+// openAPIDocument returns the OpenAPI 3 document of the endpoints.
+func openAPIDocument(endpoints []apiEndpoint) map[string]interface{} {
+	schemas := map[string]interface{}{}
+	paths := map[string]interface{}{}
+	for _, endpoint := range endpoints {
+		item := map[string]interface{}{}
+		for method, operation := range endpoint.Operations {
+			item[strings.ToLower(method)] = operation.document(schemas)
+		}
+		paths[endpoint.Path] = item
+	}
+	paths["/api/openapi.json"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":   "Get this OpenAPI document.",
+			"responses": map[string]interface{}{"200": map[string]interface{}{"description": "The OpenAPI document.", "content": map[string]interface{}{"application/json": map[string]interface{}{}}}},
+		},
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "node-perf-dash",
+			"description": "The API of the Node Performance Dashboard.",
+			"version":     buildInfo().Version,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"responses": map[string]interface{}{
+				"Error": map[string]interface{}{
+					"description": "The error, as an HTML fragment.",
+					"content":     map[string]interface{}{"text/html": map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}},
+				},
+			},
+			"securitySchemes": map[string]interface{}{
+				"bearer": map[string]interface{}{"type": "http", "scheme": "bearer", "description": "A token of --api-tokens-file."},
+			},
+		},
+	}
+}
+
+func (o *apiOperation) document(schemas map[string]interface{}) map[string]interface{} {
+	responses := map[string]interface{}{
+		"default": map[string]interface{}{"$ref": "#/components/responses/Error"},
+	}
+	content := map[string]interface{}{}
+	ok := map[string]interface{}{"description": "OK", "content": content}
+	if o.Response != nil {
+		schema := map[string]interface{}{}
+		if alternatives, isOneOf := o.Response.(oneOf); isOneOf {
+			var schemaList []interface{}
+			for _, alternative := range alternatives {
+				schemaList = append(schemaList, jsonSchema(reflect.TypeOf(alternative), schemas))
+			}
+			schema["oneOf"] = schemaList
+		} else {
+			schema = jsonSchema(reflect.TypeOf(o.Response), schemas)
+		}
+		content["application/json"] = map[string]interface{}{"schema": schema}
+		ok["headers"] = map[string]interface{}{"ETag": map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}}
+		responses["304"] = map[string]interface{}{"description": "Not modified since the ETag of If-None-Match."}
+	}
+	if o.ContentType != "" {
+		content[o.ContentType] = map[string]interface{}{"schema": map[string]interface{}{"type": "string", "format": "binary"}}
+	}
+	responses["200"] = ok
+	document := map[string]interface{}{"summary": o.Summary, "responses": responses}
+	var params []interface{}
+	for _, p := range o.Parameters {
+		schema := map[string]interface{}{"type": "string"}
+		if len(p.Enum) > 0 {
+			schema["enum"] = p.Enum
+		}
+		if p.Repeated {
+			schema = map[string]interface{}{"type": "array", "items": schema}
+		}
+		param := map[string]interface{}{"name": p.Name, "in": "query", "description": p.Description, "schema": schema}
+		if p.Required {
+			param["required"] = true
+		}
+		if p.Repeated {
+			param["style"], param["explode"] = "form", true
+		}
+		params = append(params, param)
+	}
+	if len(params) > 0 {
+		document["parameters"] = params
+	}
+	if o.Body != nil {
+		document["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content":  map[string]interface{}{"application/json": map[string]interface{}{"schema": jsonSchema(reflect.TypeOf(o.Body), schemas)}},
+		}
+	}
+	switch {
+	case o.Auth:
+		document["security"] = []interface{}{map[string]interface{}{"bearer": []string{}}}
+	case o.OptionalAuth:
+		document["security"] = []interface{}{map[string]interface{}{}, map[string]interface{}{"bearer": []string{}}}
+	}
+	return document
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	durationType   = reflect.TypeOf(Duration{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// jsonSchema returns the schema of the JSON encoding of the type. The named
+// structs are added to the schemas and referenced.
+func jsonSchema(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case durationType:
+		return map[string]interface{}{"type": "string", "description": "A duration, e.g. 1h30m."}
+	case rawMessageType:
+		return map[string]interface{}{}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return jsonSchema(t.Elem(), schemas)
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		// The nil slices and maps are encoded as null.
+		return map[string]interface{}{"type": "array", "nullable": true, "items": jsonSchema(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "nullable": true, "additionalProperties": jsonSchema(t.Elem(), schemas)}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return structSchema(t, schemas)
+		}
+		name := t.Name()
+		if _, ok := schemas[name]; !ok {
+			// The placeholder stops the recursion of the recursive types.
+			schemas[name] = nil
+			schemas[name] = structSchema(t, schemas)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+	// Any JSON value, e.g. for the interfaces.
+	return map[string]interface{}{}
+}
+
+// structSchema returns the schema of a struct, with the fields encoded by
+// encoding/json.
+func structSchema(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if field.PkgPath != "" && !field.Anonymous || tag == "-" {
+			continue
+		}
+		name, options := tag, ""
+		if i := strings.Index(tag, ","); i >= 0 {
+			name, options = tag[:i], tag[i:]
+		}
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				inlined := structSchema(embedded, schemas)
+				for key, value := range inlined["properties"].(map[string]interface{}) {
+					properties[key] = value
+				}
+				if fields, ok := inlined["required"].([]string); ok {
+					required = append(required, fields...)
+				}
+				continue
+			}
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = jsonSchema(field.Type, schemas)
+		if !strings.Contains(options, "omitempty") {
+			required = append(required, name)
+		}
+	}
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		sort.Strings(required)
+		schema["required"] = required
+	}
+	return schema
+}
+
+// serveOpenAPI returns the handler of /api/openapi.json, serving the OpenAPI
+// document of the endpoints.
+func serveOpenAPI(endpoints []apiEndpoint) http.HandlerFunc {
+	document := openAPIDocument(endpoints)
+	return func(res http.ResponseWriter, req *http.Request) {
+		writeJSON(res, req, document)
+	}
+}
@@ -0,0 +1,250 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// getOpenAPIDocument gets the OpenAPI document served by the mux.
+func getOpenAPIDocument(t *testing.T, mux *http.ServeMux) map[string]interface{} {
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, httptest.NewRequest("GET", "/api/openapi.json", nil))
+	var document map[string]interface{}
+	if res.Code != http.StatusOK || json.Unmarshal(res.Body.Bytes(), &document) != nil {
+		t.Fatalf("expected the OpenAPI document but got %d: %s", res.Code, res.Body.String())
+	}
+	return document
+}
+
+// resolve returns the schema referenced by the $ref of the schema, if any.
+func resolve(document, schema map[string]interface{}) map[string]interface{} {
+	ref, ok := schema["$ref"].(string)
+	if !ok {
+		return schema
+	}
+	var v interface{} = document
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		m, _ := v.(map[string]interface{})
+		v = m[part]
+	}
+	resolved, _ := v.(map[string]interface{})
+	return resolved
+}
+
+// checkSchema returns the mismatches of the JSON value with the schema of the
+// document. The null values are accepted for the arrays, the objects and the
+// references, which may be nil pointers.
+func checkSchema(document, schema map[string]interface{}, value interface{}, path string) []string {
+	if _, isRef := schema["$ref"]; isRef {
+		if schema = resolve(document, schema); schema == nil {
+			return []string{path + ": unresolved reference"}
+		}
+		if value == nil {
+			return nil
+		}
+	}
+	if alternatives, ok := schema["oneOf"].([]interface{}); ok {
+		for _, alternative := range alternatives {
+			if len(checkSchema(document, alternative.(map[string]interface{}), value, path)) == 0 {
+				return nil
+			}
+		}
+		return []string{fmt.Sprintf("%s: %v matches none of the alternatives", path, value)}
+	}
+	switch schema["type"] {
+	case "object":
+		if value == nil {
+			return nil
+		}
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: expected an object but got %v", path, value)}
+		}
+		var errs []string
+		required, _ := schema["required"].([]interface{})
+		for _, name := range required {
+			if _, ok := object[name.(string)]; !ok {
+				errs = append(errs, fmt.Sprintf("%s: missing %s", path, name))
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		for name, field := range object {
+			fieldSchema, ok := properties[name].(map[string]interface{})
+			if !ok {
+				fieldSchema, ok = schema["additionalProperties"].(map[string]interface{})
+			}
+			if !ok {
+				errs = append(errs, fmt.Sprintf("%s: undocumented field %s", path, name))
+				continue
+			}
+			errs = append(errs, checkSchema(document, fieldSchema, field, path+"."+name)...)
+		}
+		return errs
+	case "array":
+		if value == nil {
+			return nil
+		}
+		items, ok := value.([]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: expected an array but got %v", path, value)}
+		}
+		var errs []string
+		for i, item := range items {
+			errs = append(errs, checkSchema(document, schema["items"].(map[string]interface{}), item, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+		return errs
+	case "string":
+		if _, ok := value.(string); !ok {
+			return []string{fmt.Sprintf("%s: expected a string but got %v", path, value)}
+		}
+	case "integer", "number":
+		if n, ok := value.(float64); !ok || (schema["type"] == "integer" && n != float64(int64(n))) {
+			return []string{fmt.Sprintf("%s: expected an %s but got %v", path, schema["type"], value)}
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return []string{fmt.Sprintf("%s: expected a boolean but got %v", path, value)}
+		}
+	}
+	return nil
+}
+
+// checkContract checks the JSON body of the response to the GET of the path
+// against the OpenAPI document.
+func checkContract(t *testing.T, document map[string]interface{}, path string, body []byte) {
+	route := strings.SplitN(path, "?", 2)[0]
+	paths := document["paths"].(map[string]interface{})
+	operation, ok := paths[route].(map[string]interface{})["get"].(map[string]interface{})
+	if !ok {
+		t.Errorf("%s: not documented", route)
+		return
+	}
+	responses := operation["responses"].(map[string]interface{})
+	content := responses["200"].(map[string]interface{})["content"].(map[string]interface{})
+	schema := content["application/json"].(map[string]interface{})["schema"].(map[string]interface{})
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		t.Errorf("%s: invalid JSON: %v", path, err)
+		return
+	}
+	for _, err := range checkSchema(document, schema, value, "response") {
+		t.Errorf("%s: %s", path, err)
+	}
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	endpoints := apiEndpoints(nil)
+	mux := newMux(JobList{}, nil)
+	document := getOpenAPIDocument(t, mux)
+	if document["openapi"] != "3.0.3" {
+		t.Errorf("expected an OpenAPI 3.0.3 document but got %v", document["openapi"])
+	}
+
+	// The documented operations are the routed endpoints.
+	paths := document["paths"].(map[string]interface{})
+	for _, endpoint := range endpoints {
+		if _, pattern := mux.Handler(httptest.NewRequest("GET", endpoint.Path, nil)); pattern != endpoint.Path {
+			t.Errorf("%s: expected to be routed but got the pattern %q", endpoint.Path, pattern)
+		}
+		var methods, documented []string
+		for method := range endpoint.Operations {
+			methods = append(methods, strings.ToLower(method))
+		}
+		for method := range paths[endpoint.Path].(map[string]interface{}) {
+			documented = append(documented, method)
+		}
+		sort.Strings(methods)
+		sort.Strings(documented)
+		if strings.Join(methods, ",") != strings.Join(documented, ",") {
+			t.Errorf("%s: expected the methods %v but got %v", endpoint.Path, methods, documented)
+		}
+	}
+
+	// All the references resolve.
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if ref, ok := v["$ref"]; ok && resolve(document, v) == nil {
+				t.Errorf("unresolved reference %v", ref)
+			}
+			for _, child := range v {
+				walk(child)
+			}
+		case []interface{}:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+	walk(document)
+}
+
+type schemaTestBase struct {
+	ID string `json:"id"`
+}
+
+type schemaTestType struct {
+	schemaTestBase
+	Name     string `json:"name"`
+	Note     string `json:"note,omitempty"`
+	Internal string `json:"-"`
+	private  string
+	Created  time.Time          `json:"created"`
+	Timeout  Duration           `json:"timeout"`
+	Values   map[string]float64 `json:"values"`
+	Children []*schemaTestType  `json:"children,omitempty"`
+	Data     interface{}        `json:"data"`
+	Untagged bool
+}
+
+func TestJSONSchema(t *testing.T) {
+	schemas := map[string]interface{}{}
+	if ref := jsonSchema(reflect.TypeOf(&schemaTestType{}), schemas); ref["$ref"] != "#/components/schemas/schemaTestType" {
+		t.Errorf("expected a reference to the schema but got %v", ref)
+	}
+	content, _ := json.Marshal(schemas["schemaTestType"])
+	expected := `{"properties":{"Untagged":{"type":"boolean"},"children":{"items":{"$ref":"#/components/schemas/schemaTestType"},"nullable":true,"type":"array"},"created":{"format":"date-time","type":"string"},"data":{},"id":{"type":"string"},"name":{"type":"string"},"note":{"type":"string"},"timeout":{"description":"A duration, e.g. 1h30m.","type":"string"},"values":{"additionalProperties":{"type":"number"},"nullable":true,"type":"object"}},"required":["Untagged","created","data","id","name","timeout","values"],"type":"object"}`
+	if string(content) != expected {
+		t.Errorf("expected the schema\n%s\nbut got\n%s", expected, content)
+	}
+
+	// The encoding of a value matches its schema.
+	value := &schemaTestType{schemaTestBase: schemaTestBase{ID: "1"}, Name: "a", Timeout: Duration{time.Minute}, Children: []*schemaTestType{{Name: "b"}}}
+	encoded, _ := json.Marshal(value)
+	document := map[string]interface{}{}
+	content, _ = json.Marshal(map[string]interface{}{"components": map[string]interface{}{"schemas": schemas}})
+	json.Unmarshal(content, &document)
+	var decoded interface{}
+	json.Unmarshal(encoded, &decoded)
+	if errs := checkSchema(document, map[string]interface{}{"$ref": "#/components/schemas/schemaTestType"}, decoded, "value"); len(errs) > 0 {
+		t.Errorf("expected %s to match its schema but got %v", encoded, errs)
+	}
+	decoded.(map[string]interface{})["name"] = 3
+	if errs := checkSchema(document, map[string]interface{}{"$ref": "#/components/schemas/schemaTestType"}, decoded, "value"); len(errs) != 1 {
+		t.Errorf("expected 1 mismatch of the name but got %v", errs)
+	}
+}
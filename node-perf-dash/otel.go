@@ -0,0 +1,186 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	otelExporter    = flag.String("otel-exporter", "none", "Where to export the OpenTelemetry spans of the data collection. Options include 'none', 'stdout' and 'otlp'")
+	otelEndpoint    = flag.String("otel-endpoint", "", "The host:port of the OTLP/HTTP collector for --otel-exporter=otlp. It defaults to the standard OTEL_EXPORTER_OTLP_* environment variables or localhost:4318")
+	otelInsecure    = flag.Bool("otel-insecure", false, "If true, export the spans to the OTLP collector over plain HTTP")
+	otelSampleRatio = flag.Float64("otel-sample-ratio", 1, "The fraction of the data collection traces which are sampled")
+)
+
+// tracer creates the spans of the fetch and parse pipeline. It does nothing
+// until initTracing installs an exporter.
+var tracer = otel.Tracer("k8s.io/contrib/node-perf-dash")
+
+// initTracing installs the exporter configured by --otel-exporter. It returns
+// a function flushing the pending spans, to call before exiting.
+func initTracing(ctx context.Context) (func(context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch *otelExporter {
+	case "none":
+		return func(context.Context) error { return nil }, nil
+	case "stdout":
+		exporter, err = stdouttrace.New()
+	case "otlp":
+		var opts []otlptracehttp.Option
+		if *otelEndpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(*otelEndpoint))
+		}
+		if *otelInsecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("unsupported OpenTelemetry exporter %q", *otelExporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the %s exporter: %v", *otelExporter, err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attribute.String("service.name", "node-perf-dash"), attribute.String("service.version", buildInfo().Version)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the OpenTelemetry resource: %v", err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(*otelSampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	mainLog.Info("Exporting OpenTelemetry spans", "exporter", *otelExporter, "sampleRatio", *otelSampleRatio)
+	return provider.Shutdown, nil
+}
+
+// endSpan records err, if any, in the span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// tracedDownloader wraps a Downloader to record a span for each of its calls,
+// as a child of the span in ctx.
+type tracedDownloader struct {
+	ctx context.Context
+	Downloader
+}
+
+// withTracing returns source recording its calls as children of the span in
+// ctx.
+func withTracing(ctx context.Context, source Downloader) Downloader {
+	if traced, ok := source.(*tracedDownloader); ok {
+		source = traced.Downloader
+	}
+	return &tracedDownloader{ctx: ctx, Downloader: source}
+}
+
+// GetLastestBuildNumber returns the latest build number.
+func (d *tracedDownloader) GetLastestBuildNumber(job string) (int, error) {
+	_, span := tracer.Start(d.ctx, "GetLastestBuildNumber", trace.WithAttributes(attribute.String("job", job)))
+	build, err := d.Downloader.GetLastestBuildNumber(job)
+	span.SetAttributes(attribute.Int("build", build))
+	endSpan(span, err)
+	return build, err
+}
+
+// ListBuilds returns the builds of the job, if the data source can list them.
+func (d *tracedDownloader) ListBuilds(job string) ([]int, error) {
+	lister, ok := d.Downloader.(BuildLister)
+	if !ok {
+		return nil, fmt.Errorf("the %s data source can not list builds", *datasource)
+	}
+	_, span := tracer.Start(d.ctx, "ListBuilds", trace.WithAttributes(attribute.String("job", job)))
+	builds, err := lister.ListBuilds(job)
+	span.SetAttributes(attribute.Int("builds", len(builds)))
+	endSpan(span, err)
+	return builds, err
+}
+
+// ListFilesInBuild returns the files with the specified prefix for the test
+// job at the given buildNumber.
+func (d *tracedDownloader) ListFilesInBuild(job string, buildNumber int, prefix string) ([]string, error) {
+	_, span := tracer.Start(d.ctx, "ListFilesInBuild", trace.WithAttributes(
+		attribute.String("job", job),
+		attribute.Int("build", buildNumber),
+		attribute.String("prefix", prefix),
+	))
+	files, err := d.Downloader.ListFilesInBuild(job, buildNumber, prefix)
+	span.SetAttributes(attribute.Int("files", len(files)))
+	endSpan(span, err)
+	return files, err
+}
+
+// GetFile returns readcloser of the desired file. The span lasts until the
+// file is closed, so that it covers the download.
+func (d *tracedDownloader) GetFile(job string, buildNumber int, filePath string) (io.ReadCloser, error) {
+	_, span := tracer.Start(d.ctx, "GetFile", trace.WithAttributes(
+		attribute.String("job", job),
+		attribute.Int("build", buildNumber),
+		attribute.String("path", filePath),
+	))
+	body, err := d.Downloader.GetFile(job, buildNumber, filePath)
+	if err != nil {
+		endSpan(span, err)
+		return nil, err
+	}
+	return &tracedBody{ReadCloser: body, span: span}, nil
+}
+
+// tracedBody ends the span of a file download when it is closed, recording the
+// number of bytes read.
+type tracedBody struct {
+	io.ReadCloser
+	span  trace.Span
+	bytes int64
+	err   error
+}
+
+func (b *tracedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.bytes += int64(n)
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+	return n, err
+}
+
+func (b *tracedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.span.SetAttributes(attribute.Int64("bytes", b.bytes))
+	endSpan(b.span, b.err)
+	return err
+}
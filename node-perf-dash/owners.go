@@ -0,0 +1,93 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// sigRegexp extracts the owning SIG from a test name or description following
+// the e2e conventions, e.g. "[sig-node] Density [Benchmark]".
+var sigRegexp = regexp.MustCompile(`\[(sig-[a-z0-9-]+)\]`)
+
+// OwnerConfig assigns an owner to the metrics of some tests.
+type OwnerConfig struct {
+	// Owner is the SIG or team owning the metrics, e.g. "sig-node".
+	Owner string `json:"owner"`
+	// Tests is a regular expression matching the tests owned. It matches
+	// all tests if it is empty.
+	Tests string `json:"tests,omitempty"`
+	// Metric selects the metrics owned by their labels. It matches all
+	// metrics if it is empty.
+	Metric map[string]string `json:"metric,omitempty"`
+
+	// testsRegexp is the compiled form of Tests.
+	testsRegexp *regexp.Regexp
+}
+
+// validate checks the owner configuration and prepares it for use.
+func (o *OwnerConfig) validate() []error {
+	var errs []error
+	if o.Owner == "" {
+		errs = append(errs, fmt.Errorf("owners: owner must not be empty"))
+	}
+	if o.Tests != "" {
+		re, err := regexp.Compile(o.Tests)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("owner %q: invalid tests regular expression %q: %v", o.Owner, o.Tests, err))
+		}
+		o.testsRegexp = re
+	}
+	return errs
+}
+
+// Matches returns true if the metric of the test is owned.
+func (o *OwnerConfig) Matches(test string, labels map[string]string) bool {
+	if o.testsRegexp != nil && !o.testsRegexp.MatchString(test) {
+		return false
+	}
+	return labelSelector(o.Metric).Matches(labels)
+}
+
+// ownerOf returns the owner of the metric of the test: the first configured
+// owner matching it, or else the SIG named in the test name or description. It
+// returns an empty string if the owner is unknown. It must not be called with
+// dataLock held.
+func ownerOf(test string, labels map[string]string) string {
+	dataLock.RLock()
+	defer dataLock.RUnlock()
+	return lockedOwnerOf(test, labels)
+}
+
+// lockedOwnerOf is ownerOf for the callers holding dataLock.
+func lockedOwnerOf(test string, labels map[string]string) string {
+	if config != nil {
+		for _, owner := range config.Owners {
+			if owner.Matches(test, labels) {
+				return owner.Owner
+			}
+		}
+	}
+	if match := sigRegexp.FindStringSubmatch(test); match != nil {
+		return match[1]
+	}
+	if match := sigRegexp.FindStringSubmatch(allTestInfo.Info[test]); match != nil {
+		return match[1]
+	}
+	return ""
+}
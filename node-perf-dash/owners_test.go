@@ -0,0 +1,66 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+)
+
+func TestOwnerOf(t *testing.T) {
+	defer func(c *Config) { config = c }(config)
+	config = &Config{Owners: []*OwnerConfig{
+		{Owner: "sig-scalability", Tests: "^density_", Metric: map[string]string{"datatype": "throughput"}},
+		{Owner: "node-team", Tests: "^resource_"},
+	}}
+	for _, owner := range config.Owners {
+		if errs := owner.validate(); len(errs) > 0 {
+			t.Fatalf("Unexpected errors %v", errs)
+		}
+	}
+	dataLock.Lock()
+	allTestInfo.Info["density_create"] = "create pods [sig-node] [Benchmark]"
+	dataLock.Unlock()
+	defer func() {
+		dataLock.Lock()
+		delete(allTestInfo.Info, "density_create")
+		dataLock.Unlock()
+	}()
+
+	table := []struct {
+		test   string
+		labels map[string]string
+		expect string
+	}{
+		{test: "density_create", labels: map[string]string{"datatype": "throughput"}, expect: "sig-scalability"},
+		{test: "density_create", labels: map[string]string{"datatype": "latency"}, expect: "sig-node"},
+		{test: "resource_0", labels: map[string]string{"datatype": "resource"}, expect: "node-team"},
+		{test: "[sig-storage] volumes", labels: nil, expect: "sig-storage"},
+		{test: "unknown", labels: nil, expect: ""},
+	}
+	for _, tt := range table {
+		if got := ownerOf(tt.test, tt.labels); got != tt.expect {
+			t.Errorf("%s %v: expected owner %q but got %q", tt.test, tt.labels, tt.expect, got)
+		}
+		// The rollups are filtered by the same owner.
+		dataLock.RLock()
+		matched := seriesFilter{Owner: "sig-node"}.matches(tt.test, "node", tt.labels, "Perc99")
+		dataLock.RUnlock()
+		if matched != (tt.expect == "sig-node") {
+			t.Errorf("%s %v: expected the owner filter to match %v but got %v", tt.test, tt.labels, tt.expect == "sig-node", matched)
+		}
+	}
+}
@@ -19,28 +19,41 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"math"
-	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"k8s.io/kubernetes/test/e2e/perftype"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	nodeperftype "k8s.io/kubernetes/test/e2e_node/perftype"
 )
 
+// gzipMagic is the magic number starting gzip compressed data.
+var gzipMagic = []byte{0x1f, 0x8b}
+
 // supportedMetricVersion is the metric version supported in node-perf-dash.
-// node-perf-dash will only parse the metrics with this exact version -- any
-// older or newer versions will be ignored.
+// node-perf-dash will only parse the metrics with this exact version -- the
+// artifacts of older or newer versions are reported as unparsed.
 const supportedMetricVersion = "v2"
 
 var (
+	// dataLock protects allTestData, allTestInfo, buildFIFOs,
+	// buildCache and allGrabbedLastBuild, which are read by the web server while being
+	// updated by the data collection goroutines.
+	dataLock sync.RWMutex
+
 	// buildFIFOs is a map from (job, test, node) to a list of build
-	// numbers. It is used to find and the oldest build for a
-	// (job, test, node).
+	// numbers sorted in ascending order. It is used to find the oldest
+	// build for a (job, test, node).
 	buildFIFOs = map[string][]string{}
 
 	// allGrabbedLastBuild stores the last build grabbed for each job.
@@ -48,197 +61,327 @@ var (
 
 	// nodeNameCache stores formatted node names, looked up by host name
 	// (the machine which runs the test).
-	nodeNameCache = map[string]string{}
+	nodeNameCache     = map[string]string{}
+	nodeNameCacheLock sync.Mutex
 )
 
 // Parse fetches data from the source and populates allTestData and testInfo
-// for the given test job.
-func Parse(allTestData map[string]TestToBuildData, testInfo *TestInfo, job string, source Downloader) error {
-	fmt.Printf("Getting Data from %s... (Job: %s)\n", *datasource, job)
+// for the given test job. It stops between two builds if ctx is cancelled.
+func Parse(ctx context.Context, allTestData map[string]TestToBuildData, testInfo *TestInfo, job string, source Downloader) (err error) {
+	parserLog.Info("Getting data", "datasource", *datasource, "job", job)
+	ctx, span := tracer.Start(ctx, "Parse", trace.WithAttributes(attribute.String("job", job)))
+	defer func() { endSpan(span, err) }()
 
+	dataLock.RLock()
 	grabbedLastBuild := allGrabbedLastBuild[job]
+	dataLock.RUnlock()
 
-	lastBuildNumber, err := source.GetLastestBuildNumber(job)
+	lastBuildNumber, err := discoverLatestBuild(withTracing(ctx, source), job, grabbedLastBuild)
 	if err != nil {
-		return fmt.Errorf("failed to get the lastest build number for job %q", job)
+		return fmt.Errorf("failed to get the lastest build number for job %q: %v", job, err)
 	}
-	fmt.Printf("Last build no: %v (Job: %s)\n", lastBuildNumber, job)
+	parserLog.Info("Found the last build", "build", lastBuildNumber, "job", job)
 
 	startBuildNumber := int(math.Max(math.Max(float64(lastBuildNumber-*builds), 0), float64(grabbedLastBuild))) + 1
+	skip := beginScan(job, lastBuildNumber)
 	for buildNumber := lastBuildNumber; buildNumber >= startBuildNumber; buildNumber-- {
-		fmt.Printf("Fetching build %v... (Job: %s)\n", buildNumber, job)
-		if err := populateDataForOneBuild(allTestData[job], testInfo, job, buildNumber, source); err != nil {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if skip[buildNumber] {
+			parserLog.Debug("Skipping the build already present in the store", "build", buildNumber, "job", job)
+			continue
+		}
+		parserLog.Debug("Fetching build", "build", buildNumber, "job", job)
+		if err := setScanInProgress(job, buildNumber); err != nil {
+			parserLog.Warn("Failed to checkpoint the scan", "build", buildNumber, "job", job, "err", err)
+		}
+		// The build is parsed into its own data, so that the web server
+		// never sees a partially parsed build.
+		buildData := TestToBuildData{}
+		buildInfo := TestInfo{Info: map[string]string{}}
+		buildCtx, buildSpan := tracer.Start(ctx, "ParseBuild", trace.WithAttributes(attribute.String("job", job), attribute.Int("build", buildNumber)))
+		err := populateDataForOneBuild(buildCtx, buildData, &buildInfo, job, buildNumber, source)
+		endSpan(buildSpan, err)
+		if err != nil {
 			return err
 		}
+		mergeBuildData(allTestData[job], testInfo, job, strconv.Itoa(buildNumber), buildData, &buildInfo)
+		buildsFetched.WithLabelValues(job).Inc()
+		if err := finishScanBuild(job, buildNumber); err != nil {
+			parserLog.Warn("Failed to checkpoint the scan", "build", buildNumber, "job", job, "err", err)
+		}
 	}
 
-	allGrabbedLastBuild[job] = lastBuildNumber
+	if err := finishScan(job, lastBuildNumber); err != nil {
+		parserLog.Warn("Failed to checkpoint the scan", "job", job, "err", err)
+	}
 	return nil
 }
 
-// getDataFromFiles returns the contents of the files having the prefix for the
-// test job at the given build. The files will be fetched using the specified
-// source.
+// mergeBuildData replaces the data of the given build in testData and updates
+// testInfo with the data parsed for the build.
+func mergeBuildData(testData TestToBuildData, testInfo *TestInfo, job, build string, buildData TestToBuildData, buildInfo *TestInfo) {
+	dataLock.Lock()
+	defer dataLock.Unlock()
+
+	for test, desc := range buildInfo.Info {
+		testInfo.Info[test] = desc
+	}
+	for test, dataPerTest := range buildData {
+		for node, dataPerNode := range dataPerTest.Data {
+			data, ok := dataPerNode[build]
+			if !ok {
+				continue
+			}
+			testData.GetDataPerBuild(job, build, test, node)
+			testData[test].Data[node][build] = data
+			removeStaledBuilds(testData, job, test, node, build)
+		}
+	}
+	markBuildDirty(job, build)
+	if len(snapshotBuild(testData, build)) > 0 {
+		buildCache.touch(buildRef{Job: job, Build: build})
+		buildCache.evictExcess()
+	}
+}
+
+// getArtifact returns the content of the artifact, relative to the artifacts
+// directory, of the test job at the given build. The artifact will be fetched
+// using the specified source.
 //
-// For example, getDataFromFiles("ci-kubernetes-node-kubelet-benchmark", "1234", "peformance", source)
-// returns the contents of all files with the prefix
-// "gs://kubernetes-jenkins/logs/ci-kubernetes-node-kubelet-benchmark/1234/artifacts/performance-*".
-func getDataFromFiles(job, build, prefix string, source Downloader) ([][]byte, error) {
-	buildNumber, err := strconv.Atoi(build)
+// For example, getArtifact("ci-kubernetes-node-kubelet-benchmark", 1234, "performance-node.json", source)
+// returns the content of
+// "gs://kubernetes-jenkins/logs/ci-kubernetes-node-kubelet-benchmark/1234/artifacts/performance-node.json".
+func getArtifact(job string, buildNumber int, artifact string, source Downloader) ([]byte, error) {
+	filename := artifactsDir + artifact
+	body, err := source.GetFile(job, buildNumber, filename)
 	if err != nil {
-		return nil, fmt.Errorf("failed to convert build number %q to an int: %v", build, err)
+		return nil, fmt.Errorf("failed to get %q: %v", filename, err)
 	}
-	prefix = "artifacts/" + prefix + "-"
-	filenames, err := source.ListFilesInBuild(job, buildNumber, prefix)
+	defer body.Close()
+	data, err := ioutil.ReadAll(body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list files with prefix %q: %v", prefix, err)
+		return nil, fmt.Errorf("failed to read %q: %v", filename, err)
 	}
-	var contents [][]byte
-	for _, filename := range filenames {
-		filename := "artifacts/" + filename[strings.LastIndex(filename, "/")+1:]
-		body, err := source.GetFile(job, buildNumber, filename)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get %q: %v", filename, err)
-		}
-		defer body.Close()
-		data, err := ioutil.ReadAll(body)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read %q: %v", filename, err)
-		}
-		contents = append(contents, data)
+	if data, err = decompressArtifact(data); err != nil {
+		return nil, fmt.Errorf("failed to decompress %q: %v", filename, err)
 	}
-	return contents, nil
+	return data, nil
+}
+
+// decompressArtifact returns the decompressed content of an artifact if it is
+// gzip compressed, and the content unchanged otherwise. The content is
+// detected by its magic number rather than by the ".gz" suffix, as the
+// artifacts uploaded with "Content-Encoding: gzip" keep their name and are
+// not always decompressed by the data source.
+func decompressArtifact(content []byte) ([]byte, error) {
+	if !bytes.HasPrefix(content, gzipMagic) {
+		return content, nil
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(reader, maxDecompressedBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxDecompressedBytes {
+		return nil, fmt.Errorf("the decompressed artifact exceeds %d bytes", maxDecompressedBytes)
+	}
+	return data, nil
 }
 
 // populateMetadata populates the test description in testInfo and the test end
 // timestamp in testTime using the information in the given labels.
+// It returns an error, leaving both unchanged, if the timestamp is invalid.
 func populateMetadata(testInfo *TestInfo, testTime *TestTime, labels map[string]string) error {
 	test := labels["test"]
 
-	// Populate testInfo with the test description.
-	testInfo.Info[test] = labels["desc"]
-
-	// Populate testTime with the test end timestamp.
 	t, err := strconv.ParseInt(labels["timestamp"], 10, 64)
 	if err != nil {
 		return fmt.Errorf("failed to convert timestamp %q to an int64: %v", labels["timestamp"], err)
 	}
-	timestamp := time.Unix(t, 0).UTC().Format(testLogTimeFormat)
-	testTime.Add(test, labels["node"], timestamp)
+	// The end time is encoded in JSON and RFC 3339, which are limited to
+	// the years 0 to 9999.
+	end := time.Unix(t, 0).UTC()
+	if t < 0 || end.Year() > 9999 {
+		return fmt.Errorf("timestamp %d is out of range", t)
+	}
+
+	// Populate testInfo with the test description.
+	testInfo.Info[test] = labels["desc"]
+
+	// Populate testTime with the test end timestamp.
+	testTime.Add(test, labels["node"], end)
 
 	return nil
 }
 
 // removeStaledBuilds ensures that the testData only contains data for --builds
-// number of builds by removing staled build data.
+// number of builds by removing the data of the oldest builds. The removed data
+// are aggregated into rollups.
 func removeStaledBuilds(testData TestToBuildData, job, test, node, build string) {
 	key := job + "_" + test + "_" + node
-	count := len(buildFIFOs[key])
-	if count == 0 || buildFIFOs[key][count-1] != build {
+	fifo := buildFIFOs[key]
+	buildNumber, _ := strconv.Atoi(build)
+	i := sort.Search(len(fifo), func(i int) bool {
+		n, _ := strconv.Atoi(fifo[i])
+		return n >= buildNumber
+	})
+	if i == len(fifo) || fifo[i] != build {
 		// A new build comes.
-		buildFIFOs[key] = append(buildFIFOs[key], build)
-		count++
+		fifo = append(fifo, "")
+		copy(fifo[i+1:], fifo[i:])
+		fifo[i] = build
 	}
-	if count > *builds {
-		delete(testData[test].Data[node], buildFIFOs[key][0])
-		buildFIFOs[key] = buildFIFOs[key][1:]
+	for len(fifo) > *builds {
+		ref, key := buildRef{Job: job, Build: fifo[0]}, testNode{Test: test, Node: node}
+		data := testData[test].Data[node][fifo[0]]
+		if data == nil {
+			data = buildCache.evictedData(ref, key)
+		}
+		rollupBuild(job, test, node, fifo[0], data)
+		delete(testData[test].Data[node], fifo[0])
+		buildCache.forget(testData, ref, key)
+		fifo = fifo[1:]
 	}
+	buildFIFOs[key] = fifo
 }
 
-// TODO(yguo0905): Consider extracting the common logic in
-// populatePerformanceData() and populateTimeSeriesData() into a function.
-
-// populatePerformanceData populates the perf data in testData and the metadata
-// in testInfo and testTime for the given test job at the given build using the
-// data fetched from source.
-func populatePerformanceData(testData TestToBuildData, testInfo *TestInfo, testTime *TestTime, job, build string, source Downloader) error {
-	contents, err := getDataFromFiles(job, build, "performance", source)
+// populateArtifacts populates the data in testData and the metadata in
+// testInfo and testTime for the given test job at the given build, using the
+// registered parsers on the artifacts fetched from source. Each parser is
+// recorded as a child span of the span in ctx.
+func populateArtifacts(ctx context.Context, testData TestToBuildData, testInfo *TestInfo, testTime *TestTime, job string, buildNumber int, source Downloader) error {
+	files, err := withTracing(ctx, source).ListFilesInBuild(job, buildNumber, artifactsDir)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to list the artifacts: %v", err)
 	}
-	for _, data := range contents {
-		// Decode the data into obj.
-		var obj perftype.PerfData
-		if err := json.Unmarshal(data, &obj); err != nil {
-			return fmt.Errorf("failed to parse performance data: %v\ndata=\n%v", err, string(data))
-		}
-
-		// Ignore the metrics with unsupported versions.
-		if obj.Version != supportedMetricVersion {
+	grouped := groupArtifacts(files)
+	for _, registration := range parsers {
+		artifacts := grouped[registration.name]
+		if len(artifacts) == 0 {
 			continue
 		}
-
-		// Populate the metadata (testInfo and testTime) with the
-		// version and labels in the perf data.
-		if err := populateMetadata(testInfo, testTime, obj.Labels); err != nil {
+		stageCtx, span := tracer.Start(ctx, "ParseArtifacts", trace.WithAttributes(
+			attribute.String("parser", registration.name),
+			attribute.Int("artifacts", len(artifacts)),
+		))
+		err := populateWithParser(registration, testData, testInfo, testTime, job, buildNumber, artifacts, withTracing(stageCtx, source))
+		endSpan(span, err)
+		if err != nil {
 			return err
 		}
-
-		// Populate the result (testData) with the perf data.
-		node := formatNodeName(obj.Labels, job)
-		test := obj.Labels["test"]
-		data := testData.GetDataPerBuild(job, build, test, node)
-		data.Perf = append(data.Perf, obj.DataItems...)
-
-		removeStaledBuilds(testData, job, test, node, build)
 	}
 	return nil
 }
 
-// populateTimeSeriesData populates the time series data in testData and the
-// metadata in testInfo and testTime for the given test job at the given build
-// using the data fetched from source.
-func populateTimeSeriesData(testData TestToBuildData, testInfo *TestInfo, testTime *TestTime, job, build string, source Downloader) error {
-	contents, err := getDataFromFiles(job, build, "time_series", source)
-	if err != nil {
-		return err
-	}
-	for _, data := range contents {
-		// Decode the data into obj.
-		var obj nodeperftype.NodeTimeSeries
-		if err := json.Unmarshal(data, &obj); err != nil {
-			return fmt.Errorf("failed to parse time_series data: %v\ndata=\n%v", err, string(data))
+// populateWithParser populates testData, testInfo and testTime with the test
+// results parsed from the artifacts by the registered parser.
+func populateWithParser(registration parserRegistration, testData TestToBuildData, testInfo *TestInfo, testTime *TestTime, job string, buildNumber int, artifacts []string, source Downloader) error {
+	for _, artifact := range artifacts {
+		content, err := getArtifact(job, buildNumber, artifact, source)
+		if err != nil {
+			parseErrors.WithLabelValues(job, registration.name).Inc()
+			return err
 		}
-
-		// Ignore the metrics with unsupported versions.
-		if obj.Version != supportedMetricVersion {
+		artifactsFetched.WithLabelValues(job, registration.name).Inc()
+		artifactBytesFetched.WithLabelValues(job, registration.name).Add(float64(len(content)))
+		// An artifact which can not be parsed will never be: report it
+		// and go on with the other artifacts instead of retrying.
+		unparsed := func(version string, err error) {
+			parseErrors.WithLabelValues(job, registration.name).Inc()
+			parserLog.Warn("Failed to parse the artifact", "job", job, "build", buildNumber, "artifact", artifact, "parser", registration.name, "err", err)
+			recordUnparsed(job, &UnparsedArtifact{Build: strconv.Itoa(buildNumber), Artifact: artifact, Parser: registration.name, Version: version, Reason: err.Error(), At: time.Now().UTC()})
+		}
+		results, err := registration.parser.Parse(content)
+		if err != nil {
+			version := ""
+			if schemaErr, ok := err.(*UnsupportedSchemaError); ok {
+				version = schemaErr.Version
+			}
+			unparsed(version, err)
 			continue
 		}
-
-		// Populate the metadata (testInfo and testTime) with the
-		// version and labels in the perf data.
-		if err := populateMetadata(testInfo, testTime, obj.Labels); err != nil {
-			return err
+		for _, result := range results {
+			// Artifacts are only used in the supported version.
+			if result.Version != supportedMetricVersion {
+				unparsed(result.Version, &UnsupportedSchemaError{Version: result.Version, Supported: []string{supportedMetricVersion}})
+				continue
+			}
+			if err := addParsedArtifact(testData, testInfo, testTime, job, strconv.Itoa(buildNumber), result); err != nil {
+				unparsed(result.Version, err)
+			}
 		}
+	}
+	return nil
+}
+
+// addParsedArtifact adds the test result to the data in testData and the
+// metadata in testInfo and testTime.
+func addParsedArtifact(testData TestToBuildData, testInfo *TestInfo, testTime *TestTime, job, build string, result ParsedArtifact) error {
+	// Ignore the tests which are not configured to be displayed.
+	if jobConfig := config.Job(job); jobConfig != nil && !jobConfig.IncludesTest(result.Labels["test"]) {
+		return nil
+	}
 
-		// Populate the result (testData) with the perf data.
-		node := formatNodeName(obj.Labels, job)
-		test := obj.Labels["test"]
-		data := testData.GetDataPerBuild(job, build, test, node)
-		data.Series = append(data.Series, obj)
+	// Populate the metadata (testInfo and testTime) with the labels of the
+	// result.
+	if err := populateMetadata(testInfo, testTime, result.Labels); err != nil {
+		return err
+	}
 
-		removeStaledBuilds(testData, job, test, node, build)
+	// Populate the result (testData) with the metrics.
+	node := formatNodeName(result.Labels, job)
+	test := result.Labels["test"]
+	for i := range result.Perf {
+		normalizeDataItem(&result.Perf[i])
 	}
+	data := testData.GetDataPerBuild(job, build, test, node)
+	data.Perf = append(data.Perf, result.Perf...)
+	data.Series = append(data.Series, result.Series...)
+	data.Timestamp, _ = strconv.ParseInt(result.Labels["timestamp"], 10, 64)
 	return nil
 }
 
 // populateDataForOneBuild populates perf and time series data in testData and
 // test description in testinfo for the given test job at given buildNumber
-// with the data fetched from source.
-func populateDataForOneBuild(testData TestToBuildData, testInfo *TestInfo, job string, buildNumber int, source Downloader) error {
+// with the data fetched from source. Each stage is recorded as a child span of
+// the span in ctx.
+func populateDataForOneBuild(ctx context.Context, testData TestToBuildData, testInfo *TestInfo, job string, buildNumber int, source Downloader) error {
 	build := strconv.Itoa(buildNumber)
 	testTime := TestTime{}
-	if err := populatePerformanceData(testData, testInfo, &testTime, job, build, source); err != nil {
+	stage := func(name string, populate func(source Downloader) error) error {
+		stageCtx, span := tracer.Start(ctx, name)
+		err := populate(withTracing(stageCtx, source))
+		endSpan(span, err)
 		return err
 	}
-	if err := populateTimeSeriesData(testData, testInfo, &testTime, job, build, source); err != nil {
+	if err := populateArtifacts(ctx, testData, testInfo, &testTime, job, buildNumber, source); err != nil {
 		return err
 	}
 	if *tracing {
-		// Grab and convert tracing data from Kubelet log into time series data format.
-		tracingData := ParseKubeletLog(source, job, buildNumber, testTime)
-		// Parse time series data.
-		parseTracingData(bufio.NewScanner(strings.NewReader(tracingData)), job, buildNumber, testData)
+		stage("ParseKubeletLog", func(source Downloader) error {
+			// Grab and convert tracing data from Kubelet log into time series data format.
+			tracingData := ParseKubeletLog(source, job, buildNumber, testTime)
+			// Parse time series data.
+			parseTracingData(bufio.NewScanner(strings.NewReader(tracingData)), job, buildNumber, testData)
+			return nil
+		})
+	}
+	// Record the tested version, so that metric changes can be mapped to
+	// commit ranges.
+	if version := getBuildVersion(job, buildNumber, withTracing(ctx, source)); version != "" {
+		for _, dataPerTest := range testData {
+			for _, dataPerNode := range dataPerTest.Data {
+				if data, ok := dataPerNode[build]; ok {
+					data.Version = version
+				}
+			}
+		}
 	}
 	return nil
 }
@@ -262,9 +405,11 @@ func parseTracingData(scanner *bufio.Scanner, job string, buildNumber int, resul
 			if strings.Contains(line, timeSeriesEnd) {
 				state = scanning
 
+				data := buff.Bytes()
+				buff = &bytes.Buffer{}
 				obj := nodeperftype.NodeTimeSeries{}
-				if err := json.Unmarshal(buff.Bytes(), &obj); err != nil {
-					fmt.Fprintf(os.Stderr, "error parsing JSON in build %d: %v\n%s\n", buildNumber, err, buff.String())
+				if err := json.Unmarshal(data, &obj); err != nil {
+					parserLog.Error("Failed to parse tracing data", "job", job, "build", buildNumber, "err", err, "data", string(data))
 					continue
 				}
 
@@ -276,27 +421,26 @@ func parseTracingData(scanner *bufio.Scanner, job string, buildNumber int, resul
 				testName, nodeName := obj.Labels["test"], formatNodeName(obj.Labels, job)
 
 				if _, found := result[testName]; !found {
-					fmt.Fprintf(os.Stderr, "Error: tracing data have no test result: %s\n", testName)
+					parserLog.Warn("Tracing data have no test result", "job", job, "build", buildNumber, "test", testName)
 					continue
 				}
 				if _, found := result[testName].Data[nodeName]; !found {
-					fmt.Fprintf(os.Stderr, "Error: tracing data have no test result: %s\n", nodeName)
+					parserLog.Warn("Tracing data have no test result", "job", job, "build", buildNumber, "test", testName, "node", nodeName)
 					continue
 				}
 				if _, found := result[testName].Data[nodeName][build]; !found {
-					fmt.Fprintf(os.Stderr, "Error: tracing data have not test result: %s\n", build)
+					parserLog.Warn("Tracing data have no test result", "job", job, "build", buildNumber, "test", testName, "node", nodeName)
 					continue
 				}
 
-				data := result.GetDataPerBuild(job, build, testName, nodeName)
-				data.Series = append(data.Series, obj)
-
-				buff.Reset()
+				dataPerBuild := result.GetDataPerBuild(job, build, testName, nodeName)
+				dataPerBuild.Series = append(dataPerBuild.Series, obj)
 			}
 		}
 		if strings.Contains(line, timeSeriesTag) {
 			state = processing
-			line = line[strings.Index(line, "{"):]
+			// The data may start on the next line.
+			line = line[strings.Index(line, timeSeriesTag)+len(timeSeriesTag):]
 		}
 		if state == processing {
 			buff.WriteString(line + " ")
@@ -308,6 +452,8 @@ func parseTracingData(scanner *bufio.Scanner, job string, buildNumber int, resul
 func formatNodeName(labels map[string]string, job string) string {
 	// Get the host name of the test node.
 	node := labels["node"]
+	nodeNameCacheLock.Lock()
+	defer nodeNameCacheLock.Unlock()
 	// Check if we already have the formatted name.
 	if formatted, ok := nodeNameCache[node]; ok {
 		return formatted
@@ -327,6 +473,11 @@ func formatNodeName(labels map[string]string, job string) string {
 	// from host name "machine-image-uuid" (to be deprecated)
 	parts := strings.Split(node, "-")
 	lastPart := len(parts) - 1
+	if len(parts) < 5 {
+		// The host name is not in this format either: use it as is.
+		nodeNameCache[node] = node
+		return node
+	}
 
 	machine = parts[0] + "-" + parts[1] + "-" + parts[2]
 
@@ -0,0 +1,210 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"k8s.io/kubernetes/test/e2e/perftype"
+	nodeperftype "k8s.io/kubernetes/test/e2e_node/perftype"
+)
+
+const (
+	// artifactsDir is the directory of a build containing the artifacts
+	// parsed for metrics.
+	artifactsDir = "artifacts/"
+
+	// gzipSuffix is the suffix of the gzip compressed artifacts.
+	gzipSuffix = ".gz"
+	// maxDecompressedBytes is the maximum size of a decompressed artifact,
+	// which protects against decompression bombs.
+	maxDecompressedBytes = 1 << 30
+)
+
+// ParsedArtifact is a test result decoded from an artifact by a Parser.
+type ParsedArtifact struct {
+	// Version is the version of the metric format. Only the results with
+	// supportedMetricVersion are used.
+	Version string
+	// Labels describe the test result. They must include "test", "desc",
+	// "timestamp" and the labels used to format the node name, e.g.
+	// "node", "image" and "machine".
+	Labels map[string]string
+	// Perf are the perf data items of the result.
+	Perf []perftype.DataItem
+	// Series are the time series of the result.
+	Series []nodeperftype.NodeTimeSeries
+}
+
+// Parser decodes the metrics in the artifacts of one format.
+type Parser interface {
+	// Parse decodes the content of an artifact into test results.
+	Parse(content []byte) ([]ParsedArtifact, error)
+}
+
+// parserRegistration is a parser together with the artifacts it parses.
+type parserRegistration struct {
+	name    string
+	pattern string
+	parser  Parser
+}
+
+// parsers lists the registered parsers in registration order.
+var parsers []parserRegistration
+
+// RegisterParser registers a parser for the artifacts in the artifacts
+// directory of the builds whose file name matches pattern, in the syntax of
+// path.Match (e.g. "performance-*.json"). If several patterns match an
+// artifact, the parser registered first is used. It is meant to be called
+// from init functions, and panics if the name is already registered or the
+// pattern is invalid.
+func RegisterParser(name, pattern string, parser Parser) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		panic(fmt.Sprintf("invalid pattern %q for parser %q: %v", pattern, name, err))
+	}
+	for _, registration := range parsers {
+		if registration.name == name {
+			panic(fmt.Sprintf("parser %q is registered twice", name))
+		}
+	}
+	parsers = append(parsers, parserRegistration{name: name, pattern: pattern, parser: parser})
+}
+
+func init() {
+	RegisterParser("performance", "performance-*", VersionedParser{
+		"":                     legacyPerfDataParser{},
+		"v1":                   legacyPerfDataParser{},
+		supportedMetricVersion: perfDataParser{},
+	})
+	RegisterParser("time_series", "time_series-*", VersionedParser{supportedMetricVersion: timeSeriesParser{}})
+}
+
+// parserFor returns the registration of the parser of the artifact, or nil if
+// no parser is registered for it. The patterns are matched against the name of
+// compressed artifacts without their ".gz" suffix.
+func parserFor(artifact string) *parserRegistration {
+	artifact = strings.TrimSuffix(artifact, gzipSuffix)
+	for i := range parsers {
+		if matched, _ := path.Match(parsers[i].pattern, artifact); matched {
+			return &parsers[i]
+		}
+	}
+	return nil
+}
+
+// groupArtifacts returns a map from parser name to the artifacts it parses
+// among the files listed in the artifacts directory of a build. The names of
+// the artifacts are relative to the artifacts directory; the files in its
+// subdirectories are ignored. A compressed artifact is ignored if the build
+// also has it uncompressed.
+func groupArtifacts(files []string) map[string][]string {
+	var artifacts []string
+	uncompressed := map[string]bool{}
+	for _, file := range files {
+		artifact := file
+		if i := strings.LastIndex(file, artifactsDir); i >= 0 {
+			artifact = file[i+len(artifactsDir):]
+		}
+		if artifact == "" || strings.Contains(artifact, "/") {
+			continue
+		}
+		artifacts = append(artifacts, artifact)
+		if !strings.HasSuffix(artifact, gzipSuffix) {
+			uncompressed[artifact] = true
+		}
+	}
+	grouped := map[string][]string{}
+	for _, artifact := range artifacts {
+		if strings.HasSuffix(artifact, gzipSuffix) && uncompressed[strings.TrimSuffix(artifact, gzipSuffix)] {
+			continue
+		}
+		if registration := parserFor(artifact); registration != nil {
+			grouped[registration.name] = append(grouped[registration.name], artifact)
+		}
+	}
+	return grouped
+}
+
+// perfDataParser parses the perf data artifacts of the node e2e tests in the
+// v2 schema, e.g. "performance-<host>.json".
+type perfDataParser struct{}
+
+func (perfDataParser) Parse(content []byte) ([]ParsedArtifact, error) {
+	var obj perftype.PerfData
+	if err := json.Unmarshal(content, &obj); err != nil {
+		return nil, err
+	}
+	return []ParsedArtifact{{Version: obj.Version, Labels: obj.Labels, Perf: obj.DataItems}}, nil
+}
+
+// legacyDatasetLabels are the labels of the test result which the legacy
+// perf data carry in the labels of each data item.
+var legacyDatasetLabels = []string{"test", "desc", "timestamp", "node", "image", "machine"}
+
+// legacyPerfDataParser parses the perf data artifacts of the node e2e tests
+// in the v1 schema, also written without a version by the oldest releases. It
+// has no dataset labels: they are in the labels of each data item, so the
+// data items are grouped by them into v2 results.
+type legacyPerfDataParser struct{}
+
+func (legacyPerfDataParser) Parse(content []byte) ([]ParsedArtifact, error) {
+	var obj perftype.PerfData
+	if err := json.Unmarshal(content, &obj); err != nil {
+		return nil, err
+	}
+	var results []ParsedArtifact
+	index := map[string]int{}
+	for _, item := range obj.DataItems {
+		labels, itemLabels := map[string]string{}, map[string]string{}
+		for k, v := range item.Labels {
+			itemLabels[k] = v
+		}
+		var key []string
+		for _, k := range legacyDatasetLabels {
+			if v, ok := itemLabels[k]; ok {
+				labels[k] = v
+				delete(itemLabels, k)
+			}
+			key = append(key, labels[k])
+		}
+		item.Labels = itemLabels
+		// The values are quoted so that the key is unambiguous.
+		i, ok := index[fmt.Sprintf("%q", key)]
+		if !ok {
+			i = len(results)
+			index[fmt.Sprintf("%q", key)] = i
+			results = append(results, ParsedArtifact{Version: supportedMetricVersion, Labels: labels})
+		}
+		results[i].Perf = append(results[i].Perf, item)
+	}
+	return results, nil
+}
+
+// timeSeriesParser parses the time series artifacts of the node e2e tests in
+// the v2 schema, e.g. "time_series-<host>.json".
+type timeSeriesParser struct{}
+
+func (timeSeriesParser) Parse(content []byte) ([]ParsedArtifact, error) {
+	var obj nodeperftype.NodeTimeSeries
+	if err := json.Unmarshal(content, &obj); err != nil {
+		return nil, err
+	}
+	return []ParsedArtifact{{Version: obj.Version, Labels: obj.Labels, Series: []nodeperftype.NodeTimeSeries{obj}}}, nil
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"reflect"
+	"testing"
+)
+
+func TestGroupArtifacts(t *testing.T) {
+	files := []string{
+		"artifacts/performance-node1.json",
+		"logs/job/12/artifacts/time_series-node1.json",
+		"artifacts/node1/performance-nested.json",
+		"artifacts/kubelet.log",
+		"artifacts/performance-node2.json",
+		"artifacts/performance-node2.json.gz",
+		"artifacts/performance-node3.json.gz",
+	}
+	expect := map[string][]string{
+		"performance": {"performance-node1.json", "performance-node2.json", "performance-node3.json.gz"},
+		"time_series": {"time_series-node1.json"},
+	}
+	if got := groupArtifacts(files); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Expected %v but got %v", expect, got)
+	}
+}
+
+func TestRegisterParser(t *testing.T) {
+	defer func(registered []parserRegistration) { parsers = registered }(parsers)
+	RegisterParser("custom-json", "custom-*.json", perfDataParser{})
+	if registration := parserFor("custom-node.json"); registration == nil || registration.name != "custom-json" {
+		t.Errorf("Expected the custom parser for custom-node.json but got %v", registration)
+	}
+	if registration := parserFor("performance-node.json"); registration == nil || registration.name != "performance" {
+		t.Errorf("Expected the performance parser for performance-node.json but got %v", registration)
+	}
+
+	for _, tt := range []struct{ name, pattern string }{
+		{name: "performance", pattern: "other-*"},
+		{name: "invalid", pattern: "[-"},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected registering %q with pattern %q to panic", tt.name, tt.pattern)
+				}
+			}()
+			RegisterParser(tt.name, tt.pattern, perfDataParser{})
+		}()
+	}
+}
+
+func TestDecompressArtifact(t *testing.T) {
+	content := []byte(`{"version":"v2"}`)
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	writer.Write(content)
+	writer.Close()
+
+	for _, tt := range []struct {
+		desc  string
+		input []byte
+	}{
+		{desc: "uncompressed", input: content},
+		{desc: "gzip", input: compressed.Bytes()},
+	} {
+		got, err := decompressArtifact(tt.input)
+		if err != nil {
+			t.Errorf("%s: expected no error but got %v", tt.desc, err)
+		} else if !bytes.Equal(got, content) {
+			t.Errorf("%s: expected %q but got %q", tt.desc, content, got)
+		}
+	}
+	if _, err := decompressArtifact(compressed.Bytes()[:8]); err == nil {
+		t.Errorf("Expected an error for truncated gzip data")
+	}
+}
+
+func TestPopulateWithParserUnparsed(t *testing.T) {
+	job := "out-of-range"
+	defer delete(allUnparsed, job)
+	var registration parserRegistration
+	for _, r := range parsers {
+		if r.name == "performance" {
+			registration = r
+		}
+	}
+	// The timestamp of the first artifact is in the year 33658.
+	source := &fakeJobSource{files: map[string][]byte{
+		job + "/1/artifacts/performance-far.json":  e2eArtifact("tmp-node-e2e-1234-cos-stable-60-9592-84-0", 999999999999, 100),
+		job + "/1/artifacts/performance-node.json": e2eArtifact("tmp-node-e2e-1234-cos-stable-60-9592-84-0", 1500000000, 100),
+	}}
+	testData, testInfo, testTime := TestToBuildData{}, &TestInfo{Info: map[string]string{}}, TestTime{}
+	if err := populateWithParser(registration, testData, testInfo, &testTime, job, 1, []string{"performance-far.json", "performance-node.json"}, source); err != nil {
+		t.Fatalf("expected the artifacts to be populated but got %v", err)
+	}
+	if len(allUnparsed[job]) != 1 || allUnparsed[job][0].Artifact != "performance-far.json" {
+		t.Errorf("expected the artifact out of range to be reported as unparsed but got %+v", allUnparsed[job])
+	}
+	for _, dataPerTest := range testData {
+		for node, dataPerNode := range dataPerTest.Data {
+			if data := dataPerNode["1"]; data == nil || data.Timestamp != 1500000000 {
+				t.Errorf("expected the data of the other artifact for %s but got %+v", node, data)
+			}
+		}
+	}
+	if len(testData) != 1 {
+		t.Errorf("expected the data of 1 test but got %d", len(testData))
+	}
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"k8s.io/kubernetes/test/e2e/perftype"
+	nodeperftype "k8s.io/kubernetes/test/e2e_node/perftype"
+)
+
+const (
+	// defaultPluginTimeout is how long a plugin may run on an artifact if
+	// its timeout is not configured.
+	defaultPluginTimeout = time.Minute
+	// maxPluginStderr is the number of bytes of the standard error of a
+	// failed plugin reported with the error.
+	maxPluginStderr = 1024
+)
+
+// PluginConfig configures an out-of-tree parser run as an external command.
+// The command gets the content of an artifact, decompressed, on its standard
+// input and writes its metrics on its standard output as a PluginOutput in
+// JSON.
+type PluginConfig struct {
+	// Name identifies the parser in the metrics and the unparsed builds,
+	// e.g. "netperf".
+	Name string `json:"name"`
+	// Pattern matches the names of the artifacts parsed by the plugin, in
+	// the syntax of path.Match, e.g. "netperf-*.json".
+	Pattern string `json:"pattern"`
+	// Command is the command run with its arguments, e.g.
+	// ["/plugins/netperf", "--format=json"]. It is not run in a shell.
+	Command []string `json:"command"`
+	// Timeout is how long the command may run on an artifact, 1m by
+	// default.
+	Timeout Duration `json:"timeout,omitempty"`
+}
+
+// validate checks the plugin configuration against the parsers built in
+// node-perf-dash.
+func (p *PluginConfig) validate() []error {
+	var errs []error
+	if p.Name == "" {
+		return []error{fmt.Errorf("plugins: name must not be empty")}
+	}
+	for _, registration := range parsers {
+		if _, ok := registration.parser.(*execParser); !ok && registration.name == p.Name {
+			errs = append(errs, fmt.Errorf("plugin %q: the name of a built-in parser", p.Name))
+		}
+	}
+	if _, err := path.Match(p.Pattern, ""); err != nil || p.Pattern == "" {
+		errs = append(errs, fmt.Errorf("plugin %q: invalid pattern %q", p.Name, p.Pattern))
+	}
+	if len(p.Command) == 0 || p.Command[0] == "" {
+		errs = append(errs, fmt.Errorf("plugin %q: command must not be empty", p.Name))
+	}
+	if p.Timeout.Duration < 0 {
+		errs = append(errs, fmt.Errorf("plugin %q: timeout must not be negative", p.Name))
+	}
+	return errs
+}
+
+// PluginOutput is the output of a plugin: the test results of an artifact, in
+// the metric format of the built-in parsers.
+type PluginOutput struct {
+	Results []PluginResult `json:"results"`
+}
+
+// PluginResult is a test result of a plugin.
+type PluginResult struct {
+	// Version is the version of the metric format, supportedMetricVersion
+	// if empty.
+	Version string `json:"version,omitempty"`
+	// Labels describe the test result like the labels of the performance
+	// artifacts: they must include "test", "desc", "timestamp" and "node".
+	Labels    map[string]string             `json:"labels"`
+	DataItems []perftype.DataItem           `json:"dataItems,omitempty"`
+	Series    []nodeperftype.NodeTimeSeries `json:"series,omitempty"`
+}
+
+// execParser is the Parser of a plugin.
+type execParser struct {
+	config *PluginConfig
+}
+
+func (p *execParser) Parse(content []byte) ([]ParsedArtifact, error) {
+	timeout := p.config.Timeout.Duration
+	if timeout == 0 {
+		timeout = defaultPluginTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, p.config.Command[0], p.config.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(content)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	// Do not wait for the children of a killed plugin still holding its
+	// output.
+	cmd.WaitDelay = time.Second
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("plugin %q timed out after %v", p.config.Name, timeout)
+		}
+		message := stderr.String()
+		if len(message) > maxPluginStderr {
+			message = message[len(message)-maxPluginStderr:]
+		}
+		return nil, fmt.Errorf("plugin %q failed: %v: %s", p.config.Name, err, strings.TrimSpace(message))
+	}
+
+	var output PluginOutput
+	if err := json.Unmarshal(stdout.Bytes(), &output); err != nil {
+		return nil, fmt.Errorf("failed to decode the output of plugin %q: %v", p.config.Name, err)
+	}
+	var results []ParsedArtifact
+	for _, result := range output.Results {
+		if result.Version == "" {
+			result.Version = supportedMetricVersion
+		}
+		results = append(results, ParsedArtifact{Version: result.Version, Labels: result.Labels, Perf: result.DataItems, Series: result.Series})
+	}
+	return results, nil
+}
+
+// registerPlugins registers the parsers of the configured plugins, after the
+// built-in parsers, replacing the plugins registered before.
+func registerPlugins(plugins []*PluginConfig) {
+	var builtin []parserRegistration
+	for _, registration := range parsers {
+		if _, ok := registration.parser.(*execParser); !ok {
+			builtin = append(builtin, registration)
+		}
+	}
+	parsers = builtin
+	for _, plugin := range plugins {
+		RegisterParser(plugin.Name, plugin.Pattern, &execParser{config: plugin})
+	}
+}
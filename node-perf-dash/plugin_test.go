@@ -0,0 +1,109 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPluginValidate(t *testing.T) {
+	table := []struct {
+		name   string
+		plugin PluginConfig
+		errs   int
+	}{
+		{name: "valid", plugin: PluginConfig{Name: "netperf", Pattern: "netperf-*.json", Command: []string{"/plugins/netperf"}}, errs: 0},
+		{name: "no name", plugin: PluginConfig{Pattern: "netperf-*.json", Command: []string{"/plugins/netperf"}}, errs: 1},
+		{name: "built-in name", plugin: PluginConfig{Name: "performance", Pattern: "netperf-*.json", Command: []string{"/plugins/netperf"}}, errs: 1},
+		{name: "invalid pattern and no command", plugin: PluginConfig{Name: "netperf", Pattern: "netperf-[.json"}, errs: 2},
+		{name: "negative timeout", plugin: PluginConfig{Name: "netperf", Pattern: "*", Command: []string{"netperf"}, Timeout: Duration{-time.Second}}, errs: 1},
+	}
+	for _, tt := range table {
+		if errs := tt.plugin.validate(); len(errs) != tt.errs {
+			t.Errorf("%s: expected %d errors but got %v", tt.name, tt.errs, errs)
+		}
+	}
+}
+
+func TestExecParser(t *testing.T) {
+	table := []struct {
+		name    string
+		script  string
+		timeout time.Duration
+		version string
+		err     string
+	}{
+		{
+			name: "metrics",
+			// The plugin reads the artifact, here the build number.
+			script:  `read build; echo "{\"results\": [{\"labels\": {\"test\": \"netperf\", \"build\": \"$build\"}, \"dataItems\": [{\"data\": {\"Perc99\": 3}, \"unit\": \"ms\", \"labels\": {\"datatype\": \"latency\"}}]}]}"`,
+			version: supportedMetricVersion,
+		},
+		{
+			name:    "unsupported version",
+			script:  `echo '{"results": [{"version": "v3", "labels": {"test": "netperf"}}]}'`,
+			version: "v3",
+		},
+		{name: "failure", script: `echo "unknown format" >&2; exit 3`, err: "exit status 3: unknown format"},
+		{name: "invalid output", script: `echo metrics`, err: "failed to decode the output"},
+		{name: "timeout", script: `exec sleep 5`, timeout: 10 * time.Millisecond, err: "timed out"},
+	}
+	for _, tt := range table {
+		parser := &execParser{config: &PluginConfig{Name: "netperf", Command: []string{"sh", "-c", tt.script}, Timeout: Duration{tt.timeout}}}
+		results, err := parser.Parse([]byte("42\n"))
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: expected an error containing %q but got %v", tt.name, tt.err, err)
+			}
+			continue
+		}
+		if err != nil || len(results) != 1 {
+			t.Errorf("%s: expected 1 result but got %+v (%v)", tt.name, results, err)
+			continue
+		}
+		if results[0].Version != tt.version {
+			t.Errorf("%s: expected version %q but got %q", tt.name, tt.version, results[0].Version)
+		}
+		if tt.name == "metrics" && (results[0].Labels["build"] != "42" || len(results[0].Perf) != 1 || results[0].Perf[0].Data["Perc99"] != 3) {
+			t.Errorf("%s: unexpected result %+v", tt.name, results[0])
+		}
+	}
+}
+
+func TestRegisterPlugins(t *testing.T) {
+	defer registerPlugins(nil)
+	registerPlugins([]*PluginConfig{{Name: "netperf", Pattern: "netperf-*", Command: []string{"netperf"}}})
+	// Registering the plugins of a new configuration replaces them.
+	registerPlugins([]*PluginConfig{{Name: "iperf", Pattern: "*perf-*", Command: []string{"iperf"}}})
+
+	table := map[string]string{
+		"performance-node.json": "performance",
+		"netperf-node.json.gz":  "iperf",
+		"build-log.txt":         "",
+	}
+	for artifact, expected := range table {
+		name := ""
+		if registration := parserFor(artifact); registration != nil {
+			name = registration.name
+		}
+		if name != expected {
+			t.Errorf("%s: expected parser %q but got %q", artifact, expected, name)
+		}
+	}
+}
@@ -0,0 +1,518 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultPresubmitBuilds is the default number of the latest builds of
+	// a presubmit job which are checked.
+	defaultPresubmitBuilds = 20
+	// maxPullVerdicts is the number of verdicts kept for each presubmit
+	// job, the oldest are dropped.
+	maxPullVerdicts = 1000
+)
+
+var (
+	prWatcher = flag.Bool("pr-watcher", false, "If true, also watch the builds of the presubmit jobs in the configuration, comparing each pull request run with the master builds of its baseline job")
+)
+
+// PresubmitConfig declares a presubmit job running the tests of a job on the
+// pull requests, so that the perf regressions are caught before they merge.
+type PresubmitConfig struct {
+	// Job is the name of the presubmit job, e.g.
+	// "pull-kubernetes-node-kubelet-benchmark".
+	Job string `json:"job"`
+	// Baseline is the configured job running the same tests on master.
+	Baseline string `json:"baseline"`
+	// Builds is the number of the latest builds of the presubmit job which
+	// are checked. It defaults to 20.
+	Builds int `json:"builds,omitempty"`
+	// RefreshInterval is how often the new builds are checked, e.g. "5m".
+	// It defaults to --refresh-interval.
+	RefreshInterval Duration `json:"refreshInterval,omitempty"`
+}
+
+// validate checks the presubmit against the configured jobs.
+func (p *PresubmitConfig) validate(c *Config) []error {
+	var errs []error
+	if p.Job == "" {
+		return []error{fmt.Errorf("presubmits: job must not be empty")}
+	}
+	if c.Job(p.Job) != nil {
+		errs = append(errs, fmt.Errorf("presubmit %q: also configured as a job", p.Job))
+	}
+	if c.Job(p.Baseline) == nil {
+		errs = append(errs, fmt.Errorf("presubmit %q: baseline job %q is not configured", p.Job, p.Baseline))
+	}
+	if p.Builds < 0 {
+		errs = append(errs, fmt.Errorf("presubmit %q: builds must not be negative", p.Job))
+	}
+	if p.RefreshInterval.Duration < 0 {
+		errs = append(errs, fmt.Errorf("presubmit %q: refresh interval must not be negative", p.Job))
+	}
+	return errs
+}
+
+// builds returns the number of the latest builds which are checked.
+func (p *PresubmitConfig) builds() int {
+	if p.Builds > 0 {
+		return p.Builds
+	}
+	return defaultPresubmitBuilds
+}
+
+// interval returns how often the new builds are checked.
+func (p *PresubmitConfig) interval() time.Duration {
+	if p.RefreshInterval.Duration > 0 {
+		return p.RefreshInterval.Duration
+	}
+	return *refreshInterval
+}
+
+// PullVerdict is the comparison of a run of a presubmit job on a pull request
+// with the closest master build of its baseline job.
+type PullVerdict struct {
+	Job   string `json:"job"`
+	Build string `json:"build"`
+	Pull  int    `json:"pull"`
+	// Commit is the head commit of the pull request tested, if it is
+	// known.
+	Commit string `json:"commit,omitempty"`
+	// BaseCommit is the master commit the pull request was tested on, if
+	// it is known.
+	BaseCommit    string `json:"baseCommit,omitempty"`
+	Baseline      string `json:"baseline"`
+	BaselineBuild string `json:"baselineBuild"`
+	// BaselineByCommit is true if the baseline build tested BaseCommit,
+	// rather than being the build which ended last before the presubmit.
+	BaselineByCommit bool `json:"baselineByCommit,omitempty"`
+	// Regressed is true if any metric regressed compared with the
+	// baseline build.
+	Regressed bool               `json:"regressed"`
+	Metrics   []MetricComparison `json:"metrics"`
+	CheckedAt time.Time          `json:"checkedAt"`
+}
+
+var (
+	// allPullVerdicts is a map from presubmit job to its verdicts, the
+	// oldest first. It is protected by dataLock.
+	allPullVerdicts = map[string][]*PullVerdict{}
+
+	// uncheckedPresubmits is a map from presubmit job to the builds which
+	// can never be checked, to the reason why, so that they are not fetched
+	// again at every refresh. It is protected by dataLock.
+	uncheckedPresubmits = map[string]map[string]string{}
+)
+
+// uncheckableError is returned by checkPull for a build which can never be
+// checked, e.g. because it did not test a pull request or has no data.
+type uncheckableError struct {
+	reason string
+}
+
+func (e *uncheckableError) Error() string {
+	return e.reason
+}
+
+func pullVerdictsKey(job string) string {
+	return "pulls/" + job
+}
+
+// loadPullVerdicts loads the verdicts of the configured presubmits from the
+// store. It must be called with dataLock held.
+func loadPullVerdicts() error {
+	if config == nil {
+		return nil
+	}
+	for _, presubmit := range config.Presubmits {
+		var verdicts []*PullVerdict
+		if err := store.Get(pullVerdictsKey(presubmit.Job), &verdicts); err != nil && err != errNotFound {
+			return err
+		}
+		allPullVerdicts[presubmit.Job] = verdicts
+	}
+	return nil
+}
+
+// hasPullVerdict returns whether the build of the presubmit job was checked.
+func hasPullVerdict(job, build string) bool {
+	dataLock.RLock()
+	defer dataLock.RUnlock()
+	for _, verdict := range allPullVerdicts[job] {
+		if verdict.Build == build {
+			return true
+		}
+	}
+	return false
+}
+
+// isUnchecked returns whether the build of the presubmit job can never be
+// checked.
+func isUnchecked(job, build string) bool {
+	dataLock.RLock()
+	defer dataLock.RUnlock()
+	_, ok := uncheckedPresubmits[job][build]
+	return ok
+}
+
+// addUnchecked records that the build of the presubmit job can never be
+// checked, forgetting the builds before oldest which are no longer checked.
+func addUnchecked(job string, buildNumber, oldest int, reason string) {
+	dataLock.Lock()
+	defer dataLock.Unlock()
+	unchecked := uncheckedPresubmits[job]
+	if unchecked == nil {
+		unchecked = map[string]string{}
+		uncheckedPresubmits[job] = unchecked
+	}
+	for build := range unchecked {
+		if n, err := strconv.Atoi(build); err != nil || n < oldest {
+			delete(unchecked, build)
+		}
+	}
+	unchecked[strconv.Itoa(buildNumber)] = reason
+}
+
+// addPullVerdict records the verdict and persists the verdicts of its job. The
+// verdicts are copied under dataLock and written without holding it, like in
+// flushCache, and the writes are serialized by flushLock so that the last one
+// written is the newest.
+func addPullVerdict(verdict *PullVerdict) error {
+	flushLock.Lock()
+	defer flushLock.Unlock()
+
+	dataLock.Lock()
+	verdicts := append(allPullVerdicts[verdict.Job], verdict)
+	if len(verdicts) > maxPullVerdicts {
+		verdicts = verdicts[len(verdicts)-maxPullVerdicts:]
+	}
+	allPullVerdicts[verdict.Job] = verdicts
+	verdicts = append([]*PullVerdict(nil), verdicts...)
+	dataLock.Unlock()
+
+	if store == nil {
+		return nil
+	}
+	return store.Put(pullVerdictsKey(verdict.Job), verdicts)
+}
+
+// pullVerdicts returns the verdicts of the pull request, or of all the pull
+// requests if pull is 0, of the presubmit job or of all the presubmit jobs if
+// job is empty. They are sorted by job, and from the newest build.
+func pullVerdicts(job string, pull int) []*PullVerdict {
+	dataLock.RLock()
+	defer dataLock.RUnlock()
+	result := []*PullVerdict{}
+	for j, verdicts := range allPullVerdicts {
+		if job != "" && j != job {
+			continue
+		}
+		for _, verdict := range verdicts {
+			if pull == 0 || verdict.Pull == pull {
+				result = append(result, verdict)
+			}
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Job != result[j].Job {
+			return result[i].Job < result[j].Job
+		}
+		a, _ := strconv.Atoi(result[i].Build)
+		b, _ := strconv.Atoi(result[j].Build)
+		return a > b
+	})
+	return result
+}
+
+// pullRefs are the refs tested by a presubmit build.
+type pullRefs struct {
+	// Pull is the pull request, 0 if the build did not test one.
+	Pull int
+	// Commit is the head commit of the pull request, and BaseCommit the
+	// commit of the branch it was merged into, if they are known.
+	Commit, BaseCommit string
+}
+
+// getBuildPull returns the refs tested by the build, as recorded in the "pull"
+// field of its started.json, e.g. "master:<base>,12345:<head>" or "12345".
+func getBuildPull(job string, buildNumber int, source Downloader) (pullRefs, error) {
+	body, err := source.GetFile(job, buildNumber, startedFile)
+	if err != nil {
+		return pullRefs{}, fmt.Errorf("failed to get %s: %v", startedFile, err)
+	}
+	defer body.Close()
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return pullRefs{}, fmt.Errorf("failed to read %s: %v", startedFile, err)
+	}
+	var started struct {
+		Pull string `json:"pull"`
+	}
+	if err := json.Unmarshal(data, &started); err != nil {
+		return pullRefs{}, &uncheckableError{fmt.Sprintf("failed to parse %s: %v", startedFile, err)}
+	}
+	return parsePull(started.Pull), nil
+}
+
+// parsePull parses the "pull" field of started.json: the base ref, which is
+// the first one, and the pull request.
+func parsePull(pull string) pullRefs {
+	var refs pullRefs
+	for i, ref := range strings.Split(pull, ",") {
+		parts := strings.SplitN(strings.TrimSpace(ref), ":", 2)
+		n, err := strconv.Atoi(parts[0])
+		switch {
+		case err == nil && n > 0 && refs.Pull == 0:
+			refs.Pull = n
+			if len(parts) == 2 {
+				refs.Commit = parts[1]
+			}
+		case err != nil && i == 0 && len(parts) == 2:
+			refs.BaseCommit = parts[1]
+		}
+	}
+	return refs
+}
+
+// buildTimestamp returns when the tests of the build ended, the latest end of
+// its tests.
+func buildTimestamp(snapshot buildSnapshot) int64 {
+	var timestamp int64
+	for _, dataPerNode := range snapshot {
+		for _, data := range dataPerNode {
+			if data.Timestamp > timestamp {
+				timestamp = data.Timestamp
+			}
+		}
+	}
+	return timestamp
+}
+
+// commitBuild returns the latest build of testData which tested the commit, or
+// "" if there is none. The commits of the versions may be abbreviated.
+func commitBuild(testData TestToBuildData, commit string) string {
+	latest := -1
+	for build, version := range buildVersions(testData) {
+		tested := versionCommit(version)
+		if tested == "" || !(strings.HasPrefix(commit, tested) || strings.HasPrefix(tested, commit)) {
+			continue
+		}
+		if n, err := strconv.Atoi(build); err == nil && n > latest {
+			latest = n
+		}
+	}
+	if latest < 0 {
+		return ""
+	}
+	return strconv.Itoa(latest)
+}
+
+// baselineBuild returns the latest build of testData which ended before the
+// timestamp, or the oldest build if they all ended after it. It returns "" if
+// testData has no build.
+func baselineBuild(testData TestToBuildData, timestamp int64) string {
+	ended := map[string]int64{}
+	for _, dataPerTest := range testData {
+		for _, dataPerNode := range dataPerTest.Data {
+			for build, data := range dataPerNode {
+				if data.Timestamp >= ended[build] {
+					ended[build] = data.Timestamp
+				}
+			}
+		}
+	}
+	builds := make([]int, 0, len(ended))
+	for build := range ended {
+		if n, err := strconv.Atoi(build); err == nil {
+			builds = append(builds, n)
+		}
+	}
+	if len(builds) == 0 {
+		return ""
+	}
+	sort.Ints(builds)
+	baseline := strconv.Itoa(builds[0])
+	for _, n := range builds {
+		if build := strconv.Itoa(n); ended[build] <= timestamp {
+			baseline = build
+		}
+	}
+	return baseline
+}
+
+// checkPull compares the build of the presubmit job with the master build of
+// its baseline job which tested the base commit of the pull request, or else
+// which ended last before it. It returns an *uncheckableError if the build can
+// never be checked.
+func checkPull(ctx context.Context, presubmit *PresubmitConfig, buildNumber int, source Downloader) (*PullVerdict, error) {
+	refs, err := getBuildPull(presubmit.Job, buildNumber, source)
+	if err != nil {
+		return nil, err
+	}
+	if refs.Pull == 0 {
+		return nil, &uncheckableError{fmt.Sprintf("build %d of job %q did not test a pull request", buildNumber, presubmit.Job)}
+	}
+	if !buildFinished(source, presubmit.Job, buildNumber) {
+		return nil, fmt.Errorf("build %d of job %q is not finished", buildNumber, presubmit.Job)
+	}
+	build := strconv.Itoa(buildNumber)
+	fetched := TestToBuildData{}
+	if err := populateDataForOneBuild(ctx, fetched, &TestInfo{Info: map[string]string{}}, presubmit.Job, buildNumber, source); err != nil {
+		return nil, fmt.Errorf("failed to fetch build %d of job %q: %v", buildNumber, presubmit.Job, err)
+	}
+	snapshot := snapshotBuild(fetched, build)
+	if len(snapshot) == 0 {
+		return nil, &uncheckableError{fmt.Sprintf("build %d of job %q has no data", buildNumber, presubmit.Job)}
+	}
+
+	baselineData, err := jobData(presubmit.Baseline)
+	if err != nil {
+		return nil, err
+	}
+	baseline, byCommit := "", false
+	if refs.BaseCommit != "" {
+		baseline = commitBuild(baselineData, refs.BaseCommit)
+		byCommit = baseline != ""
+	}
+	if baseline == "" {
+		baseline = baselineBuild(baselineData, buildTimestamp(snapshot))
+	}
+	if baseline == "" {
+		return nil, fmt.Errorf("baseline job %q has no build", presubmit.Baseline)
+	}
+
+	// The builds are compared like with a golden baseline, with the
+	// thresholds of the baseline job.
+	pr := TestToBuildData{}
+	addSnapshot(pr, presubmit.Baseline, build, snapshot)
+	golden := &goldenSnapshot{GoldenBaseline: GoldenBaseline{Build: baseline}, Data: snapshotBuild(baselineData, baseline)}
+	comparison := compareBuilds(presubmit.Baseline, build, pr, golden, seriesFilter{})
+	verdict := &PullVerdict{
+		Job:              presubmit.Job,
+		Build:            build,
+		Pull:             refs.Pull,
+		Commit:           refs.Commit,
+		BaseCommit:       refs.BaseCommit,
+		Baseline:         presubmit.Baseline,
+		BaselineBuild:    baseline,
+		BaselineByCommit: byCommit,
+		Metrics:          comparison.Metrics,
+		CheckedAt:        time.Now().UTC(),
+	}
+	for _, metric := range comparison.Metrics {
+		verdict.Regressed = verdict.Regressed || metric.Regressed
+	}
+	return verdict, nil
+}
+
+// checkPresubmit checks the latest builds of the presubmit job which were not
+// checked yet, skipping those which can never be.
+func checkPresubmit(ctx context.Context, presubmit *PresubmitConfig, source Downloader) error {
+	latest, err := source.GetLastestBuildNumber(presubmit.Job)
+	if err != nil {
+		return fmt.Errorf("failed to get the latest build: %v", err)
+	}
+	oldest := latest - presubmit.builds() + 1
+	for buildNumber := latest; buildNumber > 0 && buildNumber >= oldest; buildNumber-- {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		build := strconv.Itoa(buildNumber)
+		if hasPullVerdict(presubmit.Job, build) || isUnchecked(presubmit.Job, build) {
+			continue
+		}
+		verdict, err := checkPull(ctx, presubmit, buildNumber, source)
+		if uncheckable, ok := err.(*uncheckableError); ok {
+			mainLog.Info("Skipping the presubmit build", "job", presubmit.Job, "build", buildNumber, "reason", uncheckable.reason)
+			addUnchecked(presubmit.Job, buildNumber, oldest, uncheckable.reason)
+			continue
+		}
+		if err != nil {
+			// The build may still be running, it is checked
+			// again at the next refresh.
+			mainLog.Debug("Failed to check the presubmit build", "job", presubmit.Job, "build", buildNumber, "err", err)
+			continue
+		}
+		if err := addPullVerdict(verdict); err != nil {
+			return fmt.Errorf("failed to persist the verdict of build %d: %v", buildNumber, err)
+		}
+		mainLog.Info("Checked the presubmit build", "job", presubmit.Job, "build", buildNumber, "pull", verdict.Pull, "baselineBuild", verdict.BaselineBuild, "baselineByCommit", verdict.BaselineByCommit, "regressed", verdict.Regressed)
+	}
+	return nil
+}
+
+// watchPresubmit checks the new builds of the presubmit job every refresh
+// interval until ctx is cancelled.
+func watchPresubmit(ctx context.Context, presubmit *PresubmitConfig, source Downloader) {
+	for {
+		if err := checkPresubmit(ctx, presubmit, source); err != nil && ctx.Err() == nil {
+			mainLog.Error("Error checking the presubmit builds", "job", presubmit.Job, "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(presubmit.interval()):
+		}
+	}
+}
+
+// servePulls is the HTTP handler returning the verdicts of the presubmit
+// builds, of the pull request in the "pull" query parameter and of the
+// presubmit job in the "job" parameter if they are set.
+func servePulls(res http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	pull := 0
+	if p := query.Get("pull"); p != "" {
+		var err error
+		if pull, err = strconv.Atoi(p); err != nil || pull <= 0 {
+			writeError(res, http.StatusBadRequest, fmt.Errorf("invalid pull request %q", p))
+			return
+		}
+	}
+	job := query.Get("job")
+	if job != "" && config.Presubmit(job) == nil {
+		writeError(res, http.StatusNotFound, fmt.Errorf("unknown presubmit job %q", job))
+		return
+	}
+	writeJSON(res, req, pullVerdicts(job, pull))
+}
+
+// Presubmit returns the configuration of the named presubmit job, or nil if
+// no such presubmit is configured.
+func (c *Config) Presubmit(job string) *PresubmitConfig {
+	if c == nil {
+		return nil
+	}
+	for _, presubmit := range c.Presubmits {
+		if presubmit.Job == job {
+			return presubmit
+		}
+	}
+	return nil
+}
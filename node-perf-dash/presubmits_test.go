@@ -0,0 +1,176 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+)
+
+// fakeJobSource is a data source serving the files of the builds of the jobs,
+// keyed by "<job>/<build>/<path>".
+type fakeJobSource struct {
+	latest map[string]int
+	files  map[string][]byte
+}
+
+func (s *fakeJobSource) GetLastestBuildNumber(job string) (int, error) {
+	return s.latest[job], nil
+}
+
+func (s *fakeJobSource) ListFilesInBuild(job string, build int, prefix string) ([]string, error) {
+	dir := fmt.Sprintf("%s/%d/", job, build)
+	var files []string
+	for name := range s.files {
+		if strings.HasPrefix(name, dir+prefix) {
+			files = append(files, strings.TrimPrefix(name, dir))
+		}
+	}
+	return files, nil
+}
+
+func (s *fakeJobSource) GetFile(job string, build int, filePath string) (io.ReadCloser, error) {
+	content, ok := s.files[path.Join(job, fmt.Sprint(build), filePath)]
+	if !ok {
+		return nil, fmt.Errorf("%s/%d/%s not found", job, build, filePath)
+	}
+	return ioutil.NopCloser(bytes.NewReader(content)), nil
+}
+
+// addBuild adds a finished build of the density test with the latency to the
+// source.
+func (s *fakeJobSource) addBuild(job string, build int, pull string, timestamp int64, latency float64) {
+	dir := fmt.Sprintf("%s/%d/", job, build)
+	s.files[dir+startedFile] = []byte(fmt.Sprintf(`{"timestamp": %d, "pull": %q}`, timestamp-3600, pull))
+	s.files[dir+finishedFile] = []byte(`{"result": "SUCCESS"}`)
+	s.files[dir+"artifacts/performance-node.json"] = e2eArtifact("tmp-node-e2e-1234-cos-stable-60-9592-84-0", timestamp, latency)
+	if build > s.latest[job] {
+		s.latest[job] = build
+	}
+}
+
+func TestParsePull(t *testing.T) {
+	table := []struct {
+		pull   string
+		expect pullRefs
+	}{
+		{pull: "master:5aad3f4,27898:ab12cd3", expect: pullRefs{Pull: 27898, Commit: "ab12cd3", BaseCommit: "5aad3f4"}},
+		{pull: "27898", expect: pullRefs{Pull: 27898}},
+		{pull: "master:5aad3f4", expect: pullRefs{BaseCommit: "5aad3f4"}},
+		{pull: "", expect: pullRefs{}},
+	}
+	for _, tt := range table {
+		if refs := parsePull(tt.pull); refs != tt.expect {
+			t.Errorf("%q: expected %+v but got %+v", tt.pull, tt.expect, refs)
+		}
+	}
+}
+
+func TestCheckPresubmit(t *testing.T) {
+	master, presubmit := "ci-kubernetes-node-kubelet-benchmark", "pull-kubernetes-node-kubelet-benchmark"
+	defer func(c *Config) {
+		config = c
+		dataLock.Lock()
+		delete(allTestData, master)
+		delete(allPullVerdicts, presubmit)
+		delete(uncheckedPresubmits, presubmit)
+		dataLock.Unlock()
+		nodeNameCacheLock.Lock()
+		nodeNameCache = map[string]string{}
+		nodeNameCacheLock.Unlock()
+	}(config)
+	config = &Config{Jobs: []*JobConfig{{Name: master}}, Presubmits: []*PresubmitConfig{{Job: presubmit, Baseline: master, Builds: 5}}}
+	if errs := config.Validate(); len(errs) > 0 {
+		t.Fatalf("invalid configuration: %v", errs)
+	}
+
+	source := &fakeJobSource{latest: map[string]int{}, files: map[string][]byte{}}
+	source.addBuild(master, 1, "", 1500000000, 100)
+	source.addBuild(master, 2, "", 1500086400, 200)
+	// Master build 1 tested commit 5aad3f4, the version of build 2 is
+	// unknown.
+	source.files[master+"/1/"+startedFile] = []byte(`{"timestamp": 1499996400, "version": "v1.8.0-alpha.0.690+5aad3f4c0ffee"}`)
+	// The pull request of build 11 ran between the master builds 1 and 2,
+	// it is compared with build 1.
+	source.addBuild(presubmit, 11, "27898:ab12cd3", 1500040000, 101)
+	source.addBuild(presubmit, 12, "master:1234567,27899:ef45ab6", 1500090000, 300)
+	// Build 13 is still running.
+	source.addBuild(presubmit, 13, "master:5aad3f4,27900:01234ab", 1500090000, 300)
+	delete(source.files, presubmit+"/13/"+finishedFile)
+	// Build 14 did not test a pull request.
+	source.addBuild(presubmit, 14, "", 1500090000, 300)
+	// Build 15 ran after build 2 on the commit of build 1, it is compared
+	// with build 1.
+	source.addBuild(presubmit, 15, "master:5aad3f4,27901:abcdef0", 1500090000, 101)
+	// Build 10 is outside of the checked builds.
+	source.addBuild(presubmit, 10, "master:5aad3f4,27897:cdef012", 1500000000, 900)
+
+	allTestData[master] = TestToBuildData{}
+	if err := Parse(context.Background(), allTestData, &allTestInfo, master, source); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkPresubmit(context.Background(), config.Presubmits[0], source); err != nil {
+		t.Fatal(err)
+	}
+
+	verdicts := pullVerdicts("", 0)
+	var got []string
+	for _, verdict := range verdicts {
+		got = append(got, fmt.Sprintf("%s:%d:%s:%s:%v", verdict.Build, verdict.Pull, verdict.Commit, verdict.BaselineBuild, verdict.Regressed))
+	}
+	expected := []string{"15:27901:abcdef0:1:false", "12:27899:ef45ab6:2:true", "11:27898:ab12cd3:1:false"}
+	if strings.Join(got, " ") != strings.Join(expected, " ") {
+		t.Errorf("expected the verdicts %v but got %v", expected, got)
+	}
+	if len(verdicts) == 3 {
+		if !verdicts[0].BaselineByCommit || verdicts[1].BaselineByCommit {
+			t.Errorf("expected only build 15 to be compared with the build of its base commit but got %+v", verdicts)
+		}
+		for _, metric := range verdicts[1].Metrics {
+			if metric.Bucket == "Perc99" && (metric.Golden != 200 || metric.Value != 300) {
+				t.Errorf("expected the Perc99 of build 12 to be compared with build 2 but got %+v", metric)
+			}
+		}
+	}
+	if pulls := pullVerdicts(presubmit, 27898); len(pulls) != 1 || pulls[0].Build != "11" {
+		t.Errorf("expected the verdict of build 11 for the pull request but got %+v", pulls)
+	}
+
+	if !isUnchecked(presubmit, "14") || isUnchecked(presubmit, "13") {
+		t.Errorf("expected only build 14 to be recorded as never checkable but got %v", uncheckedPresubmits[presubmit])
+	}
+
+	// The checked builds are not checked again, the finished ones are.
+	source.files[presubmit+"/13/"+finishedFile] = []byte(`{"result": "SUCCESS"}`)
+	// Build 14 is not fetched again.
+	delete(source.files, presubmit+"/14/"+startedFile)
+	if err := checkPresubmit(context.Background(), config.Presubmits[0], source); err != nil {
+		t.Fatal(err)
+	}
+	if verdicts := pullVerdicts(presubmit, 27900); len(verdicts) != 1 || verdicts[0].Build != "13" || verdicts[0].BaselineBuild != "1" {
+		t.Errorf("expected build 13 to be checked once finished but got %+v", verdicts)
+	}
+	if len(pullVerdicts(presubmit, 0)) != 4 || !isUnchecked(presubmit, "14") {
+		t.Errorf("expected 4 verdicts and build 14 to remain unchecked but got %+v", pullVerdicts(presubmit, 0))
+	}
+}
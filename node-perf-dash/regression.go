@@ -0,0 +1,170 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+)
+
+// Kinds of regressions.
+const (
+	// regressionSLO means the metric breaches its SLO. SLOs are hard
+	// limits, so it is a regression whatever the previous builds are.
+	regressionSLO = "slo"
+	// regressionRelative means the metric got worse than the previous
+	// builds by more than its threshold.
+	regressionRelative = "relative"
+)
+
+// Regression is a regression of a metric in the latest build of a job.
+type Regression struct {
+	Job    string            `json:"job"`
+	Test   string            `json:"test"`
+	Node   string            `json:"node"`
+	Labels map[string]string `json:"labels"`
+	Bucket string            `json:"bucket"`
+	Unit   string            `json:"unit"`
+	// Owner is the SIG or team owning the metric, to route the regression
+	// to, if it is known.
+	Owner string  `json:"owner,omitempty"`
+	Build string  `json:"build"`
+	Value float64 `json:"value"`
+	// Baseline is the mean of the metric in the previous builds.
+	Baseline float64 `json:"baseline"`
+	// Change is the relative change of the metric compared to Baseline.
+	Change float64 `json:"change"`
+	Kind   string  `json:"kind"`
+	// SLO is the name of the breached SLO for the "slo" kind.
+	SLO string `json:"slo,omitempty"`
+}
+
+// higherIsBetter returns true if an increase of the metric with the given
+// labels is an improvement, e.g. for throughput.
+func higherIsBetter(labels map[string]string) bool {
+	return labels["datatype"] == "throughput"
+}
+
+// baselineOf returns the mean of the points preceding the latest one within
+// the window of the threshold, and whether there are enough of them.
+func baselineOf(points []Point, threshold Threshold) (float64, bool) {
+	previous := points[:len(points)-1]
+	if len(previous) > threshold.Window {
+		previous = previous[len(previous)-threshold.Window:]
+	}
+	if len(previous) < threshold.MinBaseline || len(previous) == 0 {
+		return 0, false
+	}
+	sum := 0.0
+	for _, point := range previous {
+		sum += point.Value
+	}
+	return sum / float64(len(previous)), true
+}
+
+// detectRegressions returns the regressions of the latest build of each of
+// the series of the job.
+func detectRegressions(job string, series []*Series) []Regression {
+	regressions := []Regression{}
+	for _, s := range series {
+		if len(s.Points) == 0 {
+			continue
+		}
+		latest := s.Points[len(s.Points)-1]
+		regression := Regression{
+			Job:    job,
+			Test:   s.Test,
+			Node:   s.Node,
+			Labels: s.Labels,
+			Bucket: s.Bucket,
+			Unit:   s.Unit,
+			Owner:  s.Owner,
+			Build:  latest.Build,
+			Value:  latest.Value,
+		}
+		threshold := thresholds.For(job, s.Test, s.Labels, s.Bucket)
+		baseline, ok := baselineOf(s.Points, threshold)
+		if ok && baseline != 0 {
+			regression.Baseline = baseline
+			regression.Change = (latest.Value - baseline) / math.Abs(baseline)
+		}
+
+		if s.SLO != nil && len(s.SLO.Breaches) > 0 && s.SLO.Breaches[len(s.SLO.Breaches)-1] == latest.Build {
+			regression.Kind = regressionSLO
+			regression.SLO = s.SLO.Name
+			regressions = append(regressions, regression)
+			continue
+		}
+		if !ok || baseline == 0 {
+			continue
+		}
+		worse, worseBy := regression.Change, latest.Value-baseline
+		if higherIsBetter(s.Labels) {
+			worse, worseBy = -worse, -worseBy
+		}
+		if threshold.Exceeded(worse, worseBy) {
+			regression.Kind = regressionRelative
+			regressions = append(regressions, regression)
+		}
+	}
+	return regressions
+}
+
+// serveRegressions is the HTTP handler returning the regressions in the
+// latest builds of the job in the "job" query parameter, or of all jobs if it
+// is empty, selected by their labels with "label=<key>=<value>" query
+// parameters like in the jobs API: a job without the labels has no
+// regressions. The metrics can be filtered like in the series API.
+func serveRegressions(res http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	selector, err := parseLabelSelector(query["label"])
+	if err != nil {
+		writeError(res, http.StatusBadRequest, err)
+		return
+	}
+	jobs := []string{query.Get("job")}
+	if jobs[0] == "" {
+		jobs = nil
+		for _, job := range config.Jobs {
+			if selector.Matches(job.Labels) {
+				jobs = append(jobs, job.Name)
+			}
+		}
+	} else if _, ok := allTestData[jobs[0]]; !ok {
+		writeError(res, http.StatusNotFound, fmt.Errorf("unknown job %q", jobs[0]))
+		return
+	} else if !selector.Matches(jobLabels(jobs[0])) {
+		jobs = nil
+	}
+	filter, err := parseSeriesFilter(req)
+	if err != nil {
+		writeError(res, http.StatusBadRequest, err)
+		return
+	}
+
+	regressions := []Regression{}
+	for _, job := range jobs {
+		testData, err := jobData(job)
+		if err != nil {
+			writeError(res, http.StatusInternalServerError, err)
+			return
+		}
+		regressions = append(regressions, detectRegressions(job, extractSeries(job, testData, filter))...)
+	}
+	writeJSON(res, req, regressions)
+}
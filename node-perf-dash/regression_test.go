@@ -0,0 +1,121 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+
+	"k8s.io/kubernetes/test/e2e/perftype"
+)
+
+func TestDetectRegressions(t *testing.T) {
+	latency := map[string]string{"datatype": "latency"}
+	throughput := map[string]string{"datatype": "throughput"}
+	max := 150.0
+	table := []struct {
+		name   string
+		labels map[string]string
+		values []float64
+		slo    *SLOConfig
+		expect string
+	}{
+		{name: "stable", labels: latency, values: []float64{100, 100, 100, 110}},
+		{name: "latency increase", labels: latency, values: []float64{100, 100, 100, 130}, expect: regressionRelative},
+		{name: "latency decrease", labels: latency, values: []float64{100, 100, 100, 70}},
+		{name: "throughput decrease", labels: throughput, values: []float64{100, 100, 100, 70}, expect: regressionRelative},
+		{name: "throughput increase", labels: throughput, values: []float64{100, 100, 100, 130}},
+		{name: "too few builds", labels: latency, values: []float64{100, 100, 130}},
+		{name: "slo breach", labels: latency, values: []float64{160, 160, 160, 160}, slo: &SLOConfig{Name: "slo", Max: &max}, expect: regressionSLO},
+		{name: "slo breach without history", labels: latency, values: []float64{160}, slo: &SLOConfig{Name: "slo", Max: &max}, expect: regressionSLO},
+		{name: "slo recovered", labels: latency, values: []float64{160, 100, 100, 100}, slo: &SLOConfig{Name: "slo", Max: &max}},
+	}
+	for _, tt := range table {
+		s := &Series{Test: "test", Node: "node", Labels: tt.labels, Bucket: "Perc99"}
+		for i, value := range tt.values {
+			s.Points = append(s.Points, Point{Build: strconv.Itoa(i + 1), Value: value})
+		}
+		s.SLO = evaluateSLO(tt.slo, s.Points)
+		regressions := detectRegressions("job", []*Series{s})
+		kind := ""
+		if len(regressions) > 0 {
+			kind = regressions[0].Kind
+		}
+		if len(regressions) > 1 || kind != tt.expect {
+			t.Errorf("%s: expected regression %q but got %v", tt.name, tt.expect, regressions)
+		}
+	}
+}
+
+func TestServeRegressionsLabels(t *testing.T) {
+	defer func(c *Config) { config = c }(config)
+	config = &Config{Jobs: []*JobConfig{
+		{Name: "gce-job", Labels: map[string]string{"provider": "gce"}},
+		{Name: "aws-job", Labels: map[string]string{"provider": "aws"}},
+	}}
+	// The latest build of both jobs regresses.
+	for _, job := range []string{"gce-job", "aws-job"} {
+		allTestData[job] = TestToBuildData{}
+		defer delete(allTestData, job)
+		for i, value := range []float64{100, 100, 100, 130} {
+			data := allTestData[job].GetDataPerBuild(job, strconv.Itoa(i+1), "test", "node")
+			data.Perf = []perftype.DataItem{{Data: map[string]float64{"Perc99": value}, Unit: "ms", Labels: map[string]string{"datatype": "latency"}}}
+		}
+	}
+
+	table := []struct {
+		query  string
+		code   int
+		expect []string
+	}{
+		{query: "", code: http.StatusOK, expect: []string{"aws-job", "gce-job"}},
+		{query: "label=provider=gce", code: http.StatusOK, expect: []string{"gce-job"}},
+		{query: "label=provider=azure", code: http.StatusOK, expect: []string{}},
+		{query: "job=gce-job&label=provider=gce", code: http.StatusOK, expect: []string{"gce-job"}},
+		{query: "job=aws-job&label=provider=gce", code: http.StatusOK, expect: []string{}},
+		{query: "label=provider", code: http.StatusBadRequest},
+	}
+	for _, tt := range table {
+		res := httptest.NewRecorder()
+		serveRegressions(res, httptest.NewRequest("GET", "/api/regressions?"+tt.query, nil))
+		if res.Code != tt.code {
+			t.Errorf("%q: expected status %d but got %d: %s", tt.query, tt.code, res.Code, res.Body)
+			continue
+		}
+		if tt.code != http.StatusOK {
+			continue
+		}
+		var regressions []Regression
+		if err := json.Unmarshal(res.Body.Bytes(), &regressions); err != nil {
+			t.Errorf("%q: failed to decode the regressions: %v", tt.query, err)
+			continue
+		}
+		jobs := []string{}
+		for _, regression := range regressions {
+			jobs = append(jobs, regression.Job)
+		}
+		sort.Strings(jobs)
+		if !reflect.DeepEqual(jobs, tt.expect) {
+			t.Errorf("%q: expected the regressions of %v but got %v", tt.query, tt.expect, jobs)
+		}
+	}
+}
@@ -0,0 +1,304 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/kubernetes/test/e2e/perftype"
+)
+
+// Rollup periods.
+const (
+	rollupDay  = "day"
+	rollupWeek = "week"
+)
+
+var rollupPeriods = []string{rollupDay, rollupWeek}
+
+var (
+	// allRollups is a map from job to rollup key to the rollups of the
+	// builds dropped from the --builds window. It is protected by dataLock.
+	allRollups = map[string]map[string]*Rollup{}
+
+	// dirtyRollups lists the jobs whose rollups have changed since the
+	// last flush. It is protected by dataLock.
+	dirtyRollups = map[string]bool{}
+)
+
+func rollupsKey(job string) string {
+	return "rollups/" + job
+}
+
+// Rollup aggregates the values of a metric over the builds which ended in a
+// period, so that long term trends remain visible once the data of the builds
+// are dropped.
+type Rollup struct {
+	Test   string            `json:"test"`
+	Node   string            `json:"node"`
+	Labels map[string]string `json:"labels"`
+	Bucket string            `json:"bucket"`
+	Unit   string            `json:"unit"`
+	Period string            `json:"period"`
+	// Start is the beginning of the period in seconds since the epoch.
+	// Days start at midnight UTC and weeks on Monday.
+	Start int64 `json:"start"`
+	// Builds lists the builds aggregated in the rollup.
+	Builds []string `json:"builds"`
+	// Values are the values of the metric in the builds, sorted in
+	// ascending order.
+	Values []float64 `json:"values"`
+}
+
+// Key returns the identity of the rollup.
+func (r *Rollup) Key() string {
+	s := Series{Test: r.Test, Node: r.Node, Labels: r.Labels, Bucket: r.Bucket}
+	return fmt.Sprintf("%s/%s/%d", s.Key(), r.Period, r.Start)
+}
+
+// periodStart returns the beginning of the period containing t.
+func periodStart(period string, t time.Time) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	if period == rollupWeek {
+		// time.Sunday is 0, make the week start on Monday.
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	}
+	return day
+}
+
+// rollupBuild aggregates the perf data of the build of a test on a node into
+// the daily and weekly rollups of the job. A build is only aggregated once. It
+// must be called with dataLock held.
+func rollupBuild(job, test, node, build string, data *DataPerBuild) {
+	if data == nil || len(data.Perf) == 0 {
+		return
+	}
+	if data.Timestamp == 0 {
+		parserLog.Debug("Not rolling up the build without a timestamp", "job", job, "build", build, "test", test, "node", node)
+		return
+	}
+	if _, ok := allRollups[job]; !ok {
+		allRollups[job] = map[string]*Rollup{}
+	}
+	for _, item := range data.Perf {
+		for bucket, value := range item.Data {
+			for _, period := range rollupPeriods {
+				rollup := &Rollup{
+					Test:   test,
+					Node:   node,
+					Labels: item.Labels,
+					Bucket: bucket,
+					Unit:   item.Unit,
+					Period: period,
+					Start:  periodStart(period, time.Unix(data.Timestamp, 0)).Unix(),
+				}
+				if existing, ok := allRollups[job][rollup.Key()]; ok {
+					// The data items are in the current
+					// unit of the metric.
+					if existing.Unit != item.Unit {
+						normalizeRollup(existing)
+					}
+					rollup = existing
+				} else {
+					allRollups[job][rollup.Key()] = rollup
+				}
+				rollup.add(build, value)
+			}
+		}
+	}
+	dirtyRollups[job] = true
+}
+
+// setRollups replaces the rollups of the job with the persisted rollups. It
+// must be called with dataLock held.
+func setRollups(job string, rollups []*Rollup) {
+	allRollups[job] = map[string]*Rollup{}
+	for _, rollup := range rollups {
+		if normalizeRollup(rollup) {
+			dirtyRollups[job] = true
+		}
+		allRollups[job][rollup.Key()] = rollup
+	}
+}
+
+// normalizeRollup converts the values of the rollup to the configured or
+// canonical unit of its metric, like normalizeDataItem converts the builds, so
+// that the rollups persisted before the unit configuration changed are not
+// merged with the values of the new builds in another unit. It returns true
+// if the rollup was converted.
+func normalizeRollup(r *Rollup) bool {
+	item := perftype.DataItem{Data: map[string]float64{r.Bucket: 1}, Unit: r.Unit, Labels: r.Labels}
+	normalizeDataItem(&item)
+	if item.Unit == r.Unit {
+		return false
+	}
+	factor := item.Data[r.Bucket]
+	for i := range r.Values {
+		r.Values[i] *= factor
+	}
+	r.Unit = item.Unit
+	return true
+}
+
+// add aggregates the value of the metric in the build, unless it already is.
+func (r *Rollup) add(build string, value float64) {
+	for _, b := range r.Builds {
+		if b == build {
+			return
+		}
+	}
+	r.Builds = append(r.Builds, build)
+	i := sort.SearchFloat64s(r.Values, value)
+	r.Values = append(r.Values, 0)
+	copy(r.Values[i+1:], r.Values[i:])
+	r.Values[i] = value
+}
+
+// copyRollups returns a deep copy of the rollups of the job sorted by key, so
+// that they can be encoded without holding dataLock. It must be called with
+// dataLock held.
+func copyRollups(job string) []Rollup {
+	result := []Rollup{}
+	for _, rollup := range allRollups[job] {
+		copied := *rollup
+		copied.Builds = append([]string(nil), rollup.Builds...)
+		copied.Values = append([]float64(nil), rollup.Values...)
+		result = append(result, copied)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Key() < result[j].Key() })
+	return result
+}
+
+// RollupPoint summarizes the values of a metric over a period.
+type RollupPoint struct {
+	Start  int64   `json:"start"`
+	Count  int     `json:"count"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	Mean   float64 `json:"mean"`
+	Perc50 float64 `json:"perc50"`
+	Perc90 float64 `json:"perc90"`
+}
+
+// summarize returns the summary of the values of the rollup. As the values are
+// usually percentiles themselves, Perc50 and Perc90 are percentiles of
+// percentiles.
+func (r *Rollup) summarize() RollupPoint {
+	point := RollupPoint{Start: r.Start, Count: len(r.Values)}
+	if len(r.Values) == 0 {
+		return point
+	}
+	sum := 0.0
+	for _, value := range r.Values {
+		sum += value
+	}
+	point.Min = r.Values[0]
+	point.Max = r.Values[len(r.Values)-1]
+	point.Mean = sum / float64(len(r.Values))
+	point.Perc50 = percentile(r.Values, 0.5)
+	point.Perc90 = percentile(r.Values, 0.9)
+	return point
+}
+
+// percentile returns the p-th percentile of the values sorted in ascending
+// order using the nearest-rank method.
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
+
+// RollupSeries contains the rollups of one metric of a test on a node.
+type RollupSeries struct {
+	Test   string            `json:"test"`
+	Node   string            `json:"node"`
+	Labels map[string]string `json:"labels"`
+	Bucket string            `json:"bucket"`
+	Unit   string            `json:"unit"`
+	Period string            `json:"period"`
+	// Points are sorted by period in ascending order.
+	Points []RollupPoint `json:"points"`
+}
+
+// matches returns true if the metric of the test on the node matches the
+// filter. It must be called with dataLock held.
+func (f seriesFilter) matches(test, node string, labels map[string]string, bucket string) bool {
+	return (f.Test == "" || f.Test == test) &&
+		(f.Node == "" || f.Node == node) &&
+		(f.Bucket == "" || f.Bucket == bucket) &&
+		f.Metrics.Matches(labels) &&
+		(f.Owner == "" || lockedOwnerOf(test, labels) == f.Owner)
+}
+
+// serveRollups is the HTTP handler returning the rollups of the job in the
+// "job" query parameter for the period in "period" ("day" by default). The
+// metrics can be filtered like in the series API.
+func serveRollups(res http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	job, period := query.Get("job"), query.Get("period")
+	if _, ok := allTestData[job]; !ok {
+		writeError(res, http.StatusNotFound, fmt.Errorf("unknown job %q", job))
+		return
+	}
+	if period == "" {
+		period = rollupDay
+	}
+	if period != rollupDay && period != rollupWeek {
+		writeError(res, http.StatusBadRequest, fmt.Errorf("invalid period %q, must be one of %s", period, strings.Join(rollupPeriods, ", ")))
+		return
+	}
+	filter, err := parseSeriesFilter(req)
+	if err != nil {
+		writeError(res, http.StatusBadRequest, err)
+		return
+	}
+
+	dataLock.RLock()
+	seriesByKey := map[string]*RollupSeries{}
+	var keys []string
+	for _, rollup := range allRollups[job] {
+		if rollup.Period != period || !filter.matches(rollup.Test, rollup.Node, rollup.Labels, rollup.Bucket) {
+			continue
+		}
+		key := (&Series{Test: rollup.Test, Node: rollup.Node, Labels: rollup.Labels, Bucket: rollup.Bucket}).Key()
+		s, ok := seriesByKey[key]
+		if !ok {
+			s = &RollupSeries{Test: rollup.Test, Node: rollup.Node, Labels: rollup.Labels, Bucket: rollup.Bucket, Unit: rollup.Unit, Period: period}
+			seriesByKey[key] = s
+			keys = append(keys, key)
+		}
+		s.Points = append(s.Points, rollup.summarize())
+	}
+	dataLock.RUnlock()
+
+	sort.Strings(keys)
+	result := []*RollupSeries{}
+	for _, key := range keys {
+		s := seriesByKey[key]
+		sort.Slice(s.Points, func(i, j int) bool { return s.Points[i].Start < s.Points[j].Start })
+		result = append(result, s)
+	}
+	writeJSON(res, req, result)
+}
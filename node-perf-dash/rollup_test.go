@@ -0,0 +1,109 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/kubernetes/test/e2e/perftype"
+)
+
+func TestPeriodStart(t *testing.T) {
+	// 2017-06-15 is a Thursday.
+	at := time.Date(2017, 6, 15, 13, 45, 0, 0, time.UTC)
+	table := []struct {
+		period string
+		expect time.Time
+	}{
+		{period: rollupDay, expect: time.Date(2017, 6, 15, 0, 0, 0, 0, time.UTC)},
+		{period: rollupWeek, expect: time.Date(2017, 6, 12, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range table {
+		if got := periodStart(tt.period, at); !got.Equal(tt.expect) {
+			t.Errorf("%s: expected %v but got %v", tt.period, tt.expect, got)
+		}
+	}
+	// A Sunday belongs to the week starting on the previous Monday.
+	if got, expect := periodStart(rollupWeek, time.Date(2017, 6, 18, 23, 0, 0, 0, time.UTC)), time.Date(2017, 6, 12, 0, 0, 0, 0, time.UTC); !got.Equal(expect) {
+		t.Errorf("Sunday: expected %v but got %v", expect, got)
+	}
+}
+
+func TestRollupBuild(t *testing.T) {
+	job := "rollup"
+	defer delete(allRollups, job)
+	day := time.Date(2017, 6, 15, 0, 0, 0, 0, time.UTC)
+	labels := map[string]string{"datatype": "latency"}
+	for i, value := range []float64{30, 10, 20, 40} {
+		data := &DataPerBuild{
+			Perf:      []perftype.DataItem{{Data: map[string]float64{"Perc99": value}, Unit: "ms", Labels: labels}},
+			Timestamp: day.Add(time.Duration(i) * time.Hour).Unix(),
+		}
+		rollupBuild(job, "test", "node", string(rune('1'+i)), data)
+	}
+	// Aggregating a build again does not change the rollups.
+	rollupBuild(job, "test", "node", "1", &DataPerBuild{
+		Perf:      []perftype.DataItem{{Data: map[string]float64{"Perc99": 1000}, Unit: "ms", Labels: labels}},
+		Timestamp: day.Unix(),
+	})
+
+	rollups := copyRollups(job)
+	if len(rollups) != 2 {
+		t.Fatalf("Expected a daily and a weekly rollup but got %+v", rollups)
+	}
+	expect := RollupPoint{Start: day.Unix(), Count: 4, Min: 10, Max: 40, Mean: 25, Perc50: 20, Perc90: 40}
+	for _, rollup := range rollups {
+		if rollup.Period != rollupDay {
+			continue
+		}
+		if got := rollup.summarize(); !reflect.DeepEqual(got, expect) {
+			t.Errorf("Expected %+v but got %+v", expect, got)
+		}
+	}
+}
+
+func TestSetRollupsUnits(t *testing.T) {
+	job := "rollup-units"
+	defer func(c *Config) {
+		config = c
+		delete(allRollups, job)
+		delete(dirtyRollups, job)
+	}(config)
+	// The rollups were persisted in ms before the latencies were configured
+	// in s.
+	config = &Config{Units: []*UnitConfig{{Metric: map[string]string{"datatype": "latency"}, Unit: "s"}}}
+	day := time.Date(2017, 6, 15, 0, 0, 0, 0, time.UTC)
+	labels := map[string]string{"datatype": "latency"}
+	setRollups(job, []*Rollup{{Test: "test", Node: "node", Labels: labels, Bucket: "Perc99", Unit: "ms", Period: rollupDay, Start: day.Unix(), Builds: []string{"1", "2"}, Values: []float64{1000, 2000}}})
+	if !dirtyRollups[job] {
+		t.Errorf("Expected the converted rollups to be flushed")
+	}
+	rollupBuild(job, "test", "node", "3", &DataPerBuild{
+		Perf:      []perftype.DataItem{{Data: map[string]float64{"Perc99": 3}, Unit: "s", Labels: labels}},
+		Timestamp: day.Add(time.Hour).Unix(),
+	})
+	for _, rollup := range copyRollups(job) {
+		if rollup.Period != rollupDay {
+			continue
+		}
+		if rollup.Unit != "s" || !reflect.DeepEqual(rollup.Values, []float64{1, 2, 3}) {
+			t.Errorf("Expected the values [1 2 3] in s but got %v in %s", rollup.Values, rollup.Unit)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// allUnparsed is a map from job to the artifacts of its builds within the
+// --builds window which could not be parsed. It is protected by dataLock.
+var allUnparsed = map[string][]*UnparsedArtifact{}
+
+func unparsedKey(job string) string {
+	return "unparsed/" + job
+}
+
+// UnsupportedSchemaError is returned by a VersionedParser for the artifacts
+// with a schema version it does not support.
+type UnsupportedSchemaError struct {
+	Version   string
+	Supported []string
+}
+
+func (e *UnsupportedSchemaError) Error() string {
+	if e.Version == "" {
+		return fmt.Sprintf("the artifact has no schema version, supported versions are %v", e.Supported)
+	}
+	return fmt.Sprintf("unsupported schema version %q, supported versions are %v", e.Version, e.Supported)
+}
+
+// detectSchemaVersion returns the schema version of an artifact, read from its
+// top level "version" field.
+func detectSchemaVersion(content []byte) (string, error) {
+	var header struct {
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(content, &header); err != nil {
+		return "", fmt.Errorf("failed to detect the schema version: %v", err)
+	}
+	return header.Version, nil
+}
+
+// VersionedParser is a map from schema version to the Parser of the artifacts
+// of that version. It parses each artifact with the parser of its version, so
+// that the layout of an artifact can change across Kubernetes releases
+// without breaking the parsing of the builds of older releases.
+type VersionedParser map[string]Parser
+
+// Parse parses the content with the parser of its schema version. It returns
+// an UnsupportedSchemaError if no parser is registered for the version.
+func (p VersionedParser) Parse(content []byte) ([]ParsedArtifact, error) {
+	version, err := detectSchemaVersion(content)
+	if err != nil {
+		return nil, err
+	}
+	parser, ok := p[version]
+	if !ok {
+		supported := []string{}
+		for v := range p {
+			supported = append(supported, v)
+		}
+		sort.Strings(supported)
+		return nil, &UnsupportedSchemaError{Version: version, Supported: supported}
+	}
+	return parser.Parse(content)
+}
+
+// UnparsedArtifact is an artifact of a build which could not be parsed, so
+// that broken parsing shows up in a report instead of silent gaps in the
+// graphs.
+type UnparsedArtifact struct {
+	Build    string `json:"build"`
+	Artifact string `json:"artifact"`
+	Parser   string `json:"parser"`
+	// Version is the schema version of the artifact, if it was detected.
+	Version string `json:"version,omitempty"`
+	Reason  string `json:"reason"`
+	// At is when the artifact failed to parse.
+	At time.Time `json:"at"`
+}
+
+// recordUnparsed adds the artifact to the unparsed builds of the job, replacing
+// a previous failure of the same artifact, and persists them. The failures of
+// the builds older than the --builds window are dropped.
+func recordUnparsed(job string, unparsed *UnparsedArtifact) {
+	dataLock.Lock()
+	defer dataLock.Unlock()
+	latest, _ := strconv.Atoi(unparsed.Build)
+	for _, u := range allUnparsed[job] {
+		if n, _ := strconv.Atoi(u.Build); n > latest {
+			latest = n
+		}
+	}
+	kept := []*UnparsedArtifact{unparsed}
+	for _, u := range allUnparsed[job] {
+		n, _ := strconv.Atoi(u.Build)
+		if (u.Build != unparsed.Build || u.Artifact != unparsed.Artifact) && n > latest-*builds {
+			kept = append(kept, u)
+		}
+	}
+	allUnparsed[job] = kept
+	if store == nil {
+		return
+	}
+	if err := store.Put(unparsedKey(job), kept); err != nil {
+		parserLog.Warn("Failed to persist the unparsed builds", "job", job, "err", err)
+	}
+}
+
+// loadUnparsed loads the unparsed builds of the job from the store. It must be
+// called with dataLock held.
+func loadUnparsed(job string) error {
+	var unparsed []*UnparsedArtifact
+	if err := store.Get(unparsedKey(job), &unparsed); err != nil && err != errNotFound {
+		return err
+	}
+	if len(unparsed) > 0 {
+		allUnparsed[job] = unparsed
+	} else {
+		delete(allUnparsed, job)
+	}
+	return nil
+}
+
+// UnparsedBuilds is the response of the unparsed builds API.
+type UnparsedBuilds struct {
+	Job string `json:"job"`
+	// Artifacts are sorted by build in descending order.
+	Artifacts []*UnparsedArtifact `json:"artifacts"`
+}
+
+// serveUnparsed is the HTTP handler reporting the artifacts which could not be
+// parsed in the builds of the job in the "job" query parameter.
+func serveUnparsed(res http.ResponseWriter, req *http.Request) {
+	job := req.URL.Query().Get("job")
+	if _, ok := allTestData[job]; !ok {
+		writeError(res, http.StatusNotFound, fmt.Errorf("unknown job %q", job))
+		return
+	}
+	dataLock.RLock()
+	artifacts := append([]*UnparsedArtifact{}, allUnparsed[job]...)
+	dataLock.RUnlock()
+	sort.Slice(artifacts, func(i, j int) bool {
+		a, _ := strconv.Atoi(artifacts[i].Build)
+		b, _ := strconv.Atoi(artifacts[j].Build)
+		if a != b {
+			return a > b
+		}
+		return artifacts[i].Artifact < artifacts[j].Artifact
+	})
+	writeJSON(res, req, UnparsedBuilds{Job: job, Artifacts: artifacts})
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	"k8s.io/kubernetes/test/e2e/perftype"
+)
+
+func TestVersionedParser(t *testing.T) {
+	parser := parserFor("performance-node.json").parser
+	table := []struct {
+		content string
+		tests   []string
+		err     bool
+		schema  bool
+	}{
+		{content: `{"version": "v2", "dataItems": [], "labels": {"test": "density"}}`, tests: []string{"density"}},
+		// The legacy layout has the dataset labels in the data items.
+		{content: `{"version": "v1", "dataItems": [{"data": {"Perc99": 1}, "unit": "ms", "labels": {"test": "density", "node": "n1", "datatype": "latency"}}]}`, tests: []string{"density"}},
+		{content: `{"dataItems": [{"labels": {"test": "density"}}, {"labels": {"test": "resource"}}, {"labels": {"test": "density"}}]}`, tests: []string{"density", "resource"}},
+		{content: `{"dataItems": []}`, tests: []string{}},
+		{content: `{"version": "v3", "dataItems": []}`, err: true, schema: true},
+		{content: `not json`, err: true},
+	}
+	for _, tt := range table {
+		results, err := parser.Parse([]byte(tt.content))
+		if (err != nil) != tt.err {
+			t.Errorf("%s: expected error %v but got %v", tt.content, tt.err, err)
+			continue
+		}
+		if _, ok := err.(*UnsupportedSchemaError); ok != tt.schema {
+			t.Errorf("%s: expected an unsupported schema error %v but got %v", tt.content, tt.schema, err)
+		}
+		if tt.err {
+			continue
+		}
+		tests := []string{}
+		for _, result := range results {
+			if result.Version != supportedMetricVersion {
+				t.Errorf("%s: expected a result of version %s but got %+v", tt.content, supportedMetricVersion, result)
+			}
+			tests = append(tests, result.Labels["test"])
+		}
+		if !reflect.DeepEqual(tests, tt.tests) {
+			t.Errorf("%s: expected the results of the tests %v but got %v", tt.content, tt.tests, tests)
+		}
+	}
+}
+
+func TestLegacyPerfDataParser(t *testing.T) {
+	content := `{"version": "v1", "dataItems": [
+		{"data": {"Perc99": 1}, "unit": "ms", "labels": {"test": "density", "node": "n1", "datatype": "latency"}},
+		{"data": {"Perc99": 2}, "unit": "ms", "labels": {"test": "density", "node": "n2", "datatype": "latency"}},
+		{"data": {"Perc99": 3}, "unit": "ms", "labels": {"test": "density", "node": "n1", "datatype": "resource"}}]}`
+	results, err := legacyPerfDataParser{}.Parse([]byte(content))
+	if err != nil {
+		t.Fatalf("failed to parse the legacy perf data: %v", err)
+	}
+	expect := []ParsedArtifact{
+		{Version: "v2", Labels: map[string]string{"test": "density", "node": "n1"}, Perf: []perftype.DataItem{
+			{Data: map[string]float64{"Perc99": 1}, Unit: "ms", Labels: map[string]string{"datatype": "latency"}},
+			{Data: map[string]float64{"Perc99": 3}, Unit: "ms", Labels: map[string]string{"datatype": "resource"}},
+		}},
+		{Version: "v2", Labels: map[string]string{"test": "density", "node": "n2"}, Perf: []perftype.DataItem{
+			{Data: map[string]float64{"Perc99": 2}, Unit: "ms", Labels: map[string]string{"datatype": "latency"}},
+		}},
+	}
+	if !reflect.DeepEqual(results, expect) {
+		t.Errorf("expected the results %+v but got %+v", expect, results)
+	}
+}
+
+func TestRecordUnparsed(t *testing.T) {
+	job := "unparsed"
+	defer func(n int) {
+		*builds = n
+		delete(allUnparsed, job)
+	}(*builds)
+	*builds = 3
+
+	for _, build := range []int{1, 2, 2, 3, 4} {
+		recordUnparsed(job, &UnparsedArtifact{Build: strconv.Itoa(build), Artifact: "performance-node.json", Reason: "build " + strconv.Itoa(build)})
+	}
+	var got []string
+	for _, u := range allUnparsed[job] {
+		got = append(got, u.Build)
+	}
+	// Build 2 is only reported once and build 1 is out of the window.
+	if expect := []string{"4", "3", "2"}; !reflect.DeepEqual(got, expect) {
+		t.Errorf("expected the unparsed builds %v but got %v", expect, got)
+	}
+}
@@ -0,0 +1,201 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Series contains the values of one metric of a test on a node over builds. A
+// metric is a bucket (e.g. "Perc99") of the perf data items having the same
+// labels (e.g. "datatype=latency,latencytype=create-pod").
+type Series struct {
+	Test   string            `json:"test"`
+	Node   string            `json:"node"`
+	Labels map[string]string `json:"labels"`
+	Bucket string            `json:"bucket"`
+	Unit   string            `json:"unit"`
+	// Owner is the SIG or team owning the metric, if it is known.
+	Owner string `json:"owner,omitempty"`
+	// Points are sorted by build number in ascending order.
+	Points []Point `json:"points"`
+	// SLO is the SLO of the metric, if any is configured.
+	SLO *SLOStatus `json:"slo,omitempty"`
+	// Annotations are the annotations of the builds of the series. They
+	// are only set by the series API.
+	Annotations []*Annotation `json:"annotations,omitempty"`
+}
+
+// Point is the value of a metric in a build.
+type Point struct {
+	Build string  `json:"build"`
+	Value float64 `json:"value"`
+	// Timestamp is when the test of the build ended, in seconds since the
+	// epoch, if it is known.
+	Timestamp int64 `json:"timestamp,omitempty"`
+	// Count is the number of builds aggregated into the point, if the
+	// series is aggregated.
+	Count int `json:"count,omitempty"`
+}
+
+// Key returns the identity of the metric of the series, e.g.
+// "density_create_batch_105_0_0/<node>/datatype=latency,latencytype=create-pod/Perc99".
+func (s *Series) Key() string {
+	return strings.Join([]string{s.Test, s.Node, formatLabels(s.Labels), s.Bucket}, "/")
+}
+
+// formatLabels formats labels as sorted "key=value" pairs separated by ",".
+func formatLabels(labels map[string]string) string {
+	var pairs []string
+	for key, value := range labels {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// seriesFilter selects the series to extract from the data of a job. Empty
+// fields match everything.
+type seriesFilter struct {
+	Test    string
+	Node    string
+	Bucket  string
+	Owner   string
+	Metrics labelSelector
+}
+
+// extractSeries returns the series of the metrics in testData matching the
+// filter, sorted by key. testData is usually a copy returned by jobData. It
+// must not be called with dataLock held.
+func extractSeries(job string, testData TestToBuildData, filter seriesFilter) []*Series {
+	seriesByKey := map[string]*Series{}
+	for test, dataPerTest := range testData {
+		if filter.Test != "" && test != filter.Test {
+			continue
+		}
+		for node, dataPerNode := range dataPerTest.Data {
+			if filter.Node != "" && node != filter.Node {
+				continue
+			}
+			for build, data := range dataPerNode {
+				for _, item := range data.Perf {
+					if !filter.Metrics.Matches(item.Labels) {
+						continue
+					}
+					for bucket, value := range item.Data {
+						if filter.Bucket != "" && bucket != filter.Bucket {
+							continue
+						}
+						s := &Series{Test: test, Node: node, Labels: item.Labels, Bucket: bucket, Unit: item.Unit}
+						if existing, ok := seriesByKey[s.Key()]; ok {
+							s = existing
+						} else {
+							seriesByKey[s.Key()] = s
+						}
+						s.Points = append(s.Points, Point{Build: build, Value: value, Timestamp: data.Timestamp})
+					}
+				}
+			}
+		}
+	}
+
+	result := []*Series{}
+	for _, s := range seriesByKey {
+		s.Owner = ownerOf(s.Test, s.Labels)
+		if filter.Owner != "" && s.Owner != filter.Owner {
+			continue
+		}
+		sort.Slice(s.Points, func(i, j int) bool {
+			a, _ := strconv.Atoi(s.Points[i].Build)
+			b, _ := strconv.Atoi(s.Points[j].Build)
+			return a < b
+		})
+		s.SLO = evaluateSLO(config.MatchSLO(s.Test, s.Labels, s.Bucket, s.Unit), s.Points)
+		result = append(result, s)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Key() < result[j].Key() })
+	return result
+}
+
+// parseSeriesFilter parses the series filter from the "test", "node", "bucket",
+// "owner" and "metric=<key>=<value>" query parameters.
+func parseSeriesFilter(req *http.Request) (seriesFilter, error) {
+	return parseSeriesFilterQuery(req.URL.Query())
+}
+
+// parseSeriesFilterQuery parses the series filter from the parameters of a
+// query.
+func parseSeriesFilterQuery(query url.Values) (seriesFilter, error) {
+	metrics, err := parseLabelSelector(query["metric"])
+	if err != nil {
+		return seriesFilter{}, err
+	}
+	return seriesFilter{
+		Test:    query.Get("test"),
+		Node:    query.Get("node"),
+		Bucket:  query.Get("bucket"),
+		Owner:   query.Get("owner"),
+		Metrics: metrics,
+	}, nil
+}
+
+// SeriesResponse is the response of the series API.
+type SeriesResponse struct {
+	Job    string    `json:"job"`
+	Series []*Series `json:"series"`
+}
+
+// serveSeries is the HTTP handler returning the series of a job, selected by
+// the "job" query parameter and the filter in the other parameters. With the
+// "aggregate" and "fn" parameters, the series are downsampled to one point per
+// day or week.
+func serveSeries(res http.ResponseWriter, req *http.Request) {
+	job := req.URL.Query().Get("job")
+	if _, ok := allTestData[job]; !ok {
+		writeError(res, http.StatusNotFound, fmt.Errorf("unknown job %q", job))
+		return
+	}
+	filter, err := parseSeriesFilter(req)
+	if err != nil {
+		writeError(res, http.StatusBadRequest, err)
+		return
+	}
+	aggregate, err := parseAggregation(req)
+	if err != nil {
+		writeError(res, http.StatusBadRequest, err)
+		return
+	}
+
+	testData, err := jobData(job)
+	if err != nil {
+		writeError(res, http.StatusInternalServerError, err)
+		return
+	}
+	series := extractSeries(job, testData, filter)
+	if aggregate.Period != "" {
+		series = aggregateSeries(job, series, filter, aggregate)
+	}
+	// The annotations of the aggregated series are those of the last
+	// builds of their periods.
+	annotateSeries(job, series)
+	writeJSON(res, req, SeriesResponse{Job: job, Series: series})
+}
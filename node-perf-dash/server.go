@@ -0,0 +1,277 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	rateLimitQPS        = flag.Float64("rate-limit-qps", 10, "The sustained number of requests per second allowed for each client. Zero disables rate limiting")
+	rateLimitBurst      = flag.Int("rate-limit-burst", 50, "The number of requests a client is allowed to send in a burst above --rate-limit-qps")
+	trustForwardedFor   = flag.Bool("trust-forwarded-for", false, "If true, identify clients by the X-Forwarded-For header set by a load balancer in front of the dashboard")
+	forwardedForHops    = flag.Int("forwarded-for-hops", 1, "The number of trusted proxies in front of the dashboard appending to X-Forwarded-For, with --trust-forwarded-for")
+	maxInflightRequests = flag.Int("max-inflight-requests", 50, "The maximum number of requests served concurrently. Zero means no limit")
+	maxRequestBytes     = flag.Int64("max-request-bytes", 1<<20, "The maximum size of a request body in bytes")
+	maxResponseBytes    = flag.Int("max-response-bytes", 256<<20, "The maximum size of a data response in bytes. Zero means no limit")
+)
+
+const (
+	// rateLimiterPruneInterval is how often the rate limiter forgets the
+	// clients which have not sent requests recently.
+	rateLimiterPruneInterval = time.Minute
+)
+
+// errResponseTooLarge is returned by limitedBuffer once the response
+// exceeds --max-response-bytes.
+var errResponseTooLarge = errors.New("the response is too large")
+
+// limitedBuffer is a buffer refusing to hold more than limit bytes, or any
+// number of bytes if limit is zero.
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.limit > 0 && b.Len()+len(p) > b.limit {
+		return 0, errResponseTooLarge
+	}
+	return b.Buffer.Write(p)
+}
+
+// encodeJSON writes the JSON encoding of v to w, like json.Marshal. The
+// elements of a slice are encoded one at a time, so that the encoding stops
+// as soon as w refuses more data instead of holding all of it in memory.
+func encodeJSON(w io.Writer, v interface{}) error {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Slice || value.IsNil() || value.Type().Elem().Kind() == reflect.Uint8 {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}
+	if _, err := w.Write([]byte("[")); err != nil {
+		return err
+	}
+	for i := 0; i < value.Len(); i++ {
+		data, err := json.Marshal(value.Index(i).Interface())
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			if _, err := w.Write([]byte(",")); err != nil {
+				return err
+			}
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write([]byte("]"))
+	return err
+}
+
+// writeJSON writes v as the JSON response to req. It refuses to send responses
+// larger than --max-response-bytes, which the encoding stops at.
+//
+// The response carries an ETag computed from its content, and is replaced by
+// 304 Not Modified if the ETag matches the If-None-Match header of req, so that
+// polling clients do not download the same data again until it changes.
+func writeJSON(res http.ResponseWriter, req *http.Request, v interface{}) {
+	buffer := &limitedBuffer{limit: *maxResponseBytes}
+	if err := encodeJSON(buffer, v); err == errResponseTooLarge {
+		serverLog.Warn("Refusing to send an oversized response", "path", req.URL.Path, "max", *maxResponseBytes)
+		writeError(res, http.StatusInternalServerError, fmt.Errorf("the response exceeds the limit of %d bytes", *maxResponseBytes))
+		return
+	} else if err != nil {
+		writeError(res, http.StatusInternalServerError, err)
+		return
+	}
+	data := buffer.Bytes()
+	sum := sha256.Sum256(data)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	res.Header().Set("ETag", etag)
+	// Let the clients cache the responses, but revalidate them every time.
+	res.Header().Set("Cache-Control", "no-cache")
+	if (req.Method == http.MethodGet || req.Method == http.MethodHead) && etagMatches(req.Header.Get("If-None-Match"), etag) {
+		res.WriteHeader(http.StatusNotModified)
+		return
+	}
+	res.Header().Set("Content-type", "application/json")
+	res.WriteHeader(http.StatusOK)
+	res.Write(data)
+}
+
+// etagMatches returns true if the If-None-Match header lists etag, using the
+// weak comparison of RFC 7232.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// writeError writes err as an HTML error page with the given status code.
+func writeError(res http.ResponseWriter, code int, err error) {
+	res.Header().Set("Content-type", "text/html")
+	res.WriteHeader(code)
+	res.Write([]byte(fmt.Sprintf("<h3>%s</h3><p>%v", http.StatusText(code), err)))
+}
+
+// rateLimiter is a token bucket rate limiter keeping one bucket per client.
+type rateLimiter struct {
+	lock      sync.Mutex
+	qps       float64
+	burst     float64
+	buckets   map[string]*tokenBucket
+	lastPrune time.Time
+}
+
+// tokenBucket is the state of the rate limiter for a single client.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter creates a rateLimiter allowing qps requests per second with
+// bursts of burst requests for each client.
+func newRateLimiter(qps float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		qps:     qps,
+		burst:   float64(burst),
+		buckets: map[string]*tokenBucket{},
+	}
+}
+
+// Allow returns true if the client is allowed to send a request at now. If
+// not, it also returns how long the client should wait before retrying.
+func (l *rateLimiter) Allow(client string, now time.Time) (bool, time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if now.Sub(l.lastPrune) > rateLimiterPruneInterval {
+		l.prune(now)
+	}
+	bucket, ok := l.buckets[client]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[client] = bucket
+	}
+	bucket.tokens = math.Min(l.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*l.qps)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / l.qps * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// prune forgets the clients whose buckets are full again, since a new bucket
+// would behave the same way.
+func (l *rateLimiter) prune(now time.Time) {
+	for client, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*l.qps >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+	l.lastPrune = now
+}
+
+// clientID returns the identity of the client used for rate limiting. With
+// --trust-forwarded-for, it is the address appended to X-Forwarded-For by the
+// farthest of the --forwarded-for-hops trusted proxies: the entries before it
+// are set by the client and can not be trusted.
+func clientID(req *http.Request) string {
+	if *trustForwardedFor {
+		var forwarded []string
+		for _, header := range req.Header.Values("X-Forwarded-For") {
+			forwarded = append(forwarded, strings.Split(header, ",")...)
+		}
+		if i := len(forwarded) - *forwardedForHops; *forwardedForHops > 0 && i >= 0 {
+			if client := strings.TrimSpace(forwarded[i]); client != "" {
+				return client
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// limitRequests protects handler from misbehaving clients by enforcing the
+// per-client rate limit, the limit of concurrent requests and the request
+// size limit.
+func limitRequests(handler http.Handler) http.Handler {
+	var limiter *rateLimiter
+	if *rateLimitQPS > 0 {
+		limiter = newRateLimiter(*rateLimitQPS, *rateLimitBurst)
+	}
+	var inflight chan struct{}
+	if *maxInflightRequests > 0 {
+		inflight = make(chan struct{}, *maxInflightRequests)
+	}
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if limiter != nil {
+			client := clientID(req)
+			if ok, wait := limiter.Allow(client, time.Now()); !ok {
+				serverLog.Debug("Rate limited a client", "client", client, "path", req.URL.Path)
+				res.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				writeError(res, http.StatusTooManyRequests, fmt.Errorf("too many requests, retry in %v", wait))
+				return
+			}
+		}
+		if inflight != nil {
+			select {
+			case inflight <- struct{}{}:
+				defer func() { <-inflight }()
+			default:
+				res.Header().Set("Retry-After", "1")
+				writeError(res, http.StatusServiceUnavailable, fmt.Errorf("the server is busy, retry later"))
+				return
+			}
+		}
+		if req.Body != nil {
+			req.Body = http.MaxBytesReader(res, req.Body, *maxRequestBytes)
+		}
+		handler.ServeHTTP(res, req)
+	})
+}
@@ -0,0 +1,181 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	start := time.Unix(1500000000, 0)
+	limiter := newRateLimiter(2, 3)
+
+	table := []struct {
+		client string
+		at     time.Duration
+		expect bool
+	}{
+		// The burst is used up by the first three requests.
+		{"10.0.0.1", 0, true},
+		{"10.0.0.1", 0, true},
+		{"10.0.0.1", 0, true},
+		{"10.0.0.1", 0, false},
+		// Other clients are not affected.
+		{"10.0.0.2", 0, true},
+		// One token is refilled every 500ms.
+		{"10.0.0.1", 400 * time.Millisecond, false},
+		{"10.0.0.1", 500 * time.Millisecond, true},
+		{"10.0.0.1", 500 * time.Millisecond, false},
+		// The bucket never holds more than the burst.
+		{"10.0.0.1", time.Hour, true},
+		{"10.0.0.1", time.Hour, true},
+		{"10.0.0.1", time.Hour, true},
+		{"10.0.0.1", time.Hour, false},
+	}
+	for i, tt := range table {
+		if out, _ := limiter.Allow(tt.client, start.Add(tt.at)); out != tt.expect {
+			t.Errorf("Request %d from %s at %v: expected %v but got %v", i, tt.client, tt.at, tt.expect, out)
+		}
+	}
+}
+
+func TestWriteJSONETag(t *testing.T) {
+	get := func(ifNoneMatch string, v interface{}) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/jobs", nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		res := httptest.NewRecorder()
+		writeJSON(res, req, v)
+		return res
+	}
+	etag := get("", []string{"a"}).Header().Get("ETag")
+	if etag == "" {
+		t.Fatalf("expected an ETag")
+	}
+
+	table := []struct {
+		ifNoneMatch string
+		v           []string
+		expect      int
+	}{
+		{ifNoneMatch: etag, v: []string{"a"}, expect: http.StatusNotModified},
+		{ifNoneMatch: `"other", W/` + etag, v: []string{"a"}, expect: http.StatusNotModified},
+		{ifNoneMatch: "*", v: []string{"a"}, expect: http.StatusNotModified},
+		{ifNoneMatch: `"other"`, v: []string{"a"}, expect: http.StatusOK},
+		// The ETag changes with the data.
+		{ifNoneMatch: etag, v: []string{"b"}, expect: http.StatusOK},
+	}
+	for _, tt := range table {
+		res := get(tt.ifNoneMatch, tt.v)
+		if res.Code != tt.expect {
+			t.Errorf("If-None-Match %s with %v: expected status %d but got %d", tt.ifNoneMatch, tt.v, tt.expect, res.Code)
+		}
+		if res.Code == http.StatusNotModified && res.Body.Len() > 0 {
+			t.Errorf("If-None-Match %s: expected an empty body but got %q", tt.ifNoneMatch, res.Body)
+		}
+	}
+}
+
+func TestWriteJSONLimit(t *testing.T) {
+	defer func(limit int) { *maxResponseBytes = limit }(*maxResponseBytes)
+	*maxResponseBytes = 16
+	table := []struct {
+		v      interface{}
+		code   int
+		expect string
+	}{
+		{v: []string{"a", "b"}, code: http.StatusOK, expect: `["a","b"]`},
+		{v: []string(nil), code: http.StatusOK, expect: `null`},
+		{v: map[string]int{"a": 1}, code: http.StatusOK, expect: `{"a":1}`},
+		{v: []string{"aaaaaaaa", "bbbbbbbb"}, code: http.StatusInternalServerError},
+		{v: map[string]string{"a": "bbbbbbbbbbbbbbbb"}, code: http.StatusInternalServerError},
+	}
+	for _, tt := range table {
+		res := httptest.NewRecorder()
+		writeJSON(res, httptest.NewRequest("GET", "/api/series", nil), tt.v)
+		if res.Code != tt.code || (tt.code == http.StatusOK && res.Body.String() != tt.expect) {
+			t.Errorf("%v: expected %d %s but got %d %s", tt.v, tt.code, tt.expect, res.Code, res.Body)
+		}
+	}
+}
+
+func TestClientID(t *testing.T) {
+	defer func(trust bool, hops int) { *trustForwardedFor, *forwardedForHops = trust, hops }(*trustForwardedFor, *forwardedForHops)
+	table := []struct {
+		trust     bool
+		hops      int
+		forwarded []string
+		expect    string
+	}{
+		{trust: false, hops: 1, forwarded: []string{"10.0.0.1"}, expect: "192.0.2.1"},
+		// The load balancer appends the address of the client to the
+		// spoofed entries.
+		{trust: true, hops: 1, forwarded: []string{"10.0.0.1, 10.0.0.2"}, expect: "10.0.0.2"},
+		{trust: true, hops: 2, forwarded: []string{"10.0.0.1, 10.0.0.2", "10.0.0.3"}, expect: "10.0.0.2"},
+		{trust: true, hops: 3, forwarded: []string{"10.0.0.1"}, expect: "192.0.2.1"},
+		{trust: true, hops: 1, expect: "192.0.2.1"},
+	}
+	for _, tt := range table {
+		*trustForwardedFor, *forwardedForHops = tt.trust, tt.hops
+		req := httptest.NewRequest("GET", "/", nil)
+		for _, forwarded := range tt.forwarded {
+			req.Header.Add("X-Forwarded-For", forwarded)
+		}
+		if client := clientID(req); client != tt.expect {
+			t.Errorf("%v with %d hops: expected client %s but got %s", tt.forwarded, tt.hops, tt.expect, client)
+		}
+	}
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	// The request never finishes, so the server waits for the whole
+	// deadline.
+	release := make(chan struct{})
+	started := make(chan struct{})
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		close(started)
+		<-release
+	}))
+	server.Start()
+	defer server.Close()
+	defer close(release)
+	go http.Get(server.URL)
+	<-started
+
+	timeout := 200 * time.Millisecond
+	deadline := &shutdownDeadline{timeout: timeout}
+	defer deadline.stop()
+	start := time.Now()
+	if err := server.Config.Shutdown(deadline.context()); err != context.DeadlineExceeded {
+		t.Errorf("expected the shutdown to time out but got %v", err)
+	}
+	// The parses which never finish are not waited for beyond the deadline
+	// the server used up.
+	collected := make(chan struct{})
+	select {
+	case <-collected:
+	case <-deadline.context().Done():
+	}
+	if elapsed := time.Since(start); elapsed >= 2*timeout {
+		t.Errorf("expected the shutdown to take at most %v but got %v", timeout, elapsed)
+	}
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// SLOConfig is a service level objective for a metric, e.g. "99% of pod
+// startups take less than 5s" is the SLO with bucket "Perc99" and max 5000 for
+// the metric "datatype=latency,latencytype=create-pod" in ms.
+type SLOConfig struct {
+	// Name identifies the SLO, e.g. "pod-startup-latency".
+	Name string `json:"name"`
+	// Description is a human readable description of the SLO.
+	Description string `json:"description,omitempty"`
+	// Metric selects the perf data items the SLO applies to by their
+	// labels, e.g. {"datatype": "latency", "latencytype": "create-pod"}.
+	Metric map[string]string `json:"metric"`
+	// Bucket is the bucket of the data items the SLO applies to, e.g.
+	// "Perc99".
+	Bucket string `json:"bucket"`
+	// Unit is the unit of Max and Min. If set, the SLO only applies to the
+	// data items in this unit.
+	Unit string `json:"unit,omitempty"`
+	// Tests is a regular expression matching the tests the SLO applies
+	// to. It applies to all tests if it is empty.
+	Tests string `json:"tests,omitempty"`
+	// Max is the highest value allowed, if any.
+	Max *float64 `json:"max,omitempty"`
+	// Min is the lowest value allowed, if any.
+	Min *float64 `json:"min,omitempty"`
+
+	// testsRegexp is the compiled form of Tests.
+	testsRegexp *regexp.Regexp
+}
+
+// validate checks the SLO and prepares it for use.
+func (s *SLOConfig) validate() []error {
+	var errs []error
+	if s.Name == "" {
+		errs = append(errs, fmt.Errorf("slo: name must not be empty"))
+	}
+	if s.Bucket == "" {
+		errs = append(errs, fmt.Errorf("slo %q: bucket must not be empty", s.Name))
+	}
+	if s.Max == nil && s.Min == nil {
+		errs = append(errs, fmt.Errorf("slo %q: at least one of max and min must be set", s.Name))
+	}
+	if s.Tests != "" {
+		re, err := regexp.Compile(s.Tests)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("slo %q: invalid tests regular expression %q: %v", s.Name, s.Tests, err))
+		}
+		s.testsRegexp = re
+	}
+	return errs
+}
+
+// Matches returns true if the SLO applies to the metric of the test.
+func (s *SLOConfig) Matches(test string, labels map[string]string, bucket, unit string) bool {
+	if s.Bucket != bucket || (s.Unit != "" && s.Unit != unit) {
+		return false
+	}
+	if s.testsRegexp != nil && !s.testsRegexp.MatchString(test) {
+		return false
+	}
+	return labelSelector(s.Metric).Matches(labels)
+}
+
+// Breached returns true if value violates the SLO.
+func (s *SLOConfig) Breached(value float64) bool {
+	return (s.Max != nil && value > *s.Max) || (s.Min != nil && value < *s.Min)
+}
+
+// MatchSLO returns the first configured SLO applying to the metric of the
+// test, or nil if there is none.
+func (c *Config) MatchSLO(test string, labels map[string]string, bucket, unit string) *SLOConfig {
+	if c == nil {
+		return nil
+	}
+	for _, slo := range c.SLOs {
+		if slo.Matches(test, labels, bucket, unit) {
+			return slo
+		}
+	}
+	return nil
+}
+
+// SLOStatus is the SLO of a series, returned with the series so that it can
+// be rendered as a line with markers on the breaching builds.
+type SLOStatus struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Max         *float64 `json:"max,omitempty"`
+	Min         *float64 `json:"min,omitempty"`
+	// Breaches lists the builds violating the SLO.
+	Breaches []string `json:"breaches,omitempty"`
+}
+
+// evaluateSLO returns the status of the points against slo, or nil if slo is
+// nil.
+func evaluateSLO(slo *SLOConfig, points []Point) *SLOStatus {
+	if slo == nil {
+		return nil
+	}
+	status := &SLOStatus{Name: slo.Name, Description: slo.Description, Max: slo.Max, Min: slo.Min}
+	for _, point := range points {
+		if slo.Breached(point.Value) {
+			status.Breaches = append(status.Breaches, point.Build)
+		}
+	}
+	return status
+}
@@ -0,0 +1,181 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const storeFileSuffix = ".json"
+
+var (
+	// errNotFound is returned by Store.Get if the key does not exist.
+	errNotFound = errors.New("not found")
+	// errExists is returned by Store.Create if the key already exists.
+	errExists = errors.New("already exists")
+)
+
+// Store persists data across restarts of node-perf-dash. Keys are slash
+// separated paths, e.g. "builds/<job>/<build>".
+type Store interface {
+	// Get decodes the value stored under key into v. It returns
+	// errNotFound if the key does not exist.
+	Get(key string, v interface{}) error
+	// Put stores v under key, replacing the existing value.
+	Put(key string, v interface{}) error
+	// Create stores v under key if the key does not exist yet. It returns
+	// errExists otherwise; when several callers create the same key
+	// concurrently, exactly one of them succeeds.
+	Create(key string, v interface{}) error
+	// Delete removes key. Deleting a missing key is not an error.
+	Delete(key string) error
+	// List returns the sorted keys directly under the directory prefix,
+	// e.g. List("builds/<job>") returns "builds/<job>/<build>" keys.
+	List(prefix string) ([]string, error)
+}
+
+// FileStore is a Store keeping each value as a JSON file in a local
+// directory.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore creates a new FileStore in the given directory, creating the
+// directory if it does not exist.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create the store directory %q: %v", dir, err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (s *FileStore) path(key string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(key)+storeFileSuffix)
+}
+
+// Get decodes the value stored under key into v.
+func (s *FileStore) Get(key string, v interface{}) error {
+	data, err := ioutil.ReadFile(s.path(key))
+	if os.IsNotExist(err) {
+		return errNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to decode %q: %v", key, err)
+	}
+	return nil
+}
+
+// Put stores v under key. The value is written to a temporary file which is
+// then renamed, so that a crash never leaves a partially written value.
+func (s *FileStore) Put(key string, v interface{}) error {
+	tmp, err := s.writeTemp(key, v)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	return os.Rename(tmp, s.path(key))
+}
+
+// Create stores v under key if the key does not exist yet. The temporary file
+// is hard linked to the key, which fails atomically if the key exists.
+func (s *FileStore) Create(key string, v interface{}) error {
+	tmp, err := s.writeTemp(key, v)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	if err := os.Link(tmp, s.path(key)); err != nil {
+		if os.IsExist(err) {
+			return errExists
+		}
+		return err
+	}
+	return nil
+}
+
+// writeTemp writes v to a temporary file in the directory of key, and returns
+// the path of the file.
+func (s *FileStore) writeTemp(key string, v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode %q: %v", key, err)
+	}
+	dir := filepath.Dir(s.path(key))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	tmp, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		return "", err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// Delete removes key.
+func (s *FileStore) Delete(key string) error {
+	if err := os.Remove(s.path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// List returns the sorted keys directly under the directory prefix.
+func (s *FileStore) List(prefix string) ([]string, error) {
+	prefix = strings.Trim(prefix, "/")
+	files, err := ioutil.ReadDir(filepath.Join(s.dir, filepath.FromSlash(prefix)))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasSuffix(name, storeFileSuffix) || strings.HasPrefix(name, ".") {
+			continue
+		}
+		key := strings.TrimSuffix(name, storeFileSuffix)
+		if prefix != "" {
+			key = prefix + "/" + key
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "node-perf-dash-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var state jobState
+	if err := s.Get(jobStateKey("job"), &state); err != errNotFound {
+		t.Errorf("Expected errNotFound for a missing key but got %v", err)
+	}
+
+	expected := jobState{LastBuild: 42, Scan: &scanCheckpoint{LastListedBuild: 45, Done: []int{45, 44}, InProgress: 43}}
+	if err := s.Put(jobStateKey("job"), expected); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Get(jobStateKey("job"), &state); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(state, expected) {
+		t.Errorf("Expected %+v but got %+v", expected, state)
+	}
+
+	for _, build := range []string{"9", "10", "8"} {
+		if err := s.Put(buildKey("job", build), buildSnapshot{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Delete(buildKey("job", "8")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(buildKey("job", "missing")); err != nil {
+		t.Errorf("Expected no error deleting a missing key but got %v", err)
+	}
+	keys, err := s.List(buildsKey("job"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sortBuildKeys(keys)
+	if expected := []string{"builds/job/9", "builds/job/10"}; !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Expected keys %v but got %v", expected, keys)
+	}
+
+	if err := s.Create(jobStateKey("created"), expected); err != nil {
+		t.Errorf("Expected no error creating a new key but got %v", err)
+	}
+	if err := s.Create(jobStateKey("created"), jobState{}); err != errExists {
+		t.Errorf("Expected errExists creating an existing key but got %v", err)
+	}
+	if err := s.Get(jobStateKey("created"), &state); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(state, expected) {
+		t.Errorf("Expected %+v but got %+v", expected, state)
+	}
+
+	// The temporary files of the writes are removed, and those left by a
+	// crash are not listed.
+	files, err := ioutil.ReadDir(filepath.Join(dir, "builds", "job"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Errorf("Expected only the files of the 2 keys but got %d files", len(files))
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "builds", "job", ".tmp-crash"+storeFileSuffix), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err = s.List(buildsKey("job")); err != nil || len(keys) != 2 {
+		t.Errorf("Expected the 2 keys without the temporary file but got %v (%v)", keys, err)
+	}
+	if keys, err = s.List(buildsKey("missing")); err != nil || keys != nil {
+		t.Errorf("Expected no keys for a missing prefix but got %v (%v)", keys, err)
+	}
+}
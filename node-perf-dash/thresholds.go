@@ -0,0 +1,217 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+
+	"github.com/ghodss/yaml"
+)
+
+var thresholdsFile = flag.String("thresholds", "", "If non-empty, the YAML file defining the regression thresholds of the metrics")
+
+// defaultThreshold is the threshold of the metrics when no thresholds file
+// overrides it.
+var defaultThreshold = Threshold{
+	Relative:    floatPtr(0.2),
+	Window:      10,
+	MinBaseline: 3,
+	Trend:       floatPtr(0.05),
+}
+
+// thresholds are the regression thresholds loaded from --thresholds, or nil
+// if the default threshold applies to all metrics.
+var thresholds *Thresholds
+
+func floatPtr(f float64) *float64 {
+	return &f
+}
+
+// Threshold defines when a metric regressed. The unset fields are inherited
+// from the defaults.
+type Threshold struct {
+	// Relative is the relative change of the metric, compared to the mean
+	// of the previous builds, above which it regressed, e.g. 0.2 for 20%.
+	Relative *float64 `json:"relative,omitempty"`
+	// Absolute is the change of the metric, in its unit, above which it
+	// regressed. If both Relative and Absolute are set, the metric has to
+	// exceed both to regress, so that small or noisy metrics do not.
+	Absolute *float64 `json:"absolute,omitempty"`
+	// Window is the number of previous builds the latest build is
+	// compared with.
+	Window int `json:"window,omitempty"`
+	// MinBaseline is the minimum number of previous builds needed to
+	// detect a regression.
+	MinBaseline int `json:"minBaseline,omitempty"`
+	// Trend is the relative change of the mean of the metric between two
+	// periods above which the digests report it as a trend.
+	Trend *float64 `json:"trend,omitempty"`
+}
+
+// merge returns t with the fields set in override replaced.
+func (t Threshold) merge(override Threshold) Threshold {
+	if override.Relative != nil {
+		t.Relative = override.Relative
+	}
+	if override.Absolute != nil {
+		t.Absolute = override.Absolute
+	}
+	if override.Window != 0 {
+		t.Window = override.Window
+	}
+	if override.MinBaseline != 0 {
+		t.MinBaseline = override.MinBaseline
+	}
+	if override.Trend != nil {
+		t.Trend = override.Trend
+	}
+	return t
+}
+
+// Exceeded returns true if a change of the metric in the direction making it
+// worse, relative and in its unit, exceeds the threshold.
+func (t Threshold) Exceeded(relative, absolute float64) bool {
+	if t.Relative == nil && t.Absolute == nil {
+		return false
+	}
+	return (t.Relative == nil || relative > *t.Relative) && (t.Absolute == nil || absolute > *t.Absolute)
+}
+
+// validate checks the threshold. what names it in the errors.
+func (t Threshold) validate(what string) []error {
+	var errs []error
+	if t.Relative != nil && *t.Relative < 0 {
+		errs = append(errs, fmt.Errorf("%s: relative must not be negative", what))
+	}
+	if t.Absolute != nil && *t.Absolute < 0 {
+		errs = append(errs, fmt.Errorf("%s: absolute must not be negative", what))
+	}
+	if t.Trend != nil && *t.Trend < 0 {
+		errs = append(errs, fmt.Errorf("%s: trend must not be negative", what))
+	}
+	if t.Window < 0 || t.MinBaseline < 0 {
+		errs = append(errs, fmt.Errorf("%s: window and minBaseline must not be negative", what))
+	}
+	return errs
+}
+
+// ThresholdOverride is the threshold of the metrics it matches.
+type ThresholdOverride struct {
+	// Jobs and Tests are regular expressions matching the jobs and the
+	// tests the override applies to. It applies to all if they are empty.
+	Jobs  string `json:"jobs,omitempty"`
+	Tests string `json:"tests,omitempty"`
+	// Metric selects the perf data items the override applies to by their
+	// labels, e.g. {"datatype": "latency"}.
+	Metric map[string]string `json:"metric,omitempty"`
+	// Bucket is the bucket the override applies to, e.g. "Perc99". It
+	// applies to all buckets if it is empty.
+	Bucket string `json:"bucket,omitempty"`
+	Threshold
+
+	// jobsRegexp and testsRegexp are the compiled forms of Jobs and Tests.
+	jobsRegexp  *regexp.Regexp
+	testsRegexp *regexp.Regexp
+}
+
+// Matches returns true if the override applies to the metric of the test.
+func (o *ThresholdOverride) Matches(job, test string, labels map[string]string, bucket string) bool {
+	if o.Bucket != "" && o.Bucket != bucket {
+		return false
+	}
+	if (o.jobsRegexp != nil && !o.jobsRegexp.MatchString(job)) || (o.testsRegexp != nil && !o.testsRegexp.MatchString(test)) {
+		return false
+	}
+	return labelSelector(o.Metric).Matches(labels)
+}
+
+// Thresholds are the regression thresholds of the metrics: the defaults,
+// overridden for some jobs or metrics.
+type Thresholds struct {
+	// Defaults apply to all metrics. The unset fields are inherited from
+	// the built-in defaults.
+	Defaults Threshold `json:"defaults"`
+	// Overrides change the thresholds of the metrics they match. All
+	// matching overrides apply in order, so that later entries take
+	// precedence.
+	Overrides []*ThresholdOverride `json:"overrides,omitempty"`
+}
+
+// loadThresholds reads the thresholds from the YAML (or JSON) file at path
+// and validates them.
+func loadThresholds(path string) (*Thresholds, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read thresholds file %q: %v", path, err)
+	}
+	t := &Thresholds{}
+	if err := yaml.Unmarshal(data, t); err != nil {
+		return nil, fmt.Errorf("failed to parse thresholds file %q: %v", path, err)
+	}
+	if errs := t.validate(); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid thresholds file %q: %v", path, errs)
+	}
+	return t, nil
+}
+
+// loadThresholdsFromFlags loads the thresholds from --thresholds, if set.
+func loadThresholdsFromFlags() (*Thresholds, error) {
+	if *thresholdsFile == "" {
+		return nil, nil
+	}
+	return loadThresholds(*thresholdsFile)
+}
+
+// validate checks the thresholds and prepares them for use.
+func (t *Thresholds) validate() []error {
+	errs := t.Defaults.validate("defaults")
+	for i, o := range t.Overrides {
+		what := fmt.Sprintf("overrides[%d]", i)
+		errs = append(errs, o.Threshold.validate(what)...)
+		var err error
+		if o.Jobs != "" {
+			if o.jobsRegexp, err = regexp.Compile(o.Jobs); err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid jobs regular expression %q: %v", what, o.Jobs, err))
+			}
+		}
+		if o.Tests != "" {
+			if o.testsRegexp, err = regexp.Compile(o.Tests); err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid tests regular expression %q: %v", what, o.Tests, err))
+			}
+		}
+	}
+	return errs
+}
+
+// For returns the threshold of the metric of the test in the job. t may be
+// nil, in which case the default threshold is returned.
+func (t *Thresholds) For(job, test string, labels map[string]string, bucket string) Threshold {
+	threshold := defaultThreshold
+	if t == nil {
+		return threshold
+	}
+	threshold = threshold.merge(t.Defaults)
+	for _, o := range t.Overrides {
+		if o.Matches(job, test, labels, bucket) {
+			threshold = threshold.merge(o.Threshold)
+		}
+	}
+	return threshold
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestThresholdsFor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "thresholds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "thresholds.yaml")
+	content := `
+defaults:
+  relative: 0.1
+overrides:
+- metric:
+    datatype: latency
+  bucket: Perc99
+  relative: 0.3
+- jobs: "^ci-noisy-"
+  tests: "^density_"
+  absolute: 50
+  window: 5
+`
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadThresholds(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	latency := map[string]string{"datatype": "latency"}
+	table := []struct {
+		job, test, bucket string
+		labels            map[string]string
+		relative          float64
+		absolute          *float64
+		window            int
+	}{
+		{job: "ci-job", test: "density_1", bucket: "Perc50", labels: latency, relative: 0.1, window: 10},
+		{job: "ci-job", test: "density_1", bucket: "Perc99", labels: latency, relative: 0.3, window: 10},
+		{job: "ci-noisy-job", test: "density_1", bucket: "Perc99", labels: latency, relative: 0.3, absolute: floatPtr(50), window: 5},
+		{job: "ci-noisy-job", test: "resource_1", bucket: "Perc99", labels: latency, relative: 0.3, window: 10},
+	}
+	for _, tt := range table {
+		threshold := loaded.For(tt.job, tt.test, tt.labels, tt.bucket)
+		if *threshold.Relative != tt.relative || threshold.Window != tt.window || (threshold.Absolute == nil) != (tt.absolute == nil) ||
+			(tt.absolute != nil && *threshold.Absolute != *tt.absolute) {
+			t.Errorf("%s/%s/%s: expected relative %g, absolute %v and window %d but got %+v", tt.job, tt.test, tt.bucket, tt.relative, tt.absolute, tt.window, threshold)
+		}
+	}
+	if threshold := (*Thresholds)(nil).For("job", "test", latency, "Perc99"); *threshold.Relative != *defaultThreshold.Relative || threshold.Window != defaultThreshold.Window {
+		t.Errorf("expected the default threshold but got %+v", threshold)
+	}
+}
+
+func TestThresholdExceeded(t *testing.T) {
+	table := []struct {
+		threshold          Threshold
+		relative, absolute float64
+		expect             bool
+	}{
+		{threshold: Threshold{Relative: floatPtr(0.2)}, relative: 0.3, absolute: 1, expect: true},
+		{threshold: Threshold{Relative: floatPtr(0.2)}, relative: 0.1, absolute: 100},
+		{threshold: Threshold{Absolute: floatPtr(50)}, relative: 0.01, absolute: 100, expect: true},
+		{threshold: Threshold{Relative: floatPtr(0.2), Absolute: floatPtr(50)}, relative: 0.5, absolute: 10},
+		{threshold: Threshold{Relative: floatPtr(0.2), Absolute: floatPtr(50)}, relative: 0.5, absolute: 100, expect: true},
+		{threshold: Threshold{}, relative: 10, absolute: 1000},
+	}
+	for i, tt := range table {
+		if exceeded := tt.threshold.Exceeded(tt.relative, tt.absolute); exceeded != tt.expect {
+			t.Errorf("%d: expected %v but got %v", i, tt.expect, exceeded)
+		}
+	}
+}
+
+func TestDetectRegressionsWithThresholds(t *testing.T) {
+	defer func() { thresholds = nil }()
+	thresholds = &Thresholds{Overrides: []*ThresholdOverride{{Bucket: "Perc99", Threshold: Threshold{Relative: floatPtr(0.5)}}}}
+	for _, tt := range []struct {
+		bucket string
+		expect int
+	}{{bucket: "Perc50", expect: 1}, {bucket: "Perc99"}} {
+		s := &Series{Test: "test", Node: "node", Labels: map[string]string{"datatype": "latency"}, Bucket: tt.bucket}
+		for i, value := range []float64{100, 100, 100, 130} {
+			s.Points = append(s.Points, Point{Build: strconv.Itoa(i + 1), Value: value})
+		}
+		if regressions := detectRegressions("job", []*Series{s}); len(regressions) != tt.expect {
+			t.Errorf("%s: expected %d regressions but got %v", tt.bucket, tt.expect, regressions)
+		}
+	}
+}
+
+func TestInvalidThresholds(t *testing.T) {
+	invalid := []*Thresholds{
+		{Defaults: Threshold{Relative: floatPtr(-1)}},
+		{Overrides: []*ThresholdOverride{{Jobs: "("}}},
+		{Overrides: []*ThresholdOverride{{Threshold: Threshold{Window: -1}}}},
+	}
+	for i, thresholds := range invalid {
+		if errs := thresholds.validate(); len(errs) == 0 {
+			t.Errorf("%d: expected an error for %+v", i, thresholds)
+		}
+	}
+}
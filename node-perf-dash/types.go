@@ -17,8 +17,6 @@ limitations under the License.
 package main
 
 import (
-	"encoding/json"
-	"fmt"
 	"net/http"
 
 	"k8s.io/kubernetes/test/e2e/perftype"
@@ -29,6 +27,11 @@ import (
 type DataPerBuild struct {
 	Perf   []perftype.DataItem            `json:"perf,omitempty"`
 	Series []node_perftype.NodeTimeSeries `json:"series,omitempty"`
+	// Version is the version of the sources tested by the build, e.g.
+	// "v1.8.0-alpha.0.690+3cb7796762047e".
+	Version string `json:"version,omitempty"`
+	// Timestamp is when the test ended, in seconds since the epoch.
+	Timestamp int64 `json:"timestamp,omitempty"`
 }
 
 // DataPerNode contains perf/time series data for a node.
@@ -38,6 +41,8 @@ type DataPerNode map[string]*DataPerBuild
 type DataPerTest struct {
 	Data map[string]DataPerNode `json:"data"`
 	Job  string                 `json:"job"`
+	// Labels are the labels configured for the job.
+	Labels map[string]string `json:"labels,omitempty"`
 }
 
 // TestToBuildData is a map from job name to DataPerTest.
@@ -48,8 +53,9 @@ type TestToBuildData map[string]*DataPerTest
 func (b TestToBuildData) GetDataPerBuild(job, build, test, node string) *DataPerBuild {
 	if _, ok := b[test]; !ok {
 		b[test] = &DataPerTest{
-			Job:  job,
-			Data: map[string]DataPerNode{},
+			Job:    job,
+			Data:   map[string]DataPerNode{},
+			Labels: jobLabels(job),
 		}
 	}
 	if _, ok := b[test].Data[node]; !ok {
@@ -61,18 +67,17 @@ func (b TestToBuildData) GetDataPerBuild(job, build, test, node string) *DataPer
 	return b[test].Data[node][build]
 }
 
+// jobDataHandler is the HTTP handler for serving the TestToBuildData of a job.
+type jobDataHandler string
+
 // ServeHTTP is the HTTP handler for serving TestToBuildData.
-func (b TestToBuildData) ServeHTTP(res http.ResponseWriter, req *http.Request) {
-	data, err := json.Marshal(b)
+func (job jobDataHandler) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	testData, err := jobData(string(job))
 	if err != nil {
-		res.Header().Set("Content-type", "text/html")
-		res.WriteHeader(http.StatusInternalServerError)
-		res.Write([]byte(fmt.Sprintf("<h3>Internal Error</h3><p>%v", err)))
+		writeError(res, http.StatusInternalServerError, err)
 		return
 	}
-	res.Header().Set("Content-type", "application/json")
-	res.WriteHeader(http.StatusOK)
-	res.Write(data)
+	writeJSON(res, req, testData)
 }
 
 // TestInfo contains the mapping from test name to test description.
@@ -85,14 +90,7 @@ type TestInfo struct {
 
 // ServeHTTP is the HTTP handler for serving TestInfo.
 func (b *TestInfo) ServeHTTP(res http.ResponseWriter, req *http.Request) {
-	data, err := json.Marshal(b)
-	if err != nil {
-		res.Header().Set("Content-type", "text/html")
-		res.WriteHeader(http.StatusInternalServerError)
-		res.Write([]byte(fmt.Sprintf("<h3>Internal Error</h3><p>%v", err)))
-		return
-	}
-	res.Header().Set("Content-type", "application/json")
-	res.WriteHeader(http.StatusOK)
-	res.Write(data)
+	dataLock.RLock()
+	defer dataLock.RUnlock()
+	writeJSON(res, req, b)
 }
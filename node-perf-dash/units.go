@@ -0,0 +1,140 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"k8s.io/kubernetes/test/e2e/perftype"
+)
+
+// unitScale is a unit of a dimension, with its size expressed in the
+// canonical unit of the dimension.
+type unitScale struct {
+	Dimension string
+	Factor    float64
+}
+
+// knownUnits are the units node-perf-dash can convert between.
+var knownUnits = map[string]unitScale{
+	"ns":  {"time", 1e-6},
+	"us":  {"time", 1e-3},
+	"µs":  {"time", 1e-3},
+	"ms":  {"time", 1},
+	"s":   {"time", 1e3},
+	"min": {"time", 60e3},
+	"h":   {"time", 3600e3},
+
+	// The SI prefixes are powers of 10 and the binary ones powers of 2, so
+	// 1 MiB is 1.048576 MB.
+	"B":   {"memory", 1e-6},
+	"KB":  {"memory", 1e-3},
+	"MB":  {"memory", 1},
+	"GB":  {"memory", 1e3},
+	"KiB": {"memory", (1 << 10) / 1e6},
+	"MiB": {"memory", (1 << 20) / 1e6},
+	"GiB": {"memory", (1 << 30) / 1e6},
+
+	"mCPU":  {"cpu", 1e-3},
+	"cores": {"cpu", 1},
+}
+
+// canonicalUnits is a map from dimension to the unit the metrics of the
+// dimension are returned in, unless another unit is configured.
+var canonicalUnits = map[string]string{
+	"time":   "ms",
+	"memory": "MB",
+	"cpu":    "cores",
+}
+
+// UnitConfig describes the unit of the metrics with the given labels.
+type UnitConfig struct {
+	// Metric selects the perf data items by their labels, e.g.
+	// {"datatype": "latency"}.
+	Metric map[string]string `json:"metric"`
+	// Unit is the unit the data items are returned in, e.g. "s". It
+	// defaults to the canonical unit of their dimension, e.g. "ms" for
+	// durations.
+	Unit string `json:"unit,omitempty"`
+	// Default is the unit of the data items reported without a unit.
+	Default string `json:"default,omitempty"`
+}
+
+// validate checks the unit configuration.
+func (u *UnitConfig) validate() []error {
+	var errs []error
+	metric := formatLabels(u.Metric)
+	if u.Unit == "" && u.Default == "" {
+		errs = append(errs, fmt.Errorf("units %q: at least one of unit and default must be set", metric))
+	}
+	for _, unit := range []string{u.Unit, u.Default} {
+		if _, ok := knownUnits[unit]; unit != "" && !ok {
+			errs = append(errs, fmt.Errorf("units %q: unknown unit %q", metric, unit))
+		}
+	}
+	if u.Unit != "" && u.Default != "" && knownUnits[u.Unit].Dimension != knownUnits[u.Default].Dimension {
+		errs = append(errs, fmt.Errorf("units %q: can not convert %q to %q", metric, u.Default, u.Unit))
+	}
+	return errs
+}
+
+// MatchUnit returns the first unit configuration applying to the data items
+// with the given labels, or nil if there is none.
+func (c *Config) MatchUnit(labels map[string]string) *UnitConfig {
+	if c == nil {
+		return nil
+	}
+	for _, unit := range c.Units {
+		if labelSelector(unit.Metric).Matches(labels) {
+			return unit
+		}
+	}
+	return nil
+}
+
+// normalizeDataItem converts the values of the data item to the configured or
+// canonical unit of its dimension, so that the same metric is always returned
+// in the same unit whatever the version of the test reporting it. The data
+// items in unknown units are left as they are. Time series are not converted,
+// their units are fixed by their format.
+func normalizeDataItem(item *perftype.DataItem) {
+	unitConfig := config.MatchUnit(item.Labels)
+	from := item.Unit
+	if from == "" && unitConfig != nil {
+		from = unitConfig.Default
+	}
+	scale, ok := knownUnits[from]
+	if !ok {
+		item.Unit = from
+		return
+	}
+	to := canonicalUnits[scale.Dimension]
+	if unitConfig != nil && unitConfig.Unit != "" {
+		to = unitConfig.Unit
+	}
+	if to == from || knownUnits[to].Dimension != scale.Dimension {
+		item.Unit = from
+		return
+	}
+	factor := scale.Factor / knownUnits[to].Factor
+	data := make(map[string]float64, len(item.Data))
+	for bucket, value := range item.Data {
+		data[bucket] = value * factor
+	}
+	item.Data = data
+	item.Unit = to
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"math"
+	"testing"
+
+	"k8s.io/kubernetes/test/e2e/perftype"
+)
+
+func TestNormalizeDataItem(t *testing.T) {
+	defer func(c *Config) { config = c }(config)
+	config = &Config{Units: []*UnitConfig{
+		{Metric: map[string]string{"latencytype": "pod-startup"}, Unit: "s"},
+		{Metric: map[string]string{"datatype": "latency"}, Default: "us"},
+		{Metric: map[string]string{"resource": "memory"}, Unit: "GiB"},
+	}}
+	table := []struct {
+		name       string
+		unit       string
+		labels     map[string]string
+		value      float64
+		expectUnit string
+		expect     float64
+	}{
+		{name: "canonical", unit: "ms", labels: map[string]string{"datatype": "latency"}, value: 5, expectUnit: "ms", expect: 5},
+		{name: "seconds", unit: "s", labels: map[string]string{"datatype": "latency"}, value: 5, expectUnit: "ms", expect: 5000},
+		{name: "default unit", unit: "", labels: map[string]string{"datatype": "latency"}, value: 5000, expectUnit: "ms", expect: 5},
+		{name: "configured unit", unit: "ms", labels: map[string]string{"datatype": "latency", "latencytype": "pod-startup"}, value: 5000, expectUnit: "s", expect: 5},
+		{name: "SI memory", unit: "GB", labels: map[string]string{"datatype": "resource"}, value: 2, expectUnit: "MB", expect: 2000},
+		{name: "SI kilobytes", unit: "KB", labels: map[string]string{"datatype": "resource"}, value: 1500, expectUnit: "MB", expect: 1.5},
+		{name: "bytes", unit: "B", labels: map[string]string{"datatype": "resource"}, value: 3e6, expectUnit: "MB", expect: 3},
+		{name: "binary memory", unit: "GiB", labels: map[string]string{"datatype": "resource"}, value: 2, expectUnit: "MB", expect: 2147.483648},
+		{name: "binary kilobytes", unit: "KiB", labels: map[string]string{"datatype": "resource"}, value: 1000, expectUnit: "MB", expect: 1.024},
+		{name: "binary to binary", unit: "MiB", labels: map[string]string{"datatype": "resource", "resource": "memory"}, value: 2048, expectUnit: "GiB", expect: 2},
+		{name: "millicores", unit: "mCPU", labels: map[string]string{"datatype": "resource"}, value: 250, expectUnit: "cores", expect: 0.25},
+		{name: "unknown unit", unit: "pods/s", labels: map[string]string{"datatype": "throughput"}, value: 8, expectUnit: "pods/s", expect: 8},
+	}
+	for _, tt := range table {
+		item := perftype.DataItem{Data: map[string]float64{"Perc99": tt.value}, Unit: tt.unit, Labels: tt.labels}
+		normalizeDataItem(&item)
+		if item.Unit != tt.expectUnit || math.Abs(item.Data["Perc99"]-tt.expect) > 1e-9 {
+			t.Errorf("%s: expected %v %s but got %v %s", tt.name, tt.expect, tt.expectUnit, item.Data["Perc99"], item.Unit)
+		}
+	}
+}
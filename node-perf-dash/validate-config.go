@@ -0,0 +1,98 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// validateConfigCommand implements the "validate-config" subcommand. It parses
+// the configuration, checks that the data of every job is accessible in the
+// data source and prints the problems found, without starting the web server.
+// It returns the exit code of the command.
+func validateConfigCommand(args []string) int {
+	flag.CommandLine.Parse(args)
+	if err := initLogging(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to initialize logging: %v\n", err)
+		return 1
+	}
+	if validateConfig(os.Stdout) {
+		return 0
+	}
+	return 1
+}
+
+// validateConfig writes a report of the configuration problems to out. It
+// returns true if no problems were found.
+func validateConfig(out io.Writer) bool {
+	ok := true
+	report := func(level, format string, args ...interface{}) {
+		if level == "ERROR" {
+			ok = false
+		}
+		fmt.Fprintf(out, "%s: %s\n", level, fmt.Sprintf(format, args...))
+	}
+
+	loaded, err := loadConfigFromFlags()
+	if err != nil {
+		report("ERROR", "%v", err)
+		return false
+	}
+	for _, err := range loaded.Validate() {
+		report("ERROR", "%v", err)
+	}
+	// The artifacts of the jobs are matched by the plugin parsers too, like
+	// when serving.
+	registerPlugins(loaded.Plugins)
+	if _, err := loadThresholdsFromFlags(); err != nil {
+		report("ERROR", "%v", err)
+	}
+
+	source, err := newDownloader(*datasource)
+	if err != nil {
+		report("ERROR", "%v", err)
+		return false
+	}
+	for _, job := range loaded.Jobs {
+		if job.Name == "" {
+			continue
+		}
+		build, err := discoverLatestBuild(source, job.Name, 0)
+		if err != nil {
+			report("ERROR", "job %q: failed to find the latest build in %s: %v; check that the job name is correct and that its results are uploaded there", job.Name, *datasource, err)
+			continue
+		}
+		files, err := source.ListFilesInBuild(job.Name, build, artifactsDir)
+		if err != nil {
+			report("ERROR", "job %q: failed to list %q in build %d: %v", job.Name, artifactsDir, build, err)
+			continue
+		}
+		found := 0
+		for _, artifacts := range groupArtifacts(files) {
+			found += len(artifacts)
+		}
+		if found == 0 {
+			report("WARNING", "job %q: no artifacts matching a registered parser were found in the latest build %d; check that the job runs node performance tests", job.Name, build)
+			continue
+		}
+		report("OK", "job %q: found %d artifacts in the latest build %d", job.Name, found, build)
+	}
+	return ok
+}
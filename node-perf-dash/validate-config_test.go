@@ -0,0 +1,60 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateConfigPlugins(t *testing.T) {
+	dir, err := ioutil.TempDir("", "node-perf-dash-validate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// The only artifact of the latest build is matched by the plugin.
+	if err := os.MkdirAll(filepath.Join(dir, "3", "artifacts"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		latestBuildFile:                 "3",
+		"3/artifacts/netperf-node.json": "{}",
+		"config.yaml":                   "jobs:\n- name: netperf\nplugins:\n- name: netperf\n  pattern: netperf-*.json\n  command: [\"netperf-to-perfdash\"]\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func(file, source, data string) {
+		*configFile, *datasource, *localDataDir = file, source, data
+		registerPlugins(nil)
+	}(*configFile, *datasource, *localDataDir)
+	*configFile, *datasource, *localDataDir = filepath.Join(dir, "config.yaml"), "local", dir
+
+	var out bytes.Buffer
+	if !validateConfig(&out) {
+		t.Errorf("expected the configuration to be valid but got %q", out.String())
+	}
+	if expect := `OK: job "netperf": found 1 artifacts in the latest build 3`; !strings.Contains(out.String(), expect) {
+		t.Errorf("expected %q in the report but got %q", expect, out.String())
+	}
+}
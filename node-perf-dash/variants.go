@@ -0,0 +1,266 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+)
+
+// defaultMaxSkew is the default maximum time between the builds of a variant
+// and of its control which are compared.
+const defaultMaxSkew = 12 * time.Hour
+
+// VariantConfig declares a job as a variant of a control job, e.g. the same
+// tests run with containerd instead of dockershim, so that the effect of an
+// infrastructure change can be evaluated before making it the default.
+type VariantConfig struct {
+	// Name identifies the pair, e.g. "containerd".
+	Name string `json:"name"`
+	// Description is a human readable description of the change evaluated.
+	Description string `json:"description,omitempty"`
+	// Variant and Control are the names of the jobs compared.
+	Variant string `json:"variant"`
+	Control string `json:"control"`
+	// IgnoreNode compares the metrics of the jobs whatever the node they
+	// ran on, when the jobs use different node images.
+	IgnoreNode bool `json:"ignoreNode,omitempty"`
+	// MaxSkew is the maximum time between the builds of the jobs which are
+	// compared. It defaults to 12h.
+	MaxSkew Duration `json:"maxSkew,omitempty"`
+}
+
+// validate checks the variant against the configured jobs.
+func (v *VariantConfig) validate(c *Config) []error {
+	var errs []error
+	if v.Name == "" {
+		errs = append(errs, fmt.Errorf("variants: name must not be empty"))
+	}
+	for _, job := range []string{v.Variant, v.Control} {
+		if c.Job(job) == nil {
+			errs = append(errs, fmt.Errorf("variant %q: job %q is not configured", v.Name, job))
+		}
+	}
+	if v.Variant == v.Control {
+		errs = append(errs, fmt.Errorf("variant %q: the variant and control jobs must differ", v.Name))
+	}
+	if v.MaxSkew.Duration < 0 {
+		errs = append(errs, fmt.Errorf("variant %q: max skew must not be negative", v.Name))
+	}
+	return errs
+}
+
+// maxSkew returns the maximum time between the builds which are compared.
+func (v *VariantConfig) maxSkew() time.Duration {
+	if v.MaxSkew.Duration > 0 {
+		return v.MaxSkew.Duration
+	}
+	return defaultMaxSkew
+}
+
+// Variant returns the configuration of the named variant, or nil if no such
+// variant is configured.
+func (c *Config) Variant(name string) *VariantConfig {
+	if c == nil {
+		return nil
+	}
+	for _, variant := range c.Variants {
+		if variant.Name == name {
+			return variant
+		}
+	}
+	return nil
+}
+
+// VariantPoint is a build of the variant job aligned with the closest build of
+// the control job.
+type VariantPoint struct {
+	VariantBuild string `json:"variantBuild"`
+	ControlBuild string `json:"controlBuild"`
+	// Timestamp is the timestamp of the variant build.
+	Timestamp int64   `json:"timestamp"`
+	Variant   float64 `json:"variant"`
+	Control   float64 `json:"control"`
+	// Delta is Variant - Control, and Change the relative delta compared
+	// to Control.
+	Delta  float64 `json:"delta"`
+	Change float64 `json:"change"`
+}
+
+// VariantSeries is a metric of the variant job aligned with the same metric of
+// the control job.
+type VariantSeries struct {
+	Test   string            `json:"test"`
+	Node   string            `json:"node"`
+	Labels map[string]string `json:"labels"`
+	Bucket string            `json:"bucket"`
+	Unit   string            `json:"unit"`
+	Owner  string            `json:"owner,omitempty"`
+	// MeanChange is the mean of the relative changes of the points.
+	MeanChange float64        `json:"meanChange"`
+	Points     []VariantPoint `json:"points"`
+}
+
+// VariantComparison is the response of the variants API.
+type VariantComparison struct {
+	*VariantConfig
+	Series []*VariantSeries `json:"series"`
+}
+
+// variantKey returns the key by which the series of the variant and control
+// jobs are matched.
+func variantKey(s *Series, ignoreNode bool) string {
+	if ignoreNode {
+		return (&Series{Test: s.Test, Labels: s.Labels, Bucket: s.Bucket}).Key()
+	}
+	return s.Key()
+}
+
+// alignVariant aligns each point of the variant series with the point of the
+// control series closest in time, within maxSkew. The points of the builds
+// whose time is unknown can not be aligned and are skipped.
+func alignVariant(variant, control *Series, maxSkew time.Duration) []VariantPoint {
+	points := []VariantPoint{}
+	for _, v := range variant.Points {
+		if v.Timestamp == 0 {
+			continue
+		}
+		var closest *Point
+		for i := range control.Points {
+			c := &control.Points[i]
+			if c.Timestamp == 0 {
+				continue
+			}
+			skew := time.Duration(math.Abs(float64(c.Timestamp-v.Timestamp))) * time.Second
+			if skew <= maxSkew && (closest == nil || math.Abs(float64(c.Timestamp-v.Timestamp)) < math.Abs(float64(closest.Timestamp-v.Timestamp))) {
+				closest = c
+			}
+		}
+		if closest == nil {
+			continue
+		}
+		point := VariantPoint{VariantBuild: v.Build, ControlBuild: closest.Build, Timestamp: v.Timestamp, Variant: v.Value, Control: closest.Value, Delta: v.Value - closest.Value}
+		if closest.Value != 0 {
+			point.Change = point.Delta / math.Abs(closest.Value)
+		}
+		points = append(points, point)
+	}
+	return points
+}
+
+// mergeControls merges the series of the control job sharing the key of a
+// variant series, which are the series of its different nodes with
+// IgnoreNode, into one series averaging the values of each build across the
+// nodes. The time of a build is the latest of its nodes.
+func mergeControls(series []*Series) *Series {
+	if len(series) == 1 {
+		return series[0]
+	}
+	merged := *series[0]
+	merged.Node = ""
+	merged.Points = nil
+	index := map[string]int{}
+	var counts []int
+	for _, s := range series {
+		for _, p := range s.Points {
+			i, ok := index[p.Build]
+			if !ok {
+				i = len(merged.Points)
+				index[p.Build] = i
+				merged.Points = append(merged.Points, Point{Build: p.Build})
+				counts = append(counts, 0)
+			}
+			merged.Points[i].Value += p.Value
+			counts[i]++
+			if p.Timestamp > merged.Points[i].Timestamp {
+				merged.Points[i].Timestamp = p.Timestamp
+			}
+		}
+	}
+	for i := range merged.Points {
+		merged.Points[i].Value /= float64(counts[i])
+	}
+	return &merged
+}
+
+// compareVariant aligns the series of the variant job with the series of the
+// control job.
+func compareVariant(variant *VariantConfig, variantSeries, controlSeries []*Series) VariantComparison {
+	byKey := map[string][]*Series{}
+	for _, s := range controlSeries {
+		key := variantKey(s, variant.IgnoreNode)
+		byKey[key] = append(byKey[key], s)
+	}
+	controls := map[string]*Series{}
+	for key, series := range byKey {
+		controls[key] = mergeControls(series)
+	}
+	comparison := VariantComparison{VariantConfig: variant, Series: []*VariantSeries{}}
+	for _, s := range variantSeries {
+		control, ok := controls[variantKey(s, variant.IgnoreNode)]
+		if !ok {
+			continue
+		}
+		points := alignVariant(s, control, variant.maxSkew())
+		if len(points) == 0 {
+			continue
+		}
+		aligned := &VariantSeries{Test: s.Test, Node: s.Node, Labels: s.Labels, Bucket: s.Bucket, Unit: s.Unit, Owner: s.Owner, Points: points}
+		for _, point := range points {
+			aligned.MeanChange += point.Change / float64(len(points))
+		}
+		comparison.Series = append(comparison.Series, aligned)
+	}
+	return comparison
+}
+
+// serveVariants is the HTTP handler returning the aligned series of the
+// variant in the "name" query parameter, or the list of the configured
+// variants if it is empty. The metrics can be filtered like in the series API.
+func serveVariants(res http.ResponseWriter, req *http.Request) {
+	name := req.URL.Query().Get("name")
+	if name == "" {
+		variants := config.Variants
+		if variants == nil {
+			variants = []*VariantConfig{}
+		}
+		writeJSON(res, req, variants)
+		return
+	}
+	variant := config.Variant(name)
+	if variant == nil {
+		writeError(res, http.StatusNotFound, fmt.Errorf("unknown variant %q", name))
+		return
+	}
+	filter, err := parseSeriesFilter(req)
+	if err != nil {
+		writeError(res, http.StatusBadRequest, err)
+		return
+	}
+	series := map[string][]*Series{}
+	for _, job := range []string{variant.Variant, variant.Control} {
+		testData, err := jobData(job)
+		if err != nil {
+			writeError(res, http.StatusInternalServerError, err)
+			return
+		}
+		series[job] = extractSeries(job, testData, filter)
+	}
+	writeJSON(res, req, compareVariant(variant, series[variant.Variant], series[variant.Control]))
+}
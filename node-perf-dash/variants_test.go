@@ -0,0 +1,81 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCompareVariant(t *testing.T) {
+	hour := int64(time.Hour / time.Second)
+	labels := map[string]string{"datatype": "latency"}
+	variant := []*Series{
+		{Test: "test", Node: "containerd-node", Labels: labels, Bucket: "Perc99", Points: []Point{
+			{Build: "1", Value: 90, Timestamp: 1 * hour},
+			{Build: "2", Value: 110, Timestamp: 10 * hour},
+			{Build: "3", Value: 120, Timestamp: 100 * hour},
+			// The time of build 4 is unknown.
+			{Build: "4", Value: 130},
+		}},
+		{Test: "other", Node: "containerd-node", Labels: labels, Bucket: "Perc99", Points: []Point{{Build: "1", Value: 1}}},
+	}
+	control := []*Series{
+		{Test: "test", Node: "docker-node", Labels: labels, Bucket: "Perc99", Points: []Point{
+			{Build: "7", Value: 100, Timestamp: 1 * hour},
+			{Build: "8", Value: 100, Timestamp: 9 * hour},
+			{Build: "9", Value: 130},
+		}},
+	}
+
+	config := &VariantConfig{Name: "containerd", Variant: "containerd", Control: "docker"}
+	if comparison := compareVariant(config, variant, control); len(comparison.Series) != 0 {
+		t.Errorf("expected no aligned series across nodes but got %+v", comparison.Series)
+	}
+
+	config.IgnoreNode = true
+	comparison := compareVariant(config, variant, control)
+	if len(comparison.Series) != 1 {
+		t.Fatalf("expected 1 aligned series but got %+v", comparison.Series)
+	}
+	expected := []VariantPoint{
+		{VariantBuild: "1", ControlBuild: "7", Timestamp: 1 * hour, Variant: 90, Control: 100, Delta: -10, Change: -0.1},
+		{VariantBuild: "2", ControlBuild: "8", Timestamp: 10 * hour, Variant: 110, Control: 100, Delta: 10, Change: 0.1},
+	}
+	if s := comparison.Series[0]; !reflect.DeepEqual(s.Points, expected) || s.MeanChange != 0 {
+		t.Errorf("expected points %+v with no mean change but got %+v", expected, s)
+	}
+
+	// With IgnoreNode, the control series of the different nodes are
+	// averaged build by build rather than overwriting each other.
+	control = append(control, &Series{Test: "test", Node: "other-docker-node", Labels: labels, Bucket: "Perc99", Points: []Point{
+		{Build: "7", Value: 80, Timestamp: 2 * hour},
+		{Build: "8", Value: 120, Timestamp: 9 * hour},
+	}})
+	comparison = compareVariant(config, variant, control)
+	if len(comparison.Series) != 1 {
+		t.Fatalf("expected 1 aligned series but got %+v", comparison.Series)
+	}
+	expected = []VariantPoint{
+		{VariantBuild: "1", ControlBuild: "7", Timestamp: 1 * hour, Variant: 90, Control: 90, Delta: 0, Change: 0},
+		{VariantBuild: "2", ControlBuild: "8", Timestamp: 10 * hour, Variant: 110, Control: 110, Delta: 0, Change: 0},
+	}
+	if s := comparison.Series[0]; !reflect.DeepEqual(s.Points, expected) {
+		t.Errorf("expected points %+v but got %+v", expected, s.Points)
+	}
+}
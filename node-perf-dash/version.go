@@ -0,0 +1,92 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The build information of the binary, set by the Makefile with
+// -ldflags "-X main.version=... -X main.gitCommit=... -X main.buildDate=...".
+var (
+	version   = ""
+	gitCommit = ""
+	buildDate = ""
+)
+
+var showVersion = flag.Bool("version", false, "If true, print the version of node-perf-dash and exit")
+
+// BuildInfo identifies the binary of node-perf-dash, so that mismatched
+// deployments can be diagnosed.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	GitCommit string `json:"gitCommit"`
+	BuildDate string `json:"buildDate"`
+	GoVersion string `json:"goVersion"`
+}
+
+func (b BuildInfo) String() string {
+	return fmt.Sprintf("node-perf-dash %s (commit %s, built %s with %s)", b.Version, b.GitCommit, b.BuildDate, b.GoVersion)
+}
+
+// buildInfo returns the build information of the binary. The commit and the
+// date default to the VCS information recorded by the Go toolchain when the
+// binary is not built by the Makefile.
+func buildInfo() BuildInfo {
+	info := BuildInfo{Version: version, GitCommit: gitCommit, BuildDate: buildDate, GoVersion: runtime.Version()}
+	if vcs, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range vcs.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.GitCommit == "":
+				info.GitCommit = setting.Value
+			case setting.Key == "vcs.time" && info.BuildDate == "":
+				info.BuildDate = setting.Value
+			}
+		}
+	}
+	for _, field := range []*string{&info.Version, &info.GitCommit, &info.BuildDate} {
+		if *field == "" {
+			*field = "unknown"
+		}
+	}
+	return info
+}
+
+// buildInfoMetric is always 1, with the build information as labels.
+var buildInfoMetric = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: metricsNamespace,
+	Name:      "build_info",
+	Help:      "Always 1, labeled with the version, the commit and the build date of node-perf-dash.",
+}, []string{"version", "git_commit", "build_date", "go_version"})
+
+func init() {
+	prometheus.MustRegister(buildInfoMetric)
+	info := buildInfo()
+	buildInfoMetric.WithLabelValues(info.Version, info.GitCommit, info.BuildDate, info.GoVersion).Set(1)
+}
+
+// serveVersion is the HTTP handler of /version, returning the build
+// information of the binary.
+func serveVersion(res http.ResponseWriter, req *http.Request) {
+	writeJSON(res, req, buildInfo())
+}
@@ -0,0 +1,44 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+)
+
+func TestServeVersion(t *testing.T) {
+	defer func(v, c, d string) {
+		version, gitCommit, buildDate = v, c, d
+	}(version, gitCommit, buildDate)
+	version, gitCommit, buildDate = "v0.3", "3cb7796762047e42b880241cee87d40890217665", ""
+
+	res := httptest.NewRecorder()
+	serveVersion(res, httptest.NewRequest("GET", "/version", nil))
+	var info BuildInfo
+	if err := json.Unmarshal(res.Body.Bytes(), &info); err != nil {
+		t.Fatalf("failed to decode the version: %v: %s", err, res.Body)
+	}
+	// The test binary has no VCS information, so the unset build date is
+	// unknown.
+	expected := BuildInfo{Version: "v0.3", GitCommit: "3cb7796762047e42b880241cee87d40890217665", BuildDate: "unknown", GoVersion: runtime.Version()}
+	if info != expected {
+		t.Errorf("expected %+v but got %+v", expected, info)
+	}
+}
@@ -0,0 +1,331 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	viewsKey       = "views"
+	preferencesKey = "preferences"
+
+	// maxViewNameLength is the maximum length of the name of a view.
+	maxViewNameLength = 256
+)
+
+var (
+	// allViews lists the saved views, global and per user. It is protected
+	// by dataLock.
+	allViews []*View
+
+	// allPreferences is a map from user to their preferences. It is
+	// protected by dataLock.
+	allPreferences = map[string]*Preferences{}
+)
+
+// View is a named selection of jobs and metrics saved on the server, so that
+// teams can curate standard dashboards, e.g. "release-blocking perf".
+type View struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// User is the user owning the view, or empty for the global views
+	// shared by all users.
+	User string `json:"user,omitempty"`
+	// Jobs are the jobs displayed, all of them if empty.
+	Jobs []string `json:"jobs,omitempty"`
+	// Test, Node, Bucket, Owner and Metric select the metrics displayed
+	// like the filters of the series API.
+	Test   string            `json:"test,omitempty"`
+	Node   string            `json:"node,omitempty"`
+	Bucket string            `json:"bucket,omitempty"`
+	Owner  string            `json:"owner,omitempty"`
+	Metric map[string]string `json:"metric,omitempty"`
+	// Builds is the number of latest builds displayed, all of them if 0.
+	Builds int `json:"builds,omitempty"`
+	// Threshold overrides the regression thresholds highlighted in the
+	// view.
+	Threshold *Threshold `json:"threshold,omitempty"`
+	UpdatedBy string     `json:"updatedBy"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+}
+
+// validate checks the view submitted through the API.
+func (v *View) validate() []error {
+	var errs []error
+	if strings.TrimSpace(v.Name) == "" {
+		errs = append(errs, fmt.Errorf("name must not be empty"))
+	}
+	if len(v.Name) > maxViewNameLength {
+		errs = append(errs, fmt.Errorf("name must not exceed %d bytes", maxViewNameLength))
+	}
+	for _, job := range v.Jobs {
+		if config.Job(job) == nil {
+			errs = append(errs, fmt.Errorf("job %q is not configured", job))
+		}
+	}
+	if v.Builds < 0 {
+		errs = append(errs, fmt.Errorf("builds must not be negative"))
+	}
+	if v.Threshold != nil {
+		errs = append(errs, v.Threshold.validate(fmt.Sprintf("view %q", v.Name))...)
+	}
+	return errs
+}
+
+// Preferences are the settings of a user.
+type Preferences struct {
+	// DefaultView is the name of the view opened by default.
+	DefaultView string `json:"defaultView,omitempty"`
+	// DefaultJob is the job opened by default when there is no default
+	// view.
+	DefaultJob string `json:"defaultJob,omitempty"`
+}
+
+// visibleViews returns the global views and the views of the user, sorted by
+// name. It must be called with dataLock held.
+func visibleViews(user string) []*View {
+	result := []*View{}
+	for _, v := range allViews {
+		if v.User == "" || (user != "" && v.User == user) {
+			result = append(result, v)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Name != result[j].Name {
+			return result[i].Name < result[j].Name
+		}
+		// The view of the user comes before the global view of the
+		// same name.
+		return result[i].User > result[j].User
+	})
+	return result
+}
+
+// saveViews persists the views and the preferences. It must be called with
+// dataLock held.
+func saveViews() error {
+	if store == nil {
+		return nil
+	}
+	if err := store.Put(viewsKey, allViews); err != nil {
+		return err
+	}
+	return store.Put(preferencesKey, allPreferences)
+}
+
+// loadViews loads the views and the preferences from the store. It must be
+// called with dataLock held.
+func loadViews() error {
+	var views []*View
+	if err := store.Get(viewsKey, &views); err != nil && err != errNotFound {
+		return err
+	}
+	preferences := map[string]*Preferences{}
+	if err := store.Get(preferencesKey, &preferences); err != nil && err != errNotFound {
+		return err
+	}
+	allViews, allPreferences = views, preferences
+	return nil
+}
+
+// putView creates or replaces the view with the same name and user.
+func putView(view *View) error {
+	dataLock.Lock()
+	defer dataLock.Unlock()
+	views := []*View{view}
+	for _, v := range allViews {
+		if v.Name != view.Name || v.User != view.User {
+			views = append(views, v)
+		}
+	}
+	allViews = views
+	return saveViews()
+}
+
+// deleteView removes the view of the user, or the global view if user is
+// empty. It returns false if there is no such view.
+func deleteView(name, user string) (bool, error) {
+	dataLock.Lock()
+	defer dataLock.Unlock()
+	var views []*View
+	for _, v := range allViews {
+		if v.Name != name || v.User != user {
+			views = append(views, v)
+		}
+	}
+	if len(views) == len(allViews) {
+		return false, nil
+	}
+	allViews = views
+	return true, saveViews()
+}
+
+// optionalUser returns the user authenticated by req, or an empty user if req
+// carries no token. It writes an error response and returns false if the
+// token is invalid.
+func optionalUser(res http.ResponseWriter, req *http.Request) (string, bool) {
+	if req.Header.Get("Authorization") == "" {
+		return "", true
+	}
+	return requireUser(res, req)
+}
+
+// viewUser returns the user owning the view in the "scope" query parameter of
+// req: "global" for the global views, or "user" (the default) for the views
+// of the authenticated user.
+func viewUser(req *http.Request, user string) (string, error) {
+	switch scope := req.URL.Query().Get("scope"); scope {
+	case "", "user":
+		return user, nil
+	case "global":
+		return "", nil
+	default:
+		return "", fmt.Errorf("invalid scope %q, expected user or global", scope)
+	}
+}
+
+// serveViews is the HTTP handler for the saved views. GET lists the global
+// views and the views of the authenticated user, or returns the view in the
+// "name" query parameter, the one of the user taking precedence over the
+// global one. PUT saves the view in the JSON body under "name" and DELETE
+// removes it, in the "scope" of the user or the global scope; both require a
+// bearer token from --api-tokens-file.
+func serveViews(res http.ResponseWriter, req *http.Request) {
+	name := req.URL.Query().Get("name")
+	switch req.Method {
+	case http.MethodGet:
+		user, ok := optionalUser(res, req)
+		if !ok {
+			return
+		}
+		dataLock.RLock()
+		views := visibleViews(user)
+		dataLock.RUnlock()
+		if name == "" {
+			writeJSON(res, req, views)
+			return
+		}
+		for _, v := range views {
+			if v.Name == name {
+				writeJSON(res, req, v)
+				return
+			}
+		}
+		writeError(res, http.StatusNotFound, fmt.Errorf("unknown view %q", name))
+	case http.MethodPut:
+		user, ok := requireUser(res, req)
+		if !ok {
+			return
+		}
+		owner, err := viewUser(req, user)
+		if err != nil {
+			writeError(res, http.StatusBadRequest, err)
+			return
+		}
+		view := &View{}
+		if err := json.NewDecoder(req.Body).Decode(view); err != nil {
+			writeError(res, http.StatusBadRequest, fmt.Errorf("failed to decode the view: %v", err))
+			return
+		}
+		view.Name, view.User, view.UpdatedBy, view.UpdatedAt = name, owner, user, time.Now().UTC()
+		if errs := view.validate(); len(errs) > 0 {
+			writeError(res, http.StatusBadRequest, fmt.Errorf("invalid view: %v", errs))
+			return
+		}
+		if err := putView(view); err != nil {
+			writeError(res, http.StatusInternalServerError, fmt.Errorf("failed to persist the view: %v", err))
+			return
+		}
+		serverLog.Info("Saved a view", "name", name, "scope", owner, "user", user)
+		writeJSON(res, req, view)
+	case http.MethodDelete:
+		user, ok := requireUser(res, req)
+		if !ok {
+			return
+		}
+		owner, err := viewUser(req, user)
+		if err != nil {
+			writeError(res, http.StatusBadRequest, err)
+			return
+		}
+		found, err := deleteView(name, owner)
+		if err != nil {
+			writeError(res, http.StatusInternalServerError, fmt.Errorf("failed to persist the views: %v", err))
+			return
+		}
+		if !found {
+			writeError(res, http.StatusNotFound, fmt.Errorf("unknown view %q", name))
+			return
+		}
+		serverLog.Info("Deleted a view", "name", name, "scope", owner, "user", user)
+		dataLock.RLock()
+		views := visibleViews(user)
+		dataLock.RUnlock()
+		writeJSON(res, req, views)
+	default:
+		res.Header().Set("Allow", "GET, PUT, DELETE")
+		writeError(res, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", req.Method))
+	}
+}
+
+// servePreferences is the HTTP handler for the preferences of the user
+// authenticated by a bearer token from --api-tokens-file. GET returns them and
+// PUT replaces them with the JSON body.
+func servePreferences(res http.ResponseWriter, req *http.Request) {
+	user, ok := requireUser(res, req)
+	if !ok {
+		return
+	}
+	switch req.Method {
+	case http.MethodGet:
+		dataLock.RLock()
+		preferences := allPreferences[user]
+		dataLock.RUnlock()
+		if preferences == nil {
+			preferences = &Preferences{}
+		}
+		writeJSON(res, req, preferences)
+	case http.MethodPut:
+		preferences := &Preferences{}
+		if err := json.NewDecoder(req.Body).Decode(preferences); err != nil {
+			writeError(res, http.StatusBadRequest, fmt.Errorf("failed to decode the preferences: %v", err))
+			return
+		}
+		if preferences.DefaultJob != "" && config.Job(preferences.DefaultJob) == nil {
+			writeError(res, http.StatusBadRequest, fmt.Errorf("job %q is not configured", preferences.DefaultJob))
+			return
+		}
+		dataLock.Lock()
+		allPreferences[user] = preferences
+		err := saveViews()
+		dataLock.Unlock()
+		if err != nil {
+			writeError(res, http.StatusInternalServerError, fmt.Errorf("failed to persist the preferences: %v", err))
+			return
+		}
+		writeJSON(res, req, preferences)
+	default:
+		res.Header().Set("Allow", "GET, PUT")
+		writeError(res, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", req.Method))
+	}
+}
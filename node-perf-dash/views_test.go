@@ -0,0 +1,76 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServeViews(t *testing.T) {
+	apiTokens = map[string]string{"alice-token": "alice", "bob-token": "bob"}
+	defer func() {
+		apiTokens = nil
+		allViews = nil
+	}()
+
+	request := func(method, query, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/views?"+query, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res := httptest.NewRecorder()
+		serveViews(res, req)
+		return res
+	}
+
+	if res := request("PUT", "name=perf&scope=global", "", `{}`); res.Code != http.StatusUnauthorized {
+		t.Errorf("expected an unauthenticated PUT to be refused but got status %d", res.Code)
+	}
+	if res := request("PUT", "name=perf&scope=global", "bob-token", `{"description": "global", "builds": 50}`); res.Code != http.StatusOK {
+		t.Fatalf("expected the global view to be saved but got status %d: %s", res.Code, res.Body)
+	}
+	if res := request("PUT", "name=perf", "alice-token", `{"description": "alice", "test": "density"}`); res.Code != http.StatusOK {
+		t.Fatalf("expected the view of alice to be saved but got status %d: %s", res.Code, res.Body)
+	}
+	if res := request("PUT", "name=invalid", "alice-token", `{"builds": -1}`); res.Code != http.StatusBadRequest {
+		t.Errorf("expected an invalid view to be refused but got status %d", res.Code)
+	}
+
+	for _, tt := range []struct {
+		token, expected string
+	}{
+		{token: "", expected: "global"},
+		{token: "bob-token", expected: "global"},
+		{token: "alice-token", expected: "alice"},
+	} {
+		res := request("GET", "name=perf", tt.token, "")
+		view := View{}
+		if err := json.Unmarshal(res.Body.Bytes(), &view); err != nil || view.Description != tt.expected {
+			t.Errorf("expected the %s view for token %q but got status %d: %s", tt.expected, tt.token, res.Code, res.Body)
+		}
+	}
+
+	res := request("DELETE", "name=perf", "alice-token", "")
+	var views []*View
+	if err := json.Unmarshal(res.Body.Bytes(), &views); err != nil || len(views) != 1 || views[0].User != "" {
+		t.Errorf("expected only the global view to be left but got status %d: %s", res.Code, res.Body)
+	}
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"embed"
+	"flag"
+	"io/fs"
+	"net/http"
+)
+
+var (
+	wwwDir = flag.String("www-dir", "", "If non-empty, serve the web UI from this directory instead of the copy embedded in the binary, e.g. to edit it live")
+	oldDir = flag.String("dir", "", "Deprecated: use --www-dir")
+)
+
+// embeddedWWW is the web UI, embedded in the binary so that it does not
+// depend on a www directory mounted next to it.
+//
+//go:embed www
+var embeddedWWW embed.FS
+
+// wwwHandler returns the file server of the web UI, from --www-dir if set or
+// from the embedded copy.
+func wwwHandler() http.Handler {
+	dir := *wwwDir
+	if dir == "" && *oldDir != "" {
+		serverLog.Warn("--dir is deprecated, use --www-dir", "dir", *oldDir)
+		dir = *oldDir
+	}
+	if dir != "" {
+		return http.FileServer(http.Dir(dir))
+	}
+	root, err := fs.Sub(embeddedWWW, "www")
+	if err != nil {
+		// The embedded directory always exists.
+		panic(err)
+	}
+	return http.FileServer(http.FS(root))
+}
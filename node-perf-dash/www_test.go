@@ -0,0 +1,58 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestWWWHandler(t *testing.T) {
+	defer func(dir, old string) { *wwwDir, *oldDir = dir, old }(*wwwDir, *oldDir)
+	index, err := ioutil.ReadFile(filepath.Join("www", "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dev, "index.html"), []byte("edited"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	table := []struct {
+		name, wwwDir, oldDir string
+		path                 string
+		code                 int
+		body                 string
+	}{
+		{name: "embedded", path: "/", code: http.StatusOK, body: string(index)},
+		{name: "embedded file", path: "/index.html", code: http.StatusMovedPermanently},
+		{name: "missing", path: "/missing.js", code: http.StatusNotFound},
+		{name: "override", wwwDir: dev, path: "/", code: http.StatusOK, body: "edited"},
+		{name: "deprecated flag", oldDir: dev, path: "/", code: http.StatusOK, body: "edited"},
+	}
+	for _, tt := range table {
+		*wwwDir, *oldDir = tt.wwwDir, tt.oldDir
+		res := httptest.NewRecorder()
+		wwwHandler().ServeHTTP(res, httptest.NewRequest("GET", tt.path, nil))
+		if res.Code != tt.code || (tt.body != "" && res.Body.String() != tt.body) {
+			t.Errorf("%s: expected %d %.20q but got %d %.20q", tt.name, tt.code, tt.body, res.Code, res.Body.String())
+		}
+	}
+}
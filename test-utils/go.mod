@@ -0,0 +1,5 @@
+module k8s.io/contrib/test-utils
+
+go 1.22
+
+require github.com/golang/glog v0.0.0-20141105023935-44145f04b68c
@@ -108,32 +108,72 @@ func (b *Bucket) ExpandListURL(pathElements ...interface{}) *url.URL {
 // List returns a list of all files inside the given path.
 // The returned file name included the complete path from bucket root
 func (b *Bucket) List(pathElements ...interface{}) ([]string, error) {
+	return b.list(b.ExpandListURL(pathElements...))
+}
+
+// ListDirectory returns a list of the files directly inside the directory of
+// the given path, without the files of its subdirectories.
+// The returned file name included the complete path from bucket root
+func (b *Bucket) ListDirectory(pathElements ...interface{}) ([]string, error) {
 	listURL := b.ExpandListURL(pathElements...)
-	res, err := getResponseWithRetry(listURL.String())
+	q := listURL.Query()
+	q.Set("prefix", q.Get("prefix")+"/")
+	q.Set("delimiter", "/")
+	listURL.RawQuery = q.Encode()
+	return b.list(listURL)
+}
+
+// list returns the names of the objects of the list API query, following
+// the pages of the listing.
+func (b *Bucket) list(listURL *url.URL) ([]string, error) {
+	var ret []string
+	q := listURL.Query()
+	for {
+		page, err := listPage(listURL.String())
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range page.Items {
+			ret = append(ret, item.Name)
+		}
+		if page.NextPageToken == "" {
+			break
+		}
+		q.Set("pageToken", page.NextPageToken)
+		listURL.RawQuery = q.Encode()
+	}
+	if len(ret) == 0 {
+		glog.Warningf("No matching files were found (from: %v)", listURL.String())
+	}
+	return ret, nil
+}
+
+// listResponse is a page of the response of the list API.
+type listResponse struct {
+	Items []struct {
+		Name string `json:"name"`
+	} `json:"items"`
+	NextPageToken string `json:"nextPageToken"`
+}
+
+func listPage(listURL string) (*listResponse, error) {
+	res, err := getResponseWithRetry(listURL)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to GET %v: %v", listURL, err)
 	}
+	defer res.Body.Close()
 	if res.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Got a non-success response %v while listing %v", res.StatusCode, listURL.String())
+		return nil, fmt.Errorf("Got a non-success response %v while listing %v", res.StatusCode, listURL)
 	}
 	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to read the response for %v: %v", listURL.String(), err)
+		return nil, fmt.Errorf("Failed to read the response for %v: %v", listURL, err)
 	}
-	var data map[string]interface{}
-	err = json.Unmarshal(body, &data)
-	if err != nil {
+	page := &listResponse{}
+	if err := json.Unmarshal(body, page); err != nil {
 		return nil, fmt.Errorf("Failed to unmarshal %v: %v", string(body), err)
 	}
-	var ret []string
-	if _, ok := data["items"]; !ok {
-		glog.Warningf("No matching files were found (from: %v)", listURL.String())
-		return ret, nil
-	}
-	for _, item := range data["items"].([]interface{}) {
-		ret = append(ret, (item.(map[string]interface{})["name"]).(string))
-	}
-	return ret, nil
+	return page, nil
 }
 
 func joinStringsAndInts(pathElements ...interface{}) string {
@@ -17,6 +17,10 @@ limitations under the License.
 package utils
 
 import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
 	"testing"
 )
 
@@ -41,3 +45,44 @@ func TestExpandListURL(t *testing.T) {
 		}
 	}
 }
+
+func TestList(t *testing.T) {
+	// The listing of logs/job/1/artifacts is returned in two pages, the
+	// files of the subdirectories only without a delimiter.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query()
+		if req.URL.Path != "/storage/v1/b/bucket/o" {
+			http.NotFound(w, req)
+			return
+		}
+		directory := q.Get("delimiter") == "/"
+		if directory && q.Get("prefix") != "logs/job/1/artifacts/" {
+			http.Error(w, "unexpected prefix "+q.Get("prefix"), http.StatusBadRequest)
+			return
+		}
+		switch {
+		case q.Get("pageToken") == "":
+			fmt.Fprint(w, `{"items": [{"name": "logs/job/1/artifacts/a.json"}], "nextPageToken": "2"}`)
+		case directory:
+			fmt.Fprint(w, `{"items": [{"name": "logs/job/1/artifacts/b.json"}], "prefixes": ["logs/job/1/artifacts/node/"]}`)
+		default:
+			fmt.Fprint(w, `{"items": [{"name": "logs/job/1/artifacts/b.json"}, {"name": "logs/job/1/artifacts/node/c.log"}]}`)
+		}
+	}))
+	defer server.Close()
+	b := NewTestBucket("bucket", server.URL)
+
+	table := []struct {
+		list   func(...interface{}) ([]string, error)
+		expect []string
+	}{
+		{list: b.List, expect: []string{"logs/job/1/artifacts/a.json", "logs/job/1/artifacts/b.json", "logs/job/1/artifacts/node/c.log"}},
+		{list: b.ListDirectory, expect: []string{"logs/job/1/artifacts/a.json", "logs/job/1/artifacts/b.json"}},
+	}
+	for _, tt := range table {
+		files, err := tt.list("logs", "job", 1, "artifacts")
+		if err != nil || !reflect.DeepEqual(files, tt.expect) {
+			t.Errorf("Expected %v but got %v (%v)", tt.expect, files, err)
+		}
+	}
+}
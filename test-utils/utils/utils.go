@@ -264,17 +264,22 @@ func (u *Utils) CheckFinishedStatus(job string, buildNumber int) (bool, error) {
 }
 
 // ListFilesInBuild takes build info and list all file names with matching prefix
+// A prefix ending with "/" only lists the files directly inside that directory
 // The returned file name included the complete path from bucket root
 func (u *Utils) ListFilesInBuild(job string, buildNumber int, prefix string) ([]string, error) {
+	list := u.bucket.List
+	if strings.HasSuffix(prefix, "/") {
+		list = u.bucket.ListDirectory
+	}
 	if u.needsDeref(job) {
 		dir, err := u.deref(job, buildNumber)
 		if err != nil {
 			return nil, fmt.Errorf("Couldn't deref %v/%v: %v", job, buildNumber, err)
 		}
-		return u.bucket.List(dir, prefix)
+		return list(dir, prefix)
 	}
 
-	return u.bucket.List(u.directory, job, buildNumber, prefix)
+	return list(u.directory, job, buildNumber, prefix)
 }
 
 // ListFilesWithPrefix returns all files with matching prefix in the bucket